@@ -0,0 +1,285 @@
+package herots
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrHandshakeLimit - returned (wrapped in *HandshakeError) when client
+// exceeds AbuseOptions.MaxHandshakeBytes or MaxRecordSize.
+var ErrHandshakeLimit = errors.New("handshake size limit exceeded")
+
+// DefaultMaxHandshakeBytes - default limit of bytes read from client
+// before handshake completes, enough for ClientHello and long client
+// certificate chain.
+const DefaultMaxHandshakeBytes = 64 << 10
+
+// DefaultAbuseBanDuration - default time for which IP is banned after
+// AbuseOptions.MaxFailures consecutive failed handshakes.
+const DefaultAbuseBanDuration = 10 * time.Minute
+
+// tlsRecordHeaderLen - type (1 byte), version (2 bytes), length (2 bytes).
+const tlsRecordHeaderLen = 5
+
+// AbuseOptions - structure, which is used to configure limits protecting
+// server from malformed and abusive pre-handshake traffic
+// (Options.Abuse).
+type AbuseOptions struct {
+	// MaxHandshakeBytes - limit of bytes read from client before
+	// handshake completes. Exceeding client fails handshake with
+	// HandshakeFailureLimit.
+	//
+	// Default: DefaultMaxHandshakeBytes (64 KiB).
+	MaxHandshakeBytes int64
+
+	// MaxRecordSize - limit of length of plaintext TLS records of
+	// handshake (ClientHello and, with TLS 1.2, client certificate
+	// flight). Handshake fails as soon as header of larger record is read,
+	// without waiting for its body. Encrypted records are bounded by
+	// MaxHandshakeBytes only.
+	//
+	// Default: 0 (limit of crypto/tls, 16 KiB + 2 KiB).
+	MaxRecordSize int
+
+	// MaxFailures - number of consecutive failed handshakes from one IP,
+	// after which IP is banned for BanDuration: its connections are
+	// closed right after accept. Successful handshake resets count;
+	// connections rejected by admission rules are not counted.
+	//
+	// Default: 0 (no bans).
+	MaxFailures int
+
+	// BanDuration - time for which IP is banned.
+	//
+	// Default: DefaultAbuseBanDuration (10m).
+	BanDuration time.Duration
+
+	// OnBan is called when IP is banned, with end of ban.
+	OnBan func(ip string, until time.Time)
+}
+
+// abuseEntry - handshake history of one IP.
+type abuseEntry struct {
+	failures int
+	last     time.Time
+	until    time.Time
+}
+
+// abuseTracker - consecutive handshake failures and bans per IP.
+type abuseTracker struct {
+	o   AbuseOptions
+	now func() time.Time
+
+	mu        sync.Mutex
+	ips       map[string]*abuseEntry
+	lastSweep time.Time
+}
+
+func newAbuseTracker(o *AbuseOptions, now func() time.Time) *abuseTracker {
+	t := &abuseTracker{o: *o, now: now, ips: make(map[string]*abuseEntry)}
+	if t.o.MaxHandshakeBytes <= 0 {
+		t.o.MaxHandshakeBytes = DefaultMaxHandshakeBytes
+	}
+	if t.o.BanDuration <= 0 {
+		t.o.BanDuration = DefaultAbuseBanDuration
+	}
+	return t
+}
+
+// addrIP - IP of address, "" for addresses without one (pipes, stdio).
+func addrIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil || net.ParseIP(host) == nil {
+		return ""
+	}
+	return host
+}
+
+// banned - report whether IP of addr is banned now.
+func (t *abuseTracker) banned(addr net.Addr) bool {
+	ip := addrIP(addr)
+	if ip == "" || t.o.MaxFailures <= 0 {
+		return false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e := t.ips[ip]
+	return e != nil && t.now().Before(e.until)
+}
+
+// fail - count failed handshake from addr; return true and end of ban if
+// it bans IP.
+func (t *abuseTracker) fail(addr net.Addr) (bool, time.Time) {
+	ip := addrIP(addr)
+	if ip == "" || t.o.MaxFailures <= 0 {
+		return false, time.Time{}
+	}
+	now := t.now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sweepLocked(now)
+	e := t.ips[ip]
+	if e == nil {
+		e = &abuseEntry{}
+		t.ips[ip] = e
+	}
+	e.failures++
+	e.last = now
+	if e.failures < t.o.MaxFailures {
+		return false, time.Time{}
+	}
+	e.failures = 0
+	e.until = now.Add(t.o.BanDuration)
+	return true, e.until
+}
+
+// succeed - reset failure count of IP of addr.
+func (t *abuseTracker) succeed(addr net.Addr) {
+	ip := addrIP(addr)
+	if ip == "" {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if e := t.ips[ip]; e != nil && e.until.IsZero() {
+		delete(t.ips, ip)
+	} else if e != nil {
+		e.failures = 0
+	}
+}
+
+// unban - lift ban of ip.
+func (t *abuseTracker) unban(ip string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e := t.ips[ip]
+	if e == nil || !t.now().Before(e.until) {
+		return false
+	}
+	delete(t.ips, ip)
+	return true
+}
+
+// sweepLocked - drop entries of IPs, which are not banned and didn't fail
+// for BanDuration, so map doesn't grow with scanners. Runs at most once
+// per BanDuration.
+func (t *abuseTracker) sweepLocked(now time.Time) {
+	if now.Sub(t.lastSweep) < t.o.BanDuration {
+		return
+	}
+	t.lastSweep = now
+	for ip, e := range t.ips {
+		if !now.Before(e.until) && now.Sub(e.last) >= t.o.BanDuration {
+			delete(t.ips, ip)
+		}
+	}
+}
+
+// Unban - function for lifting ban of IP (see AbuseOptions.MaxFailures)
+// before it expires. Returns false if IP is not banned.
+func (s *Server) Unban(ip string) bool {
+	if s.abuse == nil {
+		return false
+	}
+	if !s.abuse.unban(ip) {
+		return false
+	}
+	s.logger.Log("unban "+ip+" - ok", LogLevelInfo)
+	return true
+}
+
+// countAbuse - count failed handshake of c and ban its IP if it failed
+// too often.
+func (s *Server) countAbuse(c *Conn) {
+	banned, until := s.abuse.fail(c.raw.RemoteAddr())
+	if !banned {
+		return
+	}
+	ip := addrIP(c.raw.RemoteAddr())
+	s.logger.Log("banned "+ip+" until "+until.Format(time.RFC3339)+" after failed handshakes", LogLevelNotice)
+	if s.abuse.o.OnBan != nil {
+		s.abuse.o.OnBan(ip, until)
+	}
+}
+
+// handshakeGuard - raw connection of Conn, which enforces size limits on
+// data read until handshake completes.
+type handshakeGuard struct {
+	net.Conn
+
+	maxBytes  int64
+	maxRecord int
+
+	// done - set once handshake completed, then reads pass through
+	done atomic.Bool
+
+	// read state, used only by reader (crypto/tls): total bytes, header of
+	// current record, bytes left of its body and whether encrypted records
+	// started
+	total     int64
+	hdr       [tlsRecordHeaderLen]byte
+	hdrLen    int
+	bodyLeft  int
+	encrypted bool
+}
+
+func newHandshakeGuard(raw net.Conn, o *AbuseOptions) *handshakeGuard {
+	return &handshakeGuard{Conn: raw, maxBytes: o.MaxHandshakeBytes, maxRecord: o.MaxRecordSize}
+}
+
+func (g *handshakeGuard) Read(p []byte) (int, error) {
+	if g.done.Load() {
+		return g.Conn.Read(p)
+	}
+
+	n, err := g.Conn.Read(p)
+	if g.done.Load() {
+		return n, err
+	}
+	g.total += int64(n)
+	if g.total > g.maxBytes {
+		return 0, ErrHandshakeLimit
+	}
+	if g.maxRecord > 0 && !g.encrypted && !g.scan(p[:n]) {
+		return 0, ErrHandshakeLimit
+	}
+	return n, err
+}
+
+// scan - follow record boundaries in data until encrypted records start;
+// return false if plaintext record over limit starts.
+func (g *handshakeGuard) scan(data []byte) bool {
+	for len(data) > 0 && !g.encrypted {
+		if g.bodyLeft > 0 {
+			k := min(g.bodyLeft, len(data))
+			g.bodyLeft -= k
+			data = data[k:]
+			continue
+		}
+
+		k := copy(g.hdr[g.hdrLen:], data)
+		g.hdrLen += k
+		data = data[k:]
+		if g.hdrLen < tlsRecordHeaderLen {
+			return true
+		}
+		g.hdrLen = 0
+		g.bodyLeft = int(g.hdr[3])<<8 | int(g.hdr[4])
+		switch g.hdr[0] {
+		case 20, 23: // change_cipher_spec, application_data
+			g.encrypted = true
+		}
+		if !g.encrypted && g.bodyLeft > g.maxRecord {
+			return false
+		}
+	}
+	return true
+}
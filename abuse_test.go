@@ -0,0 +1,118 @@
+package herots
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/iu0v1/herots/herotstest"
+)
+
+func TestHandshakeLimits(t *testing.T) {
+	s, _ := startTestServer(t, &Options{
+		HandshakeOnAccept: true,
+		Abuse:             &AbuseOptions{MaxHandshakeBytes: 1024, MaxRecordSize: 512},
+	})
+
+	for name, data := range map[string][]byte{
+		// header of 16 KiB handshake record, body never sent
+		"record": {22, 3, 1, 0x40, 0x00},
+		// 4 KiB ClientHello in small records
+		"bytes": func() []byte {
+			msg := append([]byte{1, 0, 0x10, 0}, make([]byte, 4096)...)
+			var b []byte
+			for ; len(msg) > 0; msg = msg[100:] {
+				b = append(b, 22, 3, 1, 0, 100)
+				b = append(b, msg[:100]...)
+			}
+			return b
+		}(),
+	} {
+		raw, err := net.Dial("tcp", s.Addr().String())
+		if err != nil {
+			t.Fatalf("dial:\n%v\n", err)
+		}
+		raw.Write(data)
+
+		_, err = s.AcceptConn()
+		var herr *HandshakeError
+		if !errors.As(err, &herr) || herr.Reason != HandshakeFailureLimit || !errors.Is(err, ErrHandshakeLimit) {
+			t.Fatalf("%s: expected limit error, got %v\n", name, err)
+		}
+		raw.Close()
+	}
+}
+
+func TestAbuseBan(t *testing.T) {
+	clock := herotstest.NewFakeClock(time.Now())
+	bans := make(chan string, 1)
+	s, _ := startTestServer(t, &Options{
+		Clock:             clock,
+		HandshakeOnAccept: true,
+		Abuse: &AbuseOptions{
+			MaxFailures: 2,
+			BanDuration: time.Minute,
+			OnBan:       func(ip string, until time.Time) { bans <- ip },
+		},
+	})
+
+	garbage := func() net.Conn {
+		raw, err := net.Dial("tcp", s.Addr().String())
+		if err != nil {
+			t.Fatalf("dial:\n%v\n", err)
+		}
+		t.Cleanup(func() { raw.Close() })
+		raw.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+		return raw
+	}
+
+	for i := 0; i < 2; i++ {
+		garbage()
+		if _, err := s.AcceptConn(); err == nil {
+			t.Fatalf("expected handshake error\n")
+		}
+	}
+	select {
+	case ip := <-bans:
+		if ip != "127.0.0.1" {
+			t.Fatalf("unexpected banned ip %q\n", ip)
+		}
+	default:
+		t.Fatalf("ip not banned after failures\n")
+	}
+
+	accepted := make(chan error, 1)
+	go func() {
+		_, err := s.AcceptConn()
+		accepted <- err
+	}()
+
+	// banned connection is closed without handshake
+	raw := garbage()
+	raw.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.Copy(io.Discard, raw); err != nil {
+		t.Fatalf("banned conn not closed: %v\n", err)
+	}
+	if n := s.Stats().Banned; n != 1 {
+		t.Fatalf("unexpected banned count %d\n", n)
+	}
+
+	// ban expires
+	clock.Advance(time.Minute)
+	garbage()
+	select {
+	case err := <-accepted:
+		var herr *HandshakeError
+		if !errors.As(err, &herr) {
+			t.Fatalf("expected handshake error after ban, got %v\n", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("connection not accepted after ban expired\n")
+	}
+
+	if s.Unban("127.0.0.1") {
+		t.Fatalf("unban of not banned ip succeeded\n")
+	}
+}
@@ -0,0 +1,193 @@
+package herots
+
+import (
+	"container/heap"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultAcceptQueueSize - default capacity of admission queue.
+const DefaultAcceptQueueSize = 128
+
+// AcceptQueueOptions - structure, which is used to configure admission
+// queue (see Options.AcceptQueue).
+//
+// Queue takes connections from listener as fast as they come, runs their
+// handshakes concurrently (within Options.HandshakeTimeout), and keeps
+// handshaked connections until AcceptConn takes them, highest priority
+// first (FIFO for equal priority). When queue is full, connection with
+// lowest priority is shed (closed): either queued one, if newcomer has
+// higher priority, or newcomer. Shed connections are counted in
+// ServerStats.Shed.
+type AcceptQueueOptions struct {
+	// Size - capacity of queue; it also limits number of concurrent
+	// handshakes (further connections wait in listen backlog).
+	//
+	// Default: DefaultAcceptQueueSize (128).
+	Size int
+
+	// Priority - function, which returns priority of handshaked
+	// connection, e.g. higher for known node fingerprints (see
+	// Conn.Identity). Negative priority sheds connection at once.
+	//
+	// Default: nil (all connections have priority 0, FIFO).
+	Priority func(c *Conn) int
+}
+
+type queuedConn struct {
+	c        *Conn
+	priority int
+	seq      uint64
+}
+
+// connHeap - max-heap of queued connections by priority, then by arrival.
+type connHeap []*queuedConn
+
+func (h connHeap) Len() int { return len(h) }
+func (h connHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+func (h connHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *connHeap) Push(x interface{}) { *h = append(*h, x.(*queuedConn)) }
+func (h *connHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// acceptQueue - admission queue of server.
+type acceptQueue struct {
+	o AcceptQueueOptions
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	conns  connHeap
+	seq    uint64
+	closed bool
+}
+
+func newAcceptQueue(o *AcceptQueueOptions) *acceptQueue {
+	q := &acceptQueue{o: *o}
+	if q.o.Size <= 0 {
+		q.o.Size = DefaultAcceptQueueSize
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// run - take connections from listener until server is closed.
+func (q *acceptQueue) run(s *Server) {
+	defer q.close()
+
+	sem := make(chan struct{}, q.o.Size)
+	var backoff time.Duration
+	for {
+		c, err := s.acceptOne()
+		if errors.Is(err, ErrServerClosed) {
+			return
+		}
+		if err != nil {
+			// reported by acceptOne; retry like Run does
+			backoff = min(max(2*backoff, 5*time.Millisecond), maxAcceptBackoff)
+			time.Sleep(backoff)
+			continue
+		}
+		backoff = 0
+
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem }()
+			if err := c.handshakeWithTimeout(); err != nil {
+				c.Close()
+				return
+			}
+			priority := 0
+			if q.o.Priority != nil {
+				priority = q.o.Priority(c)
+			}
+			if priority < 0 {
+				q.shed(c, "rejected by priority")
+				return
+			}
+			q.push(c, priority)
+		}()
+	}
+}
+
+// push - add connection to queue, shedding lowest priority one if queue
+// is full.
+func (q *acceptQueue) push(c *Conn, priority int) {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		c.Close()
+		return
+	}
+
+	var shed *Conn
+	if len(q.conns) >= q.o.Size {
+		lowest := 0
+		for i, qc := range q.conns {
+			if qc.priority < q.conns[lowest].priority ||
+				(qc.priority == q.conns[lowest].priority && qc.seq > q.conns[lowest].seq) {
+				lowest = i
+			}
+		}
+		if q.conns[lowest].priority >= priority {
+			q.mu.Unlock()
+			q.shed(c, "queue full")
+			return
+		}
+		shed = heap.Remove(&q.conns, lowest).(*queuedConn).c
+	}
+
+	q.seq++
+	heap.Push(&q.conns, &queuedConn{c: c, priority: priority, seq: q.seq})
+	q.cond.Signal()
+	q.mu.Unlock()
+
+	if shed != nil {
+		q.shed(shed, "queue full, replaced by "+c.String()+" with priority "+strconv.Itoa(priority))
+	}
+}
+
+// shed - drop connection.
+func (q *acceptQueue) shed(c *Conn, reason string) {
+	c.server.stats.shed.Add(1)
+	c.server.logger.Log("accept queue: shed "+c.String()+": "+reason, LogLevelInfo)
+	c.closeWith(CloseReasonPolicy, errors.New("shed by accept queue"))
+}
+
+// pop - wait for and return highest priority connection.
+func (q *acceptQueue) pop() (*Conn, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.conns) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return nil, ErrServerClosed
+	}
+	return heap.Pop(&q.conns).(*queuedConn).c, nil
+}
+
+// close - wake waiting AcceptConn calls and close queued connections.
+func (q *acceptQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	conns := q.conns
+	q.conns = nil
+	q.cond.Broadcast()
+	q.mu.Unlock()
+
+	for _, qc := range conns {
+		qc.c.closeWith(CloseReasonShutdown, nil)
+	}
+}
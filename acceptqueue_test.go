@@ -0,0 +1,65 @@
+package herots
+
+import (
+	"testing"
+)
+
+func TestAcceptQueuePriority(t *testing.T) {
+	priorities := map[string]int{"vip": 10, "banned": -1}
+	s, _ := startTestServer(t, &Options{AcceptQueue: &AcceptQueueOptions{
+		Size: 1,
+		Priority: func(c *Conn) int {
+			id, err := c.Identity()
+			if err != nil {
+				return -1
+			}
+			return priorities[id.CommonName()]
+		},
+	}})
+
+	queued := func() int {
+		s.queue.mu.Lock()
+		defer s.queue.mu.Unlock()
+		return len(s.queue.conns)
+	}
+	dial := func(cn string) {
+		c, cert := newcomerClient(t, s, cn)
+		s.AddClientCACert(cert)
+		conn, err := c.Dial()
+		if err != nil {
+			t.Fatalf("dial %s:\n%v\n", cn, err)
+		}
+		t.Cleanup(func() { conn.Close() })
+	}
+
+	dial("agent")
+	waitFor(t, "agent queued", func() bool { return queued() == 1 })
+	dial("vip")
+	waitFor(t, "agent shed for vip", func() bool { return s.Stats().Shed == 1 })
+	dial("late")
+	waitFor(t, "late agent shed", func() bool { return s.Stats().Shed == 2 })
+	dial("banned")
+	waitFor(t, "banned agent shed", func() bool { return s.Stats().Shed == 3 })
+
+	conn, err := s.AcceptConn()
+	if err != nil {
+		t.Fatalf("accept:\n%v\n", err)
+	}
+	defer conn.Close()
+	if id, _ := conn.Identity(); id.CommonName() != "vip" {
+		t.Fatalf("accepted %q instead of highest priority conn\n", id.CommonName())
+	}
+}
+
+func TestAcceptQueueClose(t *testing.T) {
+	s, _ := startTestServer(t, &Options{AcceptQueue: &AcceptQueueOptions{}})
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.AcceptConn()
+		done <- err
+	}()
+	s.Close()
+	if err := <-done; err != ErrServerClosed {
+		t.Fatalf("expected ErrServerClosed, got %v\n", err)
+	}
+}
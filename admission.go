@@ -0,0 +1,299 @@
+package herots
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrAdmissionDenied - wrapped by errors of admission rules, which
+// rejected connection before TLS handshake.
+var ErrAdmissionDenied = errors.New("admission denied")
+
+// AdmissionFunc - type for functions which decide whether connection from
+// remoteAddr is admitted (Options.Admission). Non-nil error rejects
+// connection before TLS handshake.
+type AdmissionFunc func(remoteAddr net.Addr) error
+
+// CountryResolver - source of ISO 3166-1 alpha-2 country codes of IPs for
+// GeoIPAdmission, usually adapter over GeoIP database reader.
+type CountryResolver interface {
+	Country(ip net.IP) (string, error)
+}
+
+// CountryResolverFunc - function adapter for CountryResolver.
+//
+// E.g. for MaxMind GeoIP2/GeoLite2 database (github.com/oschwald/geoip2-golang):
+//
+//	db, err := geoip2.Open("GeoLite2-Country.mmdb")
+//	...
+//	countries := herots.CountryResolverFunc(func(ip net.IP) (string, error) {
+//		rec, err := db.Country(ip)
+//		if err != nil {
+//			return "", err
+//		}
+//		return rec.Country.IsoCode, nil
+//	})
+//	o.Admission = herots.GeoIPAdmission(countries, "DE", "NL")
+type CountryResolverFunc func(ip net.IP) (string, error)
+
+// Country - call f(ip).
+func (f CountryResolverFunc) Country(ip net.IP) (string, error) {
+	return f(ip)
+}
+
+// GeoIPAdmission - return AdmissionFunc, which admits only clients from
+// allowed countries (ISO codes, case insensitive). Clients, which country
+// is unknown or can't be resolved, are rejected.
+func GeoIPAdmission(r CountryResolver, allowed ...string) AdmissionFunc {
+	set := make(map[string]bool, len(allowed))
+	for _, code := range allowed {
+		set[strings.ToUpper(code)] = true
+	}
+
+	return func(remoteAddr net.Addr) error {
+		host, _, err := net.SplitHostPort(remoteAddr.String())
+		if err != nil {
+			return err
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return fmt.Errorf("no IP in address %s", remoteAddr)
+		}
+		code, err := r.Country(ip)
+		if err != nil {
+			return fmt.Errorf("country of %s: %v", ip, err)
+		}
+		if !set[strings.ToUpper(code)] {
+			return fmt.Errorf("country %q of %s is not allowed", code, ip)
+		}
+		return nil
+	}
+}
+
+// default reverse DNS admission settings
+const (
+	DefaultReverseDNSTimeout   = 2 * time.Second
+	DefaultReverseDNSCacheTTL  = 5 * time.Minute
+	DefaultReverseDNSCacheSize = 4096
+)
+
+// ReverseDNSOptions - structure, which is used to configure reverse DNS
+// admission rule (Options.ReverseDNS).
+//
+// Client IP is resolved to host names (PTR records), each name is resolved
+// back and must include client IP (forward-confirmed reverse DNS), and at
+// least one confirmed name must match one of Patterns.
+type ReverseDNSOptions struct {
+	// Patterns - host name patterns in path.Match syntax, e.g.
+	// "*.nodes.example.com". Matching is case insensitive.
+	Patterns []string
+
+	// Timeout - limit of all DNS lookups for one client IP.
+	//
+	// Default: DefaultReverseDNSTimeout (2s).
+	Timeout time.Duration
+
+	// CacheTTL - time, for which result of check (both allowed and
+	// denied) is cached per client IP. Denials caused by failed lookups
+	// (timeouts, server failures) aren't cached. Negative value disables
+	// cache.
+	//
+	// Default: DefaultReverseDNSCacheTTL (5m).
+	CacheTTL time.Duration
+
+	// CacheSize - how many client IPs are cached; least recently used
+	// are evicted.
+	//
+	// Default: DefaultReverseDNSCacheSize (4096).
+	CacheSize int
+
+	// Resolver - resolver for lookups.
+	//
+	// Default: net.DefaultResolver.
+	Resolver *net.Resolver
+}
+
+type rdnsEntry struct {
+	err     error
+	expires time.Time
+}
+
+// rdnsCall - lookup in progress; concurrent checks of same IP wait for it.
+type rdnsCall struct {
+	done chan struct{}
+	err  error
+}
+
+// rdnsChecker - reverse DNS admission rule with cache.
+type rdnsChecker struct {
+	o ReverseDNSOptions
+
+	// lookups, replaced in tests
+	lookupAddr func(ctx context.Context, addr string) ([]string, error)
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+
+	// now - time source of cache expiry
+	now func() time.Time
+
+	// cache - results of checks, nil if disabled
+	cache *lruCache[rdnsEntry]
+
+	mu        sync.Mutex
+	inflight  map[string]*rdnsCall
+	lastSweep time.Time
+}
+
+func newRDNSChecker(o *ReverseDNSOptions) *rdnsChecker {
+	r := &rdnsChecker{o: *o, inflight: make(map[string]*rdnsCall), now: time.Now}
+	if r.o.Timeout <= 0 {
+		r.o.Timeout = DefaultReverseDNSTimeout
+	}
+	if r.o.CacheTTL == 0 {
+		r.o.CacheTTL = DefaultReverseDNSCacheTTL
+	}
+	if r.o.CacheSize <= 0 {
+		r.o.CacheSize = DefaultReverseDNSCacheSize
+	}
+	if r.o.CacheTTL > 0 {
+		r.cache = newLRUCache[rdnsEntry](r.o.CacheSize)
+	}
+	if r.o.Resolver == nil {
+		r.o.Resolver = net.DefaultResolver
+	}
+	r.lookupAddr = r.o.Resolver.LookupAddr
+	r.lookupHost = r.o.Resolver.LookupHost
+	return r
+}
+
+func (r *rdnsChecker) check(ip string) error {
+	now := r.now()
+	if e, ok := r.cached(ip, now); ok {
+		return e.err
+	}
+
+	r.mu.Lock()
+	if call := r.inflight[ip]; call != nil {
+		r.mu.Unlock()
+		<-call.done
+		return call.err
+	}
+	// lookup may have finished since cache miss
+	if e, ok := r.cached(ip, now); ok {
+		r.mu.Unlock()
+		return e.err
+	}
+	call := &rdnsCall{done: make(chan struct{})}
+	r.inflight[ip] = call
+	r.mu.Unlock()
+
+	var definitive bool
+	definitive, call.err = r.lookup(ip)
+
+	if r.cache != nil && definitive {
+		r.cache.put(ip, rdnsEntry{err: call.err, expires: now.Add(r.o.CacheTTL)})
+	}
+	r.mu.Lock()
+	delete(r.inflight, ip)
+	r.sweepLocked(now)
+	r.mu.Unlock()
+	close(call.done)
+
+	return call.err
+}
+
+// cached - return cached result of check of ip, if it is not expired.
+func (r *rdnsChecker) cached(ip string, now time.Time) (rdnsEntry, bool) {
+	if r.cache == nil {
+		return rdnsEntry{}, false
+	}
+	e, ok := r.cache.get(ip)
+	if !ok || !now.Before(e.expires) {
+		return rdnsEntry{}, false
+	}
+	return e, true
+}
+
+// sweepLocked - drop expired entries of cache, so scanners don't keep it
+// full. Runs at most once per CacheTTL.
+func (r *rdnsChecker) sweepLocked(now time.Time) {
+	if r.cache == nil || now.Sub(r.lastSweep) < r.o.CacheTTL {
+		return
+	}
+	r.lastSweep = now
+	r.cache.removeFunc(func(_ string, e rdnsEntry) bool {
+		return !now.Before(e.expires)
+	})
+}
+
+// lookup - check ip; definitive is false if denial may be caused by
+// failed lookup rather than by DNS records, so it must not be cached.
+func (r *rdnsChecker) lookup(ip string) (definitive bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.o.Timeout)
+	defer cancel()
+
+	names, err := r.lookupAddr(ctx, ip)
+	if err != nil {
+		return dnsNotFound(err), fmt.Errorf("%w: reverse lookup of %s: %v", ErrAdmissionDenied, ip, err)
+	}
+
+	definitive = true
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		if !r.match(name) {
+			continue
+		}
+		addrs, err := r.lookupHost(ctx, name)
+		if err != nil {
+			definitive = definitive && dnsNotFound(err)
+			continue
+		}
+		for _, addr := range addrs {
+			if net.ParseIP(addr).Equal(net.ParseIP(ip)) {
+				return true, nil
+			}
+		}
+	}
+
+	return definitive, fmt.Errorf("%w: no confirmed host name of %s matches (names %v)", ErrAdmissionDenied, ip, names)
+}
+
+// dnsNotFound - report whether lookup failed because name has no records
+// (NXDOMAIN), rather than because of timeout or server failure.
+func dnsNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+func (r *rdnsChecker) match(name string) bool {
+	for _, p := range r.o.Patterns {
+		if ok, _ := path.Match(strings.ToLower(p), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// admit - run admission rules for connection before its handshake:
+// Options.Admission first, then reverse DNS rule.
+func (s *Server) admit(c *Conn) error {
+	if s.options.Admission != nil {
+		if err := s.options.Admission(c.raw.RemoteAddr()); err != nil {
+			return fmt.Errorf("%w: %w", ErrAdmissionDenied, err)
+		}
+	}
+	if s.rdns == nil {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(c.raw.RemoteAddr().String())
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrAdmissionDenied, err)
+	}
+	return s.rdns.check(host)
+}
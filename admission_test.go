@@ -0,0 +1,196 @@
+package herots
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReverseDNSCheck(t *testing.T) {
+	r := newRDNSChecker(&ReverseDNSOptions{Patterns: []string{"*.nodes.example.com"}})
+	lookups := 0
+	r.lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		lookups++
+		switch addr {
+		case "10.0.0.1":
+			return []string{"n1.nodes.example.com."}, nil
+		case "10.0.0.2":
+			// PTR points to allowed name, which doesn't resolve back
+			return []string{"N2.Nodes.Example.com."}, nil
+		}
+		return []string{"host.other.net."}, nil
+	}
+	r.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		if host == "n1.nodes.example.com" {
+			return []string{"10.0.0.1"}, nil
+		}
+		return []string{"10.9.9.9"}, nil
+	}
+
+	if err := r.check("10.0.0.1"); err != nil {
+		t.Fatalf("confirmed name denied:\n%v\n", err)
+	}
+	for _, ip := range []string{"10.0.0.2", "10.0.0.3"} {
+		if err := r.check(ip); !errors.Is(err, ErrAdmissionDenied) {
+			t.Fatalf("%s: expected ErrAdmissionDenied, got %v\n", ip, err)
+		}
+	}
+
+	r.check("10.0.0.1")
+	r.check("10.0.0.3")
+	if lookups != 3 {
+		t.Fatalf("results not cached: %d lookups\n", lookups)
+	}
+}
+
+func TestReverseDNSTemporaryError(t *testing.T) {
+	r := newRDNSChecker(&ReverseDNSOptions{Patterns: []string{"*.nodes.example.com"}})
+	lookups := 0
+	r.lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		lookups++
+		switch addr {
+		case "10.0.0.1":
+			return nil, &net.DNSError{Err: "i/o timeout", Name: addr, IsTimeout: true}
+		case "10.0.0.2":
+			return []string{"n2.nodes.example.com."}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+	}
+	r.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		return nil, &net.DNSError{Err: "server misbehaving", Name: host, IsTemporary: true}
+	}
+
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		for i := 0; i < 2; i++ {
+			if err := r.check(ip); !errors.Is(err, ErrAdmissionDenied) {
+				t.Fatalf("%s: expected ErrAdmissionDenied, got %v\n", ip, err)
+			}
+		}
+	}
+	// failed lookups are retried, NXDOMAIN is cached
+	if lookups != 5 {
+		t.Fatalf("%d lookups, want 5\n", lookups)
+	}
+}
+
+func TestReverseDNSCacheBounds(t *testing.T) {
+	r := newRDNSChecker(&ReverseDNSOptions{Patterns: []string{"*.nodes.example.com"}, CacheSize: 2})
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	var lookups atomic.Int32
+	release := make(chan struct{})
+	r.lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		lookups.Add(1)
+		<-release
+		return []string{"host.other.net."}, nil
+	}
+
+	// concurrent checks of one IP share lookup
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.check("10.0.0.1")
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := lookups.Load(); n != 1 {
+		t.Fatalf("%d lookups of one IP, want 1\n", n)
+	}
+
+	for _, ip := range []string{"10.0.0.2", "10.0.0.3", "10.0.0.4"} {
+		r.check(ip)
+	}
+	if n := r.cache.len(); n != 2 {
+		t.Fatalf("%d cached IPs, want 2\n", n)
+	}
+
+	// expired entries are swept
+	now = now.Add(DefaultReverseDNSCacheTTL)
+	r.check("10.0.0.5")
+	if n := r.cache.len(); n != 1 {
+		t.Fatalf("%d cached IPs after sweep, want 1\n", n)
+	}
+}
+
+func TestReverseDNSAdmission(t *testing.T) {
+	s, c := startTestServer(t, &Options{
+		ReverseDNS: &ReverseDNSOptions{Patterns: []string{"*.nodes.example.com"}},
+	})
+	s.rdns.lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		return []string{"localhost"}, nil
+	}
+
+	go func() {
+		if conn, err := c.Dial(); err == nil {
+			conn.Close()
+		}
+	}()
+
+	conn, err := s.AcceptConn()
+	if err != nil {
+		t.Fatalf("accept:\n%v\n", err)
+	}
+	defer conn.Close()
+
+	var herr *HandshakeError
+	if err := conn.Handshake(); !errors.As(err, &herr) || herr.Reason != HandshakeFailureAdmission {
+		t.Fatalf("expected admission failure, got %v\n", err)
+	}
+}
+
+func TestGeoIPAdmission(t *testing.T) {
+	countries := CountryResolverFunc(func(ip net.IP) (string, error) {
+		switch ip.String() {
+		case "10.0.0.1":
+			return "de", nil
+		case "10.0.0.2":
+			return "US", nil
+		}
+		return "", errors.New("not found")
+	})
+	admit := GeoIPAdmission(countries, "DE", "NL")
+
+	addr := func(ip string) net.Addr {
+		return &net.TCPAddr{IP: net.ParseIP(ip), Port: 1}
+	}
+	if err := admit(addr("10.0.0.1")); err != nil {
+		t.Fatalf("allowed country denied:\n%v\n", err)
+	}
+	if admit(addr("10.0.0.2")) == nil || admit(addr("10.0.0.3")) == nil {
+		t.Fatalf("disallowed or unknown country admitted\n")
+	}
+}
+
+func TestAdmissionHook(t *testing.T) {
+	denied := errors.New("go away")
+	s, c := startTestServer(t, &Options{
+		Admission: func(net.Addr) error { return denied },
+	})
+
+	go func() {
+		if conn, err := c.Dial(); err == nil {
+			conn.Close()
+		}
+	}()
+
+	conn, err := s.AcceptConn()
+	if err != nil {
+		t.Fatalf("accept:\n%v\n", err)
+	}
+	defer conn.Close()
+
+	var herr *HandshakeError
+	err = conn.Handshake()
+	if !errors.As(err, &herr) || herr.Reason != HandshakeFailureAdmission || !errors.Is(err, denied) {
+		t.Fatalf("expected admission failure, got %v\n", err)
+	}
+}
@@ -0,0 +1,223 @@
+package herots
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrAuthFailed - wrapped by errors of failed authentication step (see
+// Authenticator).
+var ErrAuthFailed = errors.New("authentication failed")
+
+// DefaultMaxAuthMessageSize - limit of single message of authentication
+// exchange.
+const DefaultMaxAuthMessageSize = 64 << 10
+
+// authentication result, sent by server after Authenticator
+const (
+	authAccepted = 0
+	authDenied   = 1
+)
+
+// hmacChallengeSize - size of random challenge of HMAC authentication.
+const hmacChallengeSize = 32
+
+// hmacExporterLabel - label of keying material, which binds HMAC response
+// to TLS connection (RFC 5705), so response can't be relayed.
+const hmacExporterLabel = "EXPORTER-herots-hmac-auth"
+
+// Authenticator - server side of authentication step, which runs after
+// TLS handshake and before connection is handed to application (see
+// Options.Authenticator).
+//
+// Authenticate exchanges messages with client side (Credentials) over
+// codec and returns name of authenticated principal (see Conn.Principal),
+// or error to reject connection. TLS state, including client certificate
+// (Conn.Identity), is available, so Authenticator may complement client
+// certificate auth or replace it (see Options.TLSAuthType).
+//
+// OIDC or JWT tokens are validated by TokenAuthenticator with validate
+// function of application, as herots has no dependencies besides
+// standard library.
+type Authenticator interface {
+	Authenticate(c *Conn, codec *Codec) (string, error)
+}
+
+// AuthenticatorFunc - adapter to use ordinary function as Authenticator.
+type AuthenticatorFunc func(c *Conn, codec *Codec) (string, error)
+
+// Authenticate - implements Authenticator.
+func (f AuthenticatorFunc) Authenticate(c *Conn, codec *Codec) (string, error) {
+	return f(c, codec)
+}
+
+// Credentials - client side of authentication step (see
+// Options.Credentials), counterpart of server Authenticator.
+type Credentials interface {
+	Present(conn *tls.Conn, codec *Codec) error
+}
+
+// CredentialsFunc - adapter to use ordinary function as Credentials.
+type CredentialsFunc func(conn *tls.Conn, codec *Codec) error
+
+// Present - implements Credentials.
+func (f CredentialsFunc) Present(conn *tls.Conn, codec *Codec) error {
+	return f(conn, codec)
+}
+
+// TokenAuthenticator - return Authenticator, which reads token sent by
+// TokenCredentials and passes it to validate, which returns principal of
+// valid token.
+func TokenAuthenticator(validate func(token string) (string, error)) Authenticator {
+	return AuthenticatorFunc(func(c *Conn, codec *Codec) (string, error) {
+		token, err := codec.ReadMessage()
+		if err != nil {
+			return "", err
+		}
+		return validate(string(token))
+	})
+}
+
+// TokenCredentials - return Credentials, which send token to
+// TokenAuthenticator.
+func TokenCredentials(token string) Credentials {
+	return CredentialsFunc(func(conn *tls.Conn, codec *Codec) error {
+		return codec.WriteMessage([]byte(token))
+	})
+}
+
+// HMACAuthenticator - return Authenticator of challenge/response with
+// shared keys: server sends random challenge, client answers with key id
+// and HMAC-SHA256 of challenge and keying material of TLS connection
+// (see HMACCredentials). key returns shared key for id; principal is key
+// id.
+func HMACAuthenticator(key func(id string) ([]byte, error)) Authenticator {
+	return AuthenticatorFunc(func(c *Conn, codec *Codec) (string, error) {
+		challenge := make([]byte, hmacChallengeSize)
+		if _, err := rand.Read(challenge); err != nil {
+			return "", err
+		}
+		if err := codec.WriteMessage(challenge); err != nil {
+			return "", err
+		}
+
+		id, err := codec.ReadMessage()
+		if err != nil {
+			return "", err
+		}
+		mac, err := codec.ReadMessage()
+		if err != nil {
+			return "", err
+		}
+
+		k, err := key(string(id))
+		if err != nil {
+			return "", fmt.Errorf("key %q: %v", id, err)
+		}
+		want, err := hmacResponse(c.Conn, k, challenge)
+		if err != nil {
+			return "", err
+		}
+		if !hmac.Equal(mac, want) {
+			return "", fmt.Errorf("bad HMAC response for key %q", id)
+		}
+		return string(id), nil
+	})
+}
+
+// HMACCredentials - return Credentials, which answer challenge of
+// HMACAuthenticator with key of given id.
+func HMACCredentials(id string, key []byte) Credentials {
+	return CredentialsFunc(func(conn *tls.Conn, codec *Codec) error {
+		challenge, err := codec.ReadMessage()
+		if err != nil {
+			return err
+		}
+		if len(challenge) != hmacChallengeSize {
+			return fmt.Errorf("malformed HMAC challenge")
+		}
+		mac, err := hmacResponse(conn, key, challenge)
+		if err != nil {
+			return err
+		}
+		if err := codec.WriteMessage([]byte(id)); err != nil {
+			return err
+		}
+		return codec.WriteMessage(mac)
+	})
+}
+
+func hmacResponse(conn *tls.Conn, key, challenge []byte) ([]byte, error) {
+	st := conn.ConnectionState()
+	binding, err := st.ExportKeyingMaterial(hmacExporterLabel, nil, sha256.Size)
+	if err != nil {
+		return nil, fmt.Errorf("channel binding: %v", err)
+	}
+	h := hmac.New(sha256.New, key)
+	h.Write(challenge)
+	h.Write(binding)
+	return h.Sum(nil), nil
+}
+
+// authCodec - codec for authentication exchange over TLS connection,
+// bypassing Conn (its Read and Write wait for handshake).
+func authCodec(conn *tls.Conn) *Codec {
+	return &Codec{r: conn, w: conn, MaxMessageSize: DefaultMaxAuthMessageSize}
+}
+
+// authenticate - run Options.Authenticator on connection with completed
+// TLS handshake and send result to client. Caller must hold handshakeMu.
+func (c *Conn) authenticate() error {
+	c.identityLocked()
+
+	codec := authCodec(c.Conn)
+	principal, err := c.server.options.Authenticator.Authenticate(c, codec)
+	if err != nil {
+		// reason is not disclosed to client
+		codec.WriteMessage([]byte{authDenied})
+		return fmt.Errorf("%w: %v", ErrAuthFailed, err)
+	}
+	if err := codec.WriteMessage([]byte{authAccepted}); err != nil {
+		return err
+	}
+	c.principal = principal
+	return nil
+}
+
+// Principal - return name of principal authenticated by
+// Options.Authenticator; "" if there is none or connection has not been
+// authenticated (yet).
+func (c *Conn) Principal() string {
+	c.handshakeMu.Lock()
+	defer c.handshakeMu.Unlock()
+	return c.principal
+}
+
+// present - run Options.Credentials on established connection and wait
+// for result of server.
+func (c *Client) present(conn *tls.Conn) error {
+	if c.options.Credentials == nil {
+		return nil
+	}
+
+	conn.SetDeadline(time.Now().Add(DefaultHandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	codec := authCodec(conn)
+	if err := c.options.Credentials.Present(conn, codec); err != nil {
+		return fmt.Errorf("%w: %v", ErrAuthFailed, err)
+	}
+	res, err := codec.ReadMessage()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrAuthFailed, err)
+	}
+	if len(res) != 1 || res[0] != authAccepted {
+		return fmt.Errorf("%w: rejected by server", ErrAuthFailed)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package herots
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// dialAuth - dial server and accept connection with HandshakeOnAccept,
+// return errors of both sides.
+func dialAuth(s *Server, c *Client) (*Conn, error, error) {
+	type accepted struct {
+		conn *Conn
+		err  error
+	}
+	done := make(chan accepted, 1)
+	go func() {
+		conn, err := s.AcceptConn()
+		done <- accepted{conn, err}
+	}()
+
+	conn, cerr := c.Dial()
+	if cerr == nil {
+		conn.Close()
+	}
+	a := <-done
+	return a.conn, a.err, cerr
+}
+
+func TestTokenAuth(t *testing.T) {
+	s, c := startTestServer(t, &Options{
+		HandshakeOnAccept: true,
+		Authenticator: TokenAuthenticator(func(token string) (string, error) {
+			if token != "secret" {
+				return "", errors.New("unknown token")
+			}
+			return "svc-a", nil
+		}),
+	})
+
+	c.options.Credentials = TokenCredentials("secret")
+	conn, serr, cerr := dialAuth(s, c)
+	if serr != nil || cerr != nil {
+		t.Fatalf("valid token refused:\nserver: %v\nclient: %v\n", serr, cerr)
+	}
+	if p := conn.Principal(); p != "svc-a" {
+		t.Fatalf("principal %q, want svc-a\n", p)
+	}
+	conn.Close()
+
+	c.options.Credentials = TokenCredentials("guess")
+	_, serr, cerr = dialAuth(s, c)
+	var herr *HandshakeError
+	if !errors.As(serr, &herr) || herr.Reason != HandshakeFailureAuth || !errors.Is(serr, ErrAuthFailed) {
+		t.Fatalf("expected auth failure on server, got %v\n", serr)
+	}
+	if !errors.Is(cerr, ErrAuthFailed) {
+		t.Fatalf("expected ErrAuthFailed on client, got %v\n", cerr)
+	}
+	if n := s.Stats().Closed[CloseReasonPolicy]; n != 1 {
+		t.Fatalf("%d connections closed by policy, want 1\n", n)
+	}
+}
+
+func TestHMACAuth(t *testing.T) {
+	keys := map[string][]byte{"node-1": []byte("k1"), "node-2": []byte("k2")}
+	s, c := startTestServer(t, &Options{
+		HandshakeOnAccept: true,
+		Authenticator: HMACAuthenticator(func(id string) ([]byte, error) {
+			k, ok := keys[id]
+			if !ok {
+				return nil, fmt.Errorf("no such key")
+			}
+			return k, nil
+		}),
+	})
+
+	c.options.Credentials = HMACCredentials("node-2", []byte("k2"))
+	conn, serr, cerr := dialAuth(s, c)
+	if serr != nil || cerr != nil {
+		t.Fatalf("valid HMAC refused:\nserver: %v\nclient: %v\n", serr, cerr)
+	}
+	if p := conn.Principal(); p != "node-2" {
+		t.Fatalf("principal %q, want node-2\n", p)
+	}
+	if s.options.TLSAuthType != tls.VerifyClientCertIfGiven {
+		t.Fatalf("client certificate required with Authenticator: %v\n", s.options.TLSAuthType)
+	}
+	conn.Close()
+
+	for _, cred := range []Credentials{
+		HMACCredentials("node-2", []byte("k1")),
+		HMACCredentials("node-3", []byte("k3")),
+	} {
+		c.options.Credentials = cred
+		_, serr, cerr = dialAuth(s, c)
+		if !errors.Is(serr, ErrAuthFailed) || !errors.Is(cerr, ErrAuthFailed) {
+			t.Fatalf("bad HMAC accepted:\nserver: %v\nclient: %v\n", serr, cerr)
+		}
+	}
+}
+
+func TestAuthWithClientCert(t *testing.T) {
+	token := TokenAuthenticator(func(token string) (string, error) { return token, nil })
+	s, c := startTestServer(t, &Options{
+		HandshakeOnAccept: true,
+		Authenticator: AuthenticatorFunc(func(conn *Conn, codec *Codec) (string, error) {
+			id, err := conn.Identity()
+			if err != nil {
+				return "", err
+			}
+			user, err := token.Authenticate(conn, codec)
+			return id.CommonName() + "/" + user, err
+		}),
+	})
+
+	c.options.Credentials = TokenCredentials("alice")
+	conn, serr, cerr := dialAuth(s, c)
+	if serr != nil || cerr != nil {
+		t.Fatalf("auth failed:\nserver: %v\nclient: %v\n", serr, cerr)
+	}
+	defer conn.Close()
+	if p := conn.Principal(); p != "herots test/alice" {
+		t.Fatalf("principal %q\n", p)
+	}
+}
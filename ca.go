@@ -0,0 +1,375 @@
+package herots
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CertProfile - issuance policy of certificates: key usages, validity and
+// subject alternative name (SAN) rules.
+type CertProfile struct {
+	Name string
+
+	KeyUsage    x509.KeyUsage
+	ExtKeyUsage []x509.ExtKeyUsage
+
+	// Validity - default lifetime of issued certificates.
+	Validity time.Duration
+	// MaxValidity - maximal lifetime, which request may ask for.
+	MaxValidity time.Duration
+
+	// RequireSAN - require at least one subject alternative name (DNS
+	// name, IP address, URI or email address).
+	RequireSAN bool
+	// NoSAN - refuse subject alternative names (identity is CN only).
+	NoSAN bool
+	// NoWildcard - refuse wildcard DNS names.
+	NoWildcard bool
+	// DNSSuffixes - if not empty, DNS names must be equal to one of
+	// suffixes or end with "." + suffix.
+	DNSSuffixes []string
+}
+
+// predefined profile names
+const (
+	ProfileServer     = "server"
+	ProfileClient     = "client"
+	ProfilePeer       = "peer"
+	ProfileShortLived = "short-lived"
+)
+
+// DefaultProfiles - return predefined profiles:
+//
+//	server      - server auth, 1 year (max 2), SAN required
+//	client      - client auth, 1 year (max 2), no SANs
+//	peer        - server and client auth, 1 year (max 2), SAN required
+//	short-lived - server and client auth, 24 hours (max 24 hours)
+//
+// Returned profiles are copies, which may be modified and passed to
+// CA.SetProfile.
+func DefaultProfiles() []*CertProfile {
+	const year = 365 * 24 * time.Hour
+	ku := x509.KeyUsageDigitalSignature
+	return []*CertProfile{
+		{
+			Name:        ProfileServer,
+			KeyUsage:    ku,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			Validity:    year,
+			MaxValidity: 2 * year,
+			RequireSAN:  true,
+		},
+		{
+			Name:        ProfileClient,
+			KeyUsage:    ku,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			Validity:    year,
+			MaxValidity: 2 * year,
+			NoSAN:       true,
+		},
+		{
+			Name:        ProfilePeer,
+			KeyUsage:    ku,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+			Validity:    year,
+			MaxValidity: 2 * year,
+			RequireSAN:  true,
+		},
+		{
+			Name:        ProfileShortLived,
+			KeyUsage:    ku,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+			Validity:    24 * time.Hour,
+			MaxValidity: 24 * time.Hour,
+		},
+	}
+}
+
+// check - check request against SAN rules of profile and return validity
+// of certificate.
+func (p *CertProfile) check(r *IssueRequest) (time.Duration, error) {
+	validity := p.Validity
+	if r.Validity > 0 {
+		validity = r.Validity
+	}
+	if p.MaxValidity > 0 && validity > p.MaxValidity {
+		return 0, fmt.Errorf("validity %v exceeds %v allowed by profile %q", validity, p.MaxValidity, p.Name)
+	}
+	if validity <= 0 {
+		return 0, fmt.Errorf("profile %q has no validity", p.Name)
+	}
+
+	if err := r.checkSANs(); err != nil {
+		return 0, err
+	}
+	sans := r.sanCount()
+	switch {
+	case p.NoSAN && sans > 0:
+		return 0, fmt.Errorf("profile %q doesn't allow SANs", p.Name)
+	case p.RequireSAN && sans == 0:
+		return 0, fmt.Errorf("profile %q requires subject alternative name", p.Name)
+	}
+	for _, name := range r.DNSNames {
+		if p.NoWildcard && strings.HasPrefix(name, "*.") {
+			return 0, fmt.Errorf("wildcard DNS name %q not allowed by profile %q", name, p.Name)
+		}
+		if !dnsSuffixAllowed(name, p.DNSSuffixes) {
+			return 0, fmt.Errorf("DNS name %q not allowed by profile %q", name, p.Name)
+		}
+	}
+	return validity, nil
+}
+
+func dnsSuffixAllowed(name string, suffixes []string) bool {
+	if len(suffixes) == 0 {
+		return true
+	}
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for _, suffix := range suffixes {
+		suffix = strings.ToLower(strings.TrimSuffix(suffix, "."))
+		if name == suffix || strings.HasSuffix(name, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// IssueRequest - structure, which describes certificate to issue with
+// CA.Issue.
+type IssueRequest struct {
+	// Profile - name of profile of CA.
+	//
+	// Default: ProfilePeer.
+	Profile string
+
+	CommonName string
+
+	// Subject alternative names. DNS names may be wildcards
+	// ("*.nodes.example.com", leftmost label only); URIs must be absolute
+	// without userinfo (e.g. "spiffe://swarm/agent/1"); email addresses
+	// must be bare addresses. Invalid names fail Issue.
+	DNSNames       []string
+	IPAddresses    []net.IP
+	URIs           []*url.URL
+	EmailAddresses []string
+
+	// SANs - names of any type, classified as by ParseSANs and added to
+	// typed lists above.
+	SANs []string
+
+	// PublicKey - key to certify.
+	//
+	// Default: nil (new ECDSA P-256 key is generated and returned).
+	PublicKey crypto.PublicKey
+
+	// Validity - lifetime of certificate, up to MaxValidity of profile
+	// (and not beyond expiration of CA).
+	//
+	// Default: Validity of profile.
+	Validity time.Duration
+}
+
+// CA - certificate authority, which issues certificates by profiles, so
+// PKI of a swarm follows one policy. CA is safe for concurrent use.
+type CA struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+
+	mu       sync.Mutex
+	profiles map[string]*CertProfile
+}
+
+// NewCA - function for create CA with new self-signed root certificate
+// (ECDSA P-256 key).
+func NewCA(cn string, validity time.Duration) (*CA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("create CA error: %v\n", err)
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, fmt.Errorf("create CA error: %v\n", err)
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(validity),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, fmt.Errorf("create CA error: %v\n", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("create CA error: %v\n", err)
+	}
+	return newCA(cert, key), nil
+}
+
+// LoadCA - function for load CA from PEM encoded certificate and private
+// key.
+func LoadCA(cert, key []byte) (*CA, error) {
+	c, leaf, err := loadKeyPair(cert, key)
+	if err != nil {
+		return nil, fmt.Errorf("load CA error: %v\n", err)
+	}
+	if !leaf.IsCA {
+		return nil, fmt.Errorf("load CA error: %q is not CA certificate\n", leaf.Subject)
+	}
+	signer, ok := c.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("load CA error: unsupported private key\n")
+	}
+	return newCA(leaf, signer), nil
+}
+
+func newCA(cert *x509.Certificate, key crypto.Signer) *CA {
+	ca := &CA{cert: cert, key: key, profiles: make(map[string]*CertProfile)}
+	for _, p := range DefaultProfiles() {
+		ca.profiles[p.Name] = p
+	}
+	return ca
+}
+
+// Certificate - return PEM encoded certificate of CA, to be trusted by
+// peers (see Server.AddClientCACert and Client.AddCertToRootCA).
+func (ca *CA) Certificate() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+}
+
+// PrivateKey - return PEM encoded private key of CA, to store CA for
+// LoadCA.
+func (ca *CA) PrivateKey() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(ca.key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// SetProfile - add profile or replace profile with the same name.
+func (ca *CA) SetProfile(p *CertProfile) {
+	cp := *p
+	ca.mu.Lock()
+	ca.profiles[p.Name] = &cp
+	ca.mu.Unlock()
+}
+
+// Profiles - return sorted names of profiles.
+func (ca *CA) Profiles() []string {
+	ca.mu.Lock()
+	names := make([]string, 0, len(ca.profiles))
+	for name := range ca.profiles {
+		names = append(names, name)
+	}
+	ca.mu.Unlock()
+
+	sort.Strings(names)
+	return names
+}
+
+// Issue - issue certificate by request. Returned certificate is PEM
+// encoded; key is PEM encoded generated private key, nil if request has
+// PublicKey.
+func (ca *CA) Issue(r *IssueRequest) (cert, key []byte, err error) {
+	if len(r.SANs) > 0 {
+		dns, ips, uris, emails, err := ParseSANs(r.SANs...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("issue cert error: %v\n", err)
+		}
+		merged := *r
+		merged.SANs = nil
+		merged.DNSNames = append(dns, r.DNSNames...)
+		merged.IPAddresses = append(ips, r.IPAddresses...)
+		merged.URIs = append(uris, r.URIs...)
+		merged.EmailAddresses = append(emails, r.EmailAddresses...)
+		r = &merged
+	}
+
+	name := r.Profile
+	if name == "" {
+		name = ProfilePeer
+	}
+	ca.mu.Lock()
+	p := ca.profiles[name]
+	ca.mu.Unlock()
+	if p == nil {
+		return nil, nil, fmt.Errorf("issue cert error: unknown profile %q\n", name)
+	}
+
+	validity, err := p.check(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("issue cert error: %v\n", err)
+	}
+
+	pub := r.PublicKey
+	var priv crypto.Signer
+	if pub == nil {
+		if priv, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			return nil, nil, fmt.Errorf("issue cert error: %v\n", err)
+		}
+		pub = priv.Public()
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, fmt.Errorf("issue cert error: %v\n", err)
+	}
+	now := time.Now()
+	notAfter := now.Add(validity)
+	if notAfter.After(ca.cert.NotAfter) {
+		notAfter = ca.cert.NotAfter
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: r.CommonName},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              notAfter,
+		BasicConstraintsValid: true,
+		KeyUsage:              p.KeyUsage,
+		ExtKeyUsage:           p.ExtKeyUsage,
+		DNSNames:              r.DNSNames,
+		IPAddresses:           r.IPAddresses,
+		URIs:                  r.URIs,
+		EmailAddresses:        r.EmailAddresses,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, pub, ca.key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("issue cert error: %v\n", err)
+	}
+	cert = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	if priv != nil {
+		keyDer, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			return nil, nil, fmt.Errorf("issue cert error: %v\n", err)
+		}
+		key = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})
+	}
+	return cert, key, nil
+}
+
+// randomSerial - random 128 bit certificate serial number.
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
@@ -0,0 +1,150 @@
+package herots
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultCaptureLimit - default limit of captured bytes per direction of
+// connection.
+const DefaultCaptureLimit = 1 << 20
+
+// CaptureDirection - direction of captured traffic.
+type CaptureDirection int
+
+// predefined CaptureDirection values
+const (
+	CaptureRead CaptureDirection = iota
+	CaptureWrite
+)
+
+func (d CaptureDirection) String() string {
+	if d == CaptureWrite {
+		return "write"
+	}
+	return "read"
+}
+
+// CaptureOptions - structure, which is used to configure traffic capture
+// (decrypted application data) of connection.
+type CaptureOptions struct {
+	// Writer receives capture of all connections captured with these
+	// options (records are not interleaved). If Writer is nil, capture of
+	// each connection is written to own file conn-<id>.cap in Dir.
+	Writer io.Writer
+	Dir    string
+
+	// Limit - how many bytes to capture in each direction, after which
+	// capture of connection stops.
+	//
+	// Default: DefaultCaptureLimit.
+	Limit int64
+
+	// Redact is called for each chunk of data before it is written;
+	// returned data is captured instead (e.g. with secrets masked).
+	// Data must not be modified in place.
+	Redact func(c *Conn, dir CaptureDirection, data []byte) []byte
+
+	// shared - Writer wrapped on first StartCapture
+	sharedOnce sync.Once
+	shared     *captureWriter
+}
+
+// captureWriter - writer of capture records, which are written whole.
+type captureWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (cw *captureWriter) writeRecord(rec string) {
+	cw.mu.Lock()
+	io.WriteString(cw.w, rec)
+	cw.mu.Unlock()
+}
+
+// capture - active capture of connection.
+type capture struct {
+	o      *CaptureOptions
+	w      *captureWriter
+	closer io.Closer
+
+	mu    sync.Mutex
+	count [2]int64
+}
+
+// StartCapture - start capturing traffic of connection. Capture started
+// earlier is stopped.
+func (c *Conn) StartCapture(o *CaptureOptions) error {
+	cp := &capture{o: o}
+
+	if o.Writer != nil {
+		o.sharedOnce.Do(func() { o.shared = &captureWriter{w: o.Writer} })
+		cp.w = o.shared
+	} else {
+		name := filepath.Join(o.Dir, "conn-"+strconv.FormatUint(c.id, 10)+".cap")
+		f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return fmt.Errorf("start capture fail: %v\n", err)
+		}
+		cp.w, cp.closer = &captureWriter{w: f}, f
+	}
+
+	if old := c.capture.Swap(cp); old != nil {
+		old.close()
+	}
+	c.server.logger.Log("capture started for "+c.String(), LogLevelInfo)
+
+	return nil
+}
+
+// StopCapture - stop capturing traffic of connection.
+func (c *Conn) StopCapture() {
+	if cp := c.capture.Swap(nil); cp != nil {
+		cp.close()
+	}
+}
+
+// record - write chunk of traffic to capture.
+func (cp *capture) record(c *Conn, dir CaptureDirection, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+
+	limit := cp.o.Limit
+	if limit <= 0 {
+		limit = DefaultCaptureLimit
+	}
+
+	cp.mu.Lock()
+	left := limit - cp.count[dir]
+	if left <= 0 {
+		cp.mu.Unlock()
+		return
+	}
+	if int64(len(data)) > left {
+		data = data[:left]
+	}
+	cp.count[dir] += int64(len(data))
+	cp.mu.Unlock()
+
+	if cp.o.Redact != nil {
+		data = cp.o.Redact(c, dir, data)
+	}
+
+	rec := fmt.Sprintf("# %s conn #%d %s %s %d bytes\n%s",
+		time.Now().Format(time.RFC3339Nano), c.id, c.RemoteAddr(), dir, len(data), hex.Dump(data))
+
+	cp.w.writeRecord(rec)
+}
+
+func (cp *capture) close() {
+	if cp.closer != nil {
+		cp.closer.Close()
+	}
+}
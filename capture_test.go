@@ -0,0 +1,135 @@
+package herots
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
+func TestCapture(t *testing.T) {
+	var out syncBuffer
+	s, c := startTestServer(t, &Options{
+		Capture: &CaptureOptions{
+			Writer: &out,
+			Limit:  12,
+			Redact: func(c *Conn, dir CaptureDirection, data []byte) []byte {
+				return bytes.ReplaceAll(data, []byte("secret"), []byte("******"))
+			},
+		},
+	})
+
+	go func() {
+		conn, err := c.Dial()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("token=secret and more data"))
+		conn.Read(make([]byte, 16))
+	}()
+
+	conn, err := s.AcceptConn()
+	if err != nil {
+		t.Fatalf("accept:\n%v\n", err)
+	}
+	buf := make([]byte, 64)
+	n, _ := conn.Read(buf)
+	conn.Write([]byte("ok"))
+	conn.Close()
+
+	if n == 0 {
+		t.Fatalf("nothing read\n")
+	}
+	got := out.String()
+	if !strings.Contains(got, "read 12 bytes") || !strings.Contains(got, "write 2 bytes") {
+		t.Fatalf("unexpected capture:\n%s\n", got)
+	}
+	if strings.Contains(got, "secret") || !strings.Contains(got, "******") {
+		t.Fatalf("capture not redacted:\n%s\n", got)
+	}
+}
+
+func TestCaptureDir(t *testing.T) {
+	dir := t.TempDir()
+	s, c := startTestServer(t, &Options{})
+
+	go func() {
+		conn, err := c.Dial()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("hello"))
+	}()
+
+	conn, err := s.AcceptConn()
+	if err != nil {
+		t.Fatalf("accept:\n%v\n", err)
+	}
+	if err := conn.StartCapture(&CaptureOptions{Dir: dir}); err != nil {
+		t.Fatalf("start capture:\n%v\n", err)
+	}
+	conn.Read(make([]byte, 16))
+	conn.Close()
+
+	data, err := os.ReadFile(filepath.Join(dir, "conn-1.cap"))
+	if err != nil {
+		t.Fatalf("capture file:\n%v\n", err)
+	}
+	if !strings.Contains(string(data), "hello") {
+		t.Fatalf("unexpected capture file:\n%s\n", data)
+	}
+}
+
+// funcWriter - writer of non-comparable type.
+type funcWriter func(p []byte) (int, error)
+
+func (f funcWriter) Write(p []byte) (int, error) { return f(p) }
+
+func TestCaptureFuncWriter(t *testing.T) {
+	var out syncBuffer
+	s, c := startTestServer(t, &Options{})
+
+	go func() {
+		conn, err := c.Dial()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("hello"))
+	}()
+
+	conn, err := s.AcceptConn()
+	if err != nil {
+		t.Fatalf("accept:\n%v\n", err)
+	}
+	if err := conn.StartCapture(&CaptureOptions{Writer: funcWriter(out.Write)}); err != nil {
+		t.Fatalf("start capture:\n%v\n", err)
+	}
+	conn.Read(make([]byte, 16))
+	conn.Close()
+
+	if !strings.Contains(out.String(), "read 5 bytes") {
+		t.Fatalf("unexpected capture:\n%s\n", out.String())
+	}
+}
@@ -0,0 +1,142 @@
+package herots
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// ErrClientCANotFound - returned by RemoveClientCACert when no trusted
+// client CA has given fingerprint.
+var ErrClientCANotFound = errors.New("client CA cert not found")
+
+// Client CA pool is copy-on-write: every change builds a new list and pool
+// and a new per-handshake TLS config around it, which getConfigForClient
+// hands to crypto/tls. Handshakes in progress keep the pool they started
+// with. The same config carries current OCSP staples (see ocsp.go).
+//
+// Per-handshake config is a clone of Server.tlsConfig without its own
+// session ticket keys, so crypto/tls keeps using keys of shared config and
+// session resumption survives pool changes.
+
+// setTLSConfig - install config built by Start and derive per-handshake
+// config from it.
+func (s *Server) setTLSConfig(config *tls.Config) {
+	s.certMu.Lock()
+	defer s.certMu.Unlock()
+
+	s.tlsConfig = config
+	s.publishClientCAsLocked(s.clientCAs.Load())
+}
+
+// RemoveClientCACert - function for removing client CA certificate with
+// given SHA-256 fingerprint (see FingerprintSHA256) from trusted pool.
+//
+// Like AddClientCACert, it may be called on running server; handshakes
+// started after it returns no longer trust removed CA. Established
+// connections are not affected.
+func (s *Server) RemoveClientCACert(fingerprint []byte) error {
+	s.certMu.Lock()
+	defer s.certMu.Unlock()
+
+	kept := make([]*x509.Certificate, 0, len(s.clientCAList))
+	for _, ca := range s.clientCAList {
+		if !FingerprintEqual(FingerprintSHA256(ca), fingerprint) {
+			kept = append(kept, ca)
+		}
+	}
+	if len(kept) == len(s.clientCAList) {
+		return ErrClientCANotFound
+	}
+	s.setClientCAsLocked(kept)
+
+	s.logger.Log("remove client CA cert - ok", LogLevelInfo)
+
+	return nil
+}
+
+// ReplaceClientCAPool - function for replacing whole set of trusted client
+// CA certificates, including one added by LoadKeyPair.
+//
+// Each element of certs is PEM encoded data with one or more certificates.
+// If any of them can't be parsed, current pool is left unchanged.
+func (s *Server) ReplaceClientCAPool(certs [][]byte) error {
+	var list []*x509.Certificate
+	for _, data := range certs {
+		cas, err := parseCACerts(data)
+		if err != nil {
+			return fmt.Errorf("replace client CA pool error: %v\n", err)
+		}
+		list = append(list, cas...)
+	}
+
+	s.certMu.Lock()
+	s.setClientCAsLocked(list)
+	s.certMu.Unlock()
+
+	s.logger.Log(fmt.Sprintf("replace client CA pool - ok (%d certs)", len(list)), LogLevelInfo)
+
+	return nil
+}
+
+// parseCACerts - parse all PEM encoded certificates from data.
+func parseCACerts(data []byte) ([]*x509.Certificate, error) {
+	var list []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		ca, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, ca)
+	}
+	if len(list) == 0 {
+		return nil, errors.New("no PEM data")
+	}
+	return list, nil
+}
+
+// addClientCA - add CA to copy of current list and publish it.
+func (s *Server) addClientCA(ca *x509.Certificate) {
+	s.certMu.Lock()
+	defer s.certMu.Unlock()
+
+	s.addClientCALocked(ca)
+}
+
+func (s *Server) addClientCALocked(ca *x509.Certificate) {
+	list := make([]*x509.Certificate, 0, len(s.clientCAList)+1)
+	list = append(list, s.clientCAList...)
+	s.setClientCAsLocked(append(list, ca))
+}
+
+// setClientCAsLocked - build pool from list and publish it. List must not
+// be modified afterwards.
+func (s *Server) setClientCAsLocked(list []*x509.Certificate) {
+	pool := x509.NewCertPool()
+	for _, ca := range list {
+		pool.AddCert(ca)
+	}
+	s.clientCAList = list
+	s.publishClientCAsLocked(pool)
+}
+
+func (s *Server) publishClientCAsLocked(pool *x509.CertPool) {
+	s.clientCAs.Store(pool)
+	if s.tlsConfig == nil {
+		// not started, Start picks pool up
+		return
+	}
+
+	config := s.tlsConfig.Clone()
+	config.Certificates = s.certificates()
+	config.ClientCAs = pool
+	config.GetConfigForClient = nil
+	s.clientConfig.Store(config)
+}
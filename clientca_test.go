@@ -0,0 +1,94 @@
+package herots
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+)
+
+// newcomerClient - return client with fresh key pair for cn, trusting s,
+// but not trusted by s.
+func newcomerClient(t *testing.T, s *Server, cn string) (*Client, []byte) {
+	serverCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.certs.Cert.Certificate[0]})
+	cert, key := genKeyPair(t, cn)
+	c := NewClient(&Options{Host: s.options.Host, Port: s.options.Port})
+	c.LoadKeyPair(cert, key)
+	c.AddCertToRootCA(serverCert)
+	return c, cert
+}
+
+// dialAccepted - dial s with c and return result of server side handshake.
+func dialAccepted(s *Server, c *Client) error {
+	done := make(chan error, 1)
+	go func() {
+		conn, err := s.AcceptConn()
+		if err == nil {
+			err = conn.Handshake()
+			conn.Close()
+		}
+		done <- err
+	}()
+	if conn, err := c.Dial(); err == nil {
+		// TLS 1.3 client learns about rejected cert on first read
+		conn.Read(make([]byte, 1))
+		conn.Close()
+	}
+	return <-done
+}
+
+func TestAddClientCACertRunning(t *testing.T) {
+	s, _ := startTestServer(t, &Options{TLSAuthType: tls.RequireAndVerifyClientCert})
+	c, cert := newcomerClient(t, s, "newcomer")
+
+	if err := dialAccepted(s, c); err == nil {
+		t.Fatalf("client with unknown CA accepted\n")
+	}
+
+	if err := s.AddClientCACert(cert); err != nil {
+		t.Fatalf("add client CA:\n%v\n", err)
+	}
+	if err := dialAccepted(s, c); err != nil {
+		t.Fatalf("client CA added on running server not trusted:\n%v\n", err)
+	}
+}
+
+func TestRemoveClientCACert(t *testing.T) {
+	s, _ := startTestServer(t, &Options{TLSAuthType: tls.RequireAndVerifyClientCert})
+	c, cert := newcomerClient(t, s, "newcomer")
+	s.AddClientCACert(cert)
+
+	block, _ := pem.Decode(cert)
+	ca, _ := x509.ParseCertificate(block.Bytes)
+	fp := FingerprintSHA256(ca)
+
+	if err := s.RemoveClientCACert(fp); err != nil {
+		t.Fatalf("remove client CA:\n%v\n", err)
+	}
+	if err := dialAccepted(s, c); err == nil {
+		t.Fatalf("client with removed CA accepted\n")
+	}
+	if err := s.RemoveClientCACert(fp); !errors.Is(err, ErrClientCANotFound) {
+		t.Fatalf("expected ErrClientCANotFound, got %v\n", err)
+	}
+}
+
+func TestReplaceClientCAPool(t *testing.T) {
+	s, own := startTestServer(t, &Options{TLSAuthType: tls.RequireAndVerifyClientCert})
+	c, cert := newcomerClient(t, s, "newcomer")
+
+	if err := s.ReplaceClientCAPool([][]byte{[]byte("garbage")}); err == nil {
+		t.Fatalf("garbage accepted as CA pool\n")
+	}
+	if err := s.ReplaceClientCAPool([][]byte{cert}); err != nil {
+		t.Fatalf("replace client CA pool:\n%v\n", err)
+	}
+	if err := dialAccepted(s, c); err != nil {
+		t.Fatalf("client with new CA not trusted:\n%v\n", err)
+	}
+	// CA of server key pair is replaced too
+	if err := dialAccepted(s, own); err == nil {
+		t.Fatalf("client with replaced CA accepted\n")
+	}
+}
@@ -0,0 +1,63 @@
+package herots
+
+import (
+	"time"
+)
+
+// Clock - source of time for time-dependent behavior of Server, Client
+// and RPC: certificate expiry and OCSP checks, session ticket key
+// rotation and ticket lifetime (via tls.Config.Time), connection max age,
+// shutdown grace period, RPC and herald timeouts, reverse DNS cache and
+// periodic reloads.
+//
+// Real time is always used for:
+//   - network deadlines (handshake and I/O timeouts, Conn.SetTimeout), as
+//     they are enforced by operating system;
+//   - rate limiting (waits of rate limiters are bounded by deadlines);
+//   - validity of certificates issued by CA, as peers check it against
+//     real time;
+//   - measured durations (KeySourceStats, sign pool stats, honeypot
+//     observations), timestamps of captures, and retry backoff.
+//
+// Fake implementation for tests is herotstest.FakeClock. Clock uses only
+// standard library types, so it may be implemented without importing
+// herots.
+type Clock interface {
+	// Now - return current time.
+	Now() time.Time
+
+	// AfterFunc - call f after d: in its own goroutine (real clock) or
+	// synchronously from code, which moves time forward (fake clocks, see
+	// herotstest.FakeClock.Advance), so f must not wait for that code.
+	// Returned stop function cancels call, reporting whether it was
+	// cancelled before f started.
+	AfterFunc(d time.Duration, f func()) (stop func() bool)
+
+	// NewTicker - return channel, which gets time every d, as
+	// time.Ticker does, and function to stop ticker.
+	NewTicker(d time.Duration) (c <-chan time.Time, stop func())
+}
+
+// realClock - Clock of time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) func() bool {
+	return time.AfterFunc(d, f).Stop
+}
+
+func (realClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	t := time.NewTicker(d)
+	return t.C, t.Stop
+}
+
+// clockOrReal - return c, or real clock if c is nil.
+func clockOrReal(c Clock) Clock {
+	if c == nil {
+		return realClock{}
+	}
+	return c
+}
@@ -0,0 +1,149 @@
+package herots
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sort"
+)
+
+// CloseReason - why connection ended, as reported to Options.OnClose, in
+// log and in ServerStats.Closed.
+type CloseReason string
+
+// predefined CloseReason values
+const (
+	// closed by application without error (see Conn.CloseWithError)
+	CloseReasonLocal CloseReason = "local"
+	// application handler failed, see Conn.CloseWithError
+	CloseReasonHandlerError CloseReason = "handler-error"
+	// peer closed connection (EOF)
+	CloseReasonPeer CloseReason = "peer"
+	// peer reset connection
+	CloseReasonReset CloseReason = "reset"
+	// read or write deadline (idle timeout) exceeded
+	CloseReasonTimeout CloseReason = "timeout"
+	// other network error
+	CloseReasonNetwork CloseReason = "network"
+	// TLS handshake failed (bad or missing certificate, protocol)
+	CloseReasonHandshake CloseReason = "handshake"
+	// rejected by policy: admission rules, hello hook, admission queue
+	// shedding, herald re-registration
+	CloseReasonPolicy CloseReason = "policy"
+	// server shutdown
+	CloseReasonShutdown CloseReason = "shutdown"
+	// Options.MaxConnectionAge reached
+	CloseReasonMaxAge CloseReason = "max-age"
+)
+
+// closeCause - reason of connection end with error, which caused it.
+type closeCause struct {
+	reason CloseReason
+	err    error
+}
+
+// setCause - record reason of coming close, unless it is already
+// recorded: first explicit cause wins.
+func (c *Conn) setCause(reason CloseReason, err error) {
+	c.cause.CompareAndSwap(nil, &closeCause{reason: reason, err: err})
+}
+
+// noteIOErr - remember last I/O error, to derive close reason if no
+// explicit cause is recorded.
+func (c *Conn) noteIOErr(err error) {
+	if err != nil {
+		c.ioErr.Store(&err)
+	}
+}
+
+// closeWith - close connection for given reason.
+func (c *Conn) closeWith(reason CloseReason, err error) error {
+	c.setCause(reason, err)
+	return c.Close()
+}
+
+// CloseWithError - close connection, reporting err as reason (see
+// Options.OnClose): CloseReasonHandlerError, or CloseReasonLocal if err is
+// nil.
+func (c *Conn) CloseWithError(err error) error {
+	if err == nil {
+		return c.closeWith(CloseReasonLocal, nil)
+	}
+	return c.closeWith(CloseReasonHandlerError, err)
+}
+
+// CloseReason - return why connection ended and error, which caused it;
+// empty reason while connection is open.
+func (c *Conn) CloseReason() (CloseReason, error) {
+	if cc := c.closedBy.Load(); cc != nil {
+		return cc.reason, cc.err
+	}
+	return "", nil
+}
+
+// resolveCause - final reason of closing connection.
+func (c *Conn) resolveCause() *closeCause {
+	if cc := c.cause.Load(); cc != nil {
+		return cc
+	}
+	if p := c.ioErr.Load(); p != nil {
+		return &closeCause{reason: ioCloseReason(*p), err: *p}
+	}
+	return &closeCause{reason: CloseReasonLocal}
+}
+
+// ioCloseReason - classify I/O error of connection.
+func ioCloseReason(err error) CloseReason {
+	var nerr net.Error
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return CloseReasonPeer
+	case isConnReset(err):
+		return CloseReasonReset
+	case errors.As(err, &nerr) && nerr.Timeout():
+		return CloseReasonTimeout
+	}
+	return CloseReasonNetwork
+}
+
+// handshakeCloseReason - close reason of failed handshake.
+func handshakeCloseReason(herr *HandshakeError) CloseReason {
+	switch herr.Reason {
+	case HandshakeFailureAdmission, HandshakeFailureRejected, HandshakeFailureLimit, HandshakeFailureAuth,
+		HandshakeFailureQuota:
+		return CloseReasonPolicy
+	case HandshakeFailureNetwork:
+		return ioCloseReason(herr.Err)
+	}
+	return CloseReasonHandshake
+}
+
+// countClose - count closed connection in stats.
+func (st *serverStats) countClose(reason CloseReason) {
+	st.closedMu.Lock()
+	defer st.closedMu.Unlock()
+	if st.closed == nil {
+		st.closed = make(map[CloseReason]int64)
+	}
+	st.closed[reason]++
+}
+
+func (st *serverStats) closedByReason() map[CloseReason]int64 {
+	st.closedMu.Lock()
+	defer st.closedMu.Unlock()
+	m := make(map[CloseReason]int64, len(st.closed))
+	for reason, n := range st.closed {
+		m[reason] = n
+	}
+	return m
+}
+
+// closeReasons - sorted reasons of map, for stable output.
+func closeReasons(m map[CloseReason]int64) []CloseReason {
+	reasons := make([]CloseReason, 0, len(m))
+	for reason := range m {
+		reasons = append(reasons, reason)
+	}
+	sort.Slice(reasons, func(i, j int) bool { return reasons[i] < reasons[j] })
+	return reasons
+}
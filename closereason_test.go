@@ -0,0 +1,128 @@
+package herots
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCloseReason(t *testing.T) {
+	var mu sync.Mutex
+	reasons := make(map[uint64]CloseReason)
+	closed := make(chan struct{}, 8)
+	s, c := startTestServer(t, &Options{
+		OnClose: func(c *Conn, reason CloseReason, err error) {
+			mu.Lock()
+			reasons[c.ID()] = reason
+			mu.Unlock()
+			closed <- struct{}{}
+		},
+	})
+
+	accept := func(client func()) *Conn {
+		go client()
+		conn, err := s.AcceptConn()
+		if err != nil {
+			t.Fatalf("accept:\n%v\n", err)
+		}
+		return conn
+	}
+
+	// peer closes
+	conn := accept(func() {
+		if conn, err := c.Dial(); err == nil {
+			conn.Close()
+		}
+	})
+	conn.Read(make([]byte, 1))
+	conn.Close()
+	<-closed
+
+	// idle timeout
+	done := make(chan struct{})
+	conn2 := accept(func() {
+		if conn, err := c.Dial(); err == nil {
+			<-done
+			conn.Close()
+		}
+	})
+	conn2.SetReadTimeout(10 * time.Millisecond)
+	conn2.Read(make([]byte, 1))
+	conn2.Close()
+	<-closed
+
+	// handler error
+	conn3 := accept(func() {
+		if conn, err := c.Dial(); err == nil {
+			<-done
+			conn.Close()
+		}
+	})
+	errBad := errors.New("bad request")
+	conn3.Handshake()
+	conn3.CloseWithError(errBad)
+	<-closed
+	close(done)
+
+	if reason, err := conn3.CloseReason(); reason != CloseReasonHandlerError || err != errBad {
+		t.Fatalf("unexpected close reason of conn3: %q, %v\n", reason, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for id, want := range map[uint64]CloseReason{
+		conn.ID():  CloseReasonPeer,
+		conn2.ID(): CloseReasonTimeout,
+		conn3.ID(): CloseReasonHandlerError,
+	} {
+		if reasons[id] != want {
+			t.Fatalf("conn %d closed with %q, want %q\n", id, reasons[id], want)
+		}
+	}
+
+	st := s.Stats()
+	if st.Closed[CloseReasonPeer] != 1 || st.Closed[CloseReasonTimeout] != 1 || st.Closed[CloseReasonHandlerError] != 1 {
+		t.Fatalf("unexpected close stats: %v\n", st.Closed)
+	}
+}
+
+func TestCloseReasonPolicy(t *testing.T) {
+	s, c := startTestServer(t, &Options{
+		Admission: func(addr net.Addr) error { return errors.New("not today") },
+	})
+	go func() {
+		if conn, err := c.Dial(); err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := s.AcceptConn()
+	if err != nil {
+		t.Fatalf("accept:\n%v\n", err)
+	}
+	conn.Handshake()
+	conn.Close()
+	if reason, _ := conn.CloseReason(); reason != CloseReasonPolicy {
+		t.Fatalf("rejected conn closed with %q\n", reason)
+	}
+}
+
+func TestCloseReasonShutdown(t *testing.T) {
+	s, c := startTestServer(t, &Options{})
+	go func() {
+		if conn, err := c.Dial(); err == nil {
+			conn.Read(make([]byte, 1))
+			conn.Close()
+		}
+	}()
+	conn, err := s.AcceptConn()
+	if err != nil {
+		t.Fatalf("accept:\n%v\n", err)
+	}
+	conn.Handshake()
+	s.Shutdown()
+	if reason, _ := conn.CloseReason(); reason != CloseReasonShutdown {
+		t.Fatalf("conn closed by shutdown with %q\n", reason)
+	}
+}
@@ -0,0 +1,102 @@
+package herots
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"fmt"
+	"io"
+)
+
+// CompressionProtocol - ALPN protocol, which peers offer when
+// Options.Compression is set. Codec created over connection, which
+// negotiated it, compresses messages.
+const CompressionProtocol = "herots-gzip"
+
+// DefaultCompressionThreshold - default size of smallest message payload,
+// which is compressed.
+const DefaultCompressionThreshold = 1 << 10
+
+// frameCompressed - flag in frame length, marking gzip compressed payload.
+const frameCompressed = 1 << 31
+
+// CompressionOptions - structure, which is used to configure per-message
+// compression of Codec.
+//
+// Only gzip is supported: zstd has no implementation in the standard
+// library.
+type CompressionOptions struct {
+	// Threshold - smaller payloads are sent as is.
+	//
+	// Default: DefaultCompressionThreshold (1 KiB).
+	Threshold int
+
+	// Level - gzip compression level.
+	//
+	// Default: gzip.DefaultCompression.
+	Level int
+}
+
+// negotiatedCompression - report whether connection negotiated
+// CompressionProtocol. Runs handshake of *Conn, if needed.
+func negotiatedCompression(rw io.ReadWriter) bool {
+	var st tls.ConnectionState
+	switch c := rw.(type) {
+	case *Conn:
+		if c.Handshake() != nil {
+			return false
+		}
+		st = c.ConnectionState()
+	case *tls.Conn:
+		if c.Handshake() != nil {
+			return false
+		}
+		st = c.ConnectionState()
+	default:
+		return false
+	}
+	return st.NegotiatedProtocol == CompressionProtocol
+}
+
+// compress - gzip msg; ok is false if it isn't worth it.
+func (o *CompressionOptions) compress(msg []byte) ([]byte, bool) {
+	threshold := o.Threshold
+	if threshold <= 0 {
+		threshold = DefaultCompressionThreshold
+	}
+	if len(msg) < threshold {
+		return nil, false
+	}
+
+	level := o.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, false
+	}
+	w.Write(msg)
+	if w.Close() != nil || buf.Len() >= len(msg) {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+// decompress - gunzip payload, refusing results over max bytes.
+func decompress(payload []byte, max int) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("decompress message fail: %w\n", err)
+	}
+	msg, err := io.ReadAll(io.LimitReader(r, int64(max)+1))
+	if err != nil {
+		return nil, fmt.Errorf("decompress message fail: %w\n", err)
+	}
+	if len(msg) > max {
+		return nil, ErrMessageTooLarge
+	}
+	return msg, nil
+}
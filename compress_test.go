@@ -0,0 +1,86 @@
+package herots
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+// countingConn - net.Conn counting written bytes.
+type countingConn struct {
+	net.Conn
+	n int
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	c.n += len(p)
+	return c.Conn.Write(p)
+}
+
+func TestCodecCompression(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	cw := &countingConn{Conn: a}
+	w := NewCodec(cw)
+	w.Compression = &CompressionOptions{}
+	r := NewCodec(b)
+
+	big := []byte(strings.Repeat("herald of the swarm ", 1000))
+	small := []byte("ping")
+	go func() {
+		w.WriteMessage(big)
+		w.WriteMessage(small)
+	}()
+
+	for _, want := range [][]byte{big, small} {
+		msg, err := r.ReadMessage()
+		if err != nil || !bytes.Equal(msg, want) {
+			t.Fatalf("unexpected message (%d bytes): %v\n", len(msg), err)
+		}
+	}
+	if cw.n >= len(big) {
+		t.Fatalf("message not compressed: %d bytes written\n", cw.n)
+	}
+
+	// decompressed size is limited too
+	r.MaxMessageSize = 1000
+	go w.WriteMessage(big[:1000+1])
+	if _, err := r.ReadMessage(); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("expected ErrMessageTooLarge, got %v\n", err)
+	}
+}
+
+func TestCompressionNegotiation(t *testing.T) {
+	for _, tc := range []struct {
+		server, client, want bool
+	}{
+		{true, true, true},
+		{true, false, false},
+		{false, true, false},
+	} {
+		s, _ := startTestServer(t, &Options{Compression: tc.server})
+		c, cert := newcomerClient(t, s, "peer")
+		c.options.Compression = tc.client
+		s.AddClientCACert(cert)
+
+		go func() {
+			if conn, err := c.Dial(); err == nil {
+				NewCodec(conn)
+				conn.Close()
+			}
+		}()
+		conn, err := s.AcceptConn()
+		if err != nil {
+			t.Fatalf("accept:\n%v\n", err)
+		}
+		codec := NewCodec(conn)
+		conn.Close()
+		if got := codec.Compression != nil; got != tc.want {
+			t.Fatalf("server %v, client %v: compression %v\n", tc.server, tc.client, got)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package herots
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// TestServerConcurrentUse - run with -race.
+func TestServerConcurrentUse(t *testing.T) {
+	cert, key := genKeyPair(t, "herots test")
+	o := &Options{Host: "127.0.0.1", Port: freePort(t)}
+	s := NewServer(o)
+	if err := s.LoadKeyPair(cert, key); err != nil {
+		t.Fatalf("load key pair:\n%v\n", err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	var (
+		wg      sync.WaitGroup
+		started atomic.Int32
+	)
+	for i := 0; i < 4; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			if err := s.Start(); err == nil {
+				started.Add(1)
+			} else if !strings.Contains(err.Error(), "already started") {
+				t.Errorf("start:\n%v\n", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			ca, _ := genKeyPair(t, "ca")
+			s.AddClientCACert(ca)
+			s.LoadKeyPair(cert, key)
+		}()
+		go func() {
+			defer wg.Done()
+			rsaCert, rsaKey := genRSAKeyPair(t, "herots test")
+			s.AddKeyPair(rsaCert, rsaKey)
+			s.Stats()
+			s.Conns()
+		}()
+	}
+	wg.Wait()
+	if n := started.Load(); n != 1 {
+		t.Fatalf("server started %d times\n", n)
+	}
+
+	c := NewClient(&Options{Host: o.Host, Port: o.Port})
+	c.LoadKeyPair(cert, key)
+
+	const conns = 8
+	handled := make(chan struct{}, conns)
+	for i := 0; i < 3; i++ {
+		go func() {
+			for {
+				conn, err := s.AcceptConn()
+				if err != nil {
+					return
+				}
+				conn.Handshake()
+				conn.Close()
+				handled <- struct{}{}
+			}
+		}()
+	}
+	for i := 0; i < conns; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if conn, err := c.Dial(); err == nil {
+				conn.Close()
+			}
+			ca, _ := genKeyPair(t, "ca")
+			s.AddClientCACert(ca)
+		}()
+	}
+	wg.Wait()
+	for i := 0; i < conns; i++ {
+		<-handled
+	}
+}
@@ -0,0 +1,394 @@
+package herots
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Conn - connection accepted by Server.
+//
+// Conn implements net.Conn. Besides TLS state it carries connection id,
+// peer identity, traffic stats and application metadata (tags), which can
+// be attached by handlers and hooks with Set and read back with Get.
+type Conn struct {
+	*tls.Conn
+
+	server   *Server
+	id       uint64
+	raw      net.Conn
+	accepted time.Time
+
+	// guard - handshake limits on raw, nil without Options.Abuse
+	guard *handshakeGuard
+
+	bytesRead    atomic.Int64
+	bytesWritten atomic.Int64
+
+	identityOnce sync.Once
+	identity     *Identity
+	identityErr  error
+
+	readLimit  *rateLimiter
+	writeLimit *rateLimiter
+
+	// draining is set on graceful shutdown; reads return io.EOF after it
+	draining atomic.Bool
+
+	// capture - active traffic capture, nil if none
+	capture atomic.Pointer[capture]
+
+	// hello - ClientHello of peer, captured during handshake
+	hello atomic.Pointer[ClientHello]
+
+	// handshakeErr - diagnostics of failed handshake (set once)
+	handshakeMu   sync.Mutex
+	handshakeErr  *HandshakeError
+	handshakeDone bool
+	// principal - authenticated by Options.Authenticator
+	principal string
+	// quota - usage of identity, nil without Options.Quota or key
+	quota *quotaEntry
+
+	// deadlines, tracked for rate limiter waits; wake is closed (and
+	// replaced) when they change or connection is drained/closed
+	dlMu          sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+	wake          chan struct{}
+	closed        bool
+
+	// lifetime - stops timer of max connection age, see startLifetime
+	lifetime func() bool
+
+	mu   sync.RWMutex
+	tags map[string]interface{}
+
+	// cause - explicit reason of coming close, ioErr - last I/O error,
+	// closedBy - final reason, set on close (see closereason.go)
+	cause    atomic.Pointer[closeCause]
+	ioErr    atomic.Pointer[error]
+	closedBy atomic.Pointer[closeCause]
+
+	closeOnce sync.Once
+}
+
+// newConn - start TLS (server side) over accepted connection and register
+// it on server.
+func newConn(s *Server, raw net.Conn) *Conn {
+	c := &Conn{
+		server:     s,
+		accepted:   s.clock.Now(),
+		readLimit:  newRateLimiter(s.options.ReadRateLimit, s.options.ReadBurst),
+		writeLimit: newRateLimiter(s.options.WriteRateLimit, s.options.WriteBurst),
+		wake:       make(chan struct{}),
+	}
+	if s.abuse != nil {
+		c.guard = newHandshakeGuard(raw, &s.abuse.o)
+		raw = c.guard
+	}
+	c.raw = raw
+	s.handshaking.Store(raw, c)
+
+	s.certMu.Lock()
+	config := s.tlsConfig
+	s.certMu.Unlock()
+	if config == nil {
+		config = &tls.Config{GetConfigForClient: s.getConfigForClient}
+	}
+	c.Conn = tls.Server(raw, config)
+	s.register(c)
+	return c
+}
+
+// ID - unique (per server) connection identifier.
+func (c *Conn) ID() uint64 {
+	return c.id
+}
+
+// Read - read data from connection, honoring connection and server read
+// rate limits and quota of identity (Options.Quota).
+func (c *Conn) Read(p []byte) (int, error) {
+	if c.draining.Load() {
+		return 0, io.EOF
+	}
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	if err := c.chargeQuota(0, 0); err != nil {
+		return 0, err
+	}
+	n, err := limitedRead(c.readLimit, p, c.globalRead, c.readSleep)
+	c.bytesRead.Add(int64(n))
+	c.addQuota(int64(n))
+	c.noteIOErr(err)
+	if cp := c.capture.Load(); cp != nil {
+		cp.record(c, CaptureRead, p[:n])
+	}
+	if err != nil && c.draining.Load() {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Write - write data to connection, honoring connection and server write
+// rate limits and quota of identity (Options.Quota).
+func (c *Conn) Write(p []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	if err := c.chargeQuota(int64(len(p)), 0); err != nil {
+		return 0, err
+	}
+	n, err := limitedWrite(c.writeLimit, p, c.globalWrite, c.writeSleep)
+	c.bytesWritten.Add(int64(n))
+	c.noteIOErr(err)
+	if cp := c.capture.Load(); cp != nil {
+		cp.record(c, CaptureWrite, p[:n])
+	}
+	return n, err
+}
+
+func (c *Conn) globalRead(p []byte) (int, error) {
+	return limitedRead(c.server.readLimit, p, c.Conn.Read, c.readSleep)
+}
+
+func (c *Conn) globalWrite(p []byte) (int, error) {
+	return limitedWrite(c.server.writeLimit, p, c.Conn.Write, c.writeSleep)
+}
+
+// SetDeadline - set read and write deadlines, see net.Conn.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.dlMu.Lock()
+	c.readDeadline, c.writeDeadline = t, t
+	c.wakeLocked()
+	c.dlMu.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+// SetReadDeadline - set read deadline, see net.Conn.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.dlMu.Lock()
+	c.readDeadline = t
+	c.wakeLocked()
+	c.dlMu.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline - set write deadline, see net.Conn.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.dlMu.Lock()
+	c.writeDeadline = t
+	c.wakeLocked()
+	c.dlMu.Unlock()
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// wakeLocked - interrupt rate limiter waits. dlMu must be held.
+func (c *Conn) wakeLocked() {
+	close(c.wake)
+	c.wake = make(chan struct{})
+}
+
+func (c *Conn) readSleep(d time.Duration) error {
+	return c.limiterSleep(d, false)
+}
+
+func (c *Conn) writeSleep(d time.Duration) error {
+	return c.limiterSleep(d, true)
+}
+
+// limiterSleep - wait for rate limiter, but not past connection deadline;
+// wakes up early when deadline changes, connection is drained (reads only)
+// or closed.
+func (c *Conn) limiterSleep(d time.Duration, write bool) error {
+	c.dlMu.Lock()
+	closed, wake := c.closed, c.wake
+	deadline := c.readDeadline
+	if write {
+		deadline = c.writeDeadline
+	}
+	c.dlMu.Unlock()
+
+	if closed {
+		return net.ErrClosed
+	}
+	if !write && c.draining.Load() {
+		return io.EOF
+	}
+	if !deadline.IsZero() {
+		left := time.Until(deadline)
+		if left <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		if left < d {
+			d = left
+		}
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-wake:
+	}
+	return nil
+}
+
+// ConnStats - traffic statistics of connection.
+type ConnStats struct {
+	Accepted     time.Time
+	BytesRead    int64
+	BytesWritten int64
+}
+
+// Stats - return traffic statistics of connection (application data,
+// without TLS overhead).
+func (c *Conn) Stats() ConnStats {
+	return ConnStats{
+		Accepted:     c.accepted,
+		BytesRead:    c.bytesRead.Load(),
+		BytesWritten: c.bytesWritten.Load(),
+	}
+}
+
+// Identity - return peer identity (see PeerIdentity), running handshake
+// if needed. Result is cached.
+func (c *Conn) Identity() (*Identity, error) {
+	c.identityOnce.Do(func() {
+		c.identity, c.identityErr = PeerIdentity(c)
+	})
+	return c.identity, c.identityErr
+}
+
+// identityLocked - same as Identity, for caller holding handshakeMu
+// after TLS handshake (Identity would wait for handshake in progress).
+func (c *Conn) identityLocked() (*Identity, error) {
+	c.identityOnce.Do(func() {
+		c.identity, c.identityErr = identityFromState(c.Conn.ConnectionState())
+	})
+	return c.identity, c.identityErr
+}
+
+// SetTimeout - set read and write deadline d from now; zero d clears
+// deadlines.
+func (c *Conn) SetTimeout(d time.Duration) error {
+	return c.SetDeadline(deadlineIn(d))
+}
+
+// SetReadTimeout - set read deadline d from now; zero d clears it.
+func (c *Conn) SetReadTimeout(d time.Duration) error {
+	return c.SetReadDeadline(deadlineIn(d))
+}
+
+// SetWriteTimeout - set write deadline d from now; zero d clears it.
+func (c *Conn) SetWriteTimeout(d time.Duration) error {
+	return c.SetWriteDeadline(deadlineIn(d))
+}
+
+func deadlineIn(d time.Duration) time.Time {
+	if d == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(d)
+}
+
+// Set - attach metadata value to connection.
+func (c *Conn) Set(key string, value interface{}) {
+	c.mu.Lock()
+	if c.tags == nil {
+		c.tags = make(map[string]interface{})
+	}
+	c.tags[key] = value
+	c.mu.Unlock()
+}
+
+// Get - return metadata value attached to connection.
+func (c *Conn) Get(key string) (interface{}, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	v, ok := c.tags[key]
+	return v, ok
+}
+
+// Delete - remove metadata value from connection.
+func (c *Conn) Delete(key string) {
+	c.mu.Lock()
+	delete(c.tags, key)
+	c.mu.Unlock()
+}
+
+// Tags - return copy of all metadata attached to connection.
+func (c *Conn) Tags() map[string]interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	t := make(map[string]interface{}, len(c.tags))
+	for k, v := range c.tags {
+		t[k] = v
+	}
+	return t
+}
+
+// Close - close connection and remove it from server registry.
+func (c *Conn) Close() error {
+	c.dlMu.Lock()
+	if !c.closed {
+		c.closed = true
+		c.wakeLocked()
+		c.stopLifetimeLocked()
+	}
+	c.dlMu.Unlock()
+
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		cc := c.resolveCause()
+		c.closedBy.Store(cc)
+		c.server.handshaking.Delete(c.raw)
+		c.StopCapture()
+		c.server.unregister(c)
+		c.releaseQuota()
+		c.server.stats.countClose(cc.reason)
+
+		msg := "closed " + c.String() + " (" + string(cc.reason)
+		if cc.err != nil {
+			msg += ": " + cc.err.Error()
+		}
+		c.server.logger.Log(msg+")", LogLevelInfo)
+		if c.server.options.OnClose != nil {
+			c.server.options.OnClose(c, cc.reason, cc.err)
+		}
+	})
+	return err
+}
+
+// String - short description of connection for log messages:
+// id, remote address and tags.
+func (c *Conn) String() string {
+	str := fmt.Sprintf("conn #%d from %s", c.id, c.RemoteAddr())
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if len(c.tags) == 0 {
+		return str
+	}
+
+	keys := make([]string, 0, len(c.tags))
+	for k := range c.tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = fmt.Sprintf("%s=%v", k, c.tags[k])
+	}
+
+	return str + " [" + strings.Join(pairs, " ") + "]"
+}
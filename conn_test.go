@@ -0,0 +1,184 @@
+package herots
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestConnTags(t *testing.T) {
+	c := &Conn{server: NewServer(&Options{})}
+
+	if _, ok := c.Get("node-id"); ok {
+		t.Fatalf("unexpected value on empty conn\n")
+	}
+
+	c.Set("node-id", "n1")
+	c.Set("zone", 3)
+
+	v, ok := c.Get("node-id")
+	if !ok || v != "n1" {
+		t.Fatalf("unexpected value: %v (%v)\n", v, ok)
+	}
+
+	tags := c.Tags()
+	tags["zone"] = 4
+	if v, _ := c.Get("zone"); v != 3 {
+		t.Fatalf("Tags must return copy, got zone=%v\n", v)
+	}
+
+	c.Delete("zone")
+	if _, ok := c.Get("zone"); ok {
+		t.Fatalf("value not deleted\n")
+	}
+}
+
+func TestConnRegistry(t *testing.T) {
+	s, c := startTestServer(t, &Options{})
+
+	done := make(chan error, 1)
+	go func() {
+		conn, err := c.Dial()
+		if err == nil {
+			_, err = conn.Write([]byte("ping"))
+			conn.Close()
+		}
+		done <- err
+	}()
+
+	conn, err := s.AcceptConn()
+	if err != nil {
+		t.Fatalf("accept:\n%v\n", err)
+	}
+	hc := conn
+	hc.Set("node-id", "n1")
+
+	buf := make([]byte, 4)
+	if _, err := hc.Read(buf); err != nil {
+		t.Fatalf("read:\n%v\n", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("client:\n%v\n", err)
+	}
+
+	conns := s.Conns()
+	if len(conns) != 1 || conns[0] != hc {
+		t.Fatalf("unexpected registry content: %v\n", conns)
+	}
+
+	hc.Close()
+	if n := len(s.Conns()); n != 0 {
+		t.Fatalf("closed conn still registered (%d conns)\n", n)
+	}
+}
+
+func TestPauseAccept(t *testing.T) {
+	s, c := startTestServer(t, &Options{})
+
+	s.PauseAccept()
+	if !s.AcceptPaused() {
+		t.Fatalf("server must report paused accept\n")
+	}
+
+	accepted := make(chan *Conn, 1)
+	go func() {
+		conn, err := s.AcceptConn()
+		if err == nil {
+			// client Dial waits for handshake to complete
+			conn.Handshake()
+			accepted <- conn
+		}
+	}()
+
+	// connection made while paused is rejected
+	if conn, err := c.Dial(); err == nil {
+		conn.Close()
+		t.Fatalf("expected rejected connection\n")
+	}
+
+	s.ResumeAccept()
+	conn, err := c.Dial()
+	if err != nil {
+		t.Fatalf("dial:\n%v\n", err)
+	}
+	defer conn.Close()
+
+	select {
+	case sc := <-accepted:
+		sc.Close()
+	case <-time.After(2 * time.Second):
+		t.Fatalf("connection not accepted after resume\n")
+	}
+}
+
+func TestConnThrottledWriteDeadline(t *testing.T) {
+	s, c := startTestServer(t, &Options{WriteRateLimit: 100, WriteBurst: 100})
+
+	go func() {
+		conn, err := c.Dial()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(io.Discard, conn)
+	}()
+
+	conn, err := s.AcceptConn()
+	if err != nil {
+		t.Fatalf("accept:\n%v\n", err)
+	}
+	defer conn.Close()
+
+	conn.SetWriteDeadline(time.Now().Add(200 * time.Millisecond))
+	start := time.Now()
+	_, err = conn.Write(make([]byte, 10000))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected deadline error, got %v\n", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("throttled write blocked past deadline: %v\n", d)
+	}
+}
+
+func TestConnStatsAndIdentity(t *testing.T) {
+	s, c := startTestServer(t, &Options{})
+
+	go func() {
+		conn, err := c.Dial()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("hello"))
+		conn.Read(make([]byte, 16))
+	}()
+
+	conn, err := s.AcceptConn()
+	if err != nil {
+		t.Fatalf("accept:\n%v\n", err)
+	}
+	defer conn.Close()
+
+	conn.SetReadTimeout(2 * time.Second)
+	buf := make([]byte, 16)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("read:\n%v\n", err)
+	}
+	conn.Write(buf[:n])
+
+	st := conn.Stats()
+	if st.BytesRead != 5 || st.BytesWritten != 5 || st.Accepted.IsZero() {
+		t.Fatalf("unexpected stats: %+v\n", st)
+	}
+
+	id, err := conn.Identity()
+	if err != nil || id.CommonName() != "herots test" {
+		t.Fatalf("unexpected identity: %+v, %v\n", id, err)
+	}
+	if id2, _ := conn.Identity(); id2 != id {
+		t.Fatalf("identity must be cached\n")
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !plan9
+
+package herots
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isConnReset - report whether err is reset of connection by peer, or
+// write to connection closed by it.
+func isConnReset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+// isConnRefused - report whether err is refused connection attempt.
+func isConnRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
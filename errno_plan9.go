@@ -0,0 +1,17 @@
+package herots
+
+import "strings"
+
+// Plan 9 has no error numbers: network errors are matched by text.
+
+// isConnReset - report whether err is reset of connection by peer, or
+// write to connection closed by it.
+func isConnReset(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "connection reset") || strings.Contains(msg, "hungup")
+}
+
+// isConnRefused - report whether err is refused connection attempt.
+func isConnRefused(err error) bool {
+	return strings.Contains(err.Error(), "connection refused")
+}
@@ -0,0 +1,49 @@
+package herots
+
+// ErrorsBufferSize - capacity of channel returned by Server.Errors.
+//
+// When channel is full (nobody reads it), new errors are dropped; they are
+// still passed to log.
+const ErrorsBufferSize = 64
+
+// RuntimeError - non-fatal error, which happened while server was running:
+// in accept loop, TLS handshake or background task.
+type RuntimeError struct {
+	// Op - where error happened: "accept", "handshake", etc.
+	Op string
+
+	// Conn - connection, which caused error (nil if none).
+	Conn *Conn
+
+	Err error
+}
+
+func (e *RuntimeError) Error() string {
+	if e.Conn != nil {
+		return e.Op + " (" + e.Conn.String() + "): " + e.Err.Error()
+	}
+	return e.Op + ": " + e.Err.Error()
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// Errors - return channel of non-fatal runtime errors (*RuntimeError).
+//
+// Reading the channel is optional: errors are logged in any case, and ones
+// which don't fit into channel buffer are dropped.
+func (s *Server) Errors() <-chan error {
+	return s.errors
+}
+
+// reportError - log runtime error and pass it to Errors channel.
+func (s *Server) reportError(op string, c *Conn, err error) {
+	e := &RuntimeError{Op: op, Conn: c, Err: err}
+	s.logger.Log(e.Error(), LogLevelError)
+
+	select {
+	case s.errors <- e:
+	default:
+	}
+}
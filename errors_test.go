@@ -0,0 +1,46 @@
+package herots
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestErrorsHandshake(t *testing.T) {
+	s, _ := startTestServer(t, &Options{})
+
+	// client with untrusted key pair
+	cert, key := genKeyPair(t, "stranger")
+	c := NewClient(&Options{Host: s.options.Host, Port: s.options.Port})
+	c.LoadKeyPair(cert, key)
+	go func() {
+		if conn, err := c.Dial(); err == nil {
+			conn.Close()
+		}
+	}()
+
+	conn, err := s.AcceptConn()
+	if err != nil {
+		t.Fatalf("accept:\n%v\n", err)
+	}
+	defer conn.Close()
+	conn.Read(make([]byte, 1))
+
+	select {
+	case err := <-s.Errors():
+		var re *RuntimeError
+		if !errors.As(err, &re) || re.Op != "handshake" || re.Conn != conn {
+			t.Fatalf("unexpected error: %v\n", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("handshake error not reported\n")
+	}
+
+	// reported once per connection
+	conn.Read(make([]byte, 1))
+	select {
+	case err := <-s.Errors():
+		t.Fatalf("handshake error reported twice: %v\n", err)
+	default:
+	}
+}
@@ -3,7 +3,6 @@ package main
 import (
 	"crypto/tls"
 	"log"
-	"net"
 	"os"
 	//"io/ioutil"
 
@@ -42,14 +41,19 @@ func main() {
 	}
 
 	for {
-		conn, err := server.Accept()
+		conn, err := server.AcceptConn()
 		if err != nil {
 			log.Println(err)
 			continue
 		}
 
-		go func(conn net.Conn) {
+		go func(conn *herots.Conn) {
 			defer conn.Close()
+
+			if id, err := conn.Identity(); err == nil {
+				log.Printf("client %s connected as %q\n", conn.RemoteAddr(), id.CommonName())
+			}
+
 			for {
 				buf := make([]byte, 512)
 
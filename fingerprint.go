@@ -0,0 +1,27 @@
+package herots
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+)
+
+// FingerprintSHA256 - return SHA-256 fingerprint of DER encoded certificate.
+func FingerprintSHA256(cert *x509.Certificate) []byte {
+	if cert == nil {
+		return nil
+	}
+	sum := sha256.Sum256(cert.Raw)
+	return sum[:]
+}
+
+// FingerprintEqual - compare two fingerprints in constant time.
+//
+// Empty fingerprints never match (not even each other), and fingerprints
+// of different length are never equal.
+func FingerprintEqual(a, b []byte) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return false
+	}
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
@@ -0,0 +1,143 @@
+package herots
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// DefaultMaxMessageSize - default limit of single framed message payload.
+const DefaultMaxMessageSize = 4 << 20
+
+// frameHeaderSize - size of frame header (payload length, big endian).
+const frameHeaderSize = 4
+
+// ErrMessageTooLarge - returned when framed message exceeds codec limit.
+var ErrMessageTooLarge = errors.New("message too large")
+
+// Codec - length-prefixed message framing over stream connection.
+//
+// Each message is sent as 4 byte big endian payload length followed by
+// payload; highest bit of length marks compressed payload (see
+// Compression), so payload is limited to 2 GiB. ReadMessage and
+// WriteMessage are safe for concurrent use; reads and writes are
+// serialized separately.
+type Codec struct {
+	r io.Reader
+	w io.Writer
+
+	rmu sync.Mutex
+	wmu sync.Mutex
+
+	// MaxMessageSize - limit of message payload for both directions.
+	//
+	// Default: DefaultMaxMessageSize.
+	MaxMessageSize int
+
+	// Compression - compress written messages. Compressed messages are
+	// always accepted by ReadMessage, but peer may not support them, so
+	// set it only if peer agreed (NewCodec does it for connections, which
+	// negotiated CompressionProtocol).
+	//
+	// Default: nil (no compression).
+	Compression *CompressionOptions
+}
+
+// NewCodec - function for create Codec over connection.
+//
+// If rw is TLS connection (*Conn or *tls.Conn), which negotiated
+// CompressionProtocol (see Options.Compression), Codec compresses
+// messages with default CompressionOptions. Handshake is run then, if it
+// has not yet been.
+func NewCodec(rw io.ReadWriter) *Codec {
+	c := &Codec{
+		r:              rw,
+		w:              rw,
+		MaxMessageSize: DefaultMaxMessageSize,
+	}
+	if negotiatedCompression(rw) {
+		c.Compression = &CompressionOptions{}
+	}
+	return c
+}
+
+func (c *Codec) maxSize() int {
+	switch {
+	case c.MaxMessageSize <= 0:
+		return DefaultMaxMessageSize
+	case int64(c.MaxMessageSize) >= frameCompressed:
+		return frameCompressed - 1
+	}
+	return c.MaxMessageSize
+}
+
+// ReadMessage - read next message from connection.
+//
+// If connection is closed cleanly between messages, ReadMessage returns
+// io.EOF as is; other read errors are wrapped (use errors.Is to inspect
+// them), including io.ErrUnexpectedEOF for truncated messages.
+func (c *Codec) ReadMessage() ([]byte, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	var hdr [frameHeaderSize]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("read message fail: %w\n", err)
+	}
+
+	n := binary.BigEndian.Uint32(hdr[:])
+	compressed := n&frameCompressed != 0
+	n &^= frameCompressed
+	if int64(n) > int64(c.maxSize()) {
+		return nil, ErrMessageTooLarge
+	}
+
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(c.r, msg); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("read message fail: %w\n", err)
+	}
+
+	if err := chargeMessage(c.r); err != nil {
+		return nil, err
+	}
+	if compressed {
+		return decompress(msg, c.maxSize())
+	}
+	return msg, nil
+}
+
+// WriteMessage - write message to connection.
+func (c *Codec) WriteMessage(msg []byte) error {
+	if len(msg) > c.maxSize() {
+		return ErrMessageTooLarge
+	}
+	if err := chargeMessage(c.w); err != nil {
+		return err
+	}
+
+	n := uint32(len(msg))
+	if c.Compression != nil {
+		if z, ok := c.Compression.compress(msg); ok {
+			msg = z
+			n = uint32(len(msg)) | frameCompressed
+		}
+	}
+
+	buf := make([]byte, frameHeaderSize+len(msg))
+	binary.BigEndian.PutUint32(buf, n)
+	copy(buf[frameHeaderSize:], msg)
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	_, err := c.w.Write(buf)
+	return err
+}
@@ -0,0 +1,307 @@
+package herots
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// DefaultHandshakeTimeout - default limit of handshake run by AcceptConn
+// with Options.HandshakeOnAccept.
+const DefaultHandshakeTimeout = 10 * time.Second
+
+// ClientHello - offered parameters of client, captured during handshake.
+type ClientHello struct {
+	ServerName        string
+	SupportedProtos   []string
+	SupportedVersions []uint16
+	CipherSuites      []uint16
+}
+
+// HandshakeFailure - classified reason of failed handshake.
+type HandshakeFailure int
+
+// predefined HandshakeFailure reasons
+const (
+	HandshakeFailureUnknown HandshakeFailure = iota
+	// client didn't send certificate, while TLSAuthType requires it
+	HandshakeFailureNoClientCert
+	// certificate is not signed by trusted CA (ours or, if peer sent
+	// alert, server certificate not trusted by client)
+	HandshakeFailureUnknownCA
+	// certificate is malformed, expired or not valid for usage
+	HandshakeFailureBadCert
+	// no common TLS version, cipher suite or application protocol
+	HandshakeFailureProtocolMismatch
+	// connection was closed or timed out during handshake
+	HandshakeFailureNetwork
+	// ClientHello was rejected by Options.OnClientHello
+	HandshakeFailureRejected
+	// connection was rejected by admission rule before handshake
+	HandshakeFailureAdmission
+	// client exceeded handshake size limits (see AbuseOptions)
+	HandshakeFailureLimit
+	// client failed authentication step (see Options.Authenticator)
+	HandshakeFailureAuth
+	// identity of client is over its connection quota (see
+	// Options.Quota)
+	HandshakeFailureQuota
+)
+
+func (f HandshakeFailure) String() string {
+	switch f {
+	case HandshakeFailureNoClientCert:
+		return "missing client certificate"
+	case HandshakeFailureUnknownCA:
+		return "unknown CA"
+	case HandshakeFailureBadCert:
+		return "bad certificate"
+	case HandshakeFailureProtocolMismatch:
+		return "protocol mismatch"
+	case HandshakeFailureNetwork:
+		return "network error"
+	case HandshakeFailureRejected:
+		return "rejected by hello hook"
+	case HandshakeFailureAdmission:
+		return "rejected by admission rule"
+	case HandshakeFailureLimit:
+		return "handshake limit exceeded"
+	case HandshakeFailureAuth:
+		return "authentication failed"
+	case HandshakeFailureQuota:
+		return "connection quota exceeded"
+	}
+	return "unknown"
+}
+
+// HandshakeError - details of failed TLS handshake.
+type HandshakeError struct {
+	RemoteAddr string
+
+	// Hello - offered client parameters, nil if handshake failed before
+	// ClientHello was received.
+	Hello *ClientHello
+
+	Reason HandshakeFailure
+	Err    error
+}
+
+func (e *HandshakeError) Error() string {
+	str := "tls handshake with " + e.RemoteAddr + " failed (" + e.Reason.String()
+	if e.Hello != nil {
+		versions := make([]string, len(e.Hello.SupportedVersions))
+		for i, v := range e.Hello.SupportedVersions {
+			versions[i] = tls.VersionName(v)
+		}
+		str += "; sni=" + e.Hello.ServerName +
+			" alpn=[" + strings.Join(e.Hello.SupportedProtos, ",") + "]" +
+			" versions=[" + strings.Join(versions, ",") + "]"
+	}
+	return str + "): " + e.Err.Error()
+}
+
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
+
+// HelloRejectedError - error returned by Options.OnClientHello, which
+// aborted handshake.
+type HelloRejectedError struct {
+	Err error
+}
+
+func (e *HelloRejectedError) Error() string {
+	return "client hello rejected: " + e.Err.Error()
+}
+
+func (e *HelloRejectedError) Unwrap() error {
+	return e.Err
+}
+
+// classifyHandshakeError - guess reason of handshake failure.
+func classifyHandshakeError(err error) HandshakeFailure {
+	var rejected *HelloRejectedError
+	if errors.As(err, &rejected) {
+		return HandshakeFailureRejected
+	}
+	if errors.Is(err, ErrAdmissionDenied) {
+		return HandshakeFailureAdmission
+	}
+	if errors.Is(err, ErrHandshakeLimit) {
+		return HandshakeFailureLimit
+	}
+	if errors.Is(err, ErrAuthFailed) {
+		return HandshakeFailureAuth
+	}
+	if errors.Is(err, ErrQuotaExceeded) {
+		return HandshakeFailureQuota
+	}
+
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		invalidCert      x509.CertificateInvalidError
+		hostnameErr      x509.HostnameError
+		alert            tls.AlertError
+		netErr           net.Error
+	)
+
+	switch {
+	case errors.As(err, &unknownAuthority):
+		return HandshakeFailureUnknownCA
+	case errors.As(err, &invalidCert), errors.As(err, &hostnameErr):
+		return HandshakeFailureBadCert
+	case errors.As(err, &alert):
+		// alert sent by peer
+		switch alert {
+		case 48: // unknown_ca
+			return HandshakeFailureUnknownCA
+		case 42, 43, 44, 45, 46: // bad/unsupported/revoked/expired/unknown certificate
+			return HandshakeFailureBadCert
+		case 116: // certificate_required
+			return HandshakeFailureNoClientCert
+		case 40, 70, 71, 120: // handshake_failure, protocol_version, insufficient_security, no_application_protocol
+			return HandshakeFailureProtocolMismatch
+		}
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.As(err, &netErr):
+		return HandshakeFailureNetwork
+	}
+
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "didn't provide a certificate"):
+		return HandshakeFailureNoClientCert
+	case strings.Contains(msg, "unsupported versions"),
+		strings.Contains(msg, "no cipher suite"),
+		strings.Contains(msg, "no mutually supported"),
+		strings.Contains(msg, "application protocol"):
+		return HandshakeFailureProtocolMismatch
+	case strings.Contains(msg, "certificate"):
+		return HandshakeFailureBadCert
+	}
+
+	return HandshakeFailureUnknown
+}
+
+// getConfigForClient - tls.Config.GetConfigForClient of server: records
+// ClientHello on connection and runs OnClientHello hook.
+//
+// All connections share one base config (so automatic session ticket keys
+// are shared and sessions resume across connections); connection is found
+// by raw net.Conn of handshake.
+func (s *Server) getConfigForClient(hi *tls.ClientHelloInfo) (*tls.Config, error) {
+	if v, ok := s.handshaking.Load(hi.Conn); ok {
+		v.(*Conn).hello.Store(&ClientHello{
+			ServerName:        hi.ServerName,
+			SupportedProtos:   hi.SupportedProtos,
+			SupportedVersions: hi.SupportedVersions,
+			CipherSuites:      hi.CipherSuites,
+		})
+	}
+
+	if s.options.OnClientHello != nil {
+		if err := s.options.OnClientHello(hi); err != nil {
+			return nil, &HelloRejectedError{Err: err}
+		}
+	}
+
+	// current client CA pool, see clientca.go
+	return s.clientConfig.Load(), nil
+}
+
+// ClientHello - return parameters offered by client, nil if ClientHello
+// has not been received (yet).
+func (c *Conn) ClientHello() *ClientHello {
+	return c.hello.Load()
+}
+
+// Handshake - run TLS handshake if it has not yet been run.
+//
+// Admission rules (Options.Admission, Options.ReverseDNS) are checked
+// before handshake, authentication step (Options.Authenticator) and
+// connection quota (Options.Quota) after it.
+// Read and Write call it automatically. On failure *HandshakeError with
+// diagnostics is returned; it is also logged, passed to
+// Options.OnHandshakeError and to server Errors channel (once per
+// connection).
+func (c *Conn) Handshake() error {
+	c.handshakeMu.Lock()
+	defer c.handshakeMu.Unlock()
+
+	if c.handshakeErr != nil {
+		return c.handshakeErr
+	}
+
+	var err error
+	if !c.handshakeDone {
+		if err = c.server.admit(c); err != nil {
+			// don't leave client waiting for ServerHello
+			c.raw.Close()
+		}
+	}
+	if err == nil {
+		err = c.Conn.Handshake()
+	}
+	if err == nil && !c.handshakeDone && c.server.options.Authenticator != nil {
+		err = c.authenticate()
+	}
+	if err == nil && !c.handshakeDone && c.server.quota != nil {
+		err = c.admitQuota()
+	}
+	c.server.handshaking.Delete(c.raw)
+	if err == nil {
+		if !c.handshakeDone {
+			c.handshakeDone = true
+			if c.guard != nil {
+				c.guard.done.Store(true)
+				c.server.abuse.succeed(c.raw.RemoteAddr())
+			}
+			st := c.ConnectionState()
+			if st.DidResume {
+				c.server.stats.handshakesResumed.Add(1)
+			} else {
+				c.server.stats.handshakesFull.Add(1)
+			}
+			c.server.logger.Log("handshake - ok ("+string(resumption(st))+" resumption) "+c.String(), LogLevelInfo)
+		}
+		return nil
+	}
+
+	c.server.stats.handshakesFailed.Add(1)
+
+	herr := &HandshakeError{
+		RemoteAddr: c.RemoteAddr().String(),
+		Hello:      c.hello.Load(),
+		Reason:     classifyHandshakeError(err),
+		Err:        err,
+	}
+	c.handshakeErr = herr
+	c.setCause(handshakeCloseReason(herr), herr)
+	if c.guard != nil && herr.Reason != HandshakeFailureAdmission && herr.Reason != HandshakeFailureQuota {
+		c.server.countAbuse(c)
+	}
+
+	if c.server.options.OnHandshakeError != nil {
+		c.server.options.OnHandshakeError(herr)
+	}
+	c.server.reportError("handshake", c, herr)
+
+	return herr
+}
+
+// handshakeWithTimeout - run handshake within Options.HandshakeTimeout,
+// for Options.HandshakeOnAccept.
+func (c *Conn) handshakeWithTimeout() error {
+	timeout := c.server.options.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = DefaultHandshakeTimeout
+	}
+
+	c.SetDeadline(time.Now().Add(timeout))
+	err := c.Handshake()
+	c.SetDeadline(time.Time{})
+	return err
+}
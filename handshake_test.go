@@ -0,0 +1,163 @@
+package herots
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHandshakeDiagnostics(t *testing.T) {
+	hook := make(chan *HandshakeError, 1)
+	s, _ := startTestServer(t, &Options{
+		OnHandshakeError: func(e *HandshakeError) { hook <- e },
+	})
+
+	// client without certificate
+	go func() {
+		conn, err := tls.Dial("tcp", s.listener.Addr().String(), &tls.Config{
+			ServerName:         "herots.test",
+			NextProtos:         []string{"herots"},
+			InsecureSkipVerify: true,
+		})
+		if err == nil {
+			conn.Read(make([]byte, 1))
+			conn.Close()
+		}
+	}()
+
+	conn, err := s.AcceptConn()
+	if err != nil {
+		t.Fatalf("accept:\n%v\n", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Read(make([]byte, 1))
+	var herr *HandshakeError
+	if !errors.As(err, &herr) {
+		t.Fatalf("expected *HandshakeError, got %v\n", err)
+	}
+	if herr.Reason != HandshakeFailureNoClientCert {
+		t.Fatalf("unexpected reason: %v (%v)\n", herr.Reason, herr.Err)
+	}
+	if herr.Hello == nil || herr.Hello.ServerName != "herots.test" ||
+		len(herr.Hello.SupportedProtos) != 1 || len(herr.Hello.SupportedVersions) == 0 {
+		t.Fatalf("unexpected hello: %+v\n", herr.Hello)
+	}
+	if _, err := PeerIdentity(conn); !errors.As(err, new(*HandshakeError)) {
+		t.Fatalf("PeerIdentity lost *HandshakeError: %v\n", err)
+	}
+
+	select {
+	case e := <-hook:
+		if e != herr {
+			t.Fatalf("hook got other error: %v\n", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("OnHandshakeError not called\n")
+	}
+}
+
+func TestClassifyHandshakeError(t *testing.T) {
+	cases := []struct {
+		err  error
+		want HandshakeFailure
+	}{
+		{io.EOF, HandshakeFailureNetwork},
+		{tls.AlertError(48), HandshakeFailureUnknownCA},
+		{tls.AlertError(70), HandshakeFailureProtocolMismatch},
+		{errors.New("tls: client offered only unsupported versions: [301]"), HandshakeFailureProtocolMismatch},
+		{errors.New("tls: client didn't provide a certificate"), HandshakeFailureNoClientCert},
+		{errors.New("something else"), HandshakeFailureUnknown},
+	}
+	for _, c := range cases {
+		if got := classifyHandshakeError(c.err); got != c.want {
+			t.Errorf("%v: got %v, want %v\n", c.err, got, c.want)
+		}
+	}
+}
+
+func TestOnClientHello(t *testing.T) {
+	s, c := startTestServer(t, &Options{
+		OnClientHello: func(hello *tls.ClientHelloInfo) error {
+			for _, p := range hello.SupportedProtos {
+				if p == "banned" {
+					return errors.New("banned protocol")
+				}
+			}
+			return nil
+		},
+	})
+
+	go func() {
+		conn, err := tls.Dial("tcp", s.listener.Addr().String(), &tls.Config{
+			NextProtos:         []string{"banned"},
+			InsecureSkipVerify: true,
+		})
+		if err == nil {
+			conn.Close()
+		}
+	}()
+
+	conn, err := s.AcceptConn()
+	if err != nil {
+		t.Fatalf("accept:\n%v\n", err)
+	}
+	err = conn.Handshake()
+	var herr *HandshakeError
+	if !errors.As(err, &herr) || herr.Reason != HandshakeFailureRejected {
+		t.Fatalf("expected rejected handshake, got %v\n", err)
+	}
+	conn.Close()
+
+	// allowed client
+	go func() {
+		if conn, err := c.Dial(); err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err = s.AcceptConn()
+	if err != nil {
+		t.Fatalf("accept:\n%v\n", err)
+	}
+	defer conn.Close()
+	if err := conn.Handshake(); err != nil {
+		t.Fatalf("allowed client rejected:\n%v\n", err)
+	}
+}
+
+func TestHandshakeOnAccept(t *testing.T) {
+	s, c := startTestServer(t, &Options{HandshakeOnAccept: true, HandshakeTimeout: 200 * time.Millisecond})
+
+	// client, which never sends ClientHello
+	raw, err := net.Dial("tcp", s.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial:\n%v\n", err)
+	}
+	defer raw.Close()
+
+	var herr *HandshakeError
+	start := time.Now()
+	if _, err := s.AcceptConn(); !errors.As(err, &herr) || herr.Reason != HandshakeFailureNetwork {
+		t.Fatalf("expected handshake timeout, got %v\n", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("handshake timeout not applied: %v\n", d)
+	}
+
+	go func() {
+		if conn, err := c.Dial(); err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := s.AcceptConn()
+	if err != nil {
+		t.Fatalf("accept:\n%v\n", err)
+	}
+	defer conn.Close()
+	if !conn.ConnectionState().HandshakeComplete {
+		t.Fatalf("connection returned before handshake\n")
+	}
+}
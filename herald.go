@@ -0,0 +1,298 @@
+package herots
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrAgentNotFound - returned by Herald when no agent with given name is
+// registered.
+var ErrAgentNotFound = errors.New("herald agent not found")
+
+// HeraldCommandFunc - type for functions which execute commands received
+// by HeraldAgent. Returned result (or error) is sent back as
+// acknowledgement.
+type HeraldCommandFunc func(cmd string, payload []byte) ([]byte, error)
+
+// HeraldOptions - structure, which is used to configure Herald.
+type HeraldOptions struct {
+	// Name - function, which returns agent name for identity of its
+	// connection. Agents without name are refused.
+	//
+	// Default: certificate common name.
+	Name func(id *Identity) string
+
+	// Timeout - default timeout of command acknowledgement.
+	//
+	// Default: DefaultHeraldTimeout (10s).
+	Timeout time.Duration
+
+	// RPC - options of RPC layer of agent connections.
+	RPC *RPCOptions
+
+	// Session - enable sessions, which keep queued messages of agents
+	// across reconnects (see Herald.Publish). Required for cluster relay.
+	//
+	// Default: nil (no sessions).
+	Session *HeraldSessionOptions
+
+	// NodeID - unique name of this node in cluster of linked heralds (see
+	// ServePeer), up to 255 bytes.
+	//
+	// Default: random.
+	NodeID string
+}
+
+// DefaultHeraldTimeout - default timeout of command acknowledgement.
+const DefaultHeraldTimeout = 10 * time.Second
+
+// HeraldResult - outcome of command for one agent.
+type HeraldResult struct {
+	Name   string
+	Result []byte
+	// Err - nil if agent acknowledged command; *RPCError if agent
+	// reported failure, ErrRPCTimeout, ErrAgentNotFound or connection
+	// error otherwise.
+	Err error
+}
+
+// HeraldResults - outcome of command sent to several agents.
+type HeraldResults []HeraldResult
+
+// Failed - return results of agents which didn't acknowledge command.
+func (r HeraldResults) Failed() HeraldResults {
+	var failed HeraldResults
+	for _, res := range r {
+		if res.Err != nil {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
+
+// Err - return nil if all agents acknowledged command, or error naming
+// failed ones.
+func (r HeraldResults) Err() error {
+	failed := r.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+	names := make([]string, len(failed))
+	for i, res := range failed {
+		names[i] = res.Name
+	}
+	return fmt.Errorf("herald command failed for %d of %d agents: %v", len(failed), len(r), names)
+}
+
+// Herald - command-and-control layer of server: agents (clients running
+// HeraldAgent) register under name of their certificate identity, server
+// sends them commands and collects acknowledgements.
+type Herald struct {
+	o HeraldOptions
+
+	mu       sync.Mutex
+	agents   map[string]*heraldAgentConn
+	sessions map[string]*heraldSession
+	peers    map[*heraldPeer]struct{}
+
+	relayState *relayState
+}
+
+type heraldAgentConn struct {
+	conn *Conn
+	rpc  *RPC
+}
+
+// NewHerald - function for create Herald.
+func NewHerald(o *HeraldOptions) *Herald {
+	h := &Herald{
+		agents:   make(map[string]*heraldAgentConn),
+		sessions: make(map[string]*heraldSession),
+		peers:    make(map[*heraldPeer]struct{}),
+	}
+	if o != nil {
+		h.o = *o
+	}
+	h.relayState = newRelayState(h.o.NodeID)
+	if h.o.Timeout <= 0 {
+		h.o.Timeout = DefaultHeraldTimeout
+	}
+	if h.o.Name == nil {
+		h.o.Name = (*Identity).CommonName
+	}
+	if h.o.RPC == nil {
+		h.o.RPC = &RPCOptions{}
+	}
+	if h.o.Session != nil {
+		so := *h.o.Session
+		if so.MaxQueued <= 0 {
+			so.MaxQueued = DefaultHeraldSessionQueue
+		}
+		if so.TTL <= 0 {
+			so.TTL = DefaultHeraldSessionTTL
+		}
+		h.o.Session = &so
+	}
+	return h
+}
+
+// Serve - register connection as agent and serve it until connection
+// fails or is closed. Agent registered with the same name earlier is
+// disconnected. Serve doesn't close c.
+func (h *Herald) Serve(c *Conn) error {
+	id, err := c.Identity()
+	if err != nil {
+		return fmt.Errorf("herald register fail: %w", err)
+	}
+	name := h.o.Name(id)
+	if name == "" {
+		return fmt.Errorf("herald register fail: no agent name for %v", id.Subject)
+	}
+
+	a := &heraldAgentConn{conn: c, rpc: NewRPCWithOptions(NewCodec(c), nil, h.o.RPC)}
+
+	h.mu.Lock()
+	old := h.agents[name]
+	h.agents[name] = a
+	if h.o.Session != nil {
+		h.attachSessionLocked(name, a)
+	}
+	h.mu.Unlock()
+	if old != nil {
+		old.rpc.Close()
+		old.conn.closeWith(CloseReasonPolicy, errors.New("replaced by newer registration of "+name))
+	}
+	c.server.logger.Log("herald agent "+name+" registered "+c.String(), LogLevelInfo)
+
+	<-a.rpc.Done()
+
+	h.mu.Lock()
+	if h.agents[name] == a {
+		delete(h.agents, name)
+	}
+	if h.o.Session != nil {
+		h.detachSessionLocked(name, a)
+	}
+	h.mu.Unlock()
+	c.server.logger.Log("herald agent "+name+" gone "+c.String(), LogLevelInfo)
+
+	return a.rpc.Err()
+}
+
+// Agents - return sorted names of registered agents.
+func (h *Herald) Agents() []string {
+	h.mu.Lock()
+	names := make([]string, 0, len(h.agents))
+	for name := range h.agents {
+		names = append(names, name)
+	}
+	h.mu.Unlock()
+
+	sort.Strings(names)
+	return names
+}
+
+// Send - send command to agent and wait for its acknowledgement.
+//
+// If timeout is zero, HeraldOptions.Timeout is used.
+func (h *Herald) Send(name, cmd string, payload []byte, timeout time.Duration) ([]byte, error) {
+	h.mu.Lock()
+	a := h.agents[name]
+	h.mu.Unlock()
+	if a == nil {
+		return nil, ErrAgentNotFound
+	}
+
+	if timeout <= 0 {
+		timeout = h.o.Timeout
+	}
+	req, err := encodeHeraldCommand(cmd, payload)
+	if err != nil {
+		return nil, err
+	}
+	return a.rpc.Call(req, timeout)
+}
+
+// SendAll - send command to named agents (all registered agents if names
+// is empty) concurrently and collect results in order of names. Failure
+// of some agents doesn't affect others; see HeraldResults.Err.
+func (h *Herald) SendAll(cmd string, payload []byte, timeout time.Duration, names ...string) HeraldResults {
+	if len(names) == 0 {
+		names = h.Agents()
+	}
+
+	results := make(HeraldResults, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := h.Send(name, cmd, payload, timeout)
+			results[i] = HeraldResult{Name: name, Result: res, Err: err}
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+// HeraldAgent - client side of Herald: executes commands sent by server.
+type HeraldAgent struct {
+	rpc *RPC
+}
+
+// NewHeraldAgent - function for create HeraldAgent over connection to
+// server and start serving it.
+func NewHeraldAgent(conn net.Conn, handler HeraldCommandFunc) *HeraldAgent {
+	return &HeraldAgent{rpc: NewRPC(conn, func(req []byte) ([]byte, error) {
+		cmd, payload, err := decodeHeraldCommand(req)
+		if err != nil {
+			return nil, err
+		}
+		return handler(cmd, payload)
+	})}
+}
+
+// Done - return channel which is closed when agent stops serving
+// connection.
+func (a *HeraldAgent) Done() <-chan struct{} {
+	return a.rpc.Done()
+}
+
+// Err - return reason why agent stopped, or nil while it is serving.
+func (a *HeraldAgent) Err() error {
+	return a.rpc.Err()
+}
+
+// Close - stop agent. Close doesn't close underlying connection.
+func (a *HeraldAgent) Close() error {
+	return a.rpc.Close()
+}
+
+// command encoding: command name length (2 bytes, big endian), name,
+// payload
+func encodeHeraldCommand(cmd string, payload []byte) ([]byte, error) {
+	if len(cmd) > 0xffff {
+		return nil, fmt.Errorf("herald command name too long")
+	}
+	msg := make([]byte, 2, 2+len(cmd)+len(payload))
+	binary.BigEndian.PutUint16(msg, uint16(len(cmd)))
+	msg = append(msg, cmd...)
+	return append(msg, payload...), nil
+}
+
+func decodeHeraldCommand(msg []byte) (string, []byte, error) {
+	if len(msg) < 2 {
+		return "", nil, fmt.Errorf("malformed herald command")
+	}
+	n := int(binary.BigEndian.Uint16(msg))
+	if len(msg) < 2+n {
+		return "", nil, fmt.Errorf("malformed herald command")
+	}
+	return string(msg[2 : 2+n]), msg[2+n:], nil
+}
@@ -0,0 +1,274 @@
+package herots
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iu0v1/herots/herotstest"
+)
+
+func TestHerald(t *testing.T) {
+	s, _ := startTestServer(t, &Options{})
+	h := NewHerald(&HeraldOptions{Timeout: 300 * time.Millisecond})
+
+	go func() {
+		for {
+			conn, err := s.AcceptConn()
+			if err != nil {
+				return
+			}
+			go func() {
+				h.Serve(conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	handlers := map[string]HeraldCommandFunc{
+		"node-1": func(cmd string, payload []byte) ([]byte, error) {
+			return []byte(cmd + ":" + string(payload)), nil
+		},
+		"node-2": func(cmd string, payload []byte) ([]byte, error) {
+			return nil, errors.New("unsupported")
+		},
+		"node-3": func(cmd string, payload []byte) ([]byte, error) {
+			time.Sleep(time.Second)
+			return nil, nil
+		},
+	}
+	for name, handler := range handlers {
+		c, cert := newcomerClient(t, s, name)
+		s.AddClientCACert(cert)
+		conn, err := c.Dial()
+		if err != nil {
+			t.Fatalf("dial:\n%v\n", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		NewHeraldAgent(conn, handler)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(h.Agents()) != 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("agents not registered: %v\n", h.Agents())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	res, err := h.Send("node-1", "echo", []byte("hi"), 0)
+	if err != nil || string(res) != "echo:hi" {
+		t.Fatalf("unexpected result: %q, %v\n", res, err)
+	}
+	if _, err := h.Send("node-9", "echo", nil, 0); !errors.Is(err, ErrAgentNotFound) {
+		t.Fatalf("expected ErrAgentNotFound, got %v\n", err)
+	}
+
+	results := h.SendAll("echo", []byte("all"), 0)
+	got := fmt.Sprint(len(results), len(results.Failed()), results.Err() != nil)
+	if got != "3 2 true" {
+		t.Fatalf("unexpected results: %+v\n", results)
+	}
+	var rerr *RPCError
+	if !errors.As(results[1].Err, &rerr) || !errors.Is(results[2].Err, ErrRPCTimeout) {
+		t.Fatalf("unexpected failures: %v, %v\n", results[1].Err, results[2].Err)
+	}
+}
+
+func TestHeraldSession(t *testing.T) {
+	s, _ := startTestServer(t, &Options{})
+	clock := herotstest.NewFakeClock(time.Now())
+	var (
+		mu      sync.Mutex
+		dropped []string
+	)
+	h := NewHerald(&HeraldOptions{
+		RPC: &RPCOptions{Clock: clock},
+		Session: &HeraldSessionOptions{
+			MaxQueued: 2,
+			TTL:       time.Minute,
+			OnDrop: func(name, cmd string) {
+				mu.Lock()
+				dropped = append(dropped, name+"/"+cmd)
+				mu.Unlock()
+			},
+		},
+	})
+	go func() {
+		for {
+			conn, err := s.AcceptConn()
+			if err != nil {
+				return
+			}
+			go func() {
+				h.Serve(conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	got := make(chan string, 10)
+	c, cert := newcomerClient(t, s, "node-1")
+	s.AddClientCACert(cert)
+	connect := func() func() {
+		conn, err := c.Dial()
+		if err != nil {
+			t.Fatalf("dial:\n%v\n", err)
+		}
+		NewHeraldAgent(conn, func(cmd string, payload []byte) ([]byte, error) {
+			got <- cmd
+			return nil, nil
+		})
+		waitFor(t, "agent registration", func() bool { return len(h.Agents()) == 1 })
+		return func() {
+			conn.Close()
+			waitFor(t, "agent gone", func() bool { return len(h.Agents()) == 0 })
+		}
+	}
+
+	if err := h.Publish("node-1", "m0", nil); !errors.Is(err, ErrAgentNotFound) {
+		t.Fatalf("expected ErrAgentNotFound before registration, got %v\n", err)
+	}
+
+	disconnect := connect()
+	if n, err := h.Broadcast("m0", nil); n != 1 || err != nil {
+		t.Fatalf("broadcast: %d, %v\n", n, err)
+	}
+	if cmd := <-got; cmd != "m0" {
+		t.Fatalf("unexpected command %q\n", cmd)
+	}
+	waitFor(t, "acknowledgement", func() bool { return h.Queued("node-1") == 0 })
+	disconnect()
+
+	// queued while disconnected; oldest dropped over limit
+	for _, cmd := range []string{"m1", "m2", "m3"} {
+		if err := h.Publish("node-1", cmd, nil); err != nil {
+			t.Fatalf("publish:\n%v\n", err)
+		}
+	}
+	if n := h.Queued("node-1"); n != 2 {
+		t.Fatalf("unexpected queue length %d\n", n)
+	}
+
+	disconnect = connect()
+	for _, want := range []string{"m2", "m3"} {
+		if cmd := <-got; cmd != want {
+			t.Fatalf("unexpected command %q, want %q\n", cmd, want)
+		}
+	}
+	waitFor(t, "flush", func() bool { return h.Queued("node-1") == 0 })
+	disconnect()
+
+	// session expires with its queue
+	h.Publish("node-1", "m4", nil)
+	clock.Advance(time.Minute)
+	if err := h.Publish("node-1", "m5", nil); !errors.Is(err, ErrAgentNotFound) {
+		t.Fatalf("expected ErrAgentNotFound after expiry, got %v\n", err)
+	}
+	waitFor(t, "drop callbacks", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(dropped) == 2
+	})
+	mu.Lock()
+	if fmt.Sprint(dropped) != "[node-1/m1 node-1/m4]" {
+		t.Fatalf("unexpected dropped messages %v\n", dropped)
+	}
+	mu.Unlock()
+}
+
+// startHeraldAgents - serve connections of s by h and connect agent for
+// every handler.
+func startHeraldAgents(t *testing.T, s *Server, h *Herald, handlers map[string]HeraldCommandFunc) map[string]*HeraldAgent {
+	go func() {
+		for {
+			conn, err := s.AcceptConn()
+			if err != nil {
+				return
+			}
+			go func() {
+				h.Serve(conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	agents := make(map[string]*HeraldAgent)
+	for name, handler := range handlers {
+		c, cert := newcomerClient(t, s, name)
+		s.AddClientCACert(cert)
+		conn, err := c.Dial()
+		if err != nil {
+			t.Fatalf("dial:\n%v\n", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		agents[name] = NewHeraldAgent(conn, handler)
+	}
+	waitFor(t, "agent registration", func() bool { return len(h.Agents()) == len(handlers) })
+	return agents
+}
+
+func TestHeraldBackpressure(t *testing.T) {
+	for _, policy := range []HeraldOverflow{HeraldDropNewest, HeraldDisconnect} {
+		t.Run(policy.String(), func(t *testing.T) {
+			s, _ := startTestServer(t, &Options{})
+			h := NewHerald(&HeraldOptions{
+				Timeout: 10 * time.Second,
+				Session: &HeraldSessionOptions{MaxQueued: 1, Overflow: policy},
+			})
+
+			release := make(chan struct{})
+			defer close(release)
+			fast := make(chan string, 10)
+			agents := startHeraldAgents(t, s, h, map[string]HeraldCommandFunc{
+				"slow": func(cmd string, payload []byte) ([]byte, error) {
+					<-release
+					return nil, nil
+				},
+				"fast": func(cmd string, payload []byte) ([]byte, error) {
+					fast <- cmd
+					return nil, nil
+				},
+			})
+
+			// m1 stays in flight to slow agent and occupies its queue
+			for i, cmd := range []string{"m1", "m2", "m3"} {
+				n, _ := h.Broadcast(cmd, nil)
+				if i > 0 && n != 1 {
+					t.Fatalf("%s queued by %d sessions\n", cmd, n)
+				}
+				select {
+				case got := <-fast:
+					if got != cmd {
+						t.Fatalf("unexpected command %q, want %q\n", got, cmd)
+					}
+				case <-time.After(2 * time.Second):
+					t.Fatalf("fan-out blocked by slow agent\n")
+				}
+				waitFor(t, "acknowledgement", func() bool { return h.SessionStats()[0].Delivered == int64(i+1) })
+			}
+
+			switch policy {
+			case HeraldDropNewest:
+				stats := h.SessionStats()
+				if len(stats) != 2 || stats[1].Name != "slow" || stats[1].Dropped != 2 || stats[1].Queued != 1 {
+					t.Fatalf("unexpected stats %+v\n", stats)
+				}
+				if err := h.Publish("slow", "m4", nil); !errors.Is(err, ErrHeraldQueueFull) {
+					t.Fatalf("expected ErrHeraldQueueFull, got %v\n", err)
+				}
+			case HeraldDisconnect:
+				select {
+				case <-agents["slow"].Done():
+				case <-time.After(2 * time.Second):
+					t.Fatalf("slow agent not disconnected\n")
+				}
+				if stats := h.SessionStats(); len(stats) != 1 || stats[0].Name != "fast" {
+					t.Fatalf("unexpected stats %+v\n", stats)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package herots
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync/atomic"
+)
+
+// default herald cluster settings
+const (
+	// DefaultHeraldPeerQueue - limit of relayed messages waiting to be
+	// sent to one peer; further messages for it are dropped.
+	DefaultHeraldPeerQueue = 1024
+
+	// heraldRelaySeen - number of recent relayed messages remembered to
+	// drop duplicates arriving by other paths.
+	heraldRelaySeen = 8192
+
+	// heraldMaxHops - limit of relay path length, guarding against loops
+	// if remembered messages are evicted.
+	heraldMaxHops = 16
+)
+
+// HeraldPeerStats - relay metrics of connected peer node.
+type HeraldPeerStats struct {
+	Name string
+
+	// Sent - messages relayed to peer; Received - messages got from it
+	// (including duplicates); Dropped - messages not relayed as peer
+	// queue was full
+	Sent     int64
+	Received int64
+	Dropped  int64
+}
+
+// heraldPeer - link to other herald node.
+type heraldPeer struct {
+	name  string
+	rpc   *RPC
+	queue chan []byte
+
+	sent, received, dropped atomic.Int64
+}
+
+// relayState - identity of this node and duplicate filter of relayed
+// messages.
+type relayState struct {
+	nodeID string
+	// lastID - ID of last own broadcast; starts at random value, so IDs
+	// of restarted node (with the same nodeID) are not taken by peers for
+	// duplicates of ones remembered from previous run
+	lastID atomic.Uint64
+	seen   *lruCache[struct{}]
+}
+
+func newRelayState(nodeID string) *relayState {
+	if nodeID == "" {
+		var b [8]byte
+		rand.Read(b[:])
+		nodeID = hex.EncodeToString(b[:])
+	}
+	if len(nodeID) > 255 {
+		nodeID = nodeID[:255]
+	}
+	r := &relayState{nodeID: nodeID, seen: newLRUCache[struct{}](heraldRelaySeen)}
+	var b [8]byte
+	rand.Read(b[:])
+	r.lastID.Store(binary.BigEndian.Uint64(b[:]))
+	return r
+}
+
+// firstSeen - remember relayed message; report whether it is new.
+func (r *relayState) firstSeen(origin string, id uint64) bool {
+	return r.seen.putIfAbsent(origin+"/"+strconv.FormatUint(id, 10), struct{}{})
+}
+
+// ServePeer - link this herald with other herald node over established
+// mutual TLS connection (*Conn accepted by server or *tls.Conn dialed by
+// client) and serve link until it fails or is closed. Both nodes call
+// ServePeer on their end of connection. ServePeer doesn't close conn.
+//
+// Broadcast of any node is relayed to all nodes, which are linked
+// directly or via other nodes (mesh and chains are fine; duplicates are
+// dropped), and delivered to sessions of agents attached to them. Peer is
+// named by common name of its certificate; make sure only certificates of
+// cluster nodes are accepted for peer links, e.g. by separate CA or
+// profile.
+func (h *Herald) ServePeer(conn net.Conn) error {
+	if h.o.Session == nil {
+		return errors.New("herald peer fail: sessions not enabled")
+	}
+	name, err := peerName(conn)
+	if err != nil {
+		return fmt.Errorf("herald peer fail: %w", err)
+	}
+
+	p := &heraldPeer{name: name, queue: make(chan []byte, DefaultHeraldPeerQueue)}
+	p.rpc = NewRPCWithOptions(NewCodec(conn), func(req []byte) ([]byte, error) {
+		p.received.Add(1)
+		return nil, h.receiveRelay(p, req)
+	}, h.o.RPC)
+
+	h.mu.Lock()
+	h.peers[p] = struct{}{}
+	h.mu.Unlock()
+
+	go h.pumpPeer(p)
+	<-p.rpc.Done()
+
+	h.mu.Lock()
+	delete(h.peers, p)
+	h.mu.Unlock()
+
+	return p.rpc.Err()
+}
+
+// peerName - common name of peer certificate of TLS connection.
+func peerName(conn net.Conn) (string, error) {
+	tc, ok := conn.(interface {
+		Handshake() error
+		ConnectionState() tls.ConnectionState
+	})
+	if !ok {
+		return "", errors.New("not a TLS connection")
+	}
+	if err := tc.Handshake(); err != nil {
+		return "", err
+	}
+	certs := tc.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return "", errors.New("peer sent no certificate")
+	}
+	if certs[0].Subject.CommonName == "" {
+		return "", errors.New("peer certificate has no common name")
+	}
+	return certs[0].Subject.CommonName, nil
+}
+
+// pumpPeer - send queued relay messages to peer until link is closed.
+func (h *Herald) pumpPeer(p *heraldPeer) {
+	for {
+		select {
+		case msg := <-p.queue:
+			if _, err := p.rpc.Call(msg, h.o.Timeout); err != nil {
+				var rerr *RPCError
+				if !errors.As(err, &rerr) {
+					p.rpc.Close()
+					return
+				}
+			}
+			p.sent.Add(1)
+		case <-p.rpc.Done():
+			return
+		}
+	}
+}
+
+// relay - send message to all peers except from.
+func (h *Herald) relay(msg []byte, from *heraldPeer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for p := range h.peers {
+		if p == from {
+			continue
+		}
+		select {
+		case p.queue <- msg:
+		default:
+			p.dropped.Add(1)
+		}
+	}
+}
+
+// receiveRelay - deliver message relayed by peer to local sessions and
+// pass it on.
+func (h *Herald) receiveRelay(from *heraldPeer, req []byte) error {
+	origin, id, hops, msg, err := decodeRelay(req)
+	if err != nil {
+		return err
+	}
+	if origin == h.relayState.nodeID || !h.relayState.firstSeen(origin, id) {
+		return nil
+	}
+	cmd, _, err := decodeHeraldCommand(msg)
+	if err != nil {
+		return err
+	}
+
+	h.broadcastLocal(cmd, msg)
+	if hops+1 < heraldMaxHops {
+		h.relay(encodeRelay(origin, id, hops+1, msg), from)
+	}
+	return nil
+}
+
+// PeerStats - return relay metrics of connected peers, sorted by name.
+func (h *Herald) PeerStats() []HeraldPeerStats {
+	h.mu.Lock()
+	stats := make([]HeraldPeerStats, 0, len(h.peers))
+	for p := range h.peers {
+		stats = append(stats, HeraldPeerStats{
+			Name:     p.name,
+			Sent:     p.sent.Load(),
+			Received: p.received.Load(),
+			Dropped:  p.dropped.Load(),
+		})
+	}
+	h.mu.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+// relay message encoding: origin node id length (1 byte), origin, message
+// id (8 bytes, big endian), hops (1 byte), herald command
+func encodeRelay(origin string, id uint64, hops int, msg []byte) []byte {
+	b := make([]byte, 0, 1+len(origin)+9+len(msg))
+	b = append(b, byte(len(origin)))
+	b = append(b, origin...)
+	b = binary.BigEndian.AppendUint64(b, id)
+	b = append(b, byte(hops))
+	return append(b, msg...)
+}
+
+func decodeRelay(b []byte) (origin string, id uint64, hops int, msg []byte, err error) {
+	if len(b) < 1 || len(b) < 1+int(b[0])+9 {
+		return "", 0, 0, nil, fmt.Errorf("malformed herald relay message")
+	}
+	n := int(b[0])
+	origin = string(b[1 : 1+n])
+	id = binary.BigEndian.Uint64(b[1+n:])
+	hops = int(b[1+n+8])
+	return origin, id, hops, b[1+n+9:], nil
+}
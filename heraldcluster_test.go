@@ -0,0 +1,136 @@
+package herots
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHeraldCluster(t *testing.T) {
+	type node struct {
+		s *Server
+		h *Herald
+	}
+	nodes := make([]node, 3)
+	for i := range nodes {
+		s, _ := startTestServer(t, &Options{})
+		h := NewHerald(&HeraldOptions{NodeID: fmt.Sprint("node-", i), Session: &HeraldSessionOptions{}})
+		nodes[i] = node{s, h}
+		go func() {
+			for {
+				conn, err := s.AcceptConn()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer conn.Close()
+					if id, err := conn.Identity(); err == nil && strings.HasPrefix(id.CommonName(), "node-") {
+						h.ServePeer(conn)
+						return
+					}
+					h.Serve(conn)
+				}()
+			}
+		}()
+	}
+
+	// triangle, so every message also arrives by second path
+	for i := range nodes {
+		from, to := nodes[i], nodes[(i+1)%len(nodes)]
+		c, cert := newcomerClient(t, to.s, fmt.Sprint("node-", i))
+		to.s.AddClientCACert(cert)
+		conn, err := c.Dial()
+		if err != nil {
+			t.Fatalf("dial peer:\n%v\n", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		go from.h.ServePeer(conn)
+	}
+	for _, n := range nodes {
+		waitFor(t, "peer links", func() bool { return len(n.h.PeerStats()) == 2 })
+	}
+
+	got := make(chan string, 10)
+	for i, n := range nodes {
+		name := fmt.Sprint("agent-", i)
+		c, cert := newcomerClient(t, n.s, name)
+		n.s.AddClientCACert(cert)
+		conn, err := c.Dial()
+		if err != nil {
+			t.Fatalf("dial agent:\n%v\n", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		NewHeraldAgent(conn, func(cmd string, payload []byte) ([]byte, error) {
+			got <- name + ":" + cmd + ":" + string(payload)
+			return nil, nil
+		})
+		waitFor(t, "agent registration", func() bool { return len(n.h.Agents()) == 1 })
+	}
+
+	if n, err := nodes[0].h.Broadcast("deploy", []byte("v2")); n != 1 || err != nil {
+		t.Fatalf("broadcast: %d, %v\n", n, err)
+	}
+
+	seen := make(map[string]bool)
+	for len(seen) < 3 {
+		select {
+		case msg := <-got:
+			if seen[msg] {
+				t.Fatalf("duplicate delivery %q\n", msg)
+			}
+			seen[msg] = true
+		case <-time.After(2 * time.Second):
+			t.Fatalf("broadcast not relayed, got %v\n", seen)
+		}
+	}
+	for i := range nodes {
+		if !seen[fmt.Sprint("agent-", i, ":deploy:v2")] {
+			t.Fatalf("agent-%d missed broadcast: %v\n", i, seen)
+		}
+	}
+	select {
+	case msg := <-got:
+		t.Fatalf("duplicate delivery %q\n", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestHeraldRelayRestart(t *testing.T) {
+	peer := newRelayState("peer")
+
+	// broadcasts of node before and after restart with the same node ID
+	before := newRelayState("node")
+	for i := 0; i < 3; i++ {
+		if !peer.firstSeen("node", before.lastID.Add(1)) {
+			t.Fatalf("new broadcast %d taken for duplicate\n", i)
+		}
+	}
+	after := newRelayState("node")
+	if !peer.firstSeen("node", after.lastID.Add(1)) {
+		t.Fatalf("broadcast of restarted node taken for duplicate\n")
+	}
+}
+
+func TestHeraldRelayFirstSeen(t *testing.T) {
+	r := newRelayState("node")
+
+	// concurrent arrivals of one broadcast over different links
+	var wg sync.WaitGroup
+	var firsts atomic.Int32
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if r.firstSeen("origin", 1) {
+				firsts.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := firsts.Load(); n != 1 {
+		t.Fatalf("broadcast seen first %d times, want 1\n", n)
+	}
+}
@@ -0,0 +1,390 @@
+package herots
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ErrHeraldQueueFull - returned by Publish when message is refused by
+// overflow policy of full session queue.
+var ErrHeraldQueueFull = errors.New("herald session queue full")
+
+// HeraldOverflow - policy of full session queue, see
+// HeraldSessionOptions.Overflow.
+type HeraldOverflow int
+
+// predefined HeraldOverflow policies
+const (
+	// drop oldest queued messages to make room for new one
+	HeraldDropOldest HeraldOverflow = iota
+	// refuse new message
+	HeraldDropNewest
+	// drop whole session with its queue and disconnect agent, so slow
+	// agent starts over
+	HeraldDisconnect
+)
+
+func (p HeraldOverflow) String() string {
+	switch p {
+	case HeraldDropOldest:
+		return "drop-oldest"
+	case HeraldDropNewest:
+		return "drop-newest"
+	case HeraldDisconnect:
+		return "disconnect"
+	}
+	return "unknown"
+}
+
+// default herald session limits
+const (
+	DefaultHeraldSessionQueue = 256
+	DefaultHeraldSessionTTL   = 5 * time.Minute
+)
+
+// HeraldSessionOptions - structure, which is used to configure sessions of
+// herald agents (HeraldOptions.Session).
+//
+// Session is logical connection of agent, which survives reconnects:
+// messages sent with Publish and Broadcast are queued per agent name and
+// delivered in order; messages not acknowledged when connection breaks
+// are kept and flushed once agent with the same name reconnects within
+// TTL. Delivery is at least once: message, which was executed by agent
+// but whose acknowledgement was lost, is sent again. Agent, which doesn't
+// acknowledge message within HeraldOptions.Timeout, is disconnected, so
+// message is redelivered after it reconnects.
+type HeraldSessionOptions struct {
+	// MaxQueued - limit of queued messages per session. When it would be
+	// exceeded, Overflow policy applies.
+	//
+	// Default: DefaultHeraldSessionQueue (256).
+	MaxQueued int
+
+	// MaxQueuedBytes - limit of total size of queued messages per session,
+	// enforced as MaxQueued. Single message over limit is still queued
+	// to empty queue.
+	//
+	// Default: 0 (no limit).
+	MaxQueuedBytes int
+
+	// Overflow - what to do when message doesn't fit into queue. Queues
+	// are per session and Broadcast never waits for delivery, so slow
+	// agent only affects its own queue.
+	//
+	// Default: HeraldDropOldest.
+	Overflow HeraldOverflow
+
+	// TTL - time, for which session of disconnected agent is kept (as
+	// measured by clock of HeraldOptions.RPC). Queued messages are dropped
+	// with expired session.
+	//
+	// Default: DefaultHeraldSessionTTL (5m).
+	TTL time.Duration
+
+	// OnDrop is called for message, which was dropped from queue (or
+	// refused) because of limits, session expiry or disconnect policy.
+	OnDrop func(name, cmd string)
+}
+
+// HeraldSessionStats - delivery metrics of session.
+type HeraldSessionStats struct {
+	Name      string
+	Connected bool
+
+	Queued      int
+	QueuedBytes int
+
+	// Delivered - messages acknowledged by agent; Failed - subset of
+	// them, for which agent reported error
+	Delivered int64
+	Failed    int64
+	// Redelivered - messages sent again after connection failure
+	Redelivered int64
+	// Dropped - messages dropped or refused by limits
+	Dropped int64
+}
+
+// heraldSession - queue of messages of one agent name.
+type heraldSession struct {
+	name string
+
+	// guarded by Herald.mu
+	agent   *heraldAgentConn // nil while disconnected
+	queue   []heraldQueued
+	bytes   int
+	lastSeq uint64
+	changed chan struct{} // closed and replaced on every change
+	expire  func() bool   // stops expiry timer of disconnected session
+
+	// sentSeq - last message sent to agent, to count redeliveries
+	sentSeq uint64
+
+	delivered, failed, redelivered, dropped int64
+}
+
+type heraldQueued struct {
+	seq uint64
+	cmd string
+	msg []byte
+}
+
+// notifyLocked - wake flushing goroutine.
+func (sess *heraldSession) notifyLocked() {
+	close(sess.changed)
+	sess.changed = make(chan struct{})
+}
+
+// Publish - queue command for agent and return without waiting for
+// acknowledgement; see HeraldSessionOptions. Agent need not be connected,
+// but must have session: it must have connected before and not expired.
+func (h *Herald) Publish(name, cmd string, payload []byte) error {
+	if h.o.Session == nil {
+		return errors.New("herald publish fail: sessions not enabled")
+	}
+	msg, err := encodeHeraldCommand(cmd, payload)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	sess := h.sessions[name]
+	queued, cut := false, (*heraldAgentConn)(nil)
+	if sess != nil {
+		queued, cut = h.enqueueLocked(sess, cmd, msg)
+	}
+	h.mu.Unlock()
+
+	if sess == nil {
+		return ErrAgentNotFound
+	}
+	h.cut(name, cut)
+	if !queued {
+		return ErrHeraldQueueFull
+	}
+	return nil
+}
+
+// Broadcast - Publish command to all sessions, connected and not, and
+// return number of local sessions, which queued it. Command is also
+// relayed to linked herald nodes (see ServePeer).
+func (h *Herald) Broadcast(cmd string, payload []byte) (int, error) {
+	if h.o.Session == nil {
+		return 0, errors.New("herald broadcast fail: sessions not enabled")
+	}
+	msg, err := encodeHeraldCommand(cmd, payload)
+	if err != nil {
+		return 0, err
+	}
+
+	n := h.broadcastLocal(cmd, msg)
+	h.relay(encodeRelay(h.relayState.nodeID, h.relayState.lastID.Add(1), 0, msg), nil)
+	return n, nil
+}
+
+// broadcastLocal - queue encoded command to all local sessions.
+func (h *Herald) broadcastLocal(cmd string, msg []byte) int {
+	n := 0
+	cut := make(map[string]*heraldAgentConn)
+	h.mu.Lock()
+	for name, sess := range h.sessions {
+		queued, a := h.enqueueLocked(sess, cmd, msg)
+		if queued {
+			n++
+		}
+		if a != nil {
+			cut[name] = a
+		}
+	}
+	h.mu.Unlock()
+
+	for name, a := range cut {
+		h.cut(name, a)
+	}
+	return n
+}
+
+// Queued - return number of messages queued for agent, which are not yet
+// acknowledged.
+func (h *Herald) Queued(name string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if sess := h.sessions[name]; sess != nil {
+		return len(sess.queue)
+	}
+	return 0
+}
+
+// enqueueLocked - queue message by overflow policy; report whether it was
+// queued and connection of agent to cut, if policy drops session.
+func (h *Herald) enqueueLocked(sess *heraldSession, cmd string, msg []byte) (bool, *heraldAgentConn) {
+	o := h.o.Session
+	full := func() bool {
+		return len(sess.queue) > 0 &&
+			(len(sess.queue) >= o.MaxQueued || o.MaxQueuedBytes > 0 && sess.bytes+len(msg) > o.MaxQueuedBytes)
+	}
+
+	if full() {
+		switch o.Overflow {
+		case HeraldDropNewest:
+			sess.dropped++
+			h.dropped(sess.name, cmd)
+			return false, nil
+		case HeraldDisconnect:
+			for _, q := range sess.queue {
+				h.dropped(sess.name, q.cmd)
+			}
+			h.dropped(sess.name, cmd)
+			delete(h.sessions, sess.name)
+			if sess.expire != nil {
+				sess.expire()
+			}
+			a := sess.agent
+			sess.agent = nil
+			sess.notifyLocked()
+			return false, a
+		}
+		for full() {
+			dropped := sess.queue[0]
+			sess.queue = sess.queue[1:]
+			sess.bytes -= len(dropped.msg)
+			sess.dropped++
+			h.dropped(sess.name, dropped.cmd)
+		}
+	}
+
+	sess.lastSeq++
+	sess.queue = append(sess.queue, heraldQueued{seq: sess.lastSeq, cmd: cmd, msg: msg})
+	sess.bytes += len(msg)
+	sess.notifyLocked()
+	return true, nil
+}
+
+// cut - disconnect agent, which session was dropped by overflow policy.
+func (h *Herald) cut(name string, a *heraldAgentConn) {
+	if a == nil {
+		return
+	}
+	a.rpc.Close()
+	a.conn.closeWith(CloseReasonPolicy, fmt.Errorf("herald session %s: %w", name, ErrHeraldQueueFull))
+}
+
+// SessionStats - return delivery metrics of sessions, sorted by name.
+func (h *Herald) SessionStats() []HeraldSessionStats {
+	h.mu.Lock()
+	stats := make([]HeraldSessionStats, 0, len(h.sessions))
+	for name, sess := range h.sessions {
+		stats = append(stats, HeraldSessionStats{
+			Name:        name,
+			Connected:   sess.agent != nil,
+			Queued:      len(sess.queue),
+			QueuedBytes: sess.bytes,
+			Delivered:   sess.delivered,
+			Failed:      sess.failed,
+			Redelivered: sess.redelivered,
+			Dropped:     sess.dropped,
+		})
+	}
+	h.mu.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+func (h *Herald) dropped(name, cmd string) {
+	if h.o.Session.OnDrop != nil {
+		go h.o.Session.OnDrop(name, cmd)
+	}
+}
+
+// attachSessionLocked - bind agent connection to its session, creating
+// session on first registration.
+func (h *Herald) attachSessionLocked(name string, a *heraldAgentConn) {
+	sess := h.sessions[name]
+	if sess == nil {
+		sess = &heraldSession{name: name, changed: make(chan struct{})}
+		h.sessions[name] = sess
+	}
+	if sess.expire != nil {
+		sess.expire()
+		sess.expire = nil
+	}
+	sess.agent = a
+	sess.notifyLocked()
+
+	go h.flush(sess, a)
+}
+
+// detachSessionLocked - mark session disconnected and schedule its
+// expiry.
+func (h *Herald) detachSessionLocked(name string, a *heraldAgentConn) {
+	sess := h.sessions[name]
+	if sess == nil || sess.agent != a {
+		return
+	}
+	sess.agent = nil
+	sess.notifyLocked()
+	sess.expire = clockOrReal(h.o.RPC.Clock).AfterFunc(h.o.Session.TTL, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if h.sessions[name] != sess || sess.agent != nil {
+			return
+		}
+		delete(h.sessions, name)
+		for _, q := range sess.queue {
+			h.dropped(name, q.cmd)
+		}
+	})
+}
+
+// flush - deliver queued messages of session over a, in order, until a
+// is replaced or fails.
+func (h *Herald) flush(sess *heraldSession, a *heraldAgentConn) {
+	for {
+		h.mu.Lock()
+		if sess.agent != a {
+			h.mu.Unlock()
+			return
+		}
+		if len(sess.queue) == 0 {
+			changed := sess.changed
+			h.mu.Unlock()
+			select {
+			case <-changed:
+			case <-a.rpc.Done():
+				return
+			}
+			continue
+		}
+		head := sess.queue[0]
+		if head.seq <= sess.sentSeq {
+			sess.redelivered++
+		}
+		sess.sentSeq = head.seq
+		h.mu.Unlock()
+
+		_, err := a.rpc.Call(head.msg, h.o.Timeout)
+		var rerr *RPCError
+		if err != nil && !errors.As(err, &rerr) {
+			// not delivered; kept for next connection
+			if !errors.Is(err, ErrRPCTimeout) {
+				return
+			}
+			a.conn.closeWith(CloseReasonTimeout, fmt.Errorf("herald session %s: %w", sess.name, err))
+			return
+		}
+
+		// delivered (agent may have reported failure; it is not retried)
+		h.mu.Lock()
+		sess.delivered++
+		if err != nil {
+			sess.failed++
+		}
+		if len(sess.queue) > 0 && sess.queue[0].seq == head.seq {
+			sess.bytes -= len(head.msg)
+			sess.queue = sess.queue[1:]
+		}
+		h.mu.Unlock()
+	}
+}
@@ -7,14 +7,20 @@ package herots
 
 import (
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
+	"sort"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -40,6 +46,12 @@ type log struct {
 	LogLevel       LogLevelType
 	LogDestination io.Writer
 	Handler        LogHandlerFunc
+
+	// debug - log messages of all levels, see Server.SetDebugLog
+	debug atomic.Bool
+
+	// mu serializes writes to LogDestination
+	mu sync.Mutex
 }
 
 func (l *log) Log(message string, lvl LogLevelType) {
@@ -48,16 +60,28 @@ func (l *log) Log(message string, lvl LogLevelType) {
 		return
 	}
 
+	if l.debug.Load() {
+		l.print(message)
+		return
+	}
+
 	if l.LogLevel == 0 {
 		return
 	}
 
 	if lvl <= l.LogLevel {
-		fmt.Fprintf(l.LogDestination, "herots: %s\n", message)
+		l.print(message)
 	}
 
 }
 
+// print - write message to LogDestination regardless of log level.
+func (l *log) print(message string) {
+	l.mu.Lock()
+	fmt.Fprintf(l.LogDestination, "herots: %s\n", message)
+	l.mu.Unlock()
+}
+
 // loadKeyPair - internal function for load certificate and private key pair.
 func loadKeyPair(cert, key []byte) (tls.Certificate, *x509.Certificate, error) {
 	c, err := tls.X509KeyPair(cert, key)
@@ -74,6 +98,18 @@ func loadKeyPair(cert, key []byte) (tls.Certificate, *x509.Certificate, error) {
 	return c, ca, nil
 }
 
+// loadKeyPair - load key pair according to KeySource and ProtectKeys
+// options.
+func (o *Options) loadKeyPair(cert, key []byte) (tls.Certificate, *x509.Certificate, error) {
+	if o.KeySource != nil {
+		return loadKeySourcePair(cert, o.KeySource)
+	}
+	if o.ProtectKeys {
+		return loadProtectedKeyPair(cert, key)
+	}
+	return loadKeyPair(cert, key)
+}
+
 // Options - structure, which is used to configure a TLS server and client.
 type Options struct {
 	// Server host.
@@ -86,6 +122,44 @@ type Options struct {
 	// Default: '9000'.
 	Port int
 
+	// Pipe - path of Windows named pipe (e.g. `\\.\pipe\herots`), which
+	// server listens on and client dials instead of Host:Port, for hosts
+	// where local TCP ports can't be opened. Pipe is created with default
+	// security descriptor and rejects remote clients; TLS and all other
+	// options work as over TCP, except that pipe connections have no IP
+	// address, so IP based admission rules (GeoIPAdmission, ReverseDNS)
+	// reject them, and Restart is not supported. Client verifies server
+	// certificate against ServerName or Host.
+	//
+	// Supported only on Windows; elsewhere Start and Dial fail.
+	//
+	// Default: "" (TCP).
+	Pipe string
+
+	// Inetd - serve single already accepted connection instead of
+	// listening, for services started per connection by inetd/xinetd or
+	// as SSH ForceCommand: connection is inherited descriptor InetdFD,
+	// which may be a socket or, for standard input, a pair of standard
+	// input and output streams. First AcceptConn returns it; server closes
+	// itself once it is closed, so Run returns after its handler.
+	//
+	// Standard input and output belong to connection, so with InetdFD 0
+	// logs must go elsewhere: LogDestination defaults to io.Discard. If
+	// they are socket, they are closed once it is taken over (connection
+	// uses its duplicate), so closing connection ends it for peer.
+	//
+	// This option ignored for client implementation.
+	//
+	// Default: false.
+	Inetd bool
+
+	// InetdFD - descriptor of connection in Inetd mode.
+	//
+	// This option ignored for client implementation.
+	//
+	// Default: 0 (standard input and output).
+	InetdFD int
+
 	// LogLevel provides the opportunity to choose the level of
 	// information messages.
 	// Each level includes the messages from the previous level.
@@ -113,8 +187,360 @@ type Options struct {
 	//
 	// This option ignored for client implementation.
 	//
-	// Default: tls.RequireAnyClientCert
+	// Default: tls.RequireAnyClientCert; tls.VerifyClientCertIfGiven with
+	// Authenticator (which may replace client certificates).
 	TLSAuthType tls.ClientAuthType
+
+	// ReadRateLimit limits the rate of data read from each connection,
+	// in bytes per second. ReadBurst sets how many bytes may be read at
+	// once after connection was idle.
+	//
+	// This option ignored for client implementation.
+	//
+	// Default: 0 (no limit); burst - one second of traffic.
+	ReadRateLimit int
+	ReadBurst     int
+
+	// WriteRateLimit limits the rate of data written to each connection,
+	// in bytes per second. WriteBurst sets how many bytes may be written
+	// at once after connection was idle.
+	//
+	// This option ignored for client implementation.
+	//
+	// Default: 0 (no limit); burst - one second of traffic.
+	WriteRateLimit int
+	WriteBurst     int
+
+	// GlobalReadRateLimit and GlobalWriteRateLimit limit total rate of
+	// data read from / written to all connections of server, in bytes per
+	// second. Bandwidth is shared between connections in small chunks, so
+	// one busy connection can't take it all. Global limits apply in
+	// addition to per-connection limits.
+	//
+	// This option ignored for client implementation.
+	//
+	// Default: 0 (no limit); burst - one second of traffic.
+	GlobalReadRateLimit  int
+	GlobalReadBurst      int
+	GlobalWriteRateLimit int
+	GlobalWriteBurst     int
+
+	// AcceptQueue - admission queue, which handshakes incoming connections
+	// in background and hands them to AcceptConn by priority, shedding
+	// lowest priority ones under overload.
+	//
+	// This option ignored for client implementation.
+	//
+	// Default: nil (connections are accepted in FIFO order).
+	AcceptQueue *AcceptQueueOptions
+
+	// Clock - source of time, see Clock.
+	//
+	// Default: nil (real time).
+	Clock Clock
+
+	// Handler - function, which serves connections accepted by Server.Run,
+	// each in its own goroutine. Connection is closed after handler
+	// returns.
+	//
+	// This option ignored for client implementation.
+	Handler func(c *Conn)
+
+	// Signals - configuration of operational control signals, see
+	// Server.HandleSignals.
+	//
+	// This option ignored for client implementation.
+	//
+	// Default: nil (stats dump and debug toggle enabled, no reload).
+	Signals *SignalOptions
+
+	// OnClose is called once for each closed connection with reason of
+	// close and error, which caused it (nil if none); see CloseReason.
+	// Callback runs synchronously in Close, keep it fast.
+	//
+	// This option ignored for client implementation.
+	OnClose func(c *Conn, reason CloseReason, err error)
+
+	// OnDrain is called for each active connection when graceful shutdown
+	// begins (see Server.Shutdown), so handler may notify peer at protocol
+	// level. Callbacks run in their own goroutines; reads of new data from
+	// connection return io.EOF by the time callback starts.
+	//
+	// This option ignored for client implementation.
+	OnDrain func(c *Conn)
+
+	// ShutdownGracePeriod - how long Shutdown waits for draining
+	// connections to be closed by handlers before closing them forcibly.
+	//
+	// This option ignored for client implementation.
+	//
+	// Default: 0 (close immediately).
+	ShutdownGracePeriod time.Duration
+
+	// RestartTimeout - how long Restart waits for new process to report
+	// readiness before giving up and keeping current one.
+	//
+	// This option ignored for client implementation.
+	//
+	// Default: DefaultRestartTimeout (30s).
+	RestartTimeout time.Duration
+
+	// OnHandshakeError is called when TLS handshake of accepted
+	// connection fails, with diagnostics: remote address, offered SNI,
+	// ALPN and TLS versions and classified reason.
+	//
+	// This option ignored for client implementation.
+	OnHandshakeError func(e *HandshakeError)
+
+	// HandshakeOnAccept makes AcceptConn complete TLS handshake (within
+	// HandshakeTimeout) before returning connection, so handshake errors
+	// surface at accept time: AcceptConn closes connection and returns
+	// *HandshakeError, which is not fatal - keep accepting.
+	//
+	// This option ignored for client implementation.
+	//
+	// Default: false (handshake on first Read/Write or Conn.Handshake);
+	// timeout - DefaultHandshakeTimeout (10s).
+	HandshakeOnAccept bool
+	HandshakeTimeout  time.Duration
+
+	// OnClientHello is called with ClientHello of each connecting client
+	// before handshake continues: SNI, offered cipher suites, versions,
+	// ALPN protocols. Returned error aborts handshake; it is reported as
+	// HandshakeError with HandshakeFailureRejected reason.
+	//
+	// This option ignored for client implementation.
+	OnClientHello func(hello *tls.ClientHelloInfo) error
+
+	// Honeypot enables observation mode: server accepts any client (client
+	// certificate is requested, but not required nor verified), never
+	// serves application data and records metadata of each connection
+	// (see Observation) to log and HoneypotSink. TLSAuthType is ignored in
+	// this mode.
+	//
+	// HoneypotCaptureBytes - how many bytes of raw input and of initial
+	// application payload to record (0 - none).
+	//
+	// HoneypotTimeout - how long observed client may stay connected.
+	//
+	// HoneypotMaxConns - how many connections are observed at once;
+	// connections beyond it are closed right away (and not reported).
+	//
+	// This option ignored for client implementation.
+	//
+	// Default: false; timeout - DefaultHoneypotTimeout (10s); max conns -
+	// DefaultHoneypotMaxConns (256).
+	Honeypot             bool
+	HoneypotSink         func(o *Observation)
+	HoneypotCaptureBytes int
+	HoneypotTimeout      time.Duration
+	HoneypotMaxConns     int
+
+	// Capture enables traffic capture (see CaptureOptions) for every
+	// accepted connection. Capture may also be started for single
+	// connection with Conn.StartCapture.
+	//
+	// This option ignored for client implementation.
+	//
+	// Default: nil (no capture).
+	Capture *CaptureOptions
+
+	// MaxConnectionAge - absolute lifetime of connection. When it is
+	// reached, connection is drained as on shutdown (OnDrain is called,
+	// reads return io.EOF) and closed after MaxConnectionAgeGrace, which
+	// makes clients reconnect: re-handshake, re-authenticate and possibly
+	// land on other node.
+	//
+	// MaxConnectionAgeJitter - random extra lifetime up to this value,
+	// so connections accepted together don't expire together.
+	//
+	// This option ignored for client implementation.
+	//
+	// Default: 0 (unlimited); grace - DefaultMaxConnectionAgeGrace (10s).
+	MaxConnectionAge       time.Duration
+	MaxConnectionAgeJitter time.Duration
+	MaxConnectionAgeGrace  time.Duration
+
+	// Renegotiation - refer to http://golang.org/pkg/crypto/tls/#RenegotiationSupport
+	//
+	// Renegotiation is insecure in general; allow it only for legacy
+	// servers, which require it (tls.RenegotiateOnceAsClient is enough
+	// for most of them). TLS 1.3 never renegotiates.
+	//
+	// This option ignored for server implementation: Go TLS servers
+	// don't support renegotiation at all.
+	//
+	// Default: tls.RenegotiateNever.
+	Renegotiation tls.RenegotiationSupport
+
+	// SessionTicketsDisabled - disable TLS session resumption: every
+	// connection makes full handshake. Overrides SessionCacheSize.
+	//
+	// TLS 1.3 0-RTT early data is not supported, with resumption or
+	// without: crypto/tls doesn't implement it, so first data of client
+	// is always sent after handshake round trip.
+	//
+	// This option ignored for client implementation.
+	//
+	// Default: false.
+	SessionTicketsDisabled bool
+
+	// SessionCacheSize - keep state of resumable sessions on server, in
+	// LRU cache of given size, instead of stateless tickets with state
+	// encrypted by ticket key. Clients get only random cache key, so
+	// sessions are revoked on eviction or FlushSessionCache, and don't
+	// outlive server process; memory use is bounded by size. With 0
+	// stateless tickets are used.
+	//
+	// This option ignored for client implementation.
+	//
+	// Default: 0.
+	SessionCacheSize int
+
+	// Admission - hook, which decides whether connection is admitted,
+	// before TLS handshake and other admission rules (see GeoIPAdmission
+	// for example). Rejected connections fail handshake with
+	// HandshakeFailureAdmission.
+	//
+	// This option ignored for client implementation.
+	//
+	// Default: nil (all connections admitted).
+	Admission AdmissionFunc
+
+	// ReverseDNS - admission rule, which requires forward-confirmed host
+	// name of client IP to match configured patterns. Rule is checked
+	// before TLS handshake; rejected connections fail handshake with
+	// HandshakeFailureAdmission.
+	//
+	// This option ignored for client implementation.
+	//
+	// Default: nil (no check).
+	ReverseDNS *ReverseDNSOptions
+
+	// Abuse - limits of pre-handshake traffic and temporary bans of IPs
+	// with repeatedly failing handshakes, see AbuseOptions.
+	//
+	// This option ignored for client implementation.
+	//
+	// Default: nil (no limits beyond those of crypto/tls, no bans).
+	Abuse *AbuseOptions
+
+	// Compression - offer per-message gzip compression to peer (via ALPN,
+	// see CompressionProtocol). If both sides offer it, Codec (and RPC)
+	// created with NewCodec over connection compresses messages larger
+	// than DefaultCompressionThreshold.
+	//
+	// Default: false.
+	Compression bool
+
+	// ProtectKeys - handle private keys with extra care: key PEM data
+	// passed to LoadKeyPair (and AddKeyPair) is wiped after parsing, key
+	// material is locked in memory where platform supports it (mlock on
+	// Linux and macOS) and wiped by Server.Close/Shutdown (Client.Close
+	// for client).
+	//
+	// Protection is best effort: values precomputed internally by crypto
+	// packages (e.g. for RSA) can't be reached and wiped.
+	//
+	// Default: false.
+	ProtectKeys bool
+
+	// KeySource - source of private keys, which are kept outside of
+	// process (HSM, cloud KMS, ssh-agent-like signer service). With it
+	// LoadKeyPair and AddKeyPair take only certificate, key argument is
+	// ignored (may be nil), and handshakes are signed by KeySource signer.
+	// See KeySource for latency notes.
+	//
+	// Default: nil (keys are passed to LoadKeyPair as PEM data).
+	KeySource KeySource
+
+	// SignPool - run private key operations of handshakes in bounded
+	// pool, so bursts of full handshakes with expensive (e.g. RSA-4096)
+	// keys don't take all CPU, see SignPoolOptions.
+	//
+	// This option ignored for client implementation.
+	//
+	// Default: nil (each handshake signs in its own goroutine).
+	SignPool *SignPoolOptions
+
+	// Authenticator - authentication step, which connection must pass
+	// after TLS handshake (as part of Conn.Handshake) before it is
+	// usable, see Authenticator. Failure is reported as handshake error
+	// with HandshakeFailureAuth.
+	//
+	// This option ignored for client implementation.
+	//
+	// Set TLSAuthType to require client certificate in addition to
+	// Authenticator.
+	//
+	// Default: nil (client certificate only, see TLSAuthType).
+	Authenticator Authenticator
+
+	// Quota - limits of connections, bytes and messages per client
+	// identity, so tenants of shared server stay within bounds, see
+	// QuotaOptions. Usage is reported by Server.QuotaUsage.
+	//
+	// This option ignored for client implementation.
+	//
+	// Default: nil (no quotas).
+	Quota *QuotaOptions
+
+	// Credentials - client side of authentication step, which is run by
+	// Dial after TLS handshake; required if server has Authenticator.
+	//
+	// This option ignored for server implementation.
+	//
+	// Default: nil (no authentication step).
+	Credentials Credentials
+
+	// ServerName - name, which server certificate is verified against
+	// (and sent as SNI), e.g. internal name of herald addressed by IP.
+	//
+	// This option ignored for server implementation.
+	//
+	// Default: "" (Host).
+	ServerName string
+
+	// InsecureSkipVerify - INSECURE: don't verify server certificate
+	// chain and name, so any server, including man in the middle, is
+	// accepted. Use only for testing, or together with VerifyConnection,
+	// which does its own checks (e.g. certificate pinning).
+	//
+	// This option ignored for server implementation.
+	//
+	// Default: false.
+	InsecureSkipVerify bool
+
+	// VerifyConnection - custom verification of server, called after
+	// standard verification (or instead of it with InsecureSkipVerify);
+	// refer to http://golang.org/pkg/crypto/tls/#Config.VerifyConnection
+	//
+	// This option ignored for server implementation.
+	//
+	// Default: nil.
+	VerifyConnection func(cs tls.ConnectionState) error
+
+	// ClientSessionCacheSize - capacity of LRU session cache of client, so
+	// reconnects resume TLS sessions instead of full handshakes.
+	// ClientSessionCacheFile - file, from which cache is loaded by
+	// NewClient and to which it is saved by Client.Close.
+	//
+	// This options ignored for server implementation.
+	//
+	// Default: 0 and "" (no cache); size - DefaultClientSessionCacheSize
+	// if only file is set.
+	ClientSessionCacheSize int
+	ClientSessionCacheFile string
+
+	// AllowMissingStaple - start server even if loaded certificate has
+	// OCSP must-staple extension and no valid staple is set with
+	// SetOCSPStaple. Problem is logged with LogLevelError then; clients
+	// honoring must-staple will refuse to connect.
+	//
+	// This option ignored for client implementation.
+	//
+	// Default: false (Start fails with ErrMissingOCSPStaple).
+	AllowMissingStaple bool
 }
 
 // predefined errors messages
@@ -128,23 +554,102 @@ const (
 ////////////////////////////////////////////////////////////////////////////////
 
 // Server - primary struct for server implementation.
+//
+// Methods of Server are safe for concurrent use. Options passed to
+// NewServer must not be modified afterwards.
 type Server struct {
 	options *Options
 	certs   struct {
 		Cert tls.Certificate
-		Pool *x509.CertPool
+		// Extra - additional key pairs for the same identity, see AddKeyPair
+		Extra []tls.Certificate
 	}
-	listener net.Listener
-	logger   *log
+
+	// listenerMu guards listener, which is set by Start
+	listenerMu sync.Mutex
+	listener   net.Listener
+
+	logger *log
+	closed atomic.Bool
+	paused atomic.Bool
+
+	// tlsConfig - TLS config built by Start, shared by all connections
+	tlsConfig *tls.Config
+
+	// certMu guards certs, tlsConfig and client CA list; client CA pool
+	// and per-handshake config are replaced as a whole on every change
+	// (see clientca.go)
+	certMu       sync.Mutex
+	clientCAList []*x509.Certificate
+	clientCAs    atomic.Pointer[x509.CertPool]
+	// mtlsCAs - CA certificates of RequireMTLSFromCA, re-applied after
+	// key pair reload; nil if preset isn't used
+	mtlsCAs      []*x509.Certificate
+	clientConfig atomic.Pointer[tls.Config]
+
+	// trustMu guards trustWatches - running WatchTrustBundle watchers,
+	// re-applied after key pair reload
+	trustMu      sync.Mutex
+	trustWatches map[*trustWatch]struct{}
+
+	// handshaking - connections in handshake, by raw net.Conn
+	handshaking sync.Map
+
+	// rdns - reverse DNS admission rule, nil if not configured
+	rdns *rdnsChecker
+
+	// abuse - handshake failures and bans, nil if not configured
+	abuse *abuseTracker
+
+	// signPool - pool of private key operations, nil if not configured
+	signPool *signPool
+
+	// quota - per-identity usage, nil if not configured
+	quota *quotaTracker
+
+	// sessions - server-side session cache, nil if not configured
+	sessions *lruCache[[]byte]
+
+	// queue - admission queue, nil if not configured
+	queue *acceptQueue
+
+	// observing - slots of observed connections in honeypot mode
+	observing chan struct{}
+
+	clock Clock
+
+	// server-wide bandwidth limits
+	readLimit  *rateLimiter
+	writeLimit *rateLimiter
+
+	// registry of active connections
+	connsMu    sync.Mutex
+	conns      map[uint64]*Conn
+	lastConnID uint64
+
+	// shuttingDown - set by Shutdown, under connsMu
+	shuttingDown bool
+
+	// errors - non-fatal runtime errors, see Errors
+	errors chan error
+
+	stats serverStats
 }
 
 // NewServer - function for create Server struct
 func NewServer(o *Options) *Server {
-	s := &Server{}
+	s := &Server{
+		conns:  make(map[uint64]*Conn),
+		errors: make(chan error, ErrorsBufferSize),
+	}
 
 	// check mandatory options
 	if o.LogDestination == nil {
 		o.LogDestination = os.Stdout
+		if o.Inetd && o.InetdFD == 0 {
+			// stdout carries connection
+			o.LogDestination = io.Discard
+		}
 	}
 
 	if o.Port == 0 {
@@ -153,6 +658,10 @@ func NewServer(o *Options) *Server {
 
 	if o.TLSAuthType == 0 {
 		o.TLSAuthType = tls.RequireAnyClientCert
+		if o.Authenticator != nil {
+			// tls.NoClientCert is zero; certificate stays optional
+			o.TLSAuthType = tls.VerifyClientCertIfGiven
+		}
 	}
 
 	l := &log{
@@ -163,85 +672,435 @@ func NewServer(o *Options) *Server {
 
 	s.options = o
 	s.logger = l
+	s.clock = clockOrReal(o.Clock)
+	s.readLimit = newSharedRateLimiter(o.GlobalReadRateLimit, o.GlobalReadBurst)
+	s.writeLimit = newSharedRateLimiter(o.GlobalWriteRateLimit, o.GlobalWriteBurst)
+	if o.ReverseDNS != nil {
+		s.rdns = newRDNSChecker(o.ReverseDNS)
+		s.rdns.now = s.clock.Now
+	}
+	if o.Abuse != nil {
+		s.abuse = newAbuseTracker(o.Abuse, s.clock.Now)
+	}
+	if o.SignPool != nil {
+		s.signPool = newSignPool(o.SignPool)
+	}
+	if o.Quota != nil {
+		s.quota = newQuotaTracker(o.Quota, s.clock.Now)
+	}
+	if o.SessionCacheSize > 0 && !o.SessionTicketsDisabled {
+		s.sessions = newLRUCache[[]byte](o.SessionCacheSize)
+	}
+	if o.AcceptQueue != nil {
+		s.queue = newAcceptQueue(o.AcceptQueue)
+	}
+	if o.Honeypot {
+		max := o.HoneypotMaxConns
+		if max <= 0 {
+			max = DefaultHoneypotMaxConns
+		}
+		s.observing = make(chan struct{}, max)
+	}
 
 	return s
 }
 
 // LoadKeyPair - function for load certificate and private key pair.
 //
-// Public/private key pair require as PEM encoded data.
+// Public/private key pair require as PEM encoded data. Key pairs added by
+// AddKeyPair are dropped and client CA pool is reset to certificate of
+// new pair (or to CA certificates of RequireMTLSFromCA, if preset is
+// applied). On running server new pair is used by handshakes started
+// after LoadKeyPair returns.
 func (s *Server) LoadKeyPair(cert, key []byte) error {
-	c, ca, err := loadKeyPair(cert, key)
+	c, ca, err := s.options.loadKeyPair(cert, key)
 	if err != nil {
 		return fmt.Errorf("%s: %v\n", LoadKeyPairError, err)
 	}
 
+	s.certMu.Lock()
 	s.certs.Cert = c
-
-	s.certs.Pool = x509.NewCertPool()
-	s.certs.Pool.AddCert(ca)
+	s.certs.Extra = nil
+	s.setClientCAsLocked(s.reloadClientCAsLocked(ca))
+	s.certMu.Unlock()
 
 	s.logger.Log("load key pair - ok", LogLevelInfo)
+	s.reapplyTrustBundles()
 
 	return nil
 }
 
+// AddKeyPair - function for load additional certificate and private key
+// pair for the same identity as the one loaded by LoadKeyPair, e.g. RSA
+// pair next to ECDSA one.
+//
+// During handshake the first pair supported by client is used; ECDSA and
+// Ed25519 pairs are preferred over RSA, so modern clients get faster
+// handshakes while legacy clients still connect. Like LoadKeyPair,
+// certificate is added to client CA pool.
+func (s *Server) AddKeyPair(cert, key []byte) error {
+	c, ca, err := s.options.loadKeyPair(cert, key)
+	if err != nil {
+		return fmt.Errorf("%s: %v\n", LoadKeyPairError, err)
+	}
+
+	s.certMu.Lock()
+	defer s.certMu.Unlock()
+
+	if len(s.certs.Cert.Certificate) == 0 {
+		return fmt.Errorf("%s\n", NoKeyPairLoadError)
+	}
+	primary, err := x509.ParseCertificate(s.certs.Cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("%s: %v\n", LoadKeyPairError, err)
+	}
+	if primary.Subject.String() != ca.Subject.String() {
+		return fmt.Errorf("%s: subject %q differs from loaded %q\n",
+			LoadKeyPairError, ca.Subject, primary.Subject)
+	}
+
+	s.certs.Extra = append(s.certs.Extra, c)
+	s.addClientCALocked(ca)
+
+	s.logger.Log("add key pair - ok", LogLevelInfo)
+
+	return nil
+}
+
+// keyPairsLocked - pointers to loaded key pairs, for updating them under
+// certMu (see SetOCSPStaple).
+func (s *Server) keyPairsLocked() []*tls.Certificate {
+	if len(s.certs.Cert.Certificate) == 0 {
+		return nil
+	}
+	certs := []*tls.Certificate{&s.certs.Cert}
+	for i := range s.certs.Extra {
+		certs = append(certs, &s.certs.Extra[i])
+	}
+	return certs
+}
+
+// certificates - loaded key pairs, non-RSA first, with keys wrapped by
+// sign pool if any. Caller must hold certMu.
+func (s *Server) certificates() []tls.Certificate {
+	certs := append([]tls.Certificate{s.certs.Cert}, s.certs.Extra...)
+	sort.SliceStable(certs, func(i, j int) bool {
+		_, iRSA := certs[i].PrivateKey.(*rsa.PrivateKey)
+		_, jRSA := certs[j].PrivateKey.(*rsa.PrivateKey)
+		return !iRSA && jRSA
+	})
+	if s.signPool != nil {
+		for i := range certs {
+			certs[i].PrivateKey = s.signPool.wrap(certs[i].PrivateKey)
+		}
+	}
+	return certs
+}
+
 // AddClientCACert - function for adding client CA certificate to
 // x509.CertPool (tls.Config.ClientCAs).
 //
 // By default server add cert from server public/private key pair (LoadKeyPair)
-// to cert pool.
+// to cert pool. AddClientCACert may be called on running server: new CA is
+// trusted by handshakes started after it returns.
 func (s *Server) AddClientCACert(cert []byte) error {
 	pemData, _ := pem.Decode(cert)
+	if pemData == nil {
+		return fmt.Errorf("load client CA cert error: no PEM data\n")
+	}
 	ca, err := x509.ParseCertificate(pemData.Bytes)
 	if err != nil {
 		return fmt.Errorf("load client CA cert error: %v\n", err)
 	}
-	s.certs.Pool.AddCert(ca)
+	s.addClientCA(ca)
 
 	s.logger.Log("load client CA cert - ok", LogLevelInfo)
 
 	return nil
 }
 
-// Accept - accept and return connections.
-func (s *Server) Accept() (net.Conn, error) {
-	conn, err := s.listener.Accept()
+// AcceptConn - accept and return connections.
+//
+// While accepting is paused (see PauseAccept), incoming connections are
+// closed right away and AcceptConn keeps waiting.
+//
+// With Options.HandshakeOnAccept AcceptConn returns connections with
+// completed handshake; failed handshake is returned as *HandshakeError.
+// With Options.AcceptQueue connections are taken from admission queue in
+// order of priority (see AcceptQueueOptions).
+//
+// In honeypot mode (Options.Honeypot) AcceptConn only observes connections
+// and never returns one; it returns when listener fails or server is
+// closed.
+func (s *Server) AcceptConn() (*Conn, error) {
+	if s.queue != nil && s.currentListener() != nil {
+		return s.queue.pop()
+	}
+
+	c, err := s.acceptOne()
 	if err != nil {
-		s.logger.Log("accept conn error: "+err.Error(), LogLevelError)
-		return conn, fmt.Errorf("connection accept fail: %v\n", err)
+		return nil, err
+	}
+	if s.options.HandshakeOnAccept {
+		if err := c.handshakeWithTimeout(); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// acceptOne - take next connection from listener.
+func (s *Server) acceptOne() (*Conn, error) {
+	ln := s.currentListener()
+	if ln == nil {
+		return nil, fmt.Errorf("connection accept fail: server not started\n")
+	}
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if s.closed.Load() {
+				return nil, ErrServerClosed
+			}
+			s.reportError("accept", nil, err)
+			return nil, fmt.Errorf("connection accept fail: %v\n", err)
+		}
+
+		if s.paused.Load() {
+			s.logger.Log("accept paused, rejected conn from "+conn.RemoteAddr().String(), LogLevelInfo)
+			conn.Close()
+			continue
+		}
+
+		if s.abuse != nil && s.abuse.banned(conn.RemoteAddr()) {
+			s.stats.banned.Add(1)
+			s.logger.Log("banned, rejected conn from "+conn.RemoteAddr().String(), LogLevelInfo)
+			conn.Close()
+			continue
+		}
+
+		if s.options.Honeypot {
+			select {
+			case s.observing <- struct{}{}:
+				go s.observe(conn)
+			default:
+				s.logger.Log("honeypot: too many observed conns, rejected conn from "+conn.RemoteAddr().String(), LogLevelInfo)
+				conn.Close()
+			}
+			continue
+		}
+
+		c := newConn(s, conn)
+		s.stats.accepted.Add(1)
+		c.startLifetime()
+		s.logger.Log("accepted "+c.String(), LogLevelInfo)
+		if s.options.Capture != nil {
+			if err := c.StartCapture(s.options.Capture); err != nil {
+				s.reportError("capture", c, err)
+			}
+		}
+		return c, nil
+	}
+}
+
+// Accept - implements net.Listener, so started server can be passed to
+// http.Serve and other servers consuming listener: same as AcceptConn
+// (connections are *Conn), except that failed handshakes (with
+// Options.HandshakeOnAccept) are only logged and don't stop serving.
+//
+// Note that http.Server doesn't see TLS state of *Conn (Request.TLS is
+// nil); use http.Server.ConnContext to pass *Conn to handlers.
+func (s *Server) Accept() (net.Conn, error) {
+	for {
+		c, err := s.AcceptConn()
+		if err == nil {
+			return c, nil
+		}
+		var herr *HandshakeError
+		if !errors.As(err, &herr) {
+			return nil, err
+		}
+		s.logger.Log(err.Error(), LogLevelInfo)
+	}
+}
+
+// Addr - implements net.Listener: return listening address, nil before
+// Start.
+func (s *Server) Addr() net.Addr {
+	if ln := s.currentListener(); ln != nil {
+		return ln.Addr()
+	}
+	return nil
+}
+
+// PauseAccept - temporarily stop taking new connections.
+//
+// Active connections are not affected.
+func (s *Server) PauseAccept() {
+	if !s.paused.Swap(true) {
+		s.logger.Log("accept paused", LogLevelNotice)
+	}
+}
+
+// ResumeAccept - resume taking new connections after PauseAccept.
+func (s *Server) ResumeAccept() {
+	if s.paused.Swap(false) {
+		s.logger.Log("accept resumed", LogLevelNotice)
+	}
+}
+
+// AcceptPaused - report whether accepting of new connections is paused.
+func (s *Server) AcceptPaused() bool {
+	return s.paused.Load()
+}
+
+// Conns - return snapshot of active connections.
+func (s *Server) Conns() []*Conn {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	conns := make([]*Conn, 0, len(s.conns))
+	for _, c := range s.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// register - add connection to registry and assign its id.
+//
+// Connection registered during Shutdown is drained at once.
+func (s *Server) register(c *Conn) {
+	s.connsMu.Lock()
+	s.lastConnID++
+	c.id = s.lastConnID
+	s.conns[c.id] = c
+	shuttingDown := s.shuttingDown
+	s.connsMu.Unlock()
+
+	if shuttingDown {
+		c.drain(CloseReasonShutdown)
 	}
-	s.logger.Log("accepted conn from "+conn.RemoteAddr().String(), LogLevelInfo)
-	return conn, nil
+}
+
+// unregister - remove connection from registry.
+func (s *Server) unregister(c *Conn) {
+	s.connsMu.Lock()
+	delete(s.conns, c.id)
+	s.connsMu.Unlock()
 }
 
 // Start - function for start server.
 func (s *Server) Start() error {
-	// load keypair check
-	if len(s.certs.Cert.Certificate) == 0 {
-		return fmt.Errorf("%s\n", NoKeyPairLoadError)
-	}
+	s.listenerMu.Lock()
+	defer s.listenerMu.Unlock()
 
-	config := tls.Config{
-		ClientAuth:   s.options.TLSAuthType,
-		Certificates: []tls.Certificate{s.certs.Cert},
-		ClientCAs:    s.certs.Pool,
-		Rand:         rand.Reader,
+	if s.listener != nil {
+		return fmt.Errorf("start tls server fail: server already started\n")
 	}
 
-	service := s.options.Host + ":" + strconv.Itoa(s.options.Port)
+	config, err := s.buildTLSConfig()
+	if err != nil {
+		return err
+	}
 
-	listener, err := tls.Listen("tcp", service, &config)
+	raw, service, msg, err := s.listen()
 	if err != nil {
 		return fmt.Errorf("start tls server fail: %v\n", err)
 	}
-	s.listener = listener
+	s.setTLSConfig(config)
+	s.listener = raw
+	if service != "" {
+		addListener(service, raw)
+	}
+	if s.queue != nil {
+		go s.queue.run(s)
+	}
 
-	s.logger.Log("listening on "+service, LogLevelNotice)
+	s.logger.Log(msg, LogLevelNotice)
 
 	return nil
 }
 
+// listen - open listener of configured transport. Service is Host:Port
+// for TCP listeners, which are passed on Restart, and empty otherwise; msg
+// is log message.
+func (s *Server) listen() (ln net.Listener, service, msg string, err error) {
+	switch {
+	case s.options.Inetd:
+		l, err := listenInetd(s, s.options.InetdFD)
+		if err != nil {
+			return nil, "", "", err
+		}
+		return l, "", "serving inetd connection from " + l.conn.RemoteAddr().String(), nil
+	case s.options.Pipe != "":
+		ln, err = listenPipe(s.options.Pipe)
+		return ln, "", "listening on pipe " + s.options.Pipe, err
+	}
+
+	service = s.options.Host + ":" + strconv.Itoa(s.options.Port)
+
+	ln, inherited, err := inheritedListener(service)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if inherited {
+		return ln, service, "listening on inherited " + ln.Addr().String(), nil
+	}
+	ln, err = net.Listen("tcp", service)
+	if err != nil {
+		return nil, "", "", err
+	}
+	return ln, service, "listening on " + service, nil
+}
+
+// buildTLSConfig - check loaded key pairs and build TLS config for Start.
+func (s *Server) buildTLSConfig() (*tls.Config, error) {
+	s.certMu.Lock()
+	defer s.certMu.Unlock()
+
+	// load keypair check
+	if len(s.certs.Cert.Certificate) == 0 {
+		return nil, fmt.Errorf("%s\n", NoKeyPairLoadError)
+	}
+
+	if err := s.checkStaplesLocked(); err != nil {
+		if !s.options.AllowMissingStaple {
+			return nil, err
+		}
+		s.logger.Log(err.Error(), LogLevelError)
+	}
+
+	authType := s.options.TLSAuthType
+	if s.options.Honeypot {
+		authType = tls.RequestClientCert
+	}
+
+	config := &tls.Config{
+		ClientAuth:   authType,
+		Certificates: s.certificates(),
+		ClientCAs:    s.clientCAs.Load(),
+		Rand:         rand.Reader,
+		Time:         s.clock.Now,
+	}
+	config.GetConfigForClient = s.getConfigForClient
+	if s.options.Compression {
+		config.NextProtos = []string{CompressionProtocol}
+	}
+	config.SessionTicketsDisabled = s.options.SessionTicketsDisabled
+	if s.sessions != nil {
+		config.WrapSession = s.wrapSession
+		config.UnwrapSession = s.unwrapSession
+	}
+	return config, nil
+}
+
+// currentListener - listener of started server, nil before Start.
+func (s *Server) currentListener() net.Listener {
+	s.listenerMu.Lock()
+	defer s.listenerMu.Unlock()
+	return s.listener
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 //                                  Client                                    //
 ////////////////////////////////////////////////////////////////////////////////
@@ -254,6 +1113,9 @@ type Client struct {
 		Pool *x509.CertPool
 	}
 	logger *log
+
+	// sessions - TLS session cache, nil if not configured
+	sessions *ClientSessionCache
 }
 
 // NewClient - function for create Client struct
@@ -279,14 +1141,34 @@ func NewClient(o *Options) *Client {
 	c.logger = l
 	c.certs.Pool = x509.NewCertPool()
 
+	if o.InsecureSkipVerify {
+		l.Log("WARNING: server certificate verification disabled (InsecureSkipVerify)", LogLevelNotice)
+	}
+
+	if o.ClientSessionCacheSize > 0 || o.ClientSessionCacheFile != "" {
+		c.sessions = NewClientSessionCache(o.ClientSessionCacheSize)
+		if o.ClientSessionCacheFile != "" {
+			err := c.sessions.Load(o.ClientSessionCacheFile)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				l.Log(err.Error(), LogLevelError)
+			}
+		}
+	}
+
 	return c
 }
 
+// SessionCache - return session cache of client, nil if it is not
+// configured (see Options.ClientSessionCacheSize).
+func (c *Client) SessionCache() *ClientSessionCache {
+	return c.sessions
+}
+
 // LoadKeyPair - function for load certificate and private key pair.
 //
 // Public/private key pair require as PEM encoded data.
 func (c *Client) LoadKeyPair(cert, key []byte) error {
-	c0, ca, err := loadKeyPair(cert, key)
+	c0, ca, err := c.options.loadKeyPair(cert, key)
 	if err != nil {
 		return fmt.Errorf("%s: %v\n", LoadKeyPairError, err)
 	}
@@ -315,6 +1197,26 @@ func (c *Client) AddCertToRootCA(cert []byte) error {
 	return nil
 }
 
+// tlsConfig - build TLS config for connection with server.
+func (c *Client) tlsConfig() *tls.Config {
+	config := &tls.Config{
+		Certificates:       []tls.Certificate{c.certs.Cert},
+		InsecureSkipVerify: c.options.InsecureSkipVerify,
+		RootCAs:            c.certs.Pool,
+		Renegotiation:      c.options.Renegotiation,
+		ServerName:         c.options.ServerName,
+		VerifyConnection:   c.options.VerifyConnection,
+		Time:               clockOrReal(c.options.Clock).Now,
+	}
+	if c.sessions != nil {
+		config.ClientSessionCache = c.sessions
+	}
+	if c.options.Compression {
+		config.NextProtos = []string{CompressionProtocol}
+	}
+	return config
+}
+
 // Dial - function for start connection with server.
 func (c *Client) Dial() (*tls.Conn, error) {
 	// load keypair check
@@ -322,10 +1224,10 @@ func (c *Client) Dial() (*tls.Conn, error) {
 		return nil, fmt.Errorf("%s\n", NoKeyPairLoadError)
 	}
 
-	config := &tls.Config{
-		Certificates:       []tls.Certificate{c.certs.Cert},
-		InsecureSkipVerify: false,
-		RootCAs:            c.certs.Pool,
+	config := c.tlsConfig()
+
+	if c.options.Pipe != "" {
+		return c.dialPipe(config)
 	}
 
 	service := c.options.Host + ":" + strconv.Itoa(c.options.Port)
@@ -334,8 +1236,42 @@ func (c *Client) Dial() (*tls.Conn, error) {
 	if err != nil {
 		return nil, fmt.Errorf("fail to dial with server: %v\n", err)
 	}
+	if err := c.present(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("fail to dial with server: %w\n", err)
+	}
 
 	c.logger.Log("dial to "+service+" - ok", LogLevelInfo)
 
 	return conn, nil
 }
+
+// dialPipe - establish connection over Options.Pipe.
+func (c *Client) dialPipe(config *tls.Config) (*tls.Conn, error) {
+	// unlike TCP, pipe may be busy; bound wait for free instance and
+	// handshake
+	timeout := DefaultHandshakeTimeout
+	raw, err := dialPipe(c.options.Pipe, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("fail to dial with server: %v\n", err)
+	}
+
+	if config.ServerName == "" {
+		config.ServerName = c.options.Host
+	}
+	conn := tls.Client(raw, config)
+	raw.SetDeadline(time.Now().Add(timeout))
+	if err := conn.Handshake(); err != nil {
+		raw.Close()
+		return nil, fmt.Errorf("fail to dial with server: %v\n", err)
+	}
+	raw.SetDeadline(time.Time{})
+	if err := c.present(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("fail to dial with server: %w\n", err)
+	}
+
+	c.logger.Log("dial to pipe "+c.options.Pipe+" - ok", LogLevelInfo)
+
+	return conn, nil
+}
@@ -1,19 +1,223 @@
 package herots
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
 	"testing"
+	"time"
 )
 
 //TODO: more tests :)
 
 func TestLoadKeyPair(t *testing.T) {
-	h := NewServer()
+	h := NewServer(&Options{})
 	err := h.LoadKeyPair([]byte(c0), []byte(k0))
 	if err != nil {
 		t.Fatalf("can't load normal public/private key pair:\n%v\n", err)
 	}
 }
 
+func TestFingerprint(t *testing.T) {
+	pemData, _ := pem.Decode([]byte(c0))
+	cert, err := x509.ParseCertificate(pemData.Bytes)
+	if err != nil {
+		t.Fatalf("can't parse test cert:\n%v\n", err)
+	}
+
+	a := FingerprintSHA256(cert)
+	b := FingerprintSHA256(cert)
+	if len(a) != 32 {
+		t.Fatalf("unexpected fingerprint length: %d\n", len(a))
+	}
+	if !FingerprintEqual(a, b) {
+		t.Fatalf("equal fingerprints not matched\n")
+	}
+
+	b[0] ^= 0xff
+	if FingerprintEqual(a, b) {
+		t.Fatalf("different fingerprints matched\n")
+	}
+	if FingerprintEqual(a, a[:16]) || FingerprintEqual(nil, nil) {
+		t.Fatalf("fingerprints of different length matched\n")
+	}
+}
+
+func TestClientRenegotiation(t *testing.T) {
+	c := NewClient(&Options{})
+	if c.tlsConfig().Renegotiation != tls.RenegotiateNever {
+		t.Fatalf("renegotiation must be disabled by default\n")
+	}
+
+	c = NewClient(&Options{Renegotiation: tls.RenegotiateOnceAsClient})
+	if c.tlsConfig().Renegotiation != tls.RenegotiateOnceAsClient {
+		t.Fatalf("renegotiation option not applied\n")
+	}
+}
+
+func TestAddKeyPair(t *testing.T) {
+	s := NewServer(&Options{})
+	rsaCert, rsaKey := genRSAKeyPair(t, "herots test")
+	if err := s.AddKeyPair(rsaCert, rsaKey); err == nil {
+		t.Fatalf("AddKeyPair must require LoadKeyPair\n")
+	}
+
+	s, c := startTestServerWith(t, &Options{}, func(s *Server) {
+		if err := s.AddKeyPair(rsaCert, rsaKey); err != nil {
+			t.Fatalf("add key pair:\n%v\n", err)
+		}
+		cert, key := genRSAKeyPair(t, "stranger")
+		if err := s.AddKeyPair(cert, key); err == nil {
+			t.Fatalf("key pair with other identity accepted\n")
+		}
+	})
+	go func() {
+		for {
+			conn, err := s.AcceptConn()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.Handshake()
+				conn.Close()
+			}()
+		}
+	}()
+
+	peerKey := func(config *tls.Config) x509.PublicKeyAlgorithm {
+		config.RootCAs.AppendCertsFromPEM(rsaCert)
+		conn, err := tls.Dial("tcp", s.listener.Addr().String(), config)
+		if err != nil {
+			t.Fatalf("dial:\n%v\n", err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].PublicKeyAlgorithm
+	}
+
+	if alg := peerKey(c.tlsConfig()); alg != x509.ECDSA {
+		t.Fatalf("modern client must get ECDSA cert, got %v\n", alg)
+	}
+
+	legacy := c.tlsConfig()
+	legacy.MaxVersion = tls.VersionTLS12
+	legacy.CipherSuites = []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}
+	if alg := peerKey(legacy); alg != x509.RSA {
+		t.Fatalf("RSA only client must get RSA cert, got %v\n", alg)
+	}
+}
+
+// genKeyPair - generate self-signed PEM encoded certificate and key
+// for 127.0.0.1/localhost.
+func genKeyPair(t testing.TB, cn string) ([]byte, []byte) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key:\n%v\n", err)
+	}
+	return signKeyPair(t, cn, priv)
+}
+
+// genRSAKeyPair - same as genKeyPair, with RSA key.
+func genRSAKeyPair(t testing.TB, cn string) ([]byte, []byte) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key:\n%v\n", err)
+	}
+	return signKeyPair(t, cn, priv)
+}
+
+// signKeyPair - create self-signed PEM encoded certificate for priv with
+// extra extensions.
+func signKeyPair(t testing.TB, cn string, priv crypto.Signer, ext ...pkix.Extension) ([]byte, []byte) {
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:              []string{"localhost"},
+		ExtraExtensions:       ext,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, priv.Public(), priv)
+	if err != nil {
+		t.Fatalf("create cert:\n%v\n", err)
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatalf("marshal key:\n%v\n", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})
+}
+
+// waitFor - wait until cond holds, failing test after timeout.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s\n", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// freePort - return free local tcp port.
+func freePort(t testing.TB) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("can't find free port:\n%v\n", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// startTestServer - start server with fresh key pair and return it
+// together with client, trusting the server and using the same key pair.
+func startTestServer(t testing.TB, o *Options) (*Server, *Client) {
+	return startTestServerWith(t, o, nil)
+}
+
+// startTestServerWith - same as startTestServer, calling setup on server
+// with loaded key pair before Start.
+func startTestServerWith(t testing.TB, o *Options, setup func(*Server)) (*Server, *Client) {
+	cert, key := genKeyPair(t, "herots test")
+
+	o.Host = "127.0.0.1"
+	o.Port = freePort(t)
+
+	s := NewServer(o)
+	if err := s.LoadKeyPair(cert, key); err != nil {
+		t.Fatalf("server load key pair:\n%v\n", err)
+	}
+	if setup != nil {
+		setup(s)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("server start:\n%v\n", err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	c := NewClient(&Options{Host: o.Host, Port: o.Port})
+	if err := c.LoadKeyPair(cert, key); err != nil {
+		t.Fatalf("client load key pair:\n%v\n", err)
+	}
+
+	return s, c
+}
+
 const c0 = `-----BEGIN CERTIFICATE-----
 MIID3DCCAsagAwIBAgICBnUwCwYJKoZIhvcNAQELMGIxETAPBgNVBAYTCFNoYW1i
 YWxhMQwwCgYDVQQKEwNaRU4xDTALBgNVBAsTBE9tIDAxCzAJBgNVBAcTAlVBMQ8w
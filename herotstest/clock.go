@@ -0,0 +1,123 @@
+// Package herotstest provides helpers for testing code built on herots.
+package herotstest
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// FakeClock - manually driven clock (implements herots.Clock), so
+// time-dependent behavior can be tested without real sleeps: time stands
+// still until Advance is called.
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+	seq    uint64
+}
+
+type fakeTimer struct {
+	when   time.Time
+	seq    uint64
+	period time.Duration // ticker if > 0
+
+	f  func()         // for AfterFunc
+	ch chan time.Time // for ticker
+}
+
+// NewFakeClock - function for create FakeClock, which shows given time.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now - return current fake time.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// AfterFunc - schedule f to be called when clock is advanced by d.
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) (stop func() bool) {
+	return c.add(&fakeTimer{f: f}, d)
+}
+
+// NewTicker - return channel, which gets fake time every d of advanced
+// time. As with time.Ticker, ticks are dropped for slow receivers.
+func (c *FakeClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	if d <= 0 {
+		panic("herotstest: non-positive interval for NewTicker")
+	}
+	t := &fakeTimer{period: d, ch: make(chan time.Time, 1)}
+	stop := c.add(t, d)
+	return t.ch, func() { stop() }
+}
+
+func (c *FakeClock) add(t *fakeTimer, d time.Duration) func() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seq++
+	t.seq = c.seq
+	t.when = c.now.Add(d)
+	c.timers = append(c.timers, t)
+
+	return func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for i, pending := range c.timers {
+			if pending == t {
+				c.timers = append(c.timers[:i], c.timers[i+1:]...)
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Advance - move clock forward by d, firing due timers and tickers in
+// order of their time. AfterFunc callbacks run synchronously, so their
+// effects are visible when Advance returns.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	for {
+		sort.Slice(c.timers, func(i, j int) bool {
+			if !c.timers[i].when.Equal(c.timers[j].when) {
+				return c.timers[i].when.Before(c.timers[j].when)
+			}
+			return c.timers[i].seq < c.timers[j].seq
+		})
+		if len(c.timers) == 0 || c.timers[0].when.After(end) {
+			break
+		}
+
+		t := c.timers[0]
+		c.now = t.when
+		if t.period > 0 {
+			t.when = t.when.Add(t.period)
+			select {
+			case t.ch <- c.now:
+			default:
+			}
+			continue
+		}
+
+		c.timers = c.timers[1:]
+		// callback may use clock
+		c.mu.Unlock()
+		t.f()
+		c.mu.Lock()
+	}
+	c.now = end
+	c.mu.Unlock()
+}
+
+// Pending - return number of scheduled timers and tickers, e.g. to wait
+// until code under test has armed its timer before Advance.
+func (c *FakeClock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
@@ -0,0 +1,63 @@
+package herotstest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFakeClock(start)
+
+	var fired []string
+	c.AfterFunc(2*time.Second, func() { fired = append(fired, "b") })
+	c.AfterFunc(time.Second, func() { fired = append(fired, "a") })
+	stop := c.AfterFunc(3*time.Second, func() { fired = append(fired, "c") })
+	if !stop() || stop() {
+		t.Fatalf("stop must report whether timer was pending\n")
+	}
+
+	c.Advance(500 * time.Millisecond)
+	if len(fired) != 0 {
+		t.Fatalf("timers fired early: %v\n", fired)
+	}
+	c.Advance(5 * time.Second)
+	if len(fired) != 2 || fired[0] != "a" || fired[1] != "b" {
+		t.Fatalf("unexpected firing order: %v\n", fired)
+	}
+	if got := c.Now(); !got.Equal(start.Add(5500 * time.Millisecond)) {
+		t.Fatalf("unexpected time %v\n", got)
+	}
+	if c.Pending() != 0 {
+		t.Fatalf("fired timers still pending\n")
+	}
+}
+
+func TestFakeTicker(t *testing.T) {
+	c := NewFakeClock(time.Time{})
+	tick, stop := c.NewTicker(time.Minute)
+
+	c.Advance(time.Minute)
+	select {
+	case <-tick:
+	default:
+		t.Fatalf("no tick after interval\n")
+	}
+
+	// slow receiver gets one tick
+	c.Advance(3 * time.Minute)
+	<-tick
+	select {
+	case <-tick:
+		t.Fatalf("ticks not dropped for slow receiver\n")
+	default:
+	}
+
+	stop()
+	c.Advance(time.Hour)
+	select {
+	case <-tick:
+		t.Fatalf("tick after stop\n")
+	default:
+	}
+}
@@ -0,0 +1,145 @@
+package herots
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultHoneypotTimeout - default time given to observed client for
+// handshake and initial payload.
+const DefaultHoneypotTimeout = 10 * time.Second
+
+// DefaultHoneypotMaxConns - default limit of connections observed at once.
+const DefaultHoneypotMaxConns = 256
+
+// Observation - metadata of connection recorded in honeypot mode.
+type Observation struct {
+	Time       time.Time
+	Duration   time.Duration
+	RemoteAddr string
+
+	// RawPrefix - first bytes received from wire, before TLS (shows what
+	// non-TLS scanners send). Up to Options.HoneypotCaptureBytes.
+	RawPrefix []byte
+
+	// Hello - offered TLS parameters, nil if no ClientHello was received.
+	Hello *ClientHello
+
+	// HandshakeErr - nil if handshake was completed.
+	HandshakeErr error
+
+	// negotiated parameters and certificates offered by client (not
+	// verified)
+	Version          uint16
+	CipherSuite      uint16
+	PeerCertificates []*x509.Certificate
+
+	// Payload - first application data bytes sent by client after
+	// handshake. Up to Options.HoneypotCaptureBytes.
+	Payload []byte
+}
+
+// recordingConn - net.Conn, which keeps copy of first bytes read.
+type recordingConn struct {
+	net.Conn
+
+	mu    sync.Mutex
+	buf   []byte
+	limit int
+}
+
+func (r *recordingConn) Read(p []byte) (int, error) {
+	n, err := r.Conn.Read(p)
+	r.mu.Lock()
+	if left := r.limit - len(r.buf); left > 0 && n > 0 {
+		if left > n {
+			left = n
+		}
+		r.buf = append(r.buf, p[:left]...)
+	}
+	r.mu.Unlock()
+	return n, err
+}
+
+func (r *recordingConn) recorded() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]byte(nil), r.buf...)
+}
+
+// observe - honeypot handling of accepted connection: complete handshake
+// if client wants to, read initial payload, report observation and close.
+// No application data is ever sent. Slot of s.observing is released on
+// return.
+func (s *Server) observe(raw net.Conn) {
+	defer func() { <-s.observing }()
+
+	o := &Observation{
+		Time:       time.Now(),
+		RemoteAddr: raw.RemoteAddr().String(),
+	}
+
+	timeout := s.options.HoneypotTimeout
+	if timeout <= 0 {
+		timeout = DefaultHoneypotTimeout
+	}
+
+	rec := &recordingConn{Conn: raw, limit: s.options.HoneypotCaptureBytes}
+	c := newConn(s, rec)
+	defer c.Close()
+
+	c.SetDeadline(time.Now().Add(timeout))
+
+	// handshake errors are what honeypot is about; don't report them
+	// as server errors
+	if err := c.Conn.Handshake(); err != nil {
+		o.HandshakeErr = err
+	} else {
+		st := c.ConnectionState()
+		o.Version = st.Version
+		o.CipherSuite = st.CipherSuite
+		o.PeerCertificates = st.PeerCertificates
+
+		if s.options.HoneypotCaptureBytes > 0 {
+			buf := make([]byte, s.options.HoneypotCaptureBytes)
+			n, _ := readAtMost(c.Conn, buf)
+			o.Payload = buf[:n]
+		}
+	}
+
+	o.Hello = c.ClientHello()
+	o.RawPrefix = rec.recorded()
+	o.Duration = time.Since(o.Time)
+
+	msg := "honeypot: observed " + o.RemoteAddr
+	if o.Hello != nil {
+		msg += " sni=" + o.Hello.ServerName
+	}
+	if o.HandshakeErr != nil {
+		msg += " handshake error: " + o.HandshakeErr.Error()
+	} else {
+		msg += " " + tls.VersionName(o.Version) + ", payload " + strconv.Itoa(len(o.Payload)) + " bytes"
+	}
+	s.logger.Log(msg, LogLevelNotice)
+
+	if s.options.HoneypotSink != nil {
+		s.options.HoneypotSink(o)
+	}
+}
+
+// readAtMost - fill buf, until it is full or read fails (deadline, EOF).
+func readAtMost(c net.Conn, buf []byte) (int, error) {
+	n := 0
+	for n < len(buf) {
+		m, err := c.Read(buf[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,96 @@
+package herots
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHoneypot(t *testing.T) {
+	observed := make(chan *Observation, 2)
+	s, _ := startTestServer(t, &Options{
+		Honeypot:             true,
+		HoneypotSink:         func(o *Observation) { observed <- o },
+		HoneypotCaptureBytes: 16,
+		HoneypotTimeout:      time.Second,
+	})
+	go s.AcceptConn()
+
+	addr := s.listener.Addr().String()
+
+	// TLS client without certificate, sending payload
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: "decoy", InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("honeypot must accept any TLS client:\n%v\n", err)
+	}
+	conn.Write([]byte("GET / HTTP/1.0\r\n"))
+	if n, _ := conn.Read(make([]byte, 1)); n != 0 {
+		t.Fatalf("honeypot served data\n")
+	}
+	conn.Close()
+
+	o := <-observed
+	if o.HandshakeErr != nil || o.Hello == nil || o.Hello.ServerName != "decoy" {
+		t.Fatalf("unexpected observation: %+v\n", o)
+	}
+	if string(o.Payload) != "GET / HTTP/1.0\r\n" {
+		t.Fatalf("unexpected payload: %q\n", o.Payload)
+	}
+
+	// non-TLS scanner
+	raw, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial:\n%v\n", err)
+	}
+	raw.Write([]byte("SSH-2.0-scanner\r\n"))
+	raw.Close()
+
+	o = <-observed
+	if o.HandshakeErr == nil || string(o.RawPrefix) != "SSH-2.0-scanner\r" {
+		t.Fatalf("unexpected observation: %+v\n", o)
+	}
+}
+
+func TestHoneypotMaxConns(t *testing.T) {
+	observed := make(chan *Observation, 2)
+	s, _ := startTestServer(t, &Options{
+		Honeypot:         true,
+		HoneypotSink:     func(o *Observation) { observed <- o },
+		HoneypotTimeout:  5 * time.Second,
+		HoneypotMaxConns: 1,
+	})
+	go s.AcceptConn()
+
+	addr := s.listener.Addr().String()
+	dial := func() net.Conn {
+		raw, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("dial:\n%v\n", err)
+		}
+		t.Cleanup(func() { raw.Close() })
+		return raw
+	}
+
+	// silent client holds the only slot
+	held := dial()
+	time.Sleep(50 * time.Millisecond)
+
+	extra := dial()
+	extra.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := extra.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("connection over limit not closed: %v\n", err)
+	}
+
+	held.Close()
+	<-observed
+
+	// slot is free again
+	raw := dial()
+	raw.Write([]byte("hello"))
+	raw.Close()
+	if o := <-observed; o.HandshakeErr == nil {
+		t.Fatalf("unexpected observation: %+v\n", o)
+	}
+}
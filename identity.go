@@ -0,0 +1,102 @@
+package herots
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+)
+
+// predefined identity errors
+var (
+	ErrNotTLSConn        = errors.New("not a TLS connection")
+	ErrNoPeerCertificate = errors.New("peer presented no certificate")
+)
+
+// Identity - peer identity from its certificate and negotiated TLS
+// parameters of connection.
+type Identity struct {
+	Subject        pkix.Name
+	Issuer         pkix.Name
+	SerialNumber   *big.Int
+	DNSNames       []string
+	IPAddresses    []net.IP
+	URIs           []*url.URL
+	EmailAddresses []string
+
+	// Fingerprint - SHA-256 of peer certificate (see FingerprintSHA256).
+	Fingerprint []byte
+
+	// Certificate - peer leaf certificate; Chains - verified chains
+	// (empty if client certificates are not verified, see TLSAuthType).
+	Certificate *x509.Certificate
+	Chains      [][]*x509.Certificate
+
+	// negotiated TLS parameters
+	Version            uint16
+	CipherSuite        uint16
+	ServerName         string
+	NegotiatedProtocol string
+	DidResume          bool
+	Resumption         ResumptionMechanism
+}
+
+// CommonName - shortcut for Subject.CommonName.
+func (i *Identity) CommonName() string {
+	return i.Subject.CommonName
+}
+
+// PeerIdentity - return identity of connection peer.
+//
+// Connection must be *Conn (as returned by Server.AcceptConn) or *tls.Conn
+// (as returned by Client.Dial). Handshake is run if it has not yet been.
+func PeerIdentity(conn net.Conn) (*Identity, error) {
+	var tc *tls.Conn
+	switch c := conn.(type) {
+	case *Conn:
+		if err := c.Handshake(); err != nil {
+			return nil, fmt.Errorf("peer identity: %w\n", err)
+		}
+		tc = c.Conn
+	case *tls.Conn:
+		if err := c.Handshake(); err != nil {
+			return nil, fmt.Errorf("peer identity: %w\n", err)
+		}
+		tc = c
+	default:
+		return nil, ErrNotTLSConn
+	}
+
+	return identityFromState(tc.ConnectionState())
+}
+
+// identityFromState - build Identity from TLS connection state.
+func identityFromState(st tls.ConnectionState) (*Identity, error) {
+	if len(st.PeerCertificates) == 0 {
+		return nil, ErrNoPeerCertificate
+	}
+	cert := st.PeerCertificates[0]
+
+	return &Identity{
+		Subject:            cert.Subject,
+		Issuer:             cert.Issuer,
+		SerialNumber:       cert.SerialNumber,
+		DNSNames:           cert.DNSNames,
+		IPAddresses:        cert.IPAddresses,
+		URIs:               cert.URIs,
+		EmailAddresses:     cert.EmailAddresses,
+		Fingerprint:        FingerprintSHA256(cert),
+		Certificate:        cert,
+		Chains:             st.VerifiedChains,
+		Version:            st.Version,
+		CipherSuite:        st.CipherSuite,
+		ServerName:         st.ServerName,
+		NegotiatedProtocol: st.NegotiatedProtocol,
+		DidResume:          st.DidResume,
+		Resumption:         resumption(st),
+	}, nil
+}
@@ -0,0 +1,60 @@
+package herots
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestPeerIdentity(t *testing.T) {
+	s, c := startTestServer(t, &Options{})
+
+	type result struct {
+		id  *Identity
+		err error
+	}
+	client := make(chan result, 1)
+	go func() {
+		conn, err := c.Dial()
+		if err != nil {
+			client <- result{err: err}
+			return
+		}
+		defer conn.Close()
+		id, err := PeerIdentity(conn)
+		client <- result{id, err}
+		conn.Read(make([]byte, 1))
+	}()
+
+	conn, err := s.AcceptConn()
+	if err != nil {
+		t.Fatalf("accept:\n%v\n", err)
+	}
+	defer conn.Close()
+
+	id, err := PeerIdentity(conn)
+	if err != nil {
+		t.Fatalf("server side identity:\n%v\n", err)
+	}
+	if id.CommonName() != "herots test" || len(id.IPAddresses) != 1 {
+		t.Fatalf("unexpected identity: %+v\n", id)
+	}
+	if !bytes.Equal(id.Fingerprint, FingerprintSHA256(id.Certificate)) || id.Version == 0 {
+		t.Fatalf("unexpected identity details: %+v\n", id)
+	}
+
+	r := <-client
+	if r.err != nil {
+		t.Fatalf("client side identity:\n%v\n", r.err)
+	}
+	if !FingerprintEqual(r.id.Fingerprint, id.Fingerprint) {
+		t.Fatalf("both sides use the same key pair, fingerprints must match\n")
+	}
+
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	if _, err := PeerIdentity(a); err != ErrNotTLSConn {
+		t.Fatalf("expected ErrNotTLSConn, got %v\n", err)
+	}
+}
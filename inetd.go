@@ -0,0 +1,161 @@
+package herots
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// inetdListener - listener of Inetd mode: yields its connection once,
+// then waits until it is closed and closes server.
+type inetdListener struct {
+	s    *Server
+	conn *inetdConn
+
+	once   sync.Once
+	taken  bool
+	mu     sync.Mutex
+	closed chan struct{}
+}
+
+// inetdConn - inherited connection, which reports its close.
+type inetdConn struct {
+	net.Conn
+	once sync.Once
+	done chan struct{}
+}
+
+func (c *inetdConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() { close(c.done) })
+	return err
+}
+
+// listenInetd - take over descriptor fd as connection.
+func listenInetd(s *Server, fd int) (*inetdListener, error) {
+	conn, err := inheritedConn(fd)
+	if err != nil {
+		return nil, err
+	}
+	return &inetdListener{
+		s:      s,
+		conn:   &inetdConn{Conn: conn, done: make(chan struct{})},
+		closed: make(chan struct{}),
+	}, nil
+}
+
+// inheritedConn - return connection over descriptor fd: socket, or for
+// descriptor 0 not being a socket, standard input and output.
+func inheritedConn(fd int) (net.Conn, error) {
+	if fd == 0 {
+		// inetd passes socket as stdin and stdout; connection uses its
+		// duplicate, so they are closed for Close of connection to end it
+		if conn, err := net.FileConn(os.Stdin); err == nil {
+			os.Stdin.Close()
+			os.Stdout.Close()
+			return conn, nil
+		}
+		return newStdioConn(), nil
+	}
+
+	f := os.NewFile(uintptr(fd), "inetd")
+	defer f.Close()
+	return net.FileConn(f)
+}
+
+func (l *inetdListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	taken := l.taken
+	l.taken = true
+	l.mu.Unlock()
+	if !taken {
+		return l.conn, nil
+	}
+
+	select {
+	case <-l.conn.done:
+		// served; no more connections
+		l.s.closeListener()
+	case <-l.closed:
+	}
+	return nil, net.ErrClosed
+}
+
+// Close - stop accepting. Connection, if taken, is not affected; if
+// not, it is closed.
+func (l *inetdListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+
+	l.mu.Lock()
+	taken := l.taken
+	l.taken = true
+	l.mu.Unlock()
+	if !taken {
+		l.conn.Close()
+	}
+	return nil
+}
+
+func (l *inetdListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// stdioAddr - address of connection over standard streams.
+type stdioAddr struct{}
+
+func (stdioAddr) Network() string {
+	return "stdio"
+}
+
+func (stdioAddr) String() string {
+	return "stdio"
+}
+
+// stdioConn - connection over standard input and output.
+type stdioConn struct {
+	in, out *os.File
+}
+
+func newStdioConn() *stdioConn {
+	return &stdioConn{in: stdioFile(0, "stdin"), out: stdioFile(1, "stdout")}
+}
+
+func (c *stdioConn) Read(b []byte) (int, error) {
+	return c.in.Read(b)
+}
+
+func (c *stdioConn) Write(b []byte) (int, error) {
+	return c.out.Write(b)
+}
+
+func (c *stdioConn) Close() error {
+	err := c.out.Close()
+	if ierr := c.in.Close(); err == nil {
+		err = ierr
+	}
+	return err
+}
+
+func (c *stdioConn) LocalAddr() net.Addr {
+	return stdioAddr{}
+}
+
+func (c *stdioConn) RemoteAddr() net.Addr {
+	return stdioAddr{}
+}
+
+func (c *stdioConn) SetDeadline(t time.Time) error {
+	if err := c.in.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.out.SetWriteDeadline(t)
+}
+
+func (c *stdioConn) SetReadDeadline(t time.Time) error {
+	return c.in.SetReadDeadline(t)
+}
+
+func (c *stdioConn) SetWriteDeadline(t time.Time) error {
+	return c.out.SetWriteDeadline(t)
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package herots
+
+import (
+	"os"
+)
+
+// stdioFile - return file of standard stream fd. Deadlines are not
+// supported on this platform.
+func stdioFile(fd int, name string) *os.File {
+	return os.NewFile(uintptr(fd), name)
+}
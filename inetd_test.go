@@ -0,0 +1,129 @@
+//go:build unix
+
+package herots
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestInetd(t *testing.T) {
+	cert, key := genKeyPair(t, "herots test")
+
+	// play inetd: accept connection and pass its descriptor
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen:\n%v\n", err)
+	}
+	defer ln.Close()
+
+	c := NewClient(&Options{Host: "127.0.0.1", Port: ln.Addr().(*net.TCPAddr).Port})
+	if err := c.LoadKeyPair(cert, key); err != nil {
+		t.Fatalf("client load key pair:\n%v\n", err)
+	}
+	echoed := make(chan error, 1)
+	go func() {
+		conn, err := c.Dial()
+		if err != nil {
+			echoed <- err
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 4)
+		conn.Write([]byte("ping"))
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+			t.Errorf("echo: %q, %v\n", buf, err)
+		}
+		echoed <- nil
+	}()
+
+	raw, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("accept:\n%v\n", err)
+	}
+	rc, _ := raw.(*net.TCPConn).SyscallConn()
+	fd := -1
+	rc.Control(func(s uintptr) { fd, err = syscall.Dup(int(s)) })
+	raw.Close()
+	if err != nil {
+		t.Fatalf("dup:\n%v\n", err)
+	}
+
+	s := NewServer(&Options{
+		Inetd:   true,
+		InetdFD: fd,
+		Handler: func(c *Conn) {
+			buf := make([]byte, 4)
+			io.ReadFull(c, buf)
+			c.Write(buf)
+		},
+	})
+	if err := s.LoadKeyPair(cert, key); err != nil {
+		t.Fatalf("server load key pair:\n%v\n", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- s.Run(context.Background()) }()
+
+	if err := <-echoed; err != nil {
+		t.Fatalf("dial:\n%v\n", err)
+	}
+	// run ends with its single connection
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("run:\n%v\n", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("run didn't return after connection was served\n")
+	}
+}
+
+func TestInetdStdinSocketClose(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen:\n%v\n", err)
+	}
+	defer ln.Close()
+	peer, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial:\n%v\n", err)
+	}
+	defer peer.Close()
+	raw, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("accept:\n%v\n", err)
+	}
+
+	// play inetd: socket as standard input and output
+	dup := func() int {
+		rc, _ := raw.(*net.TCPConn).SyscallConn()
+		fd := -1
+		rc.Control(func(s uintptr) { fd, err = syscall.Dup(int(s)) })
+		if err != nil {
+			t.Fatalf("dup:\n%v\n", err)
+		}
+		return fd
+	}
+	stdin, stdout := os.Stdin, os.Stdout
+	defer func() { os.Stdin, os.Stdout = stdin, stdout }()
+	os.Stdin, os.Stdout = os.NewFile(uintptr(dup()), "stdin"), os.NewFile(uintptr(dup()), "stdout")
+	raw.Close()
+
+	conn, err := inheritedConn(0)
+	if err != nil {
+		t.Fatalf("inherited conn:\n%v\n", err)
+	}
+	conn.Close()
+
+	// no other descriptor keeps socket open
+	peer.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := peer.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected EOF after close, got %v\n", err)
+	}
+}
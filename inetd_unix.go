@@ -0,0 +1,16 @@
+//go:build unix
+
+package herots
+
+import (
+	"os"
+	"syscall"
+)
+
+// stdioFile - return file of standard stream fd in non-blocking mode, so
+// it is served by runtime poller and supports deadlines (handshake timeout
+// and drain interrupt blocked reads).
+func stdioFile(fd int, name string) *os.File {
+	syscall.SetNonblock(fd, true)
+	return os.NewFile(uintptr(fd), name)
+}
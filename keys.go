@@ -0,0 +1,181 @@
+package herots
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"unsafe"
+)
+
+// loadProtectedKeyPair - same as loadKeyPair, for Options.ProtectKeys:
+// key is parsed without intermediate copies kept by tls.X509KeyPair, key
+// PEM data and decoded DER are wiped, and key material is locked in
+// memory where possible.
+func loadProtectedKeyPair(cert, key []byte) (tls.Certificate, *x509.Certificate, error) {
+	defer clear(key)
+
+	c, leaf, err := parseCertChain(cert)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	block, _ := pem.Decode(key)
+	if block == nil {
+		return tls.Certificate{}, nil, errors.New("no private key in PEM data")
+	}
+	defer clear(block.Bytes)
+
+	priv, err := parsePrivateKey(block.Bytes)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	pub, ok := priv.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(leaf.PublicKey) {
+		wipeKey(priv)
+		return tls.Certificate{}, nil, errors.New("private key does not match public key")
+	}
+	lockKey(priv)
+
+	c.PrivateKey = priv
+	c.Leaf = leaf
+	return c, leaf, nil
+}
+
+// parseCertChain - parse PEM encoded certificate chain into key pair
+// without private key.
+func parseCertChain(cert []byte) (tls.Certificate, *x509.Certificate, error) {
+	var c tls.Certificate
+	for rest := cert; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			c.Certificate = append(c.Certificate, block.Bytes)
+		}
+	}
+	if len(c.Certificate) == 0 {
+		return tls.Certificate{}, nil, errors.New("no certificate in PEM data")
+	}
+	leaf, err := x509.ParseCertificate(c.Certificate[0])
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	c.Leaf = leaf
+	return c, leaf, nil
+}
+
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+		return nil, errors.New("unsupported private key type")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("failed to parse private key")
+}
+
+// keyInts - secret integers of private key. Values precomputed by crypto
+// packages internally are not reachable and not included.
+func keyInts(key crypto.PrivateKey) []*big.Int {
+	var ints []*big.Int
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		ints = append(ints, k.D, k.Precomputed.Dp, k.Precomputed.Dq, k.Precomputed.Qinv)
+		ints = append(ints, k.Primes...)
+		for _, v := range k.Precomputed.CRTValues {
+			ints = append(ints, v.Exp, v.Coeff, v.R)
+		}
+	case *ecdsa.PrivateKey:
+		ints = append(ints, k.D)
+	}
+	return ints
+}
+
+// keyMaterial - secret parts of private key, as byte slices over their
+// memory.
+func keyMaterial(key crypto.PrivateKey) [][]byte {
+	if k, ok := key.(ed25519.PrivateKey); ok {
+		return [][]byte{k}
+	}
+
+	var material [][]byte
+	for _, n := range keyInts(key) {
+		if n == nil {
+			continue
+		}
+		words := n.Bits()
+		if len(words) == 0 {
+			continue
+		}
+		material = append(material, unsafe.Slice((*byte)(unsafe.Pointer(&words[0])), len(words)*int(unsafe.Sizeof(words[0]))))
+	}
+	return material
+}
+
+// lockKey - lock key material in memory, so it is not swapped out (best
+// effort, see mlock).
+func lockKey(key crypto.PrivateKey) {
+	for _, b := range keyMaterial(key) {
+		mlock(b)
+	}
+}
+
+// wipeKey - overwrite key material with zeros and unlock it. Key is
+// unusable afterwards.
+func wipeKey(key crypto.PrivateKey) {
+	for _, b := range keyMaterial(key) {
+		clear(b)
+		munlock(b)
+	}
+	// keep wiped integers valid zeros
+	for _, n := range keyInts(key) {
+		if n != nil {
+			n.SetInt64(0)
+		}
+	}
+}
+
+// wipeKeys - wipe private keys of server key pairs (Options.ProtectKeys).
+func (s *Server) wipeKeys() {
+	if !s.options.ProtectKeys {
+		return
+	}
+
+	s.certMu.Lock()
+	defer s.certMu.Unlock()
+
+	for _, c := range s.keyPairsLocked() {
+		wipeKey(c.PrivateKey)
+	}
+	s.logger.Log("private keys wiped", LogLevelInfo)
+}
+
+// Close - save session cache to Options.ClientSessionCacheFile, if set,
+// and wipe private key of client (with Options.ProtectKeys); client can't
+// establish new connections afterwards.
+func (c *Client) Close() error {
+	var err error
+	if c.sessions != nil && c.options.ClientSessionCacheFile != "" {
+		err = c.sessions.Save(c.options.ClientSessionCacheFile)
+	}
+	if c.options.ProtectKeys {
+		wipeKey(c.certs.Cert.PrivateKey)
+		c.certs.Cert = tls.Certificate{}
+		c.logger.Log("private key wiped", LogLevelInfo)
+	}
+	return err
+}
@@ -0,0 +1,15 @@
+//go:build linux || darwin
+
+package herots
+
+import "syscall"
+
+// mlock - lock memory of b, so it is not swapped out. Errors (e.g. due to
+// RLIMIT_MEMLOCK) are ignored: locking is best effort.
+func mlock(b []byte) {
+	syscall.Mlock(b)
+}
+
+func munlock(b []byte) {
+	syscall.Munlock(b)
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package herots
+
+// mlock - memory locking is not supported on this platform.
+func mlock(b []byte) {}
+
+func munlock(b []byte) {}
@@ -0,0 +1,53 @@
+package herots
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"testing"
+)
+
+func TestProtectKeys(t *testing.T) {
+	cert, key := genRSAKeyPair(t, "herots test")
+	clientKey := bytes.Clone(key)
+
+	o := &Options{Host: "127.0.0.1", Port: freePort(t), ProtectKeys: true}
+	s := NewServer(o)
+	if err := s.LoadKeyPair(cert, key); err != nil {
+		t.Fatalf("load key pair:\n%v\n", err)
+	}
+	if !bytes.Equal(key, make([]byte, len(key))) {
+		t.Fatalf("key PEM data not wiped\n")
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("start:\n%v\n", err)
+	}
+
+	c := NewClient(&Options{Host: o.Host, Port: o.Port})
+	c.LoadKeyPair(cert, clientKey)
+	go func() {
+		if conn, err := s.AcceptConn(); err == nil {
+			conn.Handshake()
+			conn.Close()
+		}
+	}()
+	conn, err := c.Dial()
+	if err != nil {
+		t.Fatalf("dial with protected server key:\n%v\n", err)
+	}
+	conn.Close()
+
+	s.Close()
+	priv := s.certs.Cert.PrivateKey.(*rsa.PrivateKey)
+	if priv.D.Sign() != 0 || priv.Primes[0].Sign() != 0 {
+		t.Fatalf("private key not wiped on Close\n")
+	}
+}
+
+func TestProtectKeysMismatch(t *testing.T) {
+	cert, _ := genKeyPair(t, "a")
+	_, key := genKeyPair(t, "b")
+	s := NewServer(&Options{ProtectKeys: true})
+	if err := s.LoadKeyPair(cert, key); err == nil {
+		t.Fatalf("mismatched key pair accepted\n")
+	}
+}
@@ -0,0 +1,156 @@
+package herots
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// KeySource - source of signers for private keys, which never leave their
+// store (see Options.KeySource).
+//
+// Keys of AWS KMS and GCP Cloud KMS are used with signers of package
+// github.com/iu0v1/herots/kms (which calls REST APIs of services, without
+// cloud SDKs):
+//
+//	signer, err := kms.NewAWSSigner(&kms.AWSOptions{KeyID: "alias/tls"})
+//	...
+//	o.KeySource = herots.StaticKeySource(signer)
+//
+// Other services are plugged in by adapting their client to
+// crypto.Signer: Public returns public key of KMS key (fetched once), Sign
+// sends digest to KMS asymmetric sign call and returns DER encoded
+// signature (ECDSA) or PKCS#1 v1.5 / PSS signature (RSA, with SignerOpts
+// mapped to KMS algorithm).
+//
+// Latency: signer is called once per full handshake, so every full
+// handshake waits for KMS round trip (typically 10-100ms, more across
+// regions) and is subject to KMS rate limits and availability; resumed
+// handshakes don't sign. For busy servers keep session resumption on
+// (default stateless tickets or Options.SessionCacheSize), prefer ECDSA
+// P-256 keys (cheaper and widely supported by KMS), keep KMS key in region
+// of server, and bound adapter calls with timeout, as crypto.Signer has no
+// context. Certificate (public part) is kept locally, and Public of signer
+// is called only once, when key pair is loaded.
+type KeySource interface {
+	// Signer - return signer for private key of certificate.
+	Signer(cert *x509.Certificate) (crypto.Signer, error)
+}
+
+// KeySourceFunc - adapter to use ordinary function as KeySource.
+type KeySourceFunc func(cert *x509.Certificate) (crypto.Signer, error)
+
+// Signer - implements KeySource.
+func (f KeySourceFunc) Signer(cert *x509.Certificate) (crypto.Signer, error) {
+	return f(cert)
+}
+
+// StaticKeySource - return KeySource, which returns signer for any
+// certificate.
+func StaticKeySource(signer crypto.Signer) KeySource {
+	return KeySourceFunc(func(*x509.Certificate) (crypto.Signer, error) {
+		return signer, nil
+	})
+}
+
+// loadKeySourcePair - load certificate chain and get signer for it from
+// KeySource.
+func loadKeySourcePair(cert []byte, source KeySource) (tls.Certificate, *x509.Certificate, error) {
+	c, leaf, err := parseCertChain(cert)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	signer, err := source.Signer(leaf)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("key source: %v", err)
+	}
+	if signer == nil {
+		return tls.Certificate{}, nil, errors.New("key source: no signer")
+	}
+
+	remote := &remoteSigner{signer: signer, pub: signer.Public()}
+	pub, ok := remote.pub.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(leaf.PublicKey) {
+		return tls.Certificate{}, nil, errors.New("key source: signer key does not match public key")
+	}
+
+	c.PrivateKey = remote
+	return c, leaf, nil
+}
+
+// remoteSigner - signer of KeySource with cached public key and sign
+// latency stats.
+type remoteSigner struct {
+	signer crypto.Signer
+	pub    crypto.PublicKey
+
+	signs    atomic.Int64
+	failures atomic.Int64
+	// total and max sign latency, in nanoseconds
+	total atomic.Int64
+	max   atomic.Int64
+}
+
+// Public - implements crypto.Signer.
+func (r *remoteSigner) Public() crypto.PublicKey {
+	return r.pub
+}
+
+// Sign - implements crypto.Signer.
+func (r *remoteSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	start := time.Now()
+	sig, err := r.signer.Sign(rand, digest, opts)
+	d := int64(time.Since(start))
+
+	r.signs.Add(1)
+	r.total.Add(d)
+	for {
+		max := r.max.Load()
+		if d <= max || r.max.CompareAndSwap(max, d) {
+			break
+		}
+	}
+	if err != nil {
+		r.failures.Add(1)
+		return nil, fmt.Errorf("key source sign: %w", err)
+	}
+	return sig, nil
+}
+
+// KeySourceStats - latency statistics of KeySource signers.
+type KeySourceStats struct {
+	Signs    int64
+	Failures int64
+	Average  time.Duration
+	Max      time.Duration
+}
+
+// KeySourceStats - return sign statistics of loaded key pairs, which
+// use Options.KeySource (zero if none).
+func (s *Server) KeySourceStats() KeySourceStats {
+	s.certMu.Lock()
+	defer s.certMu.Unlock()
+
+	var st KeySourceStats
+	var total int64
+	for _, c := range s.keyPairsLocked() {
+		r, ok := c.PrivateKey.(*remoteSigner)
+		if !ok {
+			continue
+		}
+		st.Signs += r.signs.Load()
+		st.Failures += r.failures.Load()
+		total += r.total.Load()
+		st.Max = max(st.Max, time.Duration(r.max.Load()))
+	}
+	if st.Signs > 0 {
+		st.Average = time.Duration(total / st.Signs)
+	}
+	return st
+}
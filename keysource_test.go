@@ -0,0 +1,63 @@
+package herots
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"testing"
+)
+
+func TestKeySource(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key:\n%v\n", err)
+	}
+	cert, key := signKeyPair(t, "herots test", priv)
+
+	var asked *x509.Certificate
+	o := &Options{
+		Host: "127.0.0.1",
+		Port: freePort(t),
+		KeySource: KeySourceFunc(func(cert *x509.Certificate) (crypto.Signer, error) {
+			asked = cert
+			return priv, nil
+		}),
+	}
+	s := NewServer(o)
+	if err := s.LoadKeyPair(cert, nil); err != nil {
+		t.Fatalf("load key pair from key source:\n%v\n", err)
+	}
+	if asked == nil || asked.Subject.CommonName != "herots test" {
+		t.Fatalf("key source not asked for certificate signer\n")
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("server start:\n%v\n", err)
+	}
+	defer s.Close()
+
+	c := NewClient(&Options{Host: o.Host, Port: o.Port})
+	if err := c.LoadKeyPair(cert, key); err != nil {
+		t.Fatalf("client load key pair:\n%v\n", err)
+	}
+	if err := dialAccepted(s, c); err != nil {
+		t.Fatalf("handshake signed by key source:\n%v\n", err)
+	}
+	if st := s.KeySourceStats(); st.Signs != 1 || st.Failures != 0 {
+		t.Fatalf("unexpected key source stats: %+v\n", st)
+	}
+}
+
+func TestKeySourceMismatch(t *testing.T) {
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key:\n%v\n", err)
+	}
+	cert, _ := genKeyPair(t, "herots test")
+
+	s := NewServer(&Options{KeySource: StaticKeySource(other)})
+	if err := s.LoadKeyPair(cert, nil); err == nil {
+		t.Fatalf("signer of other key accepted\n")
+	}
+}
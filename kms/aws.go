@@ -0,0 +1,256 @@
+package kms
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AWSCredentials - credentials for signing of AWS requests.
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken - token of temporary credentials (empty for long-term
+	// ones).
+	SessionToken string
+}
+
+// AWSOptions - structure, which is used to configure NewAWSSigner.
+type AWSOptions struct {
+	// KeyID - ID, ARN or alias ("alias/name") of asymmetric KMS key with
+	// SIGN_VERIFY usage (ECC_NIST_* or RSA_* key spec).
+	KeyID string
+
+	// Region - AWS region of key, e.g. "eu-central-1".
+	//
+	// Default: AWS_REGION or AWS_DEFAULT_REGION environment variable.
+	Region string
+
+	// Credentials - return credentials for request, called on every
+	// request, so rotated credentials are picked up.
+	//
+	// Default: AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+	// AWS_SESSION_TOKEN environment variables.
+	Credentials func(ctx context.Context) (AWSCredentials, error)
+
+	// Endpoint - URL of KMS API.
+	//
+	// Default: "https://kms.<Region>.amazonaws.com".
+	Endpoint string
+
+	// Timeout - limit of one KMS call.
+	//
+	// Default: DefaultTimeout (10s).
+	Timeout time.Duration
+
+	// HTTPClient - client for KMS calls.
+	//
+	// Default: http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// AWSSigner - crypto.Signer, which signs with AWS KMS key.
+type AWSSigner struct {
+	o   AWSOptions
+	pub crypto.PublicKey
+}
+
+// NewAWSSigner - function for create AWSSigner; public key of KMS key is
+// fetched (GetPublicKey) and cached.
+func NewAWSSigner(o *AWSOptions) (*AWSSigner, error) {
+	s := &AWSSigner{o: *o}
+	if s.o.KeyID == "" {
+		return nil, errors.New("aws kms: no key ID")
+	}
+	if s.o.Region == "" {
+		s.o.Region = os.Getenv("AWS_REGION")
+	}
+	if s.o.Region == "" {
+		s.o.Region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if s.o.Region == "" {
+		return nil, errors.New("aws kms: no region")
+	}
+	if s.o.Credentials == nil {
+		s.o.Credentials = awsEnvCredentials
+	}
+	if s.o.Endpoint == "" {
+		s.o.Endpoint = "https://kms." + s.o.Region + ".amazonaws.com"
+	}
+
+	var out struct {
+		PublicKey []byte
+	}
+	if err := s.call("GetPublicKey", map[string]string{"KeyId": s.o.KeyID}, &out); err != nil {
+		return nil, fmt.Errorf("aws kms: public key of %s: %v", s.o.KeyID, err)
+	}
+	pub, err := x509.ParsePKIXPublicKey(out.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("aws kms: public key of %s: %v", s.o.KeyID, err)
+	}
+	s.pub = pub
+	return s, nil
+}
+
+// Public - implements crypto.Signer.
+func (s *AWSSigner) Public() crypto.PublicKey {
+	return s.pub
+}
+
+// Sign - implements crypto.Signer: sign digest with KMS Sign call. RSA
+// keys sign with PSS if opts is *rsa.PSSOptions (salt length must equal
+// hash length, as in TLS), otherwise with PKCS #1 v1.5.
+func (s *AWSSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	alg, err := awsAlgorithm(s.pub, opts)
+	if err != nil {
+		return nil, fmt.Errorf("aws kms: %v", err)
+	}
+
+	in := struct {
+		KeyId            string
+		Message          []byte
+		MessageType      string
+		SigningAlgorithm string
+	}{s.o.KeyID, digest, "DIGEST", alg}
+	var out struct {
+		Signature []byte
+	}
+	if err := s.call("Sign", in, &out); err != nil {
+		return nil, fmt.Errorf("aws kms: sign with %s: %v", s.o.KeyID, err)
+	}
+	return out.Signature, nil
+}
+
+// awsAlgorithm - KMS signing algorithm for key and opts.
+func awsAlgorithm(pub crypto.PublicKey, opts crypto.SignerOpts) (string, error) {
+	bits, err := hashName(opts.HashFunc())
+	if err != nil {
+		return "", err
+	}
+	switch pub.(type) {
+	case *ecdsa.PublicKey:
+		return "ECDSA_SHA_" + bits, nil
+	case *rsa.PublicKey:
+		pss, ok := opts.(*rsa.PSSOptions)
+		if !ok {
+			return "RSASSA_PKCS1_V1_5_SHA_" + bits, nil
+		}
+		if pss.SaltLength != rsa.PSSSaltLengthEqualsHash && pss.SaltLength != opts.HashFunc().Size() {
+			return "", fmt.Errorf("unsupported PSS salt length %d", pss.SaltLength)
+		}
+		return "RSASSA_PSS_SHA_" + bits, nil
+	}
+	return "", fmt.Errorf("unsupported key type %T", pub)
+}
+
+// call - call KMS action with JSON input and output.
+func (s *AWSSigner) call(action string, in, out any) error {
+	ctx, cancel := callContext(s.o.Timeout)
+	defer cancel()
+
+	creds, err := s.o.Credentials(ctx)
+	if err != nil {
+		return fmt.Errorf("credentials: %v", err)
+	}
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.o.Endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+	signAWSv4(req, body, creds, s.o.Region, "kms", time.Now())
+
+	return doJSON(s.o.HTTPClient, req, out)
+}
+
+func awsEnvCredentials(context.Context) (AWSCredentials, error) {
+	creds := AWSCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return creds, errors.New("AWS_ACCESS_KEY_ID or AWS_SECRET_ACCESS_KEY is not set")
+	}
+	return creds, nil
+}
+
+// signAWSv4 - sign request with AWS Signature Version 4; all headers of
+// req (and Host) are signed.
+func signAWSv4(req *http.Request, body []byte, creds AWSCredentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonical strings.Builder
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonical.WriteString(req.Method + "\n" + path + "\n")
+	canonical.WriteString(strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20") + "\n")
+	for _, name := range names {
+		canonical.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+	canonical.WriteString("\n" + signed + "\n" + hexSHA256(body))
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hexSHA256([]byte(canonical.String()))
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+creds.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signed+", Signature="+signature)
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
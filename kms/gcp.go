@@ -0,0 +1,193 @@
+package kms
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GCPOptions - structure, which is used to configure NewGCPSigner.
+type GCPOptions struct {
+	// KeyVersion - resource name of asymmetric signing key version:
+	// "projects/P/locations/L/keyRings/R/cryptoKeys/K/cryptoKeyVersions/V".
+	KeyVersion string
+
+	// Token - return OAuth 2.0 access token with cloudkms (or
+	// cloud-platform) scope, e.g. from golang.org/x/oauth2 token source.
+	//
+	// Default: token of default service account from metadata server
+	// (Compute Engine, GKE, Cloud Run; GCE_METADATA_HOST overrides host),
+	// cached until shortly before it expires.
+	Token func(ctx context.Context) (string, error)
+
+	// Endpoint - URL of Cloud KMS API.
+	//
+	// Default: "https://cloudkms.googleapis.com".
+	Endpoint string
+
+	// Timeout - limit of one KMS call.
+	//
+	// Default: DefaultTimeout (10s).
+	Timeout time.Duration
+
+	// HTTPClient - client for KMS and metadata calls.
+	//
+	// Default: http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// GCPSigner - crypto.Signer, which signs with GCP Cloud KMS key version.
+type GCPSigner struct {
+	o GCPOptions
+
+	pub crypto.PublicKey
+	// algorithm - algorithm of key version, e.g. "EC_SIGN_P256_SHA256"
+	algorithm string
+}
+
+// NewGCPSigner - function for create GCPSigner; public key of key version
+// is fetched (GetPublicKey) and cached.
+func NewGCPSigner(o *GCPOptions) (*GCPSigner, error) {
+	s := &GCPSigner{o: *o}
+	if s.o.KeyVersion == "" {
+		return nil, errors.New("gcp kms: no key version")
+	}
+	if s.o.Token == nil {
+		s.o.Token = (&metadataToken{client: s.o.HTTPClient}).get
+	}
+	if s.o.Endpoint == "" {
+		s.o.Endpoint = "https://cloudkms.googleapis.com"
+	}
+
+	var out struct {
+		PEM       string `json:"pem"`
+		Algorithm string `json:"algorithm"`
+	}
+	if err := s.call(http.MethodGet, "/publicKey", nil, &out); err != nil {
+		return nil, fmt.Errorf("gcp kms: public key of %s: %v", s.o.KeyVersion, err)
+	}
+	block, _ := pem.Decode([]byte(out.PEM))
+	if block == nil {
+		return nil, fmt.Errorf("gcp kms: public key of %s: no PEM data", s.o.KeyVersion)
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("gcp kms: public key of %s: %v", s.o.KeyVersion, err)
+	}
+	s.pub, s.algorithm = pub, out.Algorithm
+	return s, nil
+}
+
+// Public - implements crypto.Signer.
+func (s *GCPSigner) Public() crypto.PublicKey {
+	return s.pub
+}
+
+// Sign - implements crypto.Signer: sign digest with AsymmetricSign call.
+// Padding and hash are fixed by algorithm of key version, so opts must
+// match it (RSA_SIGN_PSS_* keys for TLS 1.3).
+func (s *GCPSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	bits, err := hashName(opts.HashFunc())
+	if err != nil {
+		return nil, fmt.Errorf("gcp kms: %v", err)
+	}
+	_, pss := opts.(*rsa.PSSOptions)
+	if !strings.HasSuffix(s.algorithm, "_SHA"+bits) || pss != strings.HasPrefix(s.algorithm, "RSA_SIGN_PSS_") {
+		return nil, fmt.Errorf("gcp kms: key algorithm %s can't sign with %T of %v", s.algorithm, opts, opts.HashFunc())
+	}
+
+	in := map[string]map[string][]byte{"digest": {"sha" + bits: digest}}
+	var out struct {
+		Signature []byte `json:"signature"`
+	}
+	if err := s.call(http.MethodPost, ":asymmetricSign", in, &out); err != nil {
+		return nil, fmt.Errorf("gcp kms: sign with %s: %v", s.o.KeyVersion, err)
+	}
+	return out.Signature, nil
+}
+
+// call - call method of key version, with JSON input (nil for none) and
+// output.
+func (s *GCPSigner) call(method, suffix string, in, out any) error {
+	ctx, cancel := callContext(s.o.Timeout)
+	defer cancel()
+
+	token, err := s.o.Token(ctx)
+	if err != nil {
+		return fmt.Errorf("token: %v", err)
+	}
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.o.Endpoint+"/v1/"+s.o.KeyVersion+suffix, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return doJSON(s.o.HTTPClient, req, out)
+}
+
+// metadataToken - access token of default service account from metadata
+// server, cached until minute before expiry.
+type metadataToken struct {
+	client *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func (m *metadataToken) get(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.token != "" && time.Now().Before(m.expires) {
+		return m.token, nil
+	}
+
+	host := os.Getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = "metadata.google.internal"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		"http://"+host+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	var out struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := doJSON(m.client, req, &out); err != nil {
+		return "", fmt.Errorf("metadata server: %v", err)
+	}
+	if out.AccessToken == "" {
+		return "", errors.New("metadata server: no access token")
+	}
+	m.token = out.AccessToken
+	m.expires = time.Now().Add(time.Duration(out.ExpiresIn)*time.Second - time.Minute)
+	return m.token, nil
+}
@@ -0,0 +1,74 @@
+// Package kms provides crypto.Signer adapters for asymmetric keys of cloud
+// key management services (AWS KMS, GCP Cloud KMS), to keep TLS private
+// key of herots server in cloud HSM:
+//
+//	signer, err := kms.NewAWSSigner(&kms.AWSOptions{KeyID: "alias/tls"})
+//	...
+//	o.KeySource = herots.StaticKeySource(signer)
+//
+// Adapters call REST APIs of services with net/http only, so neither
+// herots nor this package depend on cloud SDKs. Public key is fetched once,
+// by constructor; every Sign is one KMS call, see herots.KeySource for
+// latency guidance.
+package kms
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout - default limit of one KMS call.
+const DefaultTimeout = 10 * time.Second
+
+// maxResponseSize - limit of KMS response size.
+const maxResponseSize = 1 << 20
+
+// callContext - return context for one KMS call (crypto.Signer has no
+// context of its own).
+func callContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// doJSON - send request and decode JSON response into out.
+func doJSON(client *http.Client, req *http.Request, out any) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, out)
+}
+
+// hashName - suffix of hash in KMS algorithm names, e.g. "256" for
+// SHA-256.
+func hashName(h crypto.Hash) (string, error) {
+	switch h {
+	case crypto.SHA256:
+		return "256", nil
+	case crypto.SHA384:
+		return "384", nil
+	case crypto.SHA512:
+		return "512", nil
+	}
+	return "", fmt.Errorf("unsupported hash %v", h)
+}
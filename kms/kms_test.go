@@ -0,0 +1,188 @@
+package kms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignAWSv4(t *testing.T) {
+	// "get-vanilla" case of AWS Signature Version 4 test suite
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	signAWSv4(req, nil, AWSCredentials{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}, "us-east-1", "service", now)
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, " +
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Fatalf("unexpected authorization:\n%s\nwant:\n%s\n", got, want)
+	}
+}
+
+// fakeAWS - KMS API with single key.
+func fakeAWS(t *testing.T, key crypto.Signer) *httptest.Server {
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatalf("marshal public key:\n%v\n", err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+			http.Error(w, `{"__type":"UnrecognizedClientException"}`, http.StatusBadRequest)
+			return
+		}
+		var in struct {
+			KeyId            string
+			Message          []byte
+			SigningAlgorithm string
+		}
+		json.NewDecoder(r.Body).Decode(&in)
+		if in.KeyId != "alias/tls" {
+			http.Error(w, `{"__type":"NotFoundException"}`, http.StatusBadRequest)
+			return
+		}
+
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.GetPublicKey":
+			json.NewEncoder(w).Encode(map[string][]byte{"PublicKey": der})
+		case "TrentService.Sign":
+			var opts crypto.SignerOpts = crypto.SHA256
+			switch in.SigningAlgorithm {
+			case "ECDSA_SHA_256", "RSASSA_PKCS1_V1_5_SHA_256":
+			case "RSASSA_PSS_SHA_256":
+				opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+			default:
+				http.Error(w, `{"__type":"ValidationException"}`, http.StatusBadRequest)
+				return
+			}
+			sig, err := key.Sign(rand.Reader, in.Message, opts)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string][]byte{"Signature": sig})
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestAWSSigner(t *testing.T) {
+	digest := sha256.Sum256([]byte("handshake"))
+	creds := func(context.Context) (AWSCredentials, error) {
+		return AWSCredentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}, nil
+	}
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	srv := fakeAWS(t, ecKey)
+	s, err := NewAWSSigner(&AWSOptions{KeyID: "alias/tls", Region: "us-east-1", Endpoint: srv.URL, Credentials: creds})
+	if err != nil {
+		t.Fatalf("new signer:\n%v\n", err)
+	}
+	if !ecKey.PublicKey.Equal(s.Public()) {
+		t.Fatalf("public key of signer doesn't match\n")
+	}
+	sig, err := s.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatalf("sign:\n%v\n", err)
+	}
+	if !ecdsa.VerifyASN1(&ecKey.PublicKey, digest[:], sig) {
+		t.Fatalf("bad ECDSA signature\n")
+	}
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	srv = fakeAWS(t, rsaKey)
+	s, err = NewAWSSigner(&AWSOptions{KeyID: "alias/tls", Region: "us-east-1", Endpoint: srv.URL, Credentials: creds})
+	if err != nil {
+		t.Fatalf("new signer:\n%v\n", err)
+	}
+	pss := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+	sig, err = s.Sign(rand.Reader, digest[:], pss)
+	if err != nil {
+		t.Fatalf("sign:\n%v\n", err)
+	}
+	if err := rsa.VerifyPSS(&rsaKey.PublicKey, crypto.SHA256, digest[:], sig, pss); err != nil {
+		t.Fatalf("bad PSS signature:\n%v\n", err)
+	}
+
+	if _, err := NewAWSSigner(&AWSOptions{KeyID: "alias/other", Region: "us-east-1", Endpoint: srv.URL, Credentials: creds}); err == nil {
+		t.Fatalf("signer of unknown key created\n")
+	}
+}
+
+func TestGCPSigner(t *testing.T) {
+	const name = "projects/p/locations/global/keyRings/r/cryptoKeys/tls/cryptoKeyVersions/1"
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(key.Public())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/computeMetadata/v1/instance/service-accounts/default/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			http.Error(w, "no flavor", http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"access_token": "token", "expires_in": 3600})
+	})
+	mux.HandleFunc("/v1/"+name+"/publicKey", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"pem":       string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+			"algorithm": "EC_SIGN_P256_SHA256",
+		})
+	})
+	mux.HandleFunc("/v1/"+name+":asymmetricSign", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, `{"error":{"code":401}}`, http.StatusUnauthorized)
+			return
+		}
+		var in struct {
+			Digest struct {
+				SHA256 []byte `json:"sha256"`
+			} `json:"digest"`
+		}
+		json.NewDecoder(r.Body).Decode(&in)
+		sig, _ := ecdsa.SignASN1(rand.Reader, key, in.Digest.SHA256)
+		json.NewEncoder(w).Encode(map[string][]byte{"signature": sig})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// token of metadata server
+	t.Setenv("GCE_METADATA_HOST", strings.TrimPrefix(srv.URL, "http://"))
+	s, err := NewGCPSigner(&GCPOptions{KeyVersion: name, Endpoint: srv.URL})
+	if err != nil {
+		t.Fatalf("new signer:\n%v\n", err)
+	}
+	if !key.PublicKey.Equal(s.Public()) {
+		t.Fatalf("public key of signer doesn't match\n")
+	}
+
+	digest := sha256.Sum256([]byte("handshake"))
+	sig, err := s.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatalf("sign:\n%v\n", err)
+	}
+	if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], sig) {
+		t.Fatalf("bad ECDSA signature\n")
+	}
+
+	// hash of key version is fixed
+	digest384 := make([]byte, 48)
+	if _, err := s.Sign(rand.Reader, digest384, crypto.SHA384); err == nil {
+		t.Fatalf("SHA-384 digest signed with SHA-256 key version\n")
+	}
+}
@@ -0,0 +1,51 @@
+package herots
+
+import (
+	"math/rand"
+	"time"
+)
+
+// DefaultMaxConnectionAgeGrace - default time given to connection to be
+// closed by handler after its max age was reached.
+const DefaultMaxConnectionAgeGrace = 10 * time.Second
+
+// startLifetime - schedule graceful close of connection after
+// Options.MaxConnectionAge (plus random jitter).
+func (c *Conn) startLifetime() {
+	o := c.server.options
+	if o.MaxConnectionAge <= 0 {
+		return
+	}
+
+	age := o.MaxConnectionAge
+	if o.MaxConnectionAgeJitter > 0 {
+		age += time.Duration(rand.Int63n(int64(o.MaxConnectionAgeJitter)))
+	}
+
+	grace := o.MaxConnectionAgeGrace
+	if grace <= 0 {
+		grace = DefaultMaxConnectionAgeGrace
+	}
+
+	c.dlMu.Lock()
+	clock := c.server.clock
+	c.lifetime = clock.AfterFunc(age, func() {
+		c.server.logger.Log("max age reached, draining "+c.String(), LogLevelInfo)
+		c.drain(CloseReasonMaxAge)
+
+		c.dlMu.Lock()
+		if !c.closed {
+			c.lifetime = clock.AfterFunc(grace, func() { c.closeWith(CloseReasonMaxAge, nil) })
+		}
+		c.dlMu.Unlock()
+	})
+	c.dlMu.Unlock()
+}
+
+// stopLifetimeLocked - cancel scheduled close. dlMu must be held.
+func (c *Conn) stopLifetimeLocked() {
+	if c.lifetime != nil {
+		c.lifetime()
+		c.lifetime = nil
+	}
+}
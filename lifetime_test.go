@@ -0,0 +1,94 @@
+package herots
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/iu0v1/herots/herotstest"
+)
+
+func TestMaxConnectionAge(t *testing.T) {
+	drained := make(chan struct{}, 1)
+	s, c := startTestServer(t, &Options{
+		MaxConnectionAge:       100 * time.Millisecond,
+		MaxConnectionAgeJitter: 50 * time.Millisecond,
+		MaxConnectionAgeGrace:  100 * time.Millisecond,
+		OnDrain:                func(c *Conn) { drained <- struct{}{} },
+	})
+
+	clientDone := make(chan error, 1)
+	go func() {
+		conn, err := c.Dial()
+		if err != nil {
+			clientDone <- err
+			return
+		}
+		defer conn.Close()
+		_, err = io.Copy(io.Discard, conn)
+		clientDone <- err
+	}()
+
+	conn, err := s.AcceptConn()
+	if err != nil {
+		t.Fatalf("accept:\n%v\n", err)
+	}
+	conn.Handshake()
+
+	// handler ignores drain; connection must be closed after grace
+	select {
+	case <-drained:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("connection not drained after max age\n")
+	}
+	select {
+	case <-clientDone:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("connection not closed after grace period\n")
+	}
+	if n := len(s.Conns()); n != 0 {
+		t.Fatalf("%d conns left\n", n)
+	}
+}
+
+func TestMaxConnectionAgeFakeClock(t *testing.T) {
+	clock := herotstest.NewFakeClock(time.Now())
+	s, c := startTestServer(t, &Options{
+		Clock:                 clock,
+		MaxConnectionAge:      time.Hour,
+		MaxConnectionAgeGrace: time.Minute,
+	})
+
+	go func() {
+		conn, err := c.Dial()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(io.Discard, conn)
+	}()
+
+	conn, err := s.AcceptConn()
+	if err != nil {
+		t.Fatalf("accept:\n%v\n", err)
+	}
+	conn.Handshake()
+	waitFor(t, "lifetime timer", func() bool { return clock.Pending() == 1 })
+
+	clock.Advance(59 * time.Minute)
+	if conn.draining.Load() {
+		t.Fatalf("connection drained before max age\n")
+	}
+
+	clock.Advance(time.Minute)
+	if !conn.draining.Load() {
+		t.Fatalf("connection not drained at max age\n")
+	}
+	clock.Advance(time.Minute)
+	if n := len(s.Conns()); n != 0 {
+		t.Fatalf("connection not closed after grace period\n")
+	}
+	if r, _ := conn.CloseReason(); r != CloseReasonMaxAge {
+		t.Fatalf("unexpected close reason %q\n", r)
+	}
+}
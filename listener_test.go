@@ -0,0 +1,48 @@
+package herots
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+var _ net.Listener = (*Server)(nil)
+
+func TestServeHTTP(t *testing.T) {
+	s, c := startTestServer(t, &Options{})
+
+	type connKey struct{}
+	h := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn := r.Context().Value(connKey{}).(*Conn)
+			id, err := conn.Identity()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			io.WriteString(w, "hello "+id.CommonName())
+		}),
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			return context.WithValue(ctx, connKey{}, c)
+		},
+	}
+	go h.Serve(s)
+	defer h.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return c.Dial()
+		},
+	}}
+	resp, err := client.Get("https://" + s.Addr().String() + "/")
+	if err != nil {
+		t.Fatalf("get:\n%v\n", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "hello herots test" {
+		t.Fatalf("unexpected response %q\n", body)
+	}
+}
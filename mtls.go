@@ -0,0 +1,113 @@
+package herots
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+)
+
+// RequireMTLSFromCA - preset for mutual TLS: require client certificates
+// verified against CA certificates from caPEM only (client CA pool is
+// replaced, see ReplaceClientCAPool), so certificate of loaded key pair is
+// no longer trusted as client one.
+//
+// CA certificates stay trusted across key pair reloads (LoadKeyPair,
+// LoadSecretDir without ca.crt). Loaded certificate must be valid for
+// server authentication. Must be called after LoadKeyPair and before
+// Start.
+func (s *Server) RequireMTLSFromCA(caPEM []byte) error {
+	s.listenerMu.Lock()
+	defer s.listenerMu.Unlock()
+
+	if s.listener != nil {
+		return fmt.Errorf("mtls preset error: server already started\n")
+	}
+	cas, err := parseCACerts(caPEM)
+	if err != nil {
+		return fmt.Errorf("mtls preset error: %v\n", err)
+	}
+
+	s.certMu.Lock()
+	defer s.certMu.Unlock()
+
+	if len(s.certs.Cert.Certificate) == 0 {
+		return fmt.Errorf("%s\n", NoKeyPairLoadError)
+	}
+	if err := checkExtKeyUsage(s.certs.Cert.Certificate[0], x509.ExtKeyUsageServerAuth); err != nil {
+		return fmt.Errorf("mtls preset error: %v\n", err)
+	}
+	s.options.TLSAuthType = tls.RequireAndVerifyClientCert
+	s.mtlsCAs = cas
+	s.setClientCAsLocked(cas)
+
+	s.logger.Log(fmt.Sprintf("mtls preset - ok (%d CA certs)", len(cas)), LogLevelInfo)
+
+	return nil
+}
+
+// reloadClientCAsLocked - client CA list after reload of key pair with
+// certificate own: CA certificates of RequireMTLSFromCA, if preset is
+// applied, otherwise own.
+func (s *Server) reloadClientCAsLocked(own *x509.Certificate) []*x509.Certificate {
+	if s.mtlsCAs != nil {
+		return s.mtlsCAs
+	}
+	return []*x509.Certificate{own}
+}
+
+// UseMTLS - preset for mutual TLS on client side: load key pair (see
+// LoadKeyPair) and trust only CA certificates from caPEM for server
+// verification.
+//
+// Certificate must be valid for client authentication. Fails with
+// Options.InsecureSkipVerify, as server would not be verified.
+func (c *Client) UseMTLS(certPEM, keyPEM, caPEM []byte) error {
+	if c.options.InsecureSkipVerify {
+		return fmt.Errorf("mtls preset error: InsecureSkipVerify is set\n")
+	}
+	cas, err := parseCACerts(caPEM)
+	if err != nil {
+		return fmt.Errorf("mtls preset error: %v\n", err)
+	}
+
+	cert, _, err := c.options.loadKeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("%s: %v\n", LoadKeyPairError, err)
+	}
+	if err := checkExtKeyUsage(cert.Certificate[0], x509.ExtKeyUsageClientAuth); err != nil {
+		return fmt.Errorf("mtls preset error: %v\n", err)
+	}
+
+	pool := x509.NewCertPool()
+	for _, ca := range cas {
+		pool.AddCert(ca)
+	}
+	c.certs.Cert = cert
+	c.certs.Pool = pool
+
+	c.logger.Log("mtls preset - ok", LogLevelInfo)
+
+	return nil
+}
+
+// checkExtKeyUsage - check that certificate may be used for given purpose
+// (certificates without extended key usage may be used for any).
+func checkExtKeyUsage(der []byte, usage x509.ExtKeyUsage) error {
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return err
+	}
+	if len(cert.ExtKeyUsage) == 0 && len(cert.UnknownExtKeyUsage) == 0 {
+		return nil
+	}
+	for _, u := range cert.ExtKeyUsage {
+		if u == usage || u == x509.ExtKeyUsageAny {
+			return nil
+		}
+	}
+	name := "client"
+	if usage == x509.ExtKeyUsageServerAuth {
+		name = "server"
+	}
+	return fmt.Errorf("certificate %q is not valid for %s authentication", cert.Subject, name)
+}
@@ -0,0 +1,104 @@
+package herots
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMTLSPresets(t *testing.T) {
+	ca, err := NewCA("herots CA", time.Hour)
+	if err != nil {
+		t.Fatalf("new CA:\n%v\n", err)
+	}
+	issue := func(profile, cn string) ([]byte, []byte) {
+		cert, key, err := ca.Issue(&IssueRequest{
+			Profile:     profile,
+			CommonName:  cn,
+			IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		})
+		if err != nil {
+			t.Fatalf("issue cert:\n%v\n", err)
+		}
+		return cert, key
+	}
+	serverCert, serverKey := issue(ProfilePeer, "herald")
+	clientCert, clientKey := issue(ProfilePeer, "agent")
+
+	o := &Options{Host: "127.0.0.1", Port: freePort(t)}
+	s := NewServer(o)
+	if err := s.RequireMTLSFromCA(ca.Certificate()); err == nil {
+		t.Fatalf("preset without key pair must fail\n")
+	}
+	s.LoadKeyPair(serverCert, serverKey)
+	if err := s.RequireMTLSFromCA(ca.Certificate()); err != nil {
+		t.Fatalf("server preset:\n%v\n", err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("server start:\n%v\n", err)
+	}
+	defer s.Close()
+	if err := s.RequireMTLSFromCA(ca.Certificate()); err == nil {
+		t.Fatalf("preset on running server must fail\n")
+	}
+
+	c := NewClient(&Options{Host: o.Host, Port: o.Port})
+	if err := c.UseMTLS(clientCert, clientKey, ca.Certificate()); err != nil {
+		t.Fatalf("client preset:\n%v\n", err)
+	}
+	if err := dialAccepted(s, c); err != nil {
+		t.Fatalf("mtls handshake:\n%v\n", err)
+	}
+
+	// CA stays trusted after reload of rotated key pair
+	serverCert, serverKey = issue(ProfilePeer, "herald")
+	if err := s.LoadKeyPair(serverCert, serverKey); err != nil {
+		t.Fatalf("reload key pair:\n%v\n", err)
+	}
+	if err := dialAccepted(s, c); err != nil {
+		t.Fatalf("mtls handshake after reload:\n%v\n", err)
+	}
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, SecretCertFile), serverCert, 0600)
+	os.WriteFile(filepath.Join(dir, SecretKeyFile), serverKey, 0600)
+	if err := s.LoadSecretDir(dir); err != nil {
+		t.Fatalf("load secret dir:\n%v\n", err)
+	}
+	if err := dialAccepted(s, c); err != nil {
+		t.Fatalf("mtls handshake after secret dir reload:\n%v\n", err)
+	}
+
+	// self-signed cert, trusted by nobody
+	stranger, strangerKey := genKeyPair(t, "stranger")
+	c = NewClient(&Options{Host: o.Host, Port: o.Port})
+	if err := c.UseMTLS(stranger, strangerKey, ca.Certificate()); err != nil {
+		t.Fatalf("client preset:\n%v\n", err)
+	}
+	if err := dialAccepted(s, c); err == nil {
+		t.Fatalf("client cert of other CA accepted\n")
+	}
+}
+
+func TestMTLSPresetUsage(t *testing.T) {
+	ca, err := NewCA("herots CA", time.Hour)
+	if err != nil {
+		t.Fatalf("new CA:\n%v\n", err)
+	}
+	cert, key, err := ca.Issue(&IssueRequest{Profile: ProfileClient, CommonName: "agent"})
+	if err != nil {
+		t.Fatalf("issue cert:\n%v\n", err)
+	}
+
+	s := NewServer(&Options{})
+	s.LoadKeyPair(cert, key)
+	if err := s.RequireMTLSFromCA(ca.Certificate()); err == nil {
+		t.Fatalf("client-only cert accepted as server cert\n")
+	}
+
+	c := NewClient(&Options{InsecureSkipVerify: true})
+	if err := c.UseMTLS(cert, key, ca.Certificate()); err == nil {
+		t.Fatalf("preset accepted InsecureSkipVerify\n")
+	}
+}
@@ -0,0 +1,685 @@
+package herots
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Mux frame format: version (1 byte), type (1), flags (2), stream id (4),
+// length (4), all big endian, followed by length bytes of payload for data
+// frames. For window frames length is window increment.
+const (
+	muxVersion    = 0
+	muxHeaderSize = 12
+
+	muxTypeData   = 0
+	muxTypeWindow = 1
+
+	// open stream
+	muxFlagSYN = 1 << 0
+	// sender half-closed stream
+	muxFlagFIN = 1 << 1
+	// stream aborted
+	muxFlagRST = 1 << 2
+)
+
+// muxInitialWindow - receive window every stream starts with; larger
+// windows (MuxOptions.Window) are announced with window update right
+// after stream is opened or accepted.
+const muxInitialWindow = 256 << 10
+
+// muxMaxFrame - limit of data frame payload, so streams interleave.
+const muxMaxFrame = 32 << 10
+
+// muxMaxPendingControl - limit of streams with queued control frames;
+// Mux fails if peer doesn't read them.
+const muxMaxPendingControl = 4096
+
+// default mux settings
+const (
+	DefaultMuxWindow        = muxInitialWindow
+	DefaultMuxAcceptBacklog = 64
+)
+
+// predefined mux errors
+var (
+	ErrMuxClosed    = errors.New("mux session closed")
+	ErrStreamReset  = errors.New("mux stream reset by peer")
+	ErrStreamClosed = errors.New("mux stream closed")
+)
+
+// MuxOptions - structure, which is used to configure Mux.
+type MuxOptions struct {
+	// Window - receive window of each stream: how much data peer may send
+	// before local side reads it. Slow reader of one stream doesn't block
+	// other streams.
+	//
+	// Default: DefaultMuxWindow (256 KiB), which is also minimum.
+	Window int
+
+	// AcceptBacklog - number of streams opened by peer and not yet
+	// accepted; streams over limit are reset.
+	//
+	// Default: DefaultMuxAcceptBacklog.
+	AcceptBacklog int
+}
+
+// Mux - multiplexer of logical streams over one connection.
+//
+// Each side may open streams (Open) and accept streams opened by peer
+// (Accept); every stream has its own flow control window. Both sides of
+// connection must use Mux, one created with NewMuxClient and other with
+// NewMuxServer.
+type Mux struct {
+	conn   net.Conn
+	window uint32
+
+	wmu sync.Mutex
+
+	// control frames queued by read loop, coalesced per stream and sent
+	// by controlLoop
+	cmu          sync.Mutex
+	control      map[uint32]muxControl
+	controlOrder []uint32
+	controlReady chan struct{}
+
+	mu      sync.Mutex
+	streams map[uint32]*Stream
+	nextID  uint32
+	err     error
+
+	accept chan *Stream
+	done   chan struct{}
+}
+
+// NewMuxClient - function for create Mux over client side of connection
+// and start serving it.
+func NewMuxClient(conn net.Conn, o *MuxOptions) *Mux {
+	return newMux(conn, 1, o)
+}
+
+// NewMuxServer - function for create Mux over server side of connection
+// and start serving it.
+func NewMuxServer(conn net.Conn, o *MuxOptions) *Mux {
+	return newMux(conn, 2, o)
+}
+
+func newMux(conn net.Conn, firstID uint32, o *MuxOptions) *Mux {
+	if o == nil {
+		o = &MuxOptions{}
+	}
+	window := o.Window
+	if window < muxInitialWindow {
+		window = muxInitialWindow
+	}
+	backlog := o.AcceptBacklog
+	if backlog <= 0 {
+		backlog = DefaultMuxAcceptBacklog
+	}
+
+	m := &Mux{
+		conn:    conn,
+		window:  uint32(window),
+		streams: make(map[uint32]*Stream),
+		nextID:  firstID,
+		accept:  make(chan *Stream, backlog),
+		done:    make(chan struct{}),
+
+		control:      make(map[uint32]muxControl),
+		controlReady: make(chan struct{}, 1),
+	}
+	go m.readLoop()
+	go m.controlLoop()
+	return m
+}
+
+// Open - open new stream.
+func (m *Mux) Open() (*Stream, error) {
+	m.mu.Lock()
+	if m.err != nil {
+		err := m.err
+		m.mu.Unlock()
+		return nil, err
+	}
+	id := m.nextID
+	m.nextID += 2
+	s := m.newStreamLocked(id)
+	m.mu.Unlock()
+
+	if err := m.sendFrame(muxTypeWindow, muxFlagSYN, id, m.window-muxInitialWindow, nil); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Accept - wait for and return stream opened by peer.
+func (m *Mux) Accept() (*Stream, error) {
+	select {
+	case s := <-m.accept:
+		return s, nil
+	case <-m.done:
+		return nil, m.Err()
+	}
+}
+
+// Done - return channel which is closed when Mux stops serving connection.
+func (m *Mux) Done() <-chan struct{} {
+	return m.done
+}
+
+// Err - return reason why Mux stopped, or nil while it is serving.
+func (m *Mux) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
+
+// Close - close all streams and underlying connection.
+func (m *Mux) Close() error {
+	m.fail(ErrMuxClosed)
+	return m.conn.Close()
+}
+
+func (m *Mux) newStreamLocked(id uint32) *Stream {
+	s := &Stream{
+		m:          m,
+		id:         id,
+		recvAvail:  m.window,
+		sendWindow: muxInitialWindow,
+		readable:   make(chan struct{}, 1),
+		writable:   make(chan struct{}, 1),
+	}
+	m.streams[id] = s
+	return s
+}
+
+func (m *Mux) remove(id uint32) {
+	m.mu.Lock()
+	delete(m.streams, id)
+	m.mu.Unlock()
+}
+
+// sendFrame - write one frame; frames of all streams are serialized.
+func (m *Mux) sendFrame(typ byte, flags uint16, id, length uint32, payload []byte) error {
+	buf := make([]byte, muxHeaderSize+len(payload))
+	buf[0] = muxVersion
+	buf[1] = typ
+	binary.BigEndian.PutUint16(buf[2:], flags)
+	binary.BigEndian.PutUint32(buf[4:], id)
+	binary.BigEndian.PutUint32(buf[8:], length)
+	copy(buf[muxHeaderSize:], payload)
+
+	m.wmu.Lock()
+	defer m.wmu.Unlock()
+
+	if err := m.Err(); err != nil {
+		return err
+	}
+	if _, err := m.conn.Write(buf); err != nil {
+		m.fail(fmt.Errorf("mux write fail: %w", err))
+		return m.Err()
+	}
+	return nil
+}
+
+// muxControl - queued control frames of stream.
+type muxControl struct {
+	window uint32
+	rst    bool
+}
+
+// queueWindow - queue window update of stream for controlLoop, so read
+// loop doesn't block and peers writing to each other don't deadlock.
+// Updates of stream are summed until sent.
+func (m *Mux) queueWindow(id, n uint32) error {
+	return m.queueControl(id, func(c *muxControl) {
+		if !c.rst {
+			c.window += n
+		}
+	})
+}
+
+// queueReset - queue reset of stream for controlLoop; pending window
+// update of stream is dropped.
+func (m *Mux) queueReset(id uint32) error {
+	return m.queueControl(id, func(c *muxControl) {
+		c.rst, c.window = true, 0
+	})
+}
+
+// queueControl - queue control frame of stream. Error is returned if
+// peer doesn't read control frames; read loop fails Mux with it.
+func (m *Mux) queueControl(id uint32, update func(c *muxControl)) error {
+	m.cmu.Lock()
+	c, ok := m.control[id]
+	if !ok {
+		if len(m.control) >= muxMaxPendingControl {
+			m.cmu.Unlock()
+			return fmt.Errorf("mux peer doesn't read control frames (%d streams pending)", muxMaxPendingControl)
+		}
+		m.controlOrder = append(m.controlOrder, id)
+	}
+	update(&c)
+	m.control[id] = c
+	m.cmu.Unlock()
+
+	select {
+	case m.controlReady <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// controlLoop - send queued control frames until Mux stops.
+func (m *Mux) controlLoop() {
+	for {
+		select {
+		case <-m.controlReady:
+		case <-m.done:
+			return
+		}
+
+		m.cmu.Lock()
+		control, order := m.control, m.controlOrder
+		m.control, m.controlOrder = make(map[uint32]muxControl), nil
+		m.cmu.Unlock()
+
+		for _, id := range order {
+			c := control[id]
+			var err error
+			if c.rst {
+				err = m.sendFrame(muxTypeWindow, muxFlagRST, id, 0, nil)
+			} else {
+				err = m.sendFrame(muxTypeWindow, 0, id, c.window, nil)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// fail - stop Mux with error and wake all streams.
+func (m *Mux) fail(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return
+	}
+	m.err = err
+	for id, s := range m.streams {
+		s.terminate(err)
+		delete(m.streams, id)
+	}
+	close(m.done)
+}
+
+func (m *Mux) readLoop() {
+	var hdr [muxHeaderSize]byte
+	for {
+		if _, err := io.ReadFull(m.conn, hdr[:]); err != nil {
+			if err == io.EOF {
+				err = ErrMuxClosed
+			}
+			m.fail(err)
+			return
+		}
+		if hdr[0] != muxVersion {
+			m.fail(fmt.Errorf("mux unsupported version %d", hdr[0]))
+			return
+		}
+		typ := hdr[1]
+		flags := binary.BigEndian.Uint16(hdr[2:])
+		id := binary.BigEndian.Uint32(hdr[4:])
+		length := binary.BigEndian.Uint32(hdr[8:])
+
+		var payload []byte
+		if typ == muxTypeData {
+			if length > m.window {
+				m.fail(fmt.Errorf("mux frame exceeds window (%d bytes)", length))
+				return
+			}
+			payload = make([]byte, length)
+			if _, err := io.ReadFull(m.conn, payload); err != nil {
+				m.fail(err)
+				return
+			}
+		} else if typ != muxTypeWindow {
+			m.fail(fmt.Errorf("mux unknown frame type %d", typ))
+			return
+		}
+
+		if err := m.handle(typ, flags, id, length, payload); err != nil {
+			m.fail(err)
+			return
+		}
+	}
+}
+
+func (m *Mux) handle(typ byte, flags uint16, id, length uint32, payload []byte) error {
+	m.mu.Lock()
+	s, ok := m.streams[id]
+	if !ok && flags&muxFlagSYN != 0 {
+		if id%2 == m.nextID%2 {
+			m.mu.Unlock()
+			return fmt.Errorf("mux peer opened stream with local id %d", id)
+		}
+		s = m.newStreamLocked(id)
+		select {
+		case m.accept <- s:
+		default:
+			// backlog is full
+			delete(m.streams, id)
+			m.mu.Unlock()
+			return m.queueReset(id)
+		}
+		m.mu.Unlock()
+		if m.window > muxInitialWindow {
+			if err := m.queueWindow(id, m.window-muxInitialWindow); err != nil {
+				return err
+			}
+		}
+	} else {
+		m.mu.Unlock()
+	}
+
+	if s == nil {
+		// frame for already closed stream
+		if typ == muxTypeData && length > 0 {
+			return m.queueWindow(id, length)
+		}
+		return nil
+	}
+
+	if flags&muxFlagRST != 0 {
+		s.terminate(ErrStreamReset)
+		m.remove(id)
+		return nil
+	}
+
+	if typ == muxTypeWindow {
+		s.addSendWindow(length)
+	} else if err := s.receive(payload); err != nil {
+		return err
+	}
+
+	if flags&muxFlagFIN != 0 {
+		if s.peerClosed() {
+			m.remove(id)
+		}
+	}
+	return nil
+}
+
+// Stream - logical stream of Mux, which implements net.Conn.
+type Stream struct {
+	m  *Mux
+	id uint32
+
+	mu         sync.Mutex
+	buf        bytes.Buffer
+	recvAvail  uint32 // window left for peer
+	consumed   uint32 // read since last window update
+	sendWindow uint32
+
+	readClosed  bool  // peer sent FIN
+	writeClosed bool  // FIN sent
+	closed      bool  // Close called
+	err         error // reset or session error
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	readable chan struct{}
+	writable chan struct{}
+}
+
+// ID - return stream id, unique within Mux.
+func (s *Stream) ID() uint32 {
+	return s.id
+}
+
+// Read - read data of stream. After peer half-closed stream (CloseWrite
+// or Close) and all data is read, Read returns io.EOF.
+func (s *Stream) Read(p []byte) (int, error) {
+	for {
+		s.mu.Lock()
+		if s.buf.Len() > 0 {
+			n, _ := s.buf.Read(p)
+			s.consumed += uint32(n)
+			var inc uint32
+			if s.consumed >= s.m.window/2 && s.err == nil {
+				inc = s.consumed
+				s.consumed = 0
+				s.recvAvail += inc
+			}
+			s.mu.Unlock()
+			if inc > 0 {
+				s.m.sendFrame(muxTypeWindow, 0, s.id, inc, nil)
+			}
+			return n, nil
+		}
+		switch {
+		case s.closed:
+			s.mu.Unlock()
+			return 0, ErrStreamClosed
+		case s.readClosed:
+			s.mu.Unlock()
+			return 0, io.EOF
+		case s.err != nil:
+			err := s.err
+			s.mu.Unlock()
+			return 0, err
+		}
+		dl := s.readDeadline
+		s.mu.Unlock()
+
+		if err := s.wait(s.readable, dl); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write - write data to stream, blocking while peer's window is exhausted.
+func (s *Stream) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		s.mu.Lock()
+		switch {
+		case s.err != nil:
+			err := s.err
+			s.mu.Unlock()
+			return total, err
+		case s.closed, s.writeClosed:
+			s.mu.Unlock()
+			return total, ErrStreamClosed
+		}
+		if s.sendWindow == 0 {
+			dl := s.writeDeadline
+			s.mu.Unlock()
+			if err := s.wait(s.writable, dl); err != nil {
+				return total, err
+			}
+			continue
+		}
+		n := min(uint32(len(p)), s.sendWindow, muxMaxFrame)
+		s.sendWindow -= n
+		s.mu.Unlock()
+
+		if err := s.m.sendFrame(muxTypeData, 0, s.id, n, p[:n]); err != nil {
+			return total, err
+		}
+		total += int(n)
+		p = p[n:]
+	}
+	return total, nil
+}
+
+// CloseWrite - half-close stream: peer reads io.EOF after data written
+// so far, local side still may read.
+func (s *Stream) CloseWrite() error {
+	s.mu.Lock()
+	if s.writeClosed || s.err != nil {
+		s.mu.Unlock()
+		return nil
+	}
+	s.writeClosed = true
+	done := s.readClosed
+	s.mu.Unlock()
+
+	err := s.m.sendFrame(muxTypeData, muxFlagFIN, s.id, 0, nil)
+	if done {
+		s.m.remove(s.id)
+	}
+	return err
+}
+
+// Close - close stream. Peer reads io.EOF after data written so far; data
+// sent by peer afterwards is discarded.
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.buf.Reset()
+	s.mu.Unlock()
+
+	s.notify()
+	return s.CloseWrite()
+}
+
+// Reset - abort stream in both directions.
+func (s *Stream) Reset() error {
+	s.terminate(ErrStreamClosed)
+	s.m.remove(s.id)
+	return s.m.sendFrame(muxTypeWindow, muxFlagRST, s.id, 0, nil)
+}
+
+// LocalAddr - return local address of underlying connection.
+func (s *Stream) LocalAddr() net.Addr {
+	return s.m.conn.LocalAddr()
+}
+
+// RemoteAddr - return remote address of underlying connection.
+func (s *Stream) RemoteAddr() net.Addr {
+	return s.m.conn.RemoteAddr()
+}
+
+// SetDeadline - set read and write deadlines of stream.
+func (s *Stream) SetDeadline(t time.Time) error {
+	s.mu.Lock()
+	s.readDeadline, s.writeDeadline = t, t
+	s.mu.Unlock()
+	s.notify()
+	return nil
+}
+
+// SetReadDeadline - set read deadline of stream.
+func (s *Stream) SetReadDeadline(t time.Time) error {
+	s.mu.Lock()
+	s.readDeadline = t
+	s.mu.Unlock()
+	s.notify()
+	return nil
+}
+
+// SetWriteDeadline - set write deadline of stream. Deadline limits waiting
+// for peer's window, not write to underlying connection.
+func (s *Stream) SetWriteDeadline(t time.Time) error {
+	s.mu.Lock()
+	s.writeDeadline = t
+	s.mu.Unlock()
+	s.notify()
+	return nil
+}
+
+func (s *Stream) notify() {
+	for _, ch := range []chan struct{}{s.readable, s.writable} {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// wait - wait for signal on ch or deadline.
+func (s *Stream) wait(ch chan struct{}, deadline time.Time) error {
+	var timer <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timer = t.C
+	}
+
+	select {
+	case <-ch:
+		return nil
+	case <-timer:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+// receive - buffer data frame payload.
+func (s *Stream) receive(payload []byte) error {
+	s.mu.Lock()
+	n := uint32(len(payload))
+	if n > s.recvAvail {
+		s.mu.Unlock()
+		return fmt.Errorf("mux stream %d window exceeded", s.id)
+	}
+	if s.closed {
+		// nobody reads closed stream, give window back to peer
+		s.mu.Unlock()
+		if n > 0 {
+			return s.m.queueWindow(s.id, n)
+		}
+		return nil
+	}
+	s.recvAvail -= n
+	s.buf.Write(payload)
+	s.mu.Unlock()
+
+	s.notify()
+	return nil
+}
+
+func (s *Stream) addSendWindow(n uint32) {
+	s.mu.Lock()
+	s.sendWindow += n
+	s.mu.Unlock()
+	s.notify()
+}
+
+// peerClosed - record FIN of peer; reports whether stream is closed in
+// both directions.
+func (s *Stream) peerClosed() bool {
+	s.mu.Lock()
+	s.readClosed = true
+	done := s.writeClosed
+	s.mu.Unlock()
+	s.notify()
+	return done
+}
+
+// terminate - fail stream with err.
+func (s *Stream) terminate(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.mu.Unlock()
+	s.notify()
+}
@@ -0,0 +1,179 @@
+package herots
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testMuxPair(t *testing.T) (*Mux, *Mux) {
+	a, b := net.Pipe()
+	client := NewMuxClient(a, nil)
+	server := NewMuxServer(b, &MuxOptions{Window: 1 << 20})
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+func TestMuxStreams(t *testing.T) {
+	client, server := testMuxPair(t)
+
+	// echo server
+	go func() {
+		for {
+			s, err := server.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(s, s)
+				s.Close()
+			}()
+		}
+	}()
+
+	// more data than window, on several streams at once
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s, err := client.Open()
+			if err != nil {
+				errs <- err
+				return
+			}
+			data := make([]byte, 3*DefaultMuxWindow)
+			rand.Read(data)
+			go func() {
+				s.Write(data)
+				s.CloseWrite()
+			}()
+			got, err := io.ReadAll(s)
+			if err != nil || !bytes.Equal(got, data) {
+				errs <- errors.New("echoed data mismatch")
+			}
+			s.Close()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("stream:\n%v\n", err)
+	}
+}
+
+func TestMuxServerInitiated(t *testing.T) {
+	client, server := testMuxPair(t)
+
+	go func() {
+		s, err := server.Open()
+		if err != nil {
+			return
+		}
+		s.Write([]byte("push"))
+		s.Close()
+	}()
+
+	s, err := client.Accept()
+	if err != nil {
+		t.Fatalf("accept:\n%v\n", err)
+	}
+	if s.ID()%2 != 0 {
+		t.Fatalf("server stream must have even id, got %d\n", s.ID())
+	}
+	data, err := io.ReadAll(s)
+	if err != nil || string(data) != "push" {
+		t.Fatalf("unexpected data: %q, %v\n", data, err)
+	}
+}
+
+func TestMuxFlowControl(t *testing.T) {
+	client, server := testMuxPair(t)
+
+	accepted := make(chan *Stream, 1)
+	go func() {
+		s, _ := server.Accept()
+		accepted <- s
+	}()
+
+	s, err := client.Open()
+	if err != nil {
+		t.Fatalf("open:\n%v\n", err)
+	}
+	// server doesn't read, write must stop at its window
+	s.SetWriteDeadline(time.Now().Add(200 * time.Millisecond))
+	n, err := s.Write(make([]byte, 2<<20))
+	if !errors.Is(err, os.ErrDeadlineExceeded) || n != 1<<20 {
+		t.Fatalf("expected write blocked after 1 MiB, got %d, %v\n", n, err)
+	}
+
+	// other streams are not blocked
+	s2, err := client.Open()
+	if err != nil {
+		t.Fatalf("open:\n%v\n", err)
+	}
+	if _, err := s2.Write([]byte("ok")); err != nil {
+		t.Fatalf("write to second stream:\n%v\n", err)
+	}
+
+	// stream dies with session
+	<-accepted
+	client.Close()
+	if _, err := s.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("read on closed session succeeded\n")
+	}
+}
+
+func TestMuxControlQueue(t *testing.T) {
+	a, b := net.Pipe()
+	server := NewMuxServer(b, &MuxOptions{AcceptBacklog: 1})
+	defer server.Close()
+	defer a.Close()
+
+	// peer opens streams over backlog and never reads resets
+	syn := func(id uint32) []byte {
+		frame := make([]byte, muxHeaderSize)
+		frame[1] = muxTypeWindow
+		binary.BigEndian.PutUint16(frame[2:], muxFlagSYN)
+		binary.BigEndian.PutUint32(frame[4:], id)
+		return frame
+	}
+	before := runtime.NumGoroutine()
+	for id := uint32(1); id < 200; id += 2 {
+		if _, err := a.Write(syn(id)); err != nil {
+			t.Fatalf("write:\n%v\n", err)
+		}
+	}
+	if n := runtime.NumGoroutine() - before; n > 5 {
+		t.Fatalf("%d goroutines started for queued resets\n", n)
+	}
+
+	go func() {
+		for id := uint32(201); ; id += 2 {
+			if _, err := a.Write(syn(id)); err != nil {
+				return
+			}
+		}
+	}()
+	select {
+	case <-server.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("mux not failed with full control queue\n")
+	}
+	if err := server.Err(); err == nil || !strings.Contains(err.Error(), "control frames") {
+		t.Fatalf("unexpected mux error %v\n", err)
+	}
+}
@@ -0,0 +1,182 @@
+package herots
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// ErrMissingOCSPStaple - returned by Start when loaded certificate has
+// OCSP must-staple extension and has no valid staple (see SetOCSPStaple
+// and Options.AllowMissingStaple).
+var ErrMissingOCSPStaple = errors.New("certificate requires OCSP staple")
+
+// oidTLSFeature - TLS feature extension (RFC 7633), which carries
+// must-staple requirement.
+var oidTLSFeature = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}
+
+// tlsFeatureStatusRequest - status_request TLS extension number.
+const tlsFeatureStatusRequest = 5
+
+// oidOCSPBasic - id-pkix-ocsp-basic response type.
+var oidOCSPBasic = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+
+// Minimal OCSP response structures (RFC 6960), enough to check status and
+// validity period of stapled response. Signature is verified by clients.
+type ocspResponse struct {
+	Status   asn1.Enumerated
+	Response ocspResponseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type ocspBasicResponse struct {
+	TBSResponseData    ocspResponseData
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseData struct {
+	Raw            asn1.RawContent
+	Version        int `asn1:"optional,default:0,explicit,tag:0"`
+	RawResponderID asn1.RawValue
+	ProducedAt     time.Time `asn1:"generalized"`
+	Responses      []ocspSingleResponse
+}
+
+type ocspSingleResponse struct {
+	CertID           ocspCertID
+	Good             asn1.Flag        `asn1:"tag:0,optional"`
+	Revoked          ocspRevokedInfo  `asn1:"tag:1,optional"`
+	Unknown          asn1.Flag        `asn1:"tag:2,optional"`
+	ThisUpdate       time.Time        `asn1:"generalized"`
+	NextUpdate       time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+	SingleExtensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type ocspCertID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	IssuerKeyHash []byte
+	SerialNumber  *big.Int
+}
+
+type ocspRevokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+// mustStaple - report whether certificate has OCSP must-staple extension.
+func mustStaple(cert *x509.Certificate) bool {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidTLSFeature) {
+			continue
+		}
+		var features []int
+		if _, err := asn1.Unmarshal(ext.Value, &features); err != nil {
+			// malformed extension, be safe
+			return true
+		}
+		for _, f := range features {
+			if f == tlsFeatureStatusRequest {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkOCSPResponse - check that DER encoded OCSP response reports good
+// status of certificate with given serial and is valid at now.
+func checkOCSPResponse(der []byte, serial *big.Int, now time.Time) error {
+	var resp ocspResponse
+	if rest, err := asn1.Unmarshal(der, &resp); err != nil || len(rest) > 0 {
+		return fmt.Errorf("malformed OCSP response")
+	}
+	if resp.Status != 0 {
+		return fmt.Errorf("OCSP response status %d", resp.Status)
+	}
+	if !resp.Response.ResponseType.Equal(oidOCSPBasic) {
+		return fmt.Errorf("unsupported OCSP response type %v", resp.Response.ResponseType)
+	}
+
+	var basic ocspBasicResponse
+	if _, err := asn1.Unmarshal(resp.Response.Response, &basic); err != nil {
+		return fmt.Errorf("malformed OCSP basic response")
+	}
+
+	for _, r := range basic.TBSResponseData.Responses {
+		if r.CertID.SerialNumber == nil || r.CertID.SerialNumber.Cmp(serial) != 0 {
+			continue
+		}
+		switch {
+		case !bool(r.Good):
+			return fmt.Errorf("OCSP status of certificate is not good")
+		case now.Before(r.ThisUpdate):
+			return fmt.Errorf("OCSP response is not yet valid")
+		case !r.NextUpdate.IsZero() && !now.Before(r.NextUpdate):
+			return fmt.Errorf("OCSP response expired at %v", r.NextUpdate)
+		}
+		return nil
+	}
+	return fmt.Errorf("OCSP response is not for certificate %v", serial)
+}
+
+// SetOCSPStaple - function for set DER encoded OCSP response, which is
+// stapled to handshakes.
+//
+// Response is matched by serial number to one of loaded key pairs (see
+// LoadKeyPair and AddKeyPair) and must report good status and be valid
+// now; response signature is not verified. SetOCSPStaple may be called on
+// running server, e.g. to refresh response before it expires.
+func (s *Server) SetOCSPStaple(der []byte) error {
+	s.certMu.Lock()
+	defer s.certMu.Unlock()
+
+	now := s.clock.Now()
+	var lastErr error = errors.New(NoKeyPairLoadError)
+	for _, c := range s.keyPairsLocked() {
+		leaf, err := x509.ParseCertificate(c.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("set OCSP staple error: %v\n", err)
+		}
+		if lastErr = checkOCSPResponse(der, leaf.SerialNumber, now); lastErr != nil {
+			continue
+		}
+
+		c.OCSPStaple = der
+		s.publishClientCAsLocked(s.clientCAs.Load())
+		s.logger.Log("set OCSP staple - ok", LogLevelInfo)
+		return nil
+	}
+
+	return fmt.Errorf("set OCSP staple error: %v\n", lastErr)
+}
+
+// checkStaplesLocked - check that every loaded certificate with
+// must-staple extension has valid OCSP staple.
+func (s *Server) checkStaplesLocked() error {
+	now := s.clock.Now()
+	for _, c := range s.certificates() {
+		leaf, err := x509.ParseCertificate(c.Certificate[0])
+		if err != nil || !mustStaple(leaf) {
+			continue
+		}
+		if len(c.OCSPStaple) == 0 {
+			return fmt.Errorf("%w: %q has no staple (use SetOCSPStaple)\n",
+				ErrMissingOCSPStaple, leaf.Subject)
+		}
+		if err := checkOCSPResponse(c.OCSPStaple, leaf.SerialNumber, now); err != nil {
+			return fmt.Errorf("%w: %q: %v\n", ErrMissingOCSPStaple, leaf.Subject, err)
+		}
+	}
+	return nil
+}
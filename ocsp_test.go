@@ -0,0 +1,117 @@
+package herots
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+)
+
+// testOCSPResponse - build unsigned OCSP response for cert.
+func testOCSPResponse(t *testing.T, cert []byte, good bool, next time.Time) []byte {
+	block, _ := pem.Decode(cert)
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("parse cert:\n%v\n", err)
+	}
+
+	keyHash, _ := asn1.Marshal([]byte{1, 2, 3})
+	single := ocspSingleResponse{
+		CertID: ocspCertID{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}},
+			NameHash:      []byte{0},
+			IssuerKeyHash: []byte{0},
+			SerialNumber:  leaf.SerialNumber,
+		},
+		Good:       asn1.Flag(good),
+		ThisUpdate: time.Now().Add(-time.Minute).UTC(),
+		NextUpdate: next.UTC(),
+	}
+	if !good {
+		single.Revoked.RevocationTime = time.Now().Add(-time.Hour).UTC()
+	}
+	basic, err := asn1.Marshal(ocspBasicResponse{
+		TBSResponseData: ocspResponseData{
+			RawResponderID: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, IsCompound: true, Bytes: keyHash},
+			ProducedAt:     time.Now().UTC(),
+			Responses:      []ocspSingleResponse{single},
+		},
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+		Signature:          asn1.BitString{Bytes: []byte{0}, BitLength: 8},
+	})
+	if err != nil {
+		t.Fatalf("marshal basic response:\n%v\n", err)
+	}
+	der, err := asn1.Marshal(ocspResponse{
+		Response: ocspResponseBytes{ResponseType: oidOCSPBasic, Response: basic},
+	})
+	if err != nil {
+		t.Fatalf("marshal response:\n%v\n", err)
+	}
+	return der
+}
+
+func TestMustStaple(t *testing.T) {
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	feature, _ := asn1.Marshal([]int{tlsFeatureStatusRequest})
+	cert, key := signKeyPair(t, "herots test", priv, pkix.Extension{Id: oidTLSFeature, Value: feature})
+
+	newServer := func(o *Options) *Server {
+		o.Host = "127.0.0.1"
+		o.Port = freePort(t)
+		s := NewServer(o)
+		if err := s.LoadKeyPair(cert, key); err != nil {
+			t.Fatalf("load key pair:\n%v\n", err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	}
+
+	s := newServer(&Options{})
+	if err := s.Start(); !errors.Is(err, ErrMissingOCSPStaple) {
+		t.Fatalf("expected ErrMissingOCSPStaple, got %v\n", err)
+	}
+	if err := s.SetOCSPStaple(testOCSPResponse(t, cert, false, time.Now().Add(time.Hour))); err == nil {
+		t.Fatalf("revoked staple accepted\n")
+	}
+	if err := s.SetOCSPStaple(testOCSPResponse(t, cert, true, time.Now().Add(-time.Second))); err == nil {
+		t.Fatalf("expired staple accepted\n")
+	}
+
+	staple := testOCSPResponse(t, cert, true, time.Now().Add(time.Hour))
+	if err := s.SetOCSPStaple(staple); err != nil {
+		t.Fatalf("set staple:\n%v\n", err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("start with staple:\n%v\n", err)
+	}
+
+	go func() {
+		if conn, err := s.AcceptConn(); err == nil {
+			conn.Handshake()
+			conn.Close()
+		}
+	}()
+	c := NewClient(&Options{Host: s.options.Host, Port: s.options.Port})
+	c.LoadKeyPair(cert, key)
+	conn, err := tls.Dial("tcp", s.listener.Addr().String(), c.tlsConfig())
+	if err != nil {
+		t.Fatalf("dial:\n%v\n", err)
+	}
+	defer conn.Close()
+	if string(conn.ConnectionState().OCSPResponse) != string(staple) {
+		t.Fatalf("staple not sent to client\n")
+	}
+
+	s = newServer(&Options{AllowMissingStaple: true})
+	if err := s.Start(); err != nil {
+		t.Fatalf("start with AllowMissingStaple:\n%v\n", err)
+	}
+}
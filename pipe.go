@@ -0,0 +1,12 @@
+package herots
+
+// pipeAddr - address of named pipe connection and listener.
+type pipeAddr string
+
+func (a pipeAddr) Network() string {
+	return "pipe"
+}
+
+func (a pipeAddr) String() string {
+	return string(a)
+}
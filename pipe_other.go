@@ -0,0 +1,21 @@
+//go:build !windows
+
+package herots
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+var errPipeNotSupported = errors.New("named pipes not supported on this platform")
+
+// listenPipe - not supported on this platform.
+func listenPipe(path string) (net.Listener, error) {
+	return nil, errPipeNotSupported
+}
+
+// dialPipe - not supported on this platform.
+func dialPipe(path string, timeout time.Duration) (net.Conn, error) {
+	return nil, errPipeNotSupported
+}
@@ -0,0 +1,61 @@
+package herots
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestPipe(t *testing.T) {
+	pipe := fmt.Sprintf(`\\.\pipe\herots-test-%d`, os.Getpid())
+	cert, key := genKeyPair(t, "herots test")
+
+	s := NewServer(&Options{Host: "127.0.0.1", Pipe: pipe})
+	if err := s.LoadKeyPair(cert, key); err != nil {
+		t.Fatalf("server load key pair:\n%v\n", err)
+	}
+	err := s.Start()
+	if runtime.GOOS != "windows" {
+		if err == nil {
+			s.Close()
+			t.Fatalf("expected pipe error on %s\n", runtime.GOOS)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("server start:\n%v\n", err)
+	}
+	defer s.Close()
+	if got := s.Addr().String(); got != pipe {
+		t.Fatalf("unexpected address %q\n", got)
+	}
+
+	go func() {
+		conn, err := s.AcceptConn()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+
+	c := NewClient(&Options{Host: "127.0.0.1", Pipe: pipe})
+	if err := c.LoadKeyPair(cert, key); err != nil {
+		t.Fatalf("client load key pair:\n%v\n", err)
+	}
+	conn, err := c.Dial()
+	if err != nil {
+		t.Fatalf("dial:\n%v\n", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("write:\n%v\n", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("echo: %q, %v\n", buf, err)
+	}
+}
@@ -0,0 +1,243 @@
+//go:build windows
+
+package herots
+
+import (
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var (
+	kernel32                = syscall.NewLazyDLL("kernel32.dll")
+	procCreateNamedPipeW    = kernel32.NewProc("CreateNamedPipeW")
+	procConnectNamedPipe    = kernel32.NewProc("ConnectNamedPipe")
+	procCreateEventW        = kernel32.NewProc("CreateEventW")
+	procDisconnectNamedPipe = kernel32.NewProc("DisconnectNamedPipe")
+	procGetOverlappedResult = kernel32.NewProc("GetOverlappedResult")
+	procWaitNamedPipeW      = kernel32.NewProc("WaitNamedPipeW")
+)
+
+const (
+	pipeAccessDuplex         = 0x3
+	fileFlagFirstPipeInst    = 0x80000
+	pipeRejectRemoteClients  = 0x8
+	pipeUnlimitedInstances   = 255
+	pipeBufferSize           = 64 << 10
+	errorPipeBusy            = syscall.Errno(231)
+	errorPipeConnected       = syscall.Errno(535)
+	errorNoData              = syscall.Errno(232)
+	pipeBusyWaitMilliseconds = 250
+)
+
+// pipeListener - listener of named pipe. One pipe instance always waits
+// for next client, so clients may connect before Accept is called.
+//
+// Pipe handles are opened for overlapped I/O, so os.File of accepted
+// connection is integrated with runtime poller and supports deadlines
+// (Go 1.25 and later).
+type pipeListener struct {
+	path string
+
+	// acceptMu serializes Accept, which waits on single next instance
+	acceptMu sync.Mutex
+
+	// mu guards handles: next - instance for next client, owned by
+	// listener; accepting - instance Accept waits on, owned by Accept
+	// (InvalidHandle if none), so Close only cancels the wait
+	mu        sync.Mutex
+	next      syscall.Handle
+	accepting syscall.Handle
+	closed    bool
+}
+
+// listenPipe - create first instance of named pipe at path. Creation
+// fails if pipe with this name already exists, so other process can't
+// hijack it. Remote (SMB) clients are rejected.
+func listenPipe(path string) (net.Listener, error) {
+	h, err := createPipeInstance(path, true)
+	if err != nil {
+		return nil, &net.OpError{Op: "listen", Net: "pipe", Addr: pipeAddr(path), Err: err}
+	}
+	return &pipeListener{path: path, next: h, accepting: syscall.InvalidHandle}, nil
+}
+
+func createPipeInstance(path string, first bool) (syscall.Handle, error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return syscall.InvalidHandle, err
+	}
+	mode := uint32(pipeAccessDuplex | syscall.FILE_FLAG_OVERLAPPED)
+	if first {
+		mode |= fileFlagFirstPipeInst
+	}
+	// byte mode, default security descriptor
+	r, _, e := procCreateNamedPipeW.Call(uintptr(unsafe.Pointer(name)), uintptr(mode),
+		pipeRejectRemoteClients, pipeUnlimitedInstances, pipeBufferSize, pipeBufferSize, 0, 0)
+	if syscall.Handle(r) == syscall.InvalidHandle {
+		return syscall.InvalidHandle, e
+	}
+	return syscall.Handle(r), nil
+}
+
+// Accept - wait for client of current pipe instance and create new
+// instance for next one.
+func (l *pipeListener) Accept() (net.Conn, error) {
+	l.acceptMu.Lock()
+	defer l.acceptMu.Unlock()
+
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil, net.ErrClosed
+	}
+	h := l.next
+	l.next, l.accepting = syscall.InvalidHandle, h
+	l.mu.Unlock()
+
+	err := connectPipe(h)
+	for err == errorNoData {
+		// client closed its end before it was accepted
+		procDisconnectNamedPipe.Call(uintptr(h))
+		err = connectPipe(h)
+	}
+	next := syscall.InvalidHandle
+	if err == nil {
+		next, err = createPipeInstance(l.path, false)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.accepting = syscall.InvalidHandle
+	if l.closed {
+		if next != syscall.InvalidHandle {
+			syscall.CloseHandle(next)
+		}
+		syscall.CloseHandle(h)
+		return nil, net.ErrClosed
+	}
+	if err != nil {
+		// instance is kept for next Accept
+		l.next = h
+		return nil, &net.OpError{Op: "accept", Net: "pipe", Addr: pipeAddr(l.path), Err: err}
+	}
+	l.next = next
+
+	return newPipeConn(h, l.path), nil
+}
+
+// connectPipe - wait for client to connect to pipe instance.
+func connectPipe(h syscall.Handle) error {
+	ev, _, e := procCreateEventW.Call(0, 1, 0, 0)
+	if ev == 0 {
+		return e
+	}
+	defer syscall.CloseHandle(syscall.Handle(ev))
+
+	ov := &syscall.Overlapped{HEvent: syscall.Handle(ev)}
+	r, _, e := procConnectNamedPipe.Call(uintptr(h), uintptr(unsafe.Pointer(ov)))
+	switch {
+	case r != 0, e == errorPipeConnected:
+		return nil
+	case e != syscall.ERROR_IO_PENDING:
+		return e
+	}
+
+	var n uint32
+	// blocks until client connects or Close cancels it
+	r, _, e = procGetOverlappedResult.Call(uintptr(h), uintptr(unsafe.Pointer(ov)),
+		uintptr(unsafe.Pointer(&n)), 1)
+	if r == 0 {
+		return e
+	}
+	return nil
+}
+
+// Close - stop accepting: pending Accept is cancelled. Accepted
+// connections are not affected.
+func (l *pipeListener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return net.ErrClosed
+	}
+	l.closed = true
+	if l.accepting != syscall.InvalidHandle {
+		// instance belongs to Accept, which closes it once wait ends
+		syscall.CancelIoEx(l.accepting, nil)
+		return nil
+	}
+	return syscall.CloseHandle(l.next)
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr(l.path)
+}
+
+// dialPipe - connect to named pipe at path, waiting up to timeout for
+// free instance while pipe is busy.
+func dialPipe(path string, timeout time.Duration) (net.Conn, error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		h, err := syscall.CreateFile(name, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil,
+			syscall.OPEN_EXISTING, syscall.FILE_FLAG_OVERLAPPED, 0)
+		if err == nil {
+			return newPipeConn(h, path), nil
+		}
+		if err != errorPipeBusy || time.Now().After(deadline) {
+			return nil, &net.OpError{Op: "dial", Net: "pipe", Addr: pipeAddr(path), Err: err}
+		}
+		procWaitNamedPipeW.Call(uintptr(unsafe.Pointer(name)), pipeBusyWaitMilliseconds)
+	}
+}
+
+// pipeConn - connection over named pipe instance.
+type pipeConn struct {
+	f    *os.File
+	addr pipeAddr
+}
+
+func newPipeConn(h syscall.Handle, path string) *pipeConn {
+	return &pipeConn{f: os.NewFile(uintptr(h), path), addr: pipeAddr(path)}
+}
+
+func (c *pipeConn) Read(b []byte) (int, error) {
+	return c.f.Read(b)
+}
+
+func (c *pipeConn) Write(b []byte) (int, error) {
+	return c.f.Write(b)
+}
+
+func (c *pipeConn) Close() error {
+	return c.f.Close()
+}
+
+func (c *pipeConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+func (c *pipeConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func (c *pipeConn) SetDeadline(t time.Time) error {
+	return c.f.SetDeadline(t)
+}
+
+func (c *pipeConn) SetReadDeadline(t time.Time) error {
+	return c.f.SetReadDeadline(t)
+}
+
+func (c *pipeConn) SetWriteDeadline(t time.Time) error {
+	return c.f.SetWriteDeadline(t)
+}
@@ -0,0 +1,325 @@
+package herots
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrPreflight - wrapped by error of Preflight, which found failed checks.
+var ErrPreflight = errors.New("preflight check failed")
+
+// PreflightExpiryWarning - certificates expiring within this period are
+// reported by Preflight with warning.
+const PreflightExpiryWarning = 30 * 24 * time.Hour
+
+// CheckStatus - result of single preflight check.
+type CheckStatus string
+
+// predefined CheckStatus values
+const (
+	CheckOK      CheckStatus = "ok"
+	CheckWarning CheckStatus = "warning"
+	CheckFailed  CheckStatus = "failed"
+	// check does not apply to configuration, or depends on failed one
+	CheckSkipped CheckStatus = "skipped"
+)
+
+// predefined check names of Report
+const (
+	CheckKeyPair  = "key pair"
+	CheckChain    = "chain"
+	CheckValidity = "validity"
+	CheckStaple   = "ocsp staple"
+	CheckConfig   = "tls config"
+	CheckListen   = "listen"
+)
+
+// Check - result of single preflight check. Detail explains result
+// (what was checked, or what is wrong); Err is set for failed checks.
+type Check struct {
+	Name   string
+	Status CheckStatus
+	Detail string
+	Err    error
+}
+
+func (c Check) String() string {
+	return fmt.Sprintf("%-8s %s: %s", c.Status, c.Name, c.Detail)
+}
+
+// Report - structured result of Server.Preflight.
+type Report struct {
+	// Time - moment of check, by server clock (see Options.Clock).
+	Time   time.Time
+	Checks []Check
+}
+
+// OK - report whether no check failed (warnings are allowed).
+func (r *Report) OK() bool {
+	return len(r.Failed()) == 0
+}
+
+// Failed - return failed checks.
+func (r *Report) Failed() []Check {
+	var failed []Check
+	for _, c := range r.Checks {
+		if c.Status == CheckFailed {
+			failed = append(failed, c)
+		}
+	}
+	return failed
+}
+
+// Warnings - return checks with warning.
+func (r *Report) Warnings() []Check {
+	var warnings []Check
+	for _, c := range r.Checks {
+		if c.Status == CheckWarning {
+			warnings = append(warnings, c)
+		}
+	}
+	return warnings
+}
+
+// String - one line per check, e.g. for startup log.
+func (r *Report) String() string {
+	lines := make([]string, len(r.Checks))
+	for i, c := range r.Checks {
+		lines[i] = c.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (r *Report) add(name string, status CheckStatus, detail string, err error) {
+	if err != nil && detail == "" {
+		detail = err.Error()
+	}
+	r.Checks = append(r.Checks, Check{Name: name, Status: status, Detail: detail, Err: err})
+}
+
+// Preflight - check configuration of server without starting it: parse
+// loaded key pairs, check that private keys match certificates, that
+// chains are signed in order and within validity period, OCSP staples of
+// must-staple certificates, build TLS config as Start does and check
+// that address can be listened on. Loaded key pairs are required, so
+// Preflight is called after LoadKeyPair and before Start.
+//
+// Report lists result of every check. If any of them failed, error
+// wrapping ErrPreflight with details of failures is returned too, so
+// misconfigured server can fail fast:
+//
+//	report, err := server.Preflight()
+//	if err != nil {
+//		log.Fatalf("%v\n%s", err, report)
+//	}
+//
+// Preflight doesn't change server state; listener is closed right after
+// it is opened, and listener inherited on Restart is left to Start.
+func (s *Server) Preflight() (*Report, error) {
+	r := &Report{Time: s.clock.Now()}
+
+	s.certMu.Lock()
+	var pairs []tls.Certificate
+	if len(s.certs.Cert.Certificate) > 0 {
+		pairs = append([]tls.Certificate{s.certs.Cert}, s.certs.Extra...)
+	}
+	s.certMu.Unlock()
+
+	chains := preflightKeyPairs(r, pairs)
+	preflightChains(r, chains)
+	s.preflightValidity(r, chains)
+	s.preflightConfig(r)
+	s.preflightListen(r)
+
+	failed := r.Failed()
+	if len(failed) == 0 {
+		s.logger.Log("preflight - ok", LogLevelInfo)
+		return r, nil
+	}
+	parts := make([]string, len(failed))
+	for i, c := range failed {
+		parts[i] = c.Name + ": " + c.Detail
+	}
+	return r, fmt.Errorf("%w: %s\n", ErrPreflight, strings.Join(parts, "; "))
+}
+
+// preflightKeyPairs - parse chains of key pairs and match private keys
+// against leaf certificates; return parsed chains.
+func preflightKeyPairs(r *Report, pairs []tls.Certificate) [][]*x509.Certificate {
+	if len(pairs) == 0 {
+		r.add(CheckKeyPair, CheckFailed, "", errors.New(NoKeyPairLoadError))
+		return nil
+	}
+
+	var (
+		chains [][]*x509.Certificate
+		names  []string
+	)
+	for _, pair := range pairs {
+		chain := make([]*x509.Certificate, len(pair.Certificate))
+		for i, der := range pair.Certificate {
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				r.add(CheckKeyPair, CheckFailed, "", fmt.Errorf("certificate %d of chain: %v", i, err))
+				return nil
+			}
+			chain[i] = cert
+		}
+		leaf := chain[0]
+
+		signer, ok := pair.PrivateKey.(crypto.Signer)
+		if !ok {
+			r.add(CheckKeyPair, CheckFailed, "", fmt.Errorf("private key of %q can't sign", leaf.Subject))
+			return nil
+		}
+		pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+		if !ok || !pub.Equal(leaf.PublicKey) {
+			r.add(CheckKeyPair, CheckFailed, "", fmt.Errorf("private key doesn't match certificate %q", leaf.Subject))
+			return nil
+		}
+
+		chains = append(chains, chain)
+		names = append(names, fmt.Sprintf("%q (%s)", leaf.Subject, leaf.PublicKeyAlgorithm))
+	}
+	r.add(CheckKeyPair, CheckOK, strings.Join(names, ", "), nil)
+	return chains
+}
+
+// preflightChains - check that every certificate of chain is signed by
+// the next one.
+func preflightChains(r *Report, chains [][]*x509.Certificate) {
+	if len(chains) == 0 {
+		r.add(CheckChain, CheckSkipped, "no parsed key pair", nil)
+		return
+	}
+
+	for _, chain := range chains {
+		for i := 0; i+1 < len(chain); i++ {
+			if err := chain[i].CheckSignatureFrom(chain[i+1]); err != nil {
+				r.add(CheckChain, CheckFailed, "", fmt.Errorf("%q is not signed by next certificate %q: %v",
+					chain[i].Subject, chain[i+1].Subject, err))
+				return
+			}
+		}
+	}
+	r.add(CheckChain, CheckOK, "certificates signed in order", nil)
+}
+
+// preflightValidity - check validity periods of all certificates of
+// chains against server clock.
+func (s *Server) preflightValidity(r *Report, chains [][]*x509.Certificate) {
+	if len(chains) == 0 {
+		r.add(CheckValidity, CheckSkipped, "no parsed key pair", nil)
+		return
+	}
+
+	now := s.clock.Now()
+	var soonest *x509.Certificate
+	for _, chain := range chains {
+		for _, cert := range chain {
+			switch {
+			case now.Before(cert.NotBefore):
+				r.add(CheckValidity, CheckFailed, "", fmt.Errorf("%q is not valid before %s",
+					cert.Subject, cert.NotBefore.Format(time.RFC3339)))
+				return
+			case now.After(cert.NotAfter):
+				r.add(CheckValidity, CheckFailed, "", fmt.Errorf("%q expired at %s",
+					cert.Subject, cert.NotAfter.Format(time.RFC3339)))
+				return
+			}
+			if soonest == nil || cert.NotAfter.Before(soonest.NotAfter) {
+				soonest = cert
+			}
+		}
+	}
+
+	left := soonest.NotAfter.Sub(now)
+	detail := fmt.Sprintf("%q expires first, at %s (in %v)",
+		soonest.Subject, soonest.NotAfter.Format(time.RFC3339), left.Round(time.Second))
+	if left < PreflightExpiryWarning {
+		r.add(CheckValidity, CheckWarning, detail, nil)
+		return
+	}
+	r.add(CheckValidity, CheckOK, detail, nil)
+}
+
+// preflightConfig - check OCSP staples and build TLS config as Start
+// does.
+func (s *Server) preflightConfig(r *Report) {
+	var stapleErr error
+	s.certMu.Lock()
+	loaded := len(s.certs.Cert.Certificate) > 0
+	if loaded {
+		stapleErr = s.checkStaplesLocked()
+	}
+	s.certMu.Unlock()
+
+	switch {
+	case !loaded:
+		r.add(CheckStaple, CheckSkipped, "no key pair", nil)
+	case stapleErr != nil && s.options.AllowMissingStaple:
+		r.add(CheckStaple, CheckWarning, strings.TrimSpace(stapleErr.Error())+" (allowed by AllowMissingStaple)", nil)
+	case stapleErr != nil:
+		r.add(CheckStaple, CheckFailed, strings.TrimSpace(stapleErr.Error()), stapleErr)
+	default:
+		r.add(CheckStaple, CheckOK, "staples present where required", nil)
+	}
+
+	if !loaded || (stapleErr != nil && !s.options.AllowMissingStaple) {
+		r.add(CheckConfig, CheckSkipped, "depends on failed checks", nil)
+		return
+	}
+	config, err := s.buildTLSConfig()
+	if err != nil {
+		r.add(CheckConfig, CheckFailed, strings.TrimSpace(err.Error()), err)
+		return
+	}
+	r.add(CheckConfig, CheckOK, fmt.Sprintf("%d certificate(s), client auth %s",
+		len(config.Certificates), config.ClientAuth), nil)
+}
+
+// preflightListen - check that configured address can be listened on.
+func (s *Server) preflightListen(r *Report) {
+	switch {
+	case s.currentListener() != nil:
+		r.add(CheckListen, CheckSkipped, "server already started", nil)
+		return
+	case s.options.Inetd:
+		r.add(CheckListen, CheckSkipped, "inetd connection on descriptor "+strconv.Itoa(s.options.InetdFD), nil)
+		return
+	case s.options.Pipe != "":
+		ln, err := listenPipe(s.options.Pipe)
+		if err != nil {
+			r.add(CheckListen, CheckFailed, "", fmt.Errorf("pipe %s: %v", s.options.Pipe, err))
+			return
+		}
+		ln.Close()
+		r.add(CheckListen, CheckOK, "pipe "+s.options.Pipe, nil)
+		return
+	}
+
+	service := s.options.Host + ":" + strconv.Itoa(s.options.Port)
+	inherited, err := hasInheritedListener(service)
+	if err != nil {
+		r.add(CheckListen, CheckFailed, "", err)
+		return
+	}
+	if inherited {
+		r.add(CheckListen, CheckOK, "inherited listener for "+service, nil)
+		return
+	}
+	ln, err := net.Listen("tcp", service)
+	if err != nil {
+		r.add(CheckListen, CheckFailed, "", err)
+		return
+	}
+	ln.Close()
+	r.add(CheckListen, CheckOK, service, nil)
+}
@@ -0,0 +1,117 @@
+package herots
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/iu0v1/herots/herotstest"
+)
+
+// checkStatus - status of named check in report.
+func checkStatus(t *testing.T, r *Report, name string) CheckStatus {
+	for _, c := range r.Checks {
+		if c.Name == name {
+			return c.Status
+		}
+	}
+	t.Fatalf("no %q check in report:\n%s\n", name, r)
+	return ""
+}
+
+func TestPreflight(t *testing.T) {
+	clock := herotstest.NewFakeClock(time.Now())
+	s := NewServer(&Options{Host: "127.0.0.1", Port: freePort(t), Clock: clock})
+
+	r, err := s.Preflight()
+	if !errors.Is(err, ErrPreflight) || r.OK() {
+		t.Fatalf("expected preflight failure without key pair, got %v\n", err)
+	}
+	if st := checkStatus(t, r, CheckKeyPair); st != CheckFailed {
+		t.Fatalf("key pair check %s, want failed\n", st)
+	}
+	if st := checkStatus(t, r, CheckConfig); st != CheckSkipped {
+		t.Fatalf("tls config check %s, want skipped\n", st)
+	}
+
+	cert, key := genKeyPair(t, "herots test")
+	if err := s.LoadKeyPair(cert, key); err != nil {
+		t.Fatalf("load key pair:\n%v\n", err)
+	}
+	r, err = s.Preflight()
+	if err != nil {
+		t.Fatalf("preflight of valid config:\n%v\n%s\n", err, r)
+	}
+	// test certificate is valid for an hour
+	if w := r.Warnings(); len(w) != 1 || w[0].Name != CheckValidity {
+		t.Fatalf("expected expiry warning, got:\n%s\n", r)
+	}
+	for _, name := range []string{CheckKeyPair, CheckChain, CheckStaple, CheckConfig, CheckListen} {
+		if st := checkStatus(t, r, name); st != CheckOK {
+			t.Fatalf("%s check %s, want ok:\n%s\n", name, st, r)
+		}
+	}
+
+	// address in use
+	ln, err := net.Listen("tcp", s.options.Host+":"+strconv.Itoa(s.options.Port))
+	if err != nil {
+		t.Fatalf("listen:\n%v\n", err)
+	}
+	r, err = s.Preflight()
+	ln.Close()
+	if err == nil || checkStatus(t, r, CheckListen) != CheckFailed {
+		t.Fatalf("expected listen failure, got:\n%s\n", r)
+	}
+
+	if err := s.Start(); err != nil {
+		t.Fatalf("start after preflight:\n%v\n", err)
+	}
+	defer s.Close()
+	r, err = s.Preflight()
+	if err != nil || checkStatus(t, r, CheckListen) != CheckSkipped {
+		t.Fatalf("expected skipped listen check on started server, got %v:\n%s\n", err, r)
+	}
+
+	// expired certificate
+	clock.Advance(2 * time.Hour)
+	r, err = s.Preflight()
+	if err == nil || checkStatus(t, r, CheckValidity) != CheckFailed {
+		t.Fatalf("expected validity failure, got:\n%s\n", r)
+	}
+}
+
+func TestPreflightChain(t *testing.T) {
+	ca, err := NewCA("preflight ca", time.Hour)
+	if err != nil {
+		t.Fatalf("new CA:\n%v\n", err)
+	}
+	cert, key, err := ca.Issue(&IssueRequest{CommonName: "localhost", DNSNames: []string{"localhost"}})
+	if err != nil {
+		t.Fatalf("issue:\n%v\n", err)
+	}
+
+	s := NewServer(&Options{Host: "127.0.0.1", Port: freePort(t)})
+	bundle := append(append([]byte{}, cert...), ca.Certificate()...)
+	if err := s.LoadKeyPair(bundle, key); err != nil {
+		t.Fatalf("load key pair:\n%v\n", err)
+	}
+	if r, err := s.Preflight(); err != nil || checkStatus(t, r, CheckChain) != CheckOK {
+		t.Fatalf("valid chain refused, %v:\n%s\n", err, r)
+	}
+
+	// intermediate of another CA
+	other, err := NewCA("other ca", time.Hour)
+	if err != nil {
+		t.Fatalf("new CA:\n%v\n", err)
+	}
+	bundle = append(append([]byte{}, cert...), other.Certificate()...)
+	if err := s.LoadKeyPair(bundle, key); err != nil {
+		t.Fatalf("load key pair:\n%v\n", err)
+	}
+	r, err := s.Preflight()
+	if !errors.Is(err, ErrPreflight) || checkStatus(t, r, CheckChain) != CheckFailed {
+		t.Fatalf("expected chain failure, got %v:\n%s\n", err, r)
+	}
+}
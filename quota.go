@@ -0,0 +1,302 @@
+package herots
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrQuotaExceeded - wrapped by *QuotaError.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// DefaultQuotaWindow - default period of byte and message quotas.
+const DefaultQuotaWindow = time.Minute
+
+// QuotaResource - resource limited by quota.
+type QuotaResource string
+
+// predefined QuotaResource values
+const (
+	QuotaConns    QuotaResource = "connections"
+	QuotaBytes    QuotaResource = "bytes"
+	QuotaMessages QuotaResource = "messages"
+)
+
+// QuotaError - rejection of connection or I/O, which would exceed quota
+// of identity. It is returned as Err of *HandshakeError (connections) or
+// by Read, Write and Codec methods (bytes and messages).
+type QuotaError struct {
+	Key      string
+	Resource QuotaResource
+	Limit    int64
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%v of %q: %s limit %d", ErrQuotaExceeded, e.Key, e.Resource, e.Limit)
+}
+
+func (e *QuotaError) Unwrap() error {
+	return ErrQuotaExceeded
+}
+
+// QuotaLimits - limits of one identity; zero value of field means no
+// limit.
+type QuotaLimits struct {
+	// MaxConns - concurrent connections.
+	MaxConns int
+	// MaxBytes - application data read and written per window.
+	MaxBytes int64
+	// MaxMessages - messages read and written with Codec (and so RPC and
+	// Herald) per window.
+	MaxMessages int64
+}
+
+// QuotaOptions - structure, which is used to configure per-identity
+// quotas (Options.Quota).
+//
+// Usage is tracked by key of connection identity. Connection over
+// MaxConns fails handshake with HandshakeFailureQuota; once MaxBytes or
+// MaxMessages of window is used up, further reads and writes of all
+// connections of identity fail with *QuotaError and connection is closed
+// (CloseReasonPolicy). Connections without key are not limited.
+type QuotaOptions struct {
+	// Default limits of identity.
+	QuotaLimits
+
+	// Window - period, after which byte and message usage is reset.
+	//
+	// Default: DefaultQuotaWindow (1m).
+	Window time.Duration
+
+	// Key - function, which returns key of identity, which usage is
+	// accounted to. id is nil, if client sent no certificate.
+	//
+	// Default: principal (see Options.Authenticator), or certificate
+	// common name.
+	Key func(id *Identity, principal string) string
+
+	// Limits - function, which returns limits of identity with given key,
+	// e.g. tenant plan.
+	//
+	// Default: nil (QuotaLimits for all).
+	Limits func(key string) QuotaLimits
+}
+
+// QuotaUsage - usage of identity.
+type QuotaUsage struct {
+	Key    string
+	Limits QuotaLimits
+
+	Conns int
+	// Bytes and Messages - usage in current window
+	Bytes    int64
+	Messages int64
+	// Rejected - rejected connections and I/O operations, total
+	Rejected int64
+}
+
+// quotaEntry - usage of one key.
+type quotaEntry struct {
+	key    string
+	limits QuotaLimits
+
+	conns    int
+	window   time.Time
+	bytes    int64
+	messages int64
+	rejected int64
+}
+
+// quotaTracker - usage of all identities.
+type quotaTracker struct {
+	o   QuotaOptions
+	now func() time.Time
+
+	mu        sync.Mutex
+	entries   map[string]*quotaEntry
+	lastSweep time.Time
+}
+
+func newQuotaTracker(o *QuotaOptions, now func() time.Time) *quotaTracker {
+	t := &quotaTracker{o: *o, now: now, entries: make(map[string]*quotaEntry)}
+	if t.o.Window <= 0 {
+		t.o.Window = DefaultQuotaWindow
+	}
+	if t.o.Key == nil {
+		t.o.Key = func(id *Identity, principal string) string {
+			if principal != "" || id == nil {
+				return principal
+			}
+			return id.CommonName()
+		}
+	}
+	return t
+}
+
+// admit - account new connection of identity, or reject it.
+func (t *quotaTracker) admit(id *Identity, principal string) (*quotaEntry, error) {
+	key := t.o.Key(id, principal)
+	if key == "" {
+		return nil, nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	t.sweepLocked(now)
+	e := t.entries[key]
+	if e == nil {
+		limits := t.o.QuotaLimits
+		if t.o.Limits != nil {
+			limits = t.o.Limits(key)
+		}
+		e = &quotaEntry{key: key, limits: limits, window: now}
+		t.entries[key] = e
+	}
+	if e.limits.MaxConns > 0 && e.conns >= e.limits.MaxConns {
+		e.rejected++
+		return nil, &QuotaError{Key: key, Resource: QuotaConns, Limit: int64(e.limits.MaxConns)}
+	}
+	e.conns++
+	return e, nil
+}
+
+// release - account closed connection. Entry is kept after last
+// connection until sweep, so reconnect doesn't reset usage of window.
+func (t *quotaTracker) release(e *quotaEntry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e.conns--
+}
+
+// sweepLocked - drop entries of identities without connections, which
+// window has ended, so map doesn't grow with every identity ever seen.
+// Runs at most once per window.
+func (t *quotaTracker) sweepLocked(now time.Time) {
+	if now.Sub(t.lastSweep) < t.o.Window {
+		return
+	}
+	t.lastSweep = now
+	for key, e := range t.entries {
+		if e.conns == 0 && now.Sub(e.window) >= t.o.Window {
+			delete(t.entries, key)
+		}
+	}
+}
+
+// charge - account bytes of I/O or messages of Codec, which is about to
+// happen. Error is returned once limit of charged resource is exceeded.
+func (t *quotaTracker) charge(e *quotaEntry, bytes, messages int64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if now := t.now(); now.Sub(e.window) >= t.o.Window {
+		e.window, e.bytes, e.messages = now, 0, 0
+	}
+
+	var qerr *QuotaError
+	switch {
+	case messages == 0 && e.limits.MaxBytes > 0 && e.bytes >= e.limits.MaxBytes:
+		qerr = &QuotaError{Key: e.key, Resource: QuotaBytes, Limit: e.limits.MaxBytes}
+	case messages > 0 && e.limits.MaxMessages > 0 && e.messages >= e.limits.MaxMessages:
+		qerr = &QuotaError{Key: e.key, Resource: QuotaMessages, Limit: e.limits.MaxMessages}
+	default:
+		e.bytes += bytes
+		e.messages += messages
+		return nil
+	}
+	e.rejected++
+	return qerr
+}
+
+// add - account bytes, which have been read already; limit is enforced by
+// next charge.
+func (t *quotaTracker) add(e *quotaEntry, bytes int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if now := t.now(); now.Sub(e.window) >= t.o.Window {
+		e.window, e.bytes, e.messages = now, 0, 0
+	}
+	e.bytes += bytes
+}
+
+func (t *quotaTracker) usage() []QuotaUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	list := make([]QuotaUsage, 0, len(t.entries))
+	for _, e := range t.entries {
+		u := QuotaUsage{Key: e.key, Limits: e.limits, Conns: e.conns, Rejected: e.rejected}
+		if now.Sub(e.window) < t.o.Window {
+			u.Bytes, u.Messages = e.bytes, e.messages
+		}
+		list = append(list, u)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	return list
+}
+
+// QuotaUsage - return usage of identities with open connections or
+// recent usage, sorted by key (nil without Options.Quota).
+func (s *Server) QuotaUsage() []QuotaUsage {
+	if s.quota == nil {
+		return nil
+	}
+	return s.quota.usage()
+}
+
+// admitQuota - account connection with completed handshake. Caller must
+// hold handshakeMu.
+func (c *Conn) admitQuota() error {
+	id, _ := c.identityLocked()
+	e, err := c.server.quota.admit(id, c.principal)
+	if err != nil {
+		c.server.stats.quotaRejected.Add(1)
+		return err
+	}
+	c.quota = e
+	return nil
+}
+
+// chargeQuota - account I/O of connection; on exceeded quota connection
+// is closed.
+func (c *Conn) chargeQuota(bytes, messages int64) error {
+	if c.quota == nil {
+		return nil
+	}
+	err := c.server.quota.charge(c.quota, bytes, messages)
+	if err != nil {
+		c.server.stats.quotaRejected.Add(1)
+		go c.closeWith(CloseReasonPolicy, err)
+	}
+	return err
+}
+
+// addQuota - account bytes read from connection.
+func (c *Conn) addQuota(bytes int64) {
+	if c.quota != nil && bytes > 0 {
+		c.server.quota.add(c.quota, bytes)
+	}
+}
+
+// releaseQuota - account closed connection.
+func (c *Conn) releaseQuota() {
+	c.handshakeMu.Lock()
+	defer c.handshakeMu.Unlock()
+	if c.quota != nil {
+		c.server.quota.release(c.quota)
+	}
+}
+
+// chargeMessage - account message of Codec, if it runs over Conn.
+func chargeMessage(rw any) error {
+	if c, ok := rw.(*Conn); ok {
+		return c.chargeQuota(0, 1)
+	}
+	return nil
+}
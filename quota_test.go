@@ -0,0 +1,178 @@
+package herots
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/iu0v1/herots/herotstest"
+)
+
+// dialQuota - dial server, keeping client connection open until end of
+// test, and return result of AcceptConn.
+func dialQuota(t *testing.T, s *Server, c *Client) (*Conn, error) {
+	type accepted struct {
+		conn *Conn
+		err  error
+	}
+	done := make(chan accepted, 1)
+	go func() {
+		conn, err := s.AcceptConn()
+		done <- accepted{conn, err}
+	}()
+
+	conn, err := c.Dial()
+	if err != nil {
+		t.Fatalf("dial:\n%v\n", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	a := <-done
+	return a.conn, a.err
+}
+
+func TestQuotaConns(t *testing.T) {
+	s, c := startTestServer(t, &Options{
+		HandshakeOnAccept: true,
+		Quota:             &QuotaOptions{QuotaLimits: QuotaLimits{MaxConns: 1}},
+	})
+
+	accept := func() (*Conn, error) {
+		return dialQuota(t, s, c)
+	}
+
+	first, err := accept()
+	if err != nil {
+		t.Fatalf("first connection refused:\n%v\n", err)
+	}
+
+	_, err = accept()
+	var (
+		herr *HandshakeError
+		qerr *QuotaError
+	)
+	if !errors.As(err, &herr) || herr.Reason != HandshakeFailureQuota {
+		t.Fatalf("expected quota handshake failure, got %v\n", err)
+	}
+	if !errors.As(err, &qerr) || qerr.Key != "herots test" || qerr.Resource != QuotaConns {
+		t.Fatalf("unexpected quota error %v\n", err)
+	}
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("quota error does not wrap ErrQuotaExceeded\n")
+	}
+
+	usage := s.QuotaUsage()
+	if len(usage) != 1 || usage[0].Conns != 1 || usage[0].Rejected != 1 {
+		t.Fatalf("unexpected usage %+v\n", usage)
+	}
+
+	// slot is freed on close
+	first.Close()
+	if _, err := accept(); err != nil {
+		t.Fatalf("connection refused after close:\n%v\n", err)
+	}
+	if n := s.Stats().QuotaRejected; n != 1 {
+		t.Fatalf("%d quota rejections, want 1\n", n)
+	}
+}
+
+func TestQuotaMessages(t *testing.T) {
+	clock := herotstest.NewFakeClock(time.Now())
+	s, c := startTestServer(t, &Options{
+		Clock:             clock,
+		HandshakeOnAccept: true,
+		Quota: &QuotaOptions{
+			Limits: func(key string) QuotaLimits {
+				return QuotaLimits{MaxMessages: 2}
+			},
+		},
+	})
+
+	accept := func() *Conn {
+		sc, err := dialQuota(t, s, c)
+		if err != nil {
+			t.Fatalf("accept:\n%v\n", err)
+		}
+		return sc
+	}
+	a, b := accept(), accept()
+
+	codec := NewCodec(a)
+	for i := 0; i < 2; i++ {
+		if err := codec.WriteMessage([]byte("ping")); err != nil {
+			t.Fatalf("message %d within quota:\n%v\n", i, err)
+		}
+	}
+	var qerr *QuotaError
+	err := codec.WriteMessage([]byte("ping"))
+	if !errors.As(err, &qerr) || qerr.Resource != QuotaMessages || qerr.Limit != 2 {
+		t.Fatalf("expected message quota error, got %v\n", err)
+	}
+
+	usage := s.QuotaUsage()
+	if len(usage) != 1 || usage[0].Messages != 2 || usage[0].Rejected != 1 {
+		t.Fatalf("unexpected usage %+v\n", usage)
+	}
+
+	// usage of window outlives connections of identity
+	a.Close()
+	b.Close()
+	if err := NewCodec(accept()).WriteMessage([]byte("ping")); !errors.As(err, &qerr) {
+		t.Fatalf("message quota reset by reconnect, got %v\n", err)
+	}
+
+	clock.Advance(DefaultQuotaWindow)
+	if err := NewCodec(accept()).WriteMessage([]byte("ping")); err != nil {
+		t.Fatalf("message refused in new window:\n%v\n", err)
+	}
+}
+
+func TestQuotaBytes(t *testing.T) {
+	now := time.Now()
+	tr := newQuotaTracker(&QuotaOptions{
+		QuotaLimits: QuotaLimits{MaxBytes: 100},
+		Window:      time.Second,
+	}, func() time.Time { return now })
+
+	id := &Identity{}
+	if e, err := tr.admit(id, ""); e != nil || err != nil {
+		t.Fatalf("connection without key accounted\n")
+	}
+	e, err := tr.admit(id, "tenant")
+	if err != nil {
+		t.Fatalf("admit:\n%v\n", err)
+	}
+
+	if err := tr.charge(e, 60, 0); err != nil {
+		t.Fatalf("write within quota:\n%v\n", err)
+	}
+	tr.add(e, 60)
+	var qerr *QuotaError
+	if err := tr.charge(e, 1, 0); !errors.As(err, &qerr) || qerr.Resource != QuotaBytes {
+		t.Fatalf("expected byte quota error, got %v\n", err)
+	}
+
+	now = now.Add(time.Second)
+	if err := tr.charge(e, 1, 0); err != nil {
+		t.Fatalf("write refused in new window:\n%v\n", err)
+	}
+	tr.add(e, 100)
+
+	// reconnect doesn't reset usage of window
+	tr.release(e)
+	if e, err = tr.admit(id, "tenant"); err != nil {
+		t.Fatalf("admit:\n%v\n", err)
+	}
+	if err := tr.charge(e, 1, 0); !errors.As(err, &qerr) || qerr.Resource != QuotaBytes {
+		t.Fatalf("byte quota reset by reconnect, got %v\n", err)
+	}
+	tr.release(e)
+
+	// idle entry is swept after its window
+	now = now.Add(time.Second)
+	if _, err := tr.admit(id, "other"); err != nil {
+		t.Fatalf("admit:\n%v\n", err)
+	}
+	if u := tr.usage(); len(u) != 1 || u[0].Key != "other" {
+		t.Fatalf("idle entry not swept: %+v\n", u)
+	}
+}
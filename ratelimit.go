@@ -0,0 +1,213 @@
+package herots
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter - token bucket limiter for byte streams.
+//
+// Bucket holds up to burst tokens (bytes) and refills at rate tokens
+// per second.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	// chunk - upper bound of single read or write (0 - burst); keeps one
+	// consumer of shared limiter from draining the whole bucket at once.
+	chunk int
+}
+
+// sharedLimiterChunk - max size of single read or write through limiter
+// shared between connections.
+const sharedLimiterChunk = 16 << 10
+
+// newSharedRateLimiter - create limiter shared fairly between connections.
+func newSharedRateLimiter(rate, burst int) *rateLimiter {
+	l := newRateLimiter(rate, burst)
+	if l != nil {
+		l.chunk = sharedLimiterChunk
+	}
+	return l
+}
+
+// newRateLimiter - create limiter; returns nil (no limit) if rate <= 0.
+//
+// If burst <= 0, burst equals one second of traffic.
+func newRateLimiter(rate, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = rate
+	}
+	return &rateLimiter{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// sleepFunc - waits up to given duration on behalf of limiter.
+//
+// Implementations may return earlier (e.g. when connection deadline
+// changed) and return error to abort the wait (deadline exceeded,
+// connection closed).
+type sleepFunc func(d time.Duration) error
+
+// plainSleep - sleepFunc without deadlines.
+func plainSleep(d time.Duration) error {
+	time.Sleep(d)
+	return nil
+}
+
+// refill - add tokens for time passed since last refill. Lock must be held.
+func (l *rateLimiter) refill(now time.Time) {
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+}
+
+// want - how many tokens reservation of max bytes waits for: whole max,
+// but not more than burst or chunk, so throttled stream is still sent in
+// reasonably large pieces.
+func (l *rateLimiter) want(max int) int {
+	n := max
+	if b := int(l.burst); n > b {
+		n = b
+	}
+	if l.chunk > 0 && n > l.chunk {
+		n = l.chunk
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// reserve - wait until want(max) tokens are available and take them.
+// Returns number of taken tokens.
+func (l *rateLimiter) reserve(max int, sleep sleepFunc) (int, error) {
+	if max <= 0 {
+		return 0, nil
+	}
+	if sleep == nil {
+		sleep = plainSleep
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := l.want(max)
+	for {
+		l.refill(time.Now())
+		if l.tokens >= float64(n) {
+			break
+		}
+		wait := time.Duration((float64(n) - l.tokens) / l.rate * float64(time.Second))
+		l.mu.Unlock()
+		err := sleep(wait)
+		l.mu.Lock()
+		if err != nil {
+			return 0, err
+		}
+	}
+	l.tokens -= float64(n)
+
+	return n, nil
+}
+
+// refund - return unused tokens to bucket.
+func (l *rateLimiter) refund(n int) {
+	if n <= 0 {
+		return
+	}
+	l.mu.Lock()
+	l.tokens += float64(n)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.mu.Unlock()
+}
+
+// waitCredit - wait until bucket is not in debt (see charge).
+func (l *rateLimiter) waitCredit(sleep sleepFunc) error {
+	if sleep == nil {
+		sleep = plainSleep
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for {
+		l.refill(time.Now())
+		if l.tokens > 0 {
+			return nil
+		}
+		wait := time.Duration(-l.tokens/l.rate*float64(time.Second)) + time.Millisecond
+		l.mu.Unlock()
+		err := sleep(wait)
+		l.mu.Lock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// charge - take tokens for already transferred bytes. Bucket may go into
+// debt, which following callers wait out in waitCredit.
+func (l *rateLimiter) charge(n int) {
+	if n <= 0 {
+		return
+	}
+	l.mu.Lock()
+	l.refill(time.Now())
+	l.tokens -= float64(n)
+	l.mu.Unlock()
+}
+
+// limitedRead - read through limiter.
+//
+// Read waits only until limiter has credit and then is charged for bytes
+// actually read, so connection blocked in read holds no tokens (important
+// for limiter shared by many mostly idle connections). Length of single
+// read is bounded by burst (and chunk).
+func limitedRead(l *rateLimiter, p []byte, read func([]byte) (int, error), sleep sleepFunc) (int, error) {
+	if l == nil || len(p) == 0 {
+		return read(p)
+	}
+	if err := l.waitCredit(sleep); err != nil {
+		return 0, err
+	}
+	n, err := read(p[:l.want(len(p))])
+	l.charge(n)
+	return n, err
+}
+
+// limitedWrite - write through limiter in chunks of reserved tokens.
+func limitedWrite(l *rateLimiter, p []byte, write func([]byte) (int, error), sleep sleepFunc) (int, error) {
+	if l == nil {
+		return write(p)
+	}
+	written := 0
+	for len(p) > 0 {
+		k, err := l.reserve(len(p), sleep)
+		if err != nil {
+			return written, err
+		}
+		n, err := write(p[:k])
+		written += n
+		if err != nil {
+			l.refund(k - n)
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
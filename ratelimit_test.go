@@ -0,0 +1,146 @@
+package herots
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterWrite(t *testing.T) {
+	l := newRateLimiter(1000, 100)
+
+	var buf bytes.Buffer
+	start := time.Now()
+	n, err := limitedWrite(l, make([]byte, 300), buf.Write, nil)
+	if err != nil || n != 300 || buf.Len() != 300 {
+		t.Fatalf("unexpected write result: %d, %v\n", n, err)
+	}
+
+	// 100 bytes of burst, then 200 bytes at 1000 B/s
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Fatalf("write not throttled: took %v\n", d)
+	}
+}
+
+func TestRateLimiterRead(t *testing.T) {
+	l := newRateLimiter(1000, 10)
+
+	r := bytes.NewReader(make([]byte, 100))
+	p := make([]byte, 100)
+	n, err := limitedRead(l, p, r.Read, nil)
+	if err != nil || n != 10 {
+		t.Fatalf("read not limited by burst: %d, %v\n", n, err)
+	}
+
+	if newRateLimiter(0, 10) != nil {
+		t.Fatalf("zero rate must disable limiter\n")
+	}
+}
+
+func TestSharedRateLimiterChunk(t *testing.T) {
+	l := newSharedRateLimiter(1<<20, 1<<20)
+	if n, _ := l.reserve(1<<20, nil); n != sharedLimiterChunk {
+		t.Fatalf("shared reservation not bounded by chunk: %d\n", n)
+	}
+}
+
+func TestRateLimiterWriteChunks(t *testing.T) {
+	l := newRateLimiter(100000, 1000)
+
+	writes := 0
+	write := func(p []byte) (int, error) {
+		writes++
+		return len(p), nil
+	}
+	if _, err := limitedWrite(l, make([]byte, 20000), write, nil); err != nil {
+		t.Fatalf("write:\n%v\n", err)
+	}
+
+	// throttled stream must go in burst sized pieces, not byte by byte
+	if writes > 20 {
+		t.Fatalf("write split into %d pieces\n", writes)
+	}
+}
+
+func TestRateLimiterDeadline(t *testing.T) {
+	l := newRateLimiter(10, 10)
+	l.reserve(10, nil)
+
+	deadline := time.Now().Add(50 * time.Millisecond)
+	sleep := func(d time.Duration) error {
+		left := time.Until(deadline)
+		if left <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		if left < d {
+			d = left
+		}
+		time.Sleep(d)
+		return nil
+	}
+
+	start := time.Now()
+	_, err := l.reserve(10, sleep)
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected deadline error, got %v\n", err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("limiter waited past deadline: %v\n", d)
+	}
+}
+
+func TestSharedRateLimiterIdleReaders(t *testing.T) {
+	l := newSharedRateLimiter(100000, 100000)
+
+	// idle connections blocked in read must not hold shared tokens
+	block := make(chan struct{})
+	defer close(block)
+	for i := 0; i < 8; i++ {
+		go limitedRead(l, make([]byte, 1<<20), func(p []byte) (int, error) {
+			<-block
+			return 0, nil
+		}, nil)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	r := bytes.NewReader(make([]byte, 80000))
+	p := make([]byte, 1<<20)
+	start := time.Now()
+	total := 0
+	for total < 80000 {
+		n, err := limitedRead(l, p, r.Read, nil)
+		if err != nil {
+			t.Fatalf("read:\n%v\n", err)
+		}
+		total += n
+	}
+
+	// 80000 bytes fit into burst of 100000
+	if d := time.Since(start); d > 300*time.Millisecond {
+		t.Fatalf("active reader starved by idle ones: took %v\n", d)
+	}
+}
+
+func TestRateLimiterReadDebt(t *testing.T) {
+	l := newRateLimiter(1000, 100)
+
+	r := bytes.NewReader(make([]byte, 300))
+	p := make([]byte, 300)
+	start := time.Now()
+	total := 0
+	for total < 300 {
+		n, err := limitedRead(l, p, r.Read, nil)
+		if err != nil {
+			t.Fatalf("read:\n%v\n", err)
+		}
+		total += n
+	}
+
+	// reads are charged after the fact: burst, then one read on credit,
+	// then the third waits out ~100 bytes of debt at 1000 B/s
+	if d := time.Since(start); d < 90*time.Millisecond {
+		t.Fatalf("read not throttled: took %v\n", d)
+	}
+}
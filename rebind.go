@@ -0,0 +1,319 @@
+package herots
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// default settings of managed connection
+const (
+	DefaultRebindInterval = 30 * time.Second
+	DefaultRedialBackoff  = 30 * time.Second
+)
+
+// minStableServe - how long connection must be served for redial backoff
+// to be reset; shorter ones count as failed dials, so server, which
+// accepts and drops connections, isn't redialed in tight loop.
+const minStableServe = 10 * time.Second
+
+// RebindOptions - structure, which is used to configure managed
+// connection (Client.DialManaged).
+type RebindOptions struct {
+	// Interval - how often server host name is re-resolved.
+	//
+	// Default: DefaultRebindInterval (30s).
+	Interval time.Duration
+
+	// MaxBackoff - upper limit of delay between failed dials.
+	//
+	// Default: DefaultRedialBackoff (30s).
+	MaxBackoff time.Duration
+
+	// Lookup - function, which resolves host name to addresses.
+	//
+	// Default: net.DefaultResolver.LookupHost.
+	Lookup func(ctx context.Context, host string) ([]string, error)
+
+	// OnRebind is called when connection is about to move from address,
+	// which host name no longer resolves to.
+	OnRebind func(old string, addrs []string)
+}
+
+// ManagedConn - connection to server, which is re-established when it
+// fails and moved to new address when server host name starts to resolve
+// to other addresses (e.g. after DNS failover), see Client.DialManaged.
+type ManagedConn struct {
+	c     *Client
+	o     RebindOptions
+	serve func(conn *tls.Conn) error
+	clock Clock
+
+	mu   sync.Mutex
+	addr string
+	conn *tls.Conn
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// DialManaged - function for keep connection with server: dial it, run
+// serve over connection, and when serve returns (it should return once
+// connection fails), dial again with backoff.
+//
+// Host name of server (Options.Host) is re-resolved every
+// RebindOptions.Interval; if address of current connection is no longer
+// among resolved ones, connection is closed cleanly (TLS close_notify)
+// and serve is expected to return, so next connection goes to new
+// address. If Options.ClientSessionCacheSize is set, new connection
+// resumes TLS session. Host given as IP address is not re-resolved.
+func (c *Client) DialManaged(serve func(conn *tls.Conn) error, o *RebindOptions) *ManagedConn {
+	m := &ManagedConn{
+		c:     c,
+		serve: serve,
+		clock: clockOrReal(c.options.Clock),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	if o != nil {
+		m.o = *o
+	}
+	if m.o.Interval <= 0 {
+		m.o.Interval = DefaultRebindInterval
+	}
+	if m.o.MaxBackoff <= 0 {
+		m.o.MaxBackoff = DefaultRedialBackoff
+	}
+	if m.o.Lookup == nil {
+		m.o.Lookup = net.DefaultResolver.LookupHost
+	}
+
+	go m.run()
+	if net.ParseIP(c.options.Host) == nil {
+		go m.watch()
+	}
+	return m
+}
+
+// Addr - return address of current connection, "" while disconnected.
+func (m *ManagedConn) Addr() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.addr
+}
+
+// Done - return channel, which is closed once managed connection is
+// closed and last serve returned.
+func (m *ManagedConn) Done() <-chan struct{} {
+	return m.done
+}
+
+// Close - close current connection and stop re-establishing it. Close
+// waits for serve to return.
+func (m *ManagedConn) Close() error {
+	m.once.Do(func() { close(m.stop) })
+	m.closeCurrent()
+	<-m.done
+	return nil
+}
+
+func (m *ManagedConn) closeCurrent() {
+	m.mu.Lock()
+	conn := m.conn
+	m.mu.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+func (m *ManagedConn) stopped() bool {
+	select {
+	case <-m.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// sleep - wait d or until Close; report whether to go on.
+func (m *ManagedConn) sleep(d time.Duration) bool {
+	wake := make(chan struct{})
+	cancel := m.clock.AfterFunc(d, func() { close(wake) })
+	defer cancel()
+	select {
+	case <-wake:
+		return true
+	case <-m.stop:
+		return false
+	}
+}
+
+// run - dial and serve until Close.
+func (m *ManagedConn) run() {
+	defer close(m.done)
+
+	var backoff time.Duration
+	for !m.stopped() {
+		conn, addr, err := m.dial()
+		if err == nil {
+			start := m.clock.Now()
+			if !m.serveConn(conn, addr) {
+				return
+			}
+			if m.clock.Now().Sub(start) >= minStableServe {
+				backoff = 0
+				continue
+			}
+			err = fmt.Errorf("managed connection to %s ended too soon\n", addr)
+		}
+
+		if backoff == 0 {
+			backoff = 100 * time.Millisecond
+		} else {
+			backoff = min(2*backoff, m.o.MaxBackoff)
+		}
+		m.c.logger.Log(fmt.Sprintf("%vredial in %v", err, backoff), LogLevelError)
+		if !m.sleep(backoff) {
+			return
+		}
+	}
+}
+
+// serveConn - publish conn as current connection and serve it; report
+// whether to go on (false once Close is called).
+func (m *ManagedConn) serveConn(conn *tls.Conn, addr string) bool {
+	m.mu.Lock()
+	m.conn, m.addr = conn, addr
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		m.conn, m.addr = nil, ""
+		m.mu.Unlock()
+	}()
+	if m.stopped() {
+		// raced with Close
+		conn.Close()
+		return false
+	}
+
+	err := m.serve(conn)
+	conn.Close()
+	if m.stopped() {
+		return false
+	}
+	if err != nil {
+		m.c.logger.Log("managed connection to "+addr+" ended: "+err.Error(), LogLevelInfo)
+	}
+	return true
+}
+
+// dial - resolve host and connect to first reachable address.
+func (m *ManagedConn) dial() (*tls.Conn, string, error) {
+	addrs, err := m.resolve()
+	if err != nil {
+		return nil, "", fmt.Errorf("fail to dial with server: %v\n", err)
+	}
+
+	var lastErr error
+	for _, ip := range addrs {
+		addr := net.JoinHostPort(ip, strconv.Itoa(m.c.options.Port))
+		conn, err := m.c.dialAddr(addr)
+		if err == nil {
+			return conn, addr, nil
+		}
+		lastErr = err
+	}
+	return nil, "", lastErr
+}
+
+func (m *ManagedConn) resolve() ([]string, error) {
+	host := m.c.options.Host
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultHandshakeTimeout)
+	defer cancel()
+	addrs, err := m.o.Lookup(ctx, host)
+	if err == nil && len(addrs) == 0 {
+		err = errors.New("no addresses for " + host)
+	}
+	return addrs, err
+}
+
+// watch - periodically re-resolve host and move connection off stale
+// address.
+func (m *ManagedConn) watch() {
+	tick, stopTicker := m.clock.NewTicker(m.o.Interval)
+	defer stopTicker()
+
+	for {
+		select {
+		case <-tick:
+		case <-m.stop:
+			return
+		}
+
+		current := m.Addr()
+		if current == "" {
+			continue
+		}
+		addrs, err := m.resolve()
+		if err != nil {
+			// keep working connection on resolver failure
+			m.c.logger.Log("re-resolve "+m.c.options.Host+" fail: "+err.Error(), LogLevelError)
+			continue
+		}
+		ip, _, _ := net.SplitHostPort(current)
+		if containsAddr(addrs, ip) {
+			continue
+		}
+
+		m.c.logger.Log(fmt.Sprintf("%s moved to %v, leaving %s", m.c.options.Host, addrs, current), LogLevelNotice)
+		if m.o.OnRebind != nil {
+			m.o.OnRebind(current, addrs)
+		}
+		m.closeCurrent()
+	}
+}
+
+func containsAddr(addrs []string, ip string) bool {
+	for _, a := range addrs {
+		if net.ParseIP(a).Equal(net.ParseIP(ip)) {
+			return true
+		}
+	}
+	return false
+}
+
+// dialAddr - connect to server at addr, verifying certificate against
+// ServerName or Host.
+func (c *Client) dialAddr(addr string) (*tls.Conn, error) {
+	if len(c.certs.Cert.Certificate) == 0 {
+		return nil, fmt.Errorf("%s\n", NoKeyPairLoadError)
+	}
+
+	config := c.tlsConfig()
+	if config.ServerName == "" {
+		config.ServerName = c.options.Host
+	}
+	d := &net.Dialer{Timeout: DefaultHandshakeTimeout}
+	conn, err := tls.DialWithDialer(d, "tcp", addr, config)
+	if err != nil {
+		return nil, fmt.Errorf("fail to dial with server: %v\n", err)
+	}
+	if err := c.present(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("fail to dial with server: %w\n", err)
+	}
+
+	c.logger.Log("dial to "+addr+" - ok", LogLevelInfo)
+
+	return conn, nil
+}
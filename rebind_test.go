@@ -0,0 +1,155 @@
+package herots
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/iu0v1/herots/herotstest"
+)
+
+func TestDialManagedRebind(t *testing.T) {
+	cert, key := genKeyPair(t, "herots test")
+	port := freePort(t)
+
+	var servers []*Server
+	for _, host := range []string{"127.0.0.1", "127.0.0.2"} {
+		s := NewServer(&Options{Host: host, Port: port})
+		if err := s.LoadKeyPair(cert, key); err != nil {
+			t.Fatalf("server load key pair:\n%v\n", err)
+		}
+		if err := s.Start(); err != nil {
+			t.Skipf("no %s to listen on:\n%v\n", host, err)
+		}
+		t.Cleanup(func() { s.Close() })
+		servers = append(servers, s)
+	}
+	for _, s := range servers {
+		go func() {
+			for {
+				conn, err := s.AcceptConn()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer conn.Close()
+					io.Copy(conn, conn)
+				}()
+			}
+		}()
+	}
+
+	clock := herotstest.NewFakeClock(time.Now())
+	c := NewClient(&Options{Host: "herots.test", Port: port, ServerName: "localhost", Clock: clock})
+	if err := c.LoadKeyPair(cert, key); err != nil {
+		t.Fatalf("client load key pair:\n%v\n", err)
+	}
+
+	var mu sync.Mutex
+	addr := "127.0.0.1"
+	lookup := func(ctx context.Context, host string) ([]string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return []string{addr}, nil
+	}
+	rebound := make(chan string, 1)
+
+	m := c.DialManaged(func(conn *tls.Conn) error {
+		_, err := io.Copy(io.Discard, conn)
+		return err
+	}, &RebindOptions{
+		Interval: time.Minute,
+		Lookup:   lookup,
+		OnRebind: func(old string, addrs []string) { rebound <- old },
+	})
+	defer m.Close()
+
+	first := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	waitFor(t, "first connection", func() bool { return m.Addr() == first })
+	waitFor(t, "rebind ticker", func() bool { return clock.Pending() == 1 })
+
+	// same address: connection is kept
+	clock.Advance(time.Minute)
+	select {
+	case old := <-rebound:
+		t.Fatalf("rebound from %s without address change\n", old)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	mu.Lock()
+	addr = "127.0.0.2"
+	mu.Unlock()
+	clock.Advance(time.Minute)
+	select {
+	case old := <-rebound:
+		if old != first {
+			t.Fatalf("rebound from %s, want %s\n", old, first)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no rebind after address change\n")
+	}
+
+	second := net.JoinHostPort("127.0.0.2", strconv.Itoa(port))
+	waitFor(t, "migrated connection", func() bool { return m.Addr() == second })
+	waitFor(t, "old connection closed", func() bool { return len(servers[0].Conns()) == 0 })
+
+	m.Close()
+	select {
+	case <-m.Done():
+	default:
+		t.Fatalf("managed connection not done after Close\n")
+	}
+}
+
+func TestDialManagedBackoff(t *testing.T) {
+	cert, key := genKeyPair(t, "herots test")
+	s := NewServer(&Options{Host: "127.0.0.1", Port: freePort(t)})
+	if err := s.LoadKeyPair(cert, key); err != nil {
+		t.Fatalf("server load key pair:\n%v\n", err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("server start:\n%v\n", err)
+	}
+	defer s.Close()
+
+	// server drops every connection right away
+	var accepted atomic.Int32
+	go func() {
+		for {
+			conn, err := s.AcceptConn()
+			if err != nil {
+				return
+			}
+			if conn.Handshake() == nil {
+				accepted.Add(1)
+			}
+			conn.Close()
+		}
+	}()
+
+	clock := herotstest.NewFakeClock(time.Now())
+	c := NewClient(&Options{Host: s.options.Host, Port: s.options.Port, ServerName: "localhost", Clock: clock})
+	if err := c.LoadKeyPair(cert, key); err != nil {
+		t.Fatalf("client load key pair:\n%v\n", err)
+	}
+	m := c.DialManaged(func(conn *tls.Conn) error {
+		_, err := io.Copy(io.Discard, conn)
+		return err
+	}, nil)
+	defer m.Close()
+
+	// short-lived connection counts as failed dial
+	waitFor(t, "redial backoff", func() bool { return accepted.Load() == 1 && clock.Pending() == 1 })
+	time.Sleep(50 * time.Millisecond)
+	if n := accepted.Load(); n != 1 {
+		t.Fatalf("%d connections without backoff, want 1\n", n)
+	}
+	clock.Advance(100 * time.Millisecond)
+	waitFor(t, "second connection", func() bool { return accepted.Load() == 2 })
+}
@@ -0,0 +1,172 @@
+package herots
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ListenFDsEnv - name of environment variable, which passes inherited
+// listening sockets to restarted process (see Server.Restart).
+//
+// Value is a list of "address=fd" pairs separated by ';', where address is
+// the Host:Port service of server, which owned the socket.
+const ListenFDsEnv = "HEROTS_LISTEN_FDS"
+
+// ReadyFDEnv - name of environment variable with descriptor, which
+// restarted process writes to once all inherited listeners are serving.
+const ReadyFDEnv = "HEROTS_READY_FD"
+
+// DefaultRestartTimeout - default time which Restart waits for new
+// process to become ready.
+const DefaultRestartTimeout = 30 * time.Second
+
+// listening sockets of started servers in this process, by service; all of
+// them are passed to restarted process
+var (
+	listenersMu sync.Mutex
+	listeners   = make(map[string]net.Listener)
+)
+
+// inherited sockets not yet picked up by Server.Start, by service
+var (
+	inheritOnce sync.Once
+	inheritErr  error
+	inherited   map[string]int
+)
+
+// loadInherited - parse and clear inheritance variables, so processes
+// started by this one don't try to reuse the descriptors.
+func loadInherited() {
+	inherited = make(map[string]int)
+
+	v := os.Getenv(ListenFDsEnv)
+	os.Unsetenv(ListenFDsEnv)
+	if v == "" {
+		return
+	}
+
+	for _, pair := range strings.Split(v, ";") {
+		i := strings.LastIndex(pair, "=")
+		if i < 0 {
+			inheritErr = fmt.Errorf("bad %s value %q", ListenFDsEnv, v)
+			return
+		}
+		fd, err := strconv.Atoi(pair[i+1:])
+		if err != nil {
+			inheritErr = fmt.Errorf("bad %s value %q", ListenFDsEnv, v)
+			return
+		}
+		inherited[pair[:i]] = fd
+	}
+}
+
+// inheritedListener - return listener for service passed by parent
+// process, if any.
+func inheritedListener(service string) (net.Listener, bool, error) {
+	inheritOnce.Do(loadInherited)
+
+	listenersMu.Lock()
+	defer listenersMu.Unlock()
+
+	if inheritErr != nil {
+		return nil, false, inheritErr
+	}
+	fd, ok := inherited[service]
+	if !ok {
+		return nil, false, nil
+	}
+	delete(inherited, service)
+
+	f := os.NewFile(uintptr(fd), "herots-listener")
+	defer f.Close()
+
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, false, fmt.Errorf("inherited listener: %v", err)
+	}
+
+	return l, true, nil
+}
+
+// hasInheritedListener - report whether parent process passed listener
+// for service, without taking it (see Server.Preflight).
+func hasInheritedListener(service string) (bool, error) {
+	inheritOnce.Do(loadInherited)
+
+	listenersMu.Lock()
+	defer listenersMu.Unlock()
+
+	if inheritErr != nil {
+		return false, inheritErr
+	}
+	_, ok := inherited[service]
+	return ok, nil
+}
+
+// addListener - remember listening socket of started server and, once all
+// inherited sockets are picked up, report readiness to parent process.
+func addListener(service string, l net.Listener) {
+	inheritOnce.Do(loadInherited)
+
+	listenersMu.Lock()
+	listeners[service] = l
+	ready := len(inherited) == 0
+	listenersMu.Unlock()
+
+	if ready {
+		notifyReady()
+	}
+}
+
+// removeListener - forget listening socket of stopped server.
+func removeListener(l net.Listener) {
+	listenersMu.Lock()
+	for service, sl := range listeners {
+		if sl == l {
+			delete(listeners, service)
+		}
+	}
+	listenersMu.Unlock()
+}
+
+// activeListeners - return services and listeners of started servers,
+// sorted by service.
+func activeListeners() ([]string, []net.Listener) {
+	listenersMu.Lock()
+	defer listenersMu.Unlock()
+
+	services := make([]string, 0, len(listeners))
+	for service := range listeners {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	ls := make([]net.Listener, len(services))
+	for i, service := range services {
+		ls[i] = listeners[service]
+	}
+	return services, ls
+}
+
+// notifyReady - write readiness byte to parent process (once).
+func notifyReady() {
+	v := os.Getenv(ReadyFDEnv)
+	if v == "" {
+		return
+	}
+	os.Unsetenv(ReadyFDEnv)
+
+	fd, err := strconv.Atoi(v)
+	if err != nil {
+		return
+	}
+	f := os.NewFile(uintptr(fd), "herots-ready")
+	f.Write([]byte{1})
+	f.Close()
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package herots
+
+import (
+	"fmt"
+)
+
+// Restart - not supported on this platform.
+func (s *Server) Restart() error {
+	return fmt.Errorf("restart fail: listener handoff not supported on this platform\n")
+}
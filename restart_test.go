@@ -0,0 +1,145 @@
+//go:build unix
+
+package herots
+
+import (
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// env of child process started by TestRestart
+const (
+	restartTestModeEnv = "HEROTS_TEST_RESTART"
+	restartTestPortEnv = "HEROTS_TEST_RESTART_PORT"
+	restartTestCertEnv = "HEROTS_TEST_RESTART_CERT"
+	restartTestKeyEnv  = "HEROTS_TEST_RESTART_KEY"
+)
+
+func TestMain(m *testing.M) {
+	switch os.Getenv(restartTestModeEnv) {
+	case "":
+		os.Exit(m.Run())
+	case "fail":
+		// new binary which can't start
+		os.Exit(3)
+	default:
+		restartTestChild()
+	}
+}
+
+// restartTestChild - restarted process: serve one connection on inherited
+// socket and exit.
+func restartTestChild() {
+	time.AfterFunc(10*time.Second, func() { os.Exit(4) })
+
+	port, _ := strconv.Atoi(os.Getenv(restartTestPortEnv))
+	s := NewServer(&Options{Host: "127.0.0.1", Port: port})
+	s.LoadKeyPair([]byte(os.Getenv(restartTestCertEnv)), []byte(os.Getenv(restartTestKeyEnv)))
+	if err := s.Start(); err != nil {
+		os.Exit(5)
+	}
+
+	conn, err := s.AcceptConn()
+	if err != nil {
+		os.Exit(6)
+	}
+	conn.Write([]byte("child"))
+	conn.Close()
+	os.Exit(0)
+}
+
+func TestRestart(t *testing.T) {
+	cert, key := genKeyPair(t, "herots restart test")
+	port := freePort(t)
+
+	s := NewServer(&Options{Host: "127.0.0.1", Port: port, RestartTimeout: 5 * time.Second})
+	if err := s.LoadKeyPair(cert, key); err != nil {
+		t.Fatalf("load key pair:\n%v\n", err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("start:\n%v\n", err)
+	}
+	defer s.Close()
+
+	t.Setenv(restartTestPortEnv, strconv.Itoa(port))
+	t.Setenv(restartTestCertEnv, string(cert))
+	t.Setenv(restartTestKeyEnv, string(key))
+
+	// broken new binary: restart fails, old server keeps serving
+	t.Setenv(restartTestModeEnv, "fail")
+	if err := s.Restart(); err == nil {
+		t.Fatalf("expected restart error for failing child\n")
+	}
+	if s.closed.Load() {
+		t.Fatalf("server shut down after failed restart\n")
+	}
+
+	t.Setenv(restartTestModeEnv, "ok")
+	if err := s.Restart(); err != nil {
+		t.Fatalf("restart:\n%v\n", err)
+	}
+	if !s.closed.Load() {
+		t.Fatalf("old server still running after restart\n")
+	}
+
+	c := NewClient(&Options{Host: "127.0.0.1", Port: port})
+	if err := c.LoadKeyPair(cert, key); err != nil {
+		t.Fatalf("client load key pair:\n%v\n", err)
+	}
+	conn, err := c.Dial()
+	if err != nil {
+		t.Fatalf("dial restarted server:\n%v\n", err)
+	}
+	defer conn.Close()
+
+	reply, err := io.ReadAll(conn)
+	if err != nil || string(reply) != "child" {
+		t.Fatalf("unexpected reply from restarted server: %q, %v\n", reply, err)
+	}
+}
+
+func TestInheritedListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen:\n%v\n", err)
+	}
+	defer l.Close()
+
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("listener file:\n%v\n", err)
+	}
+	defer f.Close()
+
+	// inheritedListener takes ownership of descriptor
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		t.Fatalf("dup:\n%v\n", err)
+	}
+
+	inheritOnce.Do(loadInherited)
+	inherited = map[string]int{"127.0.0.1:1": fd}
+	defer func() { inherited = map[string]int{} }()
+
+	if _, ok, err := inheritedListener("127.0.0.1:2"); ok || err != nil {
+		t.Fatalf("listener inherited by server with other address: %v\n", err)
+	}
+
+	il, ok, err := inheritedListener("127.0.0.1:1")
+	if err != nil || !ok {
+		t.Fatalf("inherited listener not picked up: %v\n", err)
+	}
+	defer il.Close()
+
+	if il.Addr().String() != l.Addr().String() {
+		t.Fatalf("unexpected inherited address %s\n", il.Addr())
+	}
+	if _, ok, _ := inheritedListener("127.0.0.1:1"); ok {
+		t.Fatalf("listener inherited twice\n")
+	}
+}
@@ -0,0 +1,135 @@
+//go:build unix
+
+package herots
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Restart - start new copy of the running binary, pass listening sockets
+// to it and gracefully shutdown current server.
+//
+// The child process is started with the same arguments and environment.
+// Sockets of all started servers of this process are passed to it, keyed
+// by their Host:Port, so Server.Start of child picks up its own socket
+// instead of binding address again, and listening port stays open during
+// upgrade. Child reports readiness once all passed sockets are in use;
+// only then current server is drained as in Shutdown (other servers of
+// this process keep running until closed by caller). If child exits or
+// doesn't report readiness within Options.RestartTimeout, it is killed,
+// error is returned and current server keeps serving.
+func (s *Server) Restart() error {
+	if _, ok := s.currentListener().(*net.TCPListener); !ok {
+		return fmt.Errorf("restart fail: server not started\n")
+	}
+
+	path, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("restart fail: %v\n", err)
+	}
+
+	services, ls := activeListeners()
+	files := make([]*os.File, 0, len(ls)+1)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	pairs := make([]string, 0, len(ls))
+	for i, l := range ls {
+		tl, ok := l.(*net.TCPListener)
+		if !ok {
+			continue
+		}
+		f, err := tl.File()
+		if err != nil {
+			return fmt.Errorf("restart fail: %v\n", err)
+		}
+		files = append(files, f)
+		// ExtraFiles start from descriptor 3 in child
+		pairs = append(pairs, services[i]+"="+strconv.Itoa(3+len(files)-1))
+	}
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("restart fail: %v\n", err)
+	}
+	defer readyR.Close()
+	files = append(files, readyW)
+	readyFD := 3 + len(files) - 1
+
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Env = append(restartEnv(),
+		ListenFDsEnv+"="+strings.Join(pairs, ";"),
+		ReadyFDEnv+"="+strconv.Itoa(readyFD),
+	)
+	cmd.ExtraFiles = files
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("restart fail: %v\n", err)
+	}
+	// reap child whenever it exits
+	go cmd.Wait()
+
+	// only child may hold write end now, so its exit gives EOF
+	readyW.Close()
+	files = files[:len(files)-1]
+
+	pid := strconv.Itoa(cmd.Process.Pid)
+	s.logger.Log("restart: started new process "+pid+", waiting for it to become ready", LogLevelNotice)
+
+	if err := waitReady(readyR, s.restartTimeout()); err != nil {
+		cmd.Process.Kill()
+		s.logger.Log("restart: new process "+pid+" failed: "+err.Error(), LogLevelError)
+		return fmt.Errorf("restart fail: %v\n", err)
+	}
+
+	s.logger.Log("restart: new process "+pid+" is ready", LogLevelNotice)
+
+	return s.Shutdown()
+}
+
+func (s *Server) restartTimeout() time.Duration {
+	if s.options.RestartTimeout > 0 {
+		return s.options.RestartTimeout
+	}
+	return DefaultRestartTimeout
+}
+
+// waitReady - wait for readiness byte from child.
+func waitReady(r *os.File, timeout time.Duration) error {
+	r.SetReadDeadline(time.Now().Add(timeout))
+
+	buf := make([]byte, 1)
+	if _, err := r.Read(buf); err != nil {
+		if os.IsTimeout(err) {
+			return fmt.Errorf("new process not ready after %v", timeout)
+		}
+		return fmt.Errorf("new process exited before ready")
+	}
+	return nil
+}
+
+// restartEnv - environment of current process without inheritance
+// variables.
+func restartEnv() []string {
+	env := os.Environ()
+	out := env[:0:0]
+	for _, kv := range env {
+		if strings.HasPrefix(kv, ListenFDsEnv+"=") || strings.HasPrefix(kv, ReadyFDEnv+"=") {
+			continue
+		}
+		out = append(out, kv)
+	}
+	return out
+}
@@ -0,0 +1,362 @@
+package herots
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// RPCHandlerFunc - type for functions which serve incoming RPC requests.
+//
+// Returned error is delivered to caller as *RPCError.
+type RPCHandlerFunc func(req []byte) ([]byte, error)
+
+// RPCError - error returned by remote RPC handler.
+type RPCError struct {
+	Message string
+}
+
+func (e *RPCError) Error() string {
+	return "rpc remote error: " + e.Message
+}
+
+// Is - report whether remote side rejected request as ErrRPCBusy.
+func (e *RPCError) Is(target error) bool {
+	return target == ErrRPCBusy && e.Message == ErrRPCBusy.Error()
+}
+
+// predefined RPC errors
+var (
+	ErrRPCTimeout = errors.New("rpc call timeout")
+	ErrRPCClosed  = errors.New("rpc closed")
+	// ErrRPCBusy - request was rejected by remote side, as its handlers
+	// and queue were full (see RPCOptions.MaxQueued); matched by
+	// errors.Is on *RPCError.
+	ErrRPCBusy = errors.New("rpc busy")
+)
+
+// RPC message kinds
+const (
+	rpcRequest byte = iota + 1
+	rpcResponse
+	rpcError
+)
+
+// DefaultRPCMaxHandlers - default limit of concurrently running handlers
+// of one RPC.
+const DefaultRPCMaxHandlers = 64
+
+// DefaultRPCMaxQueued - default limit of incoming requests waiting for
+// handler.
+const DefaultRPCMaxQueued = 1024
+
+// rpcMaxBusyReplies - limit of ErrRPCBusy replies waiting to be sent;
+// further rejected requests get no reply.
+const rpcMaxBusyReplies = 64
+
+// RPCOptions - structure, which is used to configure RPC.
+type RPCOptions struct {
+	// MaxHandlers limits the number of incoming requests served
+	// concurrently. When limit is reached, further requests wait in queue
+	// (see MaxQueued) until one of running handlers returns, so remote
+	// side can't spawn unbounded number of goroutines. Responses are read
+	// meanwhile, so handlers may make calls over the same RPC.
+	//
+	// Default: DefaultRPCMaxHandlers.
+	MaxHandlers int
+
+	// MaxQueued limits the number of requests waiting for handler; when
+	// queue is full, requests are answered with ErrRPCBusy (or get no
+	// reply, if remote side doesn't read replies either).
+	//
+	// Default: DefaultRPCMaxQueued.
+	MaxQueued int
+
+	// Clock - source of time for call timeouts, see Clock.
+	//
+	// Default: nil (real time).
+	Clock Clock
+}
+
+// rpcHeaderSize - kind (1 byte) + call id (8 bytes).
+const rpcHeaderSize = 9
+
+type rpcResult struct {
+	body []byte
+	err  error
+}
+
+// rpcIncoming - request waiting for handler.
+type rpcIncoming struct {
+	id   uint64
+	body []byte
+}
+
+// RPC - request/response layer over framed messages.
+//
+// One RPC serves both directions of a connection: it runs calls issued by
+// local side (Call) and hands requests of remote side to handler.
+// Any number of calls may be in flight concurrently; responses are matched
+// to requests by id.
+type RPC struct {
+	codec   *Codec
+	handler RPCHandlerFunc
+
+	// handlers - semaphore bounding concurrently running handlers;
+	// queue - requests waiting for it
+	handlers chan struct{}
+	queue    chan rpcIncoming
+	// busy - ids of requests to answer with ErrRPCBusy, sent by busyLoop,
+	// so peer which doesn't read doesn't block readLoop
+	busy chan uint64
+
+	clock Clock
+
+	mu      sync.Mutex
+	pending map[uint64]chan rpcResult
+	lastID  uint64
+	err     error
+
+	done chan struct{}
+}
+
+// NewRPC - function for create RPC over connection and start serving it.
+//
+// Handler may be nil if local side only makes calls; incoming requests are
+// answered with error in that case.
+func NewRPC(rw io.ReadWriter, handler RPCHandlerFunc) *RPC {
+	return NewRPCWithCodec(NewCodec(rw), handler)
+}
+
+// NewRPCWithCodec - same as NewRPC, but over preconfigured codec.
+func NewRPCWithCodec(codec *Codec, handler RPCHandlerFunc) *RPC {
+	return NewRPCWithOptions(codec, handler, &RPCOptions{})
+}
+
+// NewRPCWithOptions - same as NewRPCWithCodec, with RPC options.
+func NewRPCWithOptions(codec *Codec, handler RPCHandlerFunc, o *RPCOptions) *RPC {
+	max := o.MaxHandlers
+	if max <= 0 {
+		max = DefaultRPCMaxHandlers
+	}
+	queued := o.MaxQueued
+	if queued <= 0 {
+		queued = DefaultRPCMaxQueued
+	}
+
+	r := &RPC{
+		codec:    codec,
+		handler:  handler,
+		handlers: make(chan struct{}, max),
+		queue:    make(chan rpcIncoming, queued),
+		busy:     make(chan uint64, rpcMaxBusyReplies),
+		clock:    clockOrReal(o.Clock),
+		pending:  make(map[uint64]chan rpcResult),
+		done:     make(chan struct{}),
+	}
+	go r.readLoop()
+	go r.dispatch()
+	go r.busyLoop()
+	return r
+}
+
+// Call - send request and wait for response.
+//
+// If timeout is zero, Call waits until response arrives or RPC is closed.
+func (r *RPC) Call(req []byte, timeout time.Duration) ([]byte, error) {
+	ch := make(chan rpcResult, 1)
+
+	r.mu.Lock()
+	if r.err != nil {
+		err := r.err
+		r.mu.Unlock()
+		return nil, err
+	}
+	r.lastID++
+	id := r.lastID
+	r.pending[id] = ch
+	r.mu.Unlock()
+
+	if err := r.send(rpcRequest, id, req); err != nil {
+		r.forget(id)
+		return nil, fmt.Errorf("rpc call fail: %v\n", err)
+	}
+
+	var expired chan struct{}
+	if timeout > 0 {
+		expired = make(chan struct{})
+		stop := r.clock.AfterFunc(timeout, func() { close(expired) })
+		defer stop()
+	}
+
+	select {
+	case res := <-ch:
+		return res.body, res.err
+	case <-expired:
+		r.forget(id)
+		return nil, ErrRPCTimeout
+	}
+}
+
+// Done - return channel which is closed when RPC stops serving connection.
+func (r *RPC) Done() <-chan struct{} {
+	return r.done
+}
+
+// Err - return reason why RPC stopped, or nil while it is serving.
+func (r *RPC) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Close - stop RPC and fail all in-flight calls.
+//
+// Close doesn't close underlying connection.
+func (r *RPC) Close() error {
+	r.fail(ErrRPCClosed)
+	return nil
+}
+
+func (r *RPC) forget(id uint64) {
+	r.mu.Lock()
+	delete(r.pending, id)
+	r.mu.Unlock()
+}
+
+func (r *RPC) send(kind byte, id uint64, body []byte) error {
+	msg := make([]byte, rpcHeaderSize+len(body))
+	msg[0] = kind
+	binary.BigEndian.PutUint64(msg[1:], id)
+	copy(msg[rpcHeaderSize:], body)
+	return r.codec.WriteMessage(msg)
+}
+
+// fail - stop RPC with error and wake all waiting calls.
+func (r *RPC) fail(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	r.err = err
+	for id, ch := range r.pending {
+		ch <- rpcResult{err: err}
+		delete(r.pending, id)
+	}
+	close(r.done)
+}
+
+func (r *RPC) readLoop() {
+	for {
+		msg, err := r.codec.ReadMessage()
+		if err != nil {
+			r.fail(err)
+			return
+		}
+		if len(msg) < rpcHeaderSize {
+			r.fail(fmt.Errorf("malformed rpc message\n"))
+			return
+		}
+
+		select {
+		case <-r.done:
+			return
+		default:
+		}
+
+		kind := msg[0]
+		id := binary.BigEndian.Uint64(msg[1:])
+		body := msg[rpcHeaderSize:]
+
+		switch kind {
+		case rpcRequest:
+			select {
+			case r.queue <- rpcIncoming{id: id, body: body}:
+			default:
+				select {
+				case r.busy <- id:
+				default:
+					// peer doesn't read replies either, drop it
+				}
+			}
+		case rpcResponse, rpcError:
+			r.mu.Lock()
+			ch, ok := r.pending[id]
+			delete(r.pending, id)
+			r.mu.Unlock()
+			if !ok {
+				// late response for timed out call
+				continue
+			}
+			if kind == rpcError {
+				ch <- rpcResult{err: &RPCError{Message: string(body)}}
+			} else {
+				ch <- rpcResult{body: body}
+			}
+		default:
+			r.fail(fmt.Errorf("unknown rpc message kind %d\n", kind))
+			return
+		}
+	}
+}
+
+// dispatch - start handlers for queued requests, as handler slots become
+// free. It runs apart from readLoop, so responses to calls made by
+// handlers are delivered while requests wait.
+func (r *RPC) dispatch() {
+	for {
+		// slot first, so waiting request stays counted in queue
+		select {
+		case r.handlers <- struct{}{}:
+		case <-r.done:
+			return
+		}
+		select {
+		case req := <-r.queue:
+			go r.serve(req.id, req.body)
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// busyLoop - answer requests rejected by readLoop with ErrRPCBusy.
+func (r *RPC) busyLoop() {
+	for {
+		select {
+		case id := <-r.busy:
+			r.send(rpcError, id, []byte(ErrRPCBusy.Error()))
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// serve - run handler for request and send its result back.
+//
+// Handler panic is reported to caller as error instead of crashing
+// process.
+func (r *RPC) serve(id uint64, req []byte) {
+	defer func() { <-r.handlers }()
+	defer func() {
+		if p := recover(); p != nil {
+			r.send(rpcError, id, []byte(fmt.Sprintf("handler panic: %v", p)))
+		}
+	}()
+
+	if r.handler == nil {
+		r.send(rpcError, id, []byte("no rpc handler"))
+		return
+	}
+
+	resp, err := r.handler(req)
+	if err != nil {
+		r.send(rpcError, id, []byte(err.Error()))
+		return
+	}
+	r.send(rpcResponse, id, resp)
+}
@@ -0,0 +1,302 @@
+package herots
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iu0v1/herots/herotstest"
+)
+
+func TestRPC(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	release := make(chan struct{})
+	server := NewRPC(b, func(req []byte) ([]byte, error) {
+		switch string(req) {
+		case "fail":
+			return nil, errors.New("boom")
+		case "slow":
+			<-release
+		}
+		return append([]byte("re: "), req...), nil
+	})
+	defer server.Close()
+	client := NewRPC(a, nil)
+	defer client.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := fmt.Sprintf("call %d", i)
+			resp, err := client.Call([]byte(req), time.Second)
+			if err != nil {
+				t.Errorf("call %d:\n%v\n", i, err)
+				return
+			}
+			if string(resp) != "re: "+req {
+				t.Errorf("call %d: unexpected response %q\n", i, resp)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	_, err := client.Call([]byte("fail"), time.Second)
+	if e, ok := err.(*RPCError); !ok || e.Message != "boom" {
+		t.Fatalf("expected remote error, got %v\n", err)
+	}
+
+	if _, err := client.Call([]byte("slow"), 50*time.Millisecond); err != ErrRPCTimeout {
+		t.Fatalf("expected timeout, got %v\n", err)
+	}
+	close(release)
+
+	// handler-less side answers with error
+	if _, err := server.Call([]byte("x"), time.Second); err == nil {
+		t.Fatalf("expected error from side without handler\n")
+	}
+}
+
+func TestRPCClose(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+
+	client := NewRPC(a, nil)
+	go func() {
+		// other side never answers
+		NewCodec(b).ReadMessage()
+		a.Close()
+	}()
+
+	if _, err := client.Call([]byte("x"), 0); err == nil {
+		t.Fatalf("expected error after connection close\n")
+	}
+	<-client.Done()
+	if _, err := client.Call([]byte("x"), 0); err == nil {
+		t.Fatalf("expected error on stopped rpc\n")
+	}
+}
+
+func TestRPCFakeClockTimeout(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	clock := herotstest.NewFakeClock(time.Now())
+	client := NewRPCWithOptions(NewCodec(a), nil, &RPCOptions{Clock: clock})
+	defer client.Close()
+	// other side reads requests and never answers
+	go io.Copy(io.Discard, b)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Call([]byte("x"), time.Hour)
+		done <- err
+	}()
+
+	waitFor(t, "call timer", func() bool { return clock.Pending() == 1 })
+	clock.Advance(59 * time.Minute)
+	select {
+	case err := <-done:
+		t.Fatalf("call finished before timeout: %v\n", err)
+	default:
+	}
+
+	clock.Advance(time.Minute)
+	if err := <-done; err != ErrRPCTimeout {
+		t.Fatalf("expected timeout, got %v\n", err)
+	}
+}
+
+func TestRPCHandlerLimit(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	var (
+		mu      sync.Mutex
+		running int
+		peak    int
+	)
+	release := make(chan struct{})
+	server := NewRPCWithOptions(NewCodec(b), func(req []byte) ([]byte, error) {
+		if string(req) == "panic" {
+			panic("oops")
+		}
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+		<-release
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return req, nil
+	}, &RPCOptions{MaxHandlers: 2})
+	defer server.Close()
+	client := NewRPC(a, nil)
+	defer client.Close()
+
+	if _, err := client.Call([]byte("panic"), time.Second); err == nil {
+		t.Fatalf("expected error from panicked handler\n")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.Call([]byte("x"), 5*time.Second); err != nil {
+				t.Errorf("call:\n%v\n", err)
+			}
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if peak > 2 {
+		t.Fatalf("handler limit exceeded: %d running\n", peak)
+	}
+}
+
+func TestRPCNestedCalls(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	// every handler of server calls back client before answering; with
+	// all handler slots taken, responses must still be read
+	var server *RPC
+	server = NewRPCWithOptions(NewCodec(b), func(req []byte) ([]byte, error) {
+		return server.Call(req, 5*time.Second)
+	}, &RPCOptions{MaxHandlers: 1})
+	defer server.Close()
+	client := NewRPC(a, func(req []byte) ([]byte, error) {
+		return append([]byte("re: "), req...), nil
+	})
+	defer client.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := fmt.Sprintf("call %d", i)
+			resp, err := client.Call([]byte(req), 5*time.Second)
+			if err != nil || string(resp) != "re: "+req {
+				t.Errorf("nested call %d: %q, %v\n", i, resp, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestRPCBusy(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	release := make(chan struct{})
+	server := NewRPCWithOptions(NewCodec(b), func(req []byte) ([]byte, error) {
+		<-release
+		return req, nil
+	}, &RPCOptions{MaxHandlers: 1, MaxQueued: 1})
+	defer server.Close()
+	client := NewRPC(a, nil)
+	defer client.Close()
+
+	// one request runs, one waits in queue
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := client.Call([]byte("x"), 5*time.Second)
+			errs <- err
+		}()
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	_, err := client.Call([]byte("x"), 5*time.Second)
+	var rerr *RPCError
+	if !errors.Is(err, ErrRPCBusy) || !errors.As(err, &rerr) {
+		t.Fatalf("expected ErrRPCBusy, got %v\n", err)
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("queued call:\n%v\n", err)
+		}
+	}
+}
+
+func TestRPCBusyPeerNotReading(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	release := make(chan struct{})
+	defer close(release)
+	server := NewRPCWithOptions(NewCodec(b), func(req []byte) ([]byte, error) {
+		<-release
+		return req, nil
+	}, &RPCOptions{MaxHandlers: 1, MaxQueued: 1})
+	defer server.Close()
+
+	// peer floods requests and never reads replies; writes to pipe block
+	// unless server keeps reading
+	written := make(chan error, 1)
+	go func() {
+		codec := NewCodec(a)
+		msg := make([]byte, rpcHeaderSize)
+		msg[0] = rpcRequest
+		for i := 0; i < 2+rpcMaxBusyReplies*2; i++ {
+			binary.BigEndian.PutUint64(msg[1:], uint64(i+1))
+			if err := codec.WriteMessage(msg); err != nil {
+				written <- err
+				return
+			}
+		}
+		written <- nil
+	}()
+
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatalf("write request:\n%v\n", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("server stopped reading requests of peer which doesn't read\n")
+	}
+}
+
+func TestCodecErrors(t *testing.T) {
+	a, b := net.Pipe()
+
+	go func() {
+		// header announces 10 bytes, only 3 are sent
+		b.Write([]byte{0, 0, 0, 10, 1, 2, 3})
+		b.Close()
+	}()
+	_, err := NewCodec(a).ReadMessage()
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected wrapped io.ErrUnexpectedEOF, got %v\n", err)
+	}
+
+	a, b = net.Pipe()
+	b.Close()
+	if _, err := NewCodec(a).ReadMessage(); err != io.EOF {
+		t.Fatalf("expected io.EOF between messages, got %v\n", err)
+	}
+}
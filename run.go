@@ -0,0 +1,91 @@
+package herots
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// maxAcceptBackoff - upper limit of delay between retries of failed
+// accept in Run.
+const maxAcceptBackoff = time.Second
+
+// Run - start server and serve connections with Options.Handler until ctx
+// is done or process gets SIGINT or SIGTERM, then gracefully shut server
+// down (see Shutdown) and return once handlers are finished. Operational
+// control signals are handled meanwhile (see Options.Signals).
+//
+// Run returns nil after shutdown, or error of Start or Shutdown. Failed
+// handshakes (with Options.HandshakeOnAccept) and transient accept errors
+// are logged and don't stop serving.
+func (s *Server) Run(ctx context.Context) error {
+	if s.options.Handler == nil {
+		return fmt.Errorf("run tls server fail: no Options.Handler\n")
+	}
+	if err := s.Start(); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	defer s.HandleSignals()()
+
+	var handlers sync.WaitGroup
+	accepting := make(chan struct{})
+	go func() {
+		defer close(accepting)
+		s.serve(&handlers)
+	}()
+
+	select {
+	case <-ctx.Done():
+	case <-accepting:
+	}
+	s.logger.Log("run: stopping", LogLevelNotice)
+
+	err := s.Shutdown()
+	<-accepting
+	handlers.Wait()
+
+	return err
+}
+
+// serve - accept connections and start handlers until server is closed.
+func (s *Server) serve(handlers *sync.WaitGroup) {
+	var backoff time.Duration
+	for {
+		c, err := s.AcceptConn()
+		if err != nil {
+			var herr *HandshakeError
+			switch {
+			case errors.Is(err, ErrServerClosed):
+				return
+			case errors.As(err, &herr):
+				s.logger.Log(err.Error(), LogLevelInfo)
+				continue
+			}
+
+			if backoff == 0 {
+				backoff = 5 * time.Millisecond
+			} else {
+				backoff = min(2*backoff, maxAcceptBackoff)
+			}
+			s.logger.Log(fmt.Sprintf("%vretrying in %v", err, backoff), LogLevelError)
+			time.Sleep(backoff)
+			continue
+		}
+		backoff = 0
+
+		handlers.Add(1)
+		go func() {
+			defer handlers.Done()
+			defer c.Close()
+			s.options.Handler(c)
+		}()
+	}
+}
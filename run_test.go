@@ -0,0 +1,76 @@
+package herots
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	cert, key := genKeyPair(t, "herots test")
+	handled := make(chan struct{})
+	o := &Options{
+		Host: "127.0.0.1",
+		Port: freePort(t),
+		Handler: func(c *Conn) {
+			defer close(handled)
+			io.Copy(c, c)
+		},
+		ShutdownGracePeriod: 5 * time.Second,
+	}
+	s := NewServer(o)
+	if err := s.LoadKeyPair(cert, key); err != nil {
+		t.Fatalf("server load key pair:\n%v\n", err)
+	}
+	c := NewClient(&Options{Host: o.Host, Port: o.Port})
+	if err := c.LoadKeyPair(cert, key); err != nil {
+		t.Fatalf("client load key pair:\n%v\n", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+
+	var conn io.ReadWriteCloser
+	for i := 0; ; i++ {
+		var err error
+		if conn, err = c.Dial(); err == nil {
+			break
+		}
+		if i == 50 {
+			t.Fatalf("dial:\n%v\n", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 4)
+	conn.Write([]byte("ping"))
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("echo: %q, %v\n", buf, err)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("run:\n%v\n", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("run not stopped after context cancellation\n")
+	}
+	select {
+	case <-handled:
+	default:
+		t.Fatalf("run returned before handler finished\n")
+	}
+}
+
+func TestRunNoHandler(t *testing.T) {
+	s := NewServer(&Options{})
+	if err := s.Run(context.Background()); err == nil {
+		t.Fatalf("run without handler must fail\n")
+	}
+}
@@ -0,0 +1,140 @@
+package herots
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"strings"
+)
+
+// ParseSANs - function for classify subject alternative names by syntax:
+// IP address, URI ("scheme://..." or "urn:...", e.g.
+// "spiffe://swarm/agent"), email address (with "@") or DNS name otherwise,
+// including wildcard ("*.nodes.example.com"). Every name is validated, see
+// IssueRequest.
+func ParseSANs(sans ...string) (dns []string, ips []net.IP, uris []*url.URL, emails []string, err error) {
+	for _, san := range sans {
+		switch {
+		case net.ParseIP(san) != nil:
+			ips = append(ips, net.ParseIP(san))
+		case strings.Contains(san, "://") || strings.HasPrefix(san, "urn:"):
+			u, err := url.Parse(san)
+			if err != nil {
+				return nil, nil, nil, nil, fmt.Errorf("bad URI SAN %q: %v", san, err)
+			}
+			uris = append(uris, u)
+		case strings.Contains(san, "@"):
+			emails = append(emails, san)
+		default:
+			dns = append(dns, san)
+		}
+	}
+	r := &IssueRequest{DNSNames: dns, IPAddresses: ips, URIs: uris, EmailAddresses: emails}
+	if err := r.checkSANs(); err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return dns, ips, uris, emails, nil
+}
+
+// sanCount - number of subject alternative names of request.
+func (r *IssueRequest) sanCount() int {
+	return len(r.DNSNames) + len(r.IPAddresses) + len(r.URIs) + len(r.EmailAddresses)
+}
+
+// checkSANs - validate syntax of subject alternative names.
+func (r *IssueRequest) checkSANs() error {
+	for _, name := range r.DNSNames {
+		if err := checkDNSName(name, true); err != nil {
+			return fmt.Errorf("bad DNS SAN %q: %v", name, err)
+		}
+	}
+	for _, ip := range r.IPAddresses {
+		if len(ip) != net.IPv4len && len(ip) != net.IPv6len {
+			return fmt.Errorf("bad IP SAN %v", ip)
+		}
+	}
+	for _, u := range r.URIs {
+		if err := checkURI(u); err != nil {
+			return fmt.Errorf("bad URI SAN %q: %v", u, err)
+		}
+	}
+	for _, email := range r.EmailAddresses {
+		if err := checkEmail(email); err != nil {
+			return fmt.Errorf("bad email SAN %q: %v", email, err)
+		}
+	}
+	return nil
+}
+
+// checkDNSName - check host name syntax (RFC 1035 labels; underscores are
+// allowed as used by service names). Wildcard, if allowed, may only be
+// whole leftmost label and must be followed by at least two labels.
+func checkDNSName(name string, wildcard bool) error {
+	if name == "" {
+		return fmt.Errorf("empty name")
+	}
+	if len(name) > 253 {
+		return fmt.Errorf("name longer than 253 characters")
+	}
+	labels := strings.Split(name, ".")
+	if labels[0] == "*" {
+		if !wildcard {
+			return fmt.Errorf("wildcard not allowed")
+		}
+		if len(labels) < 3 {
+			return fmt.Errorf("wildcard must be followed by at least two labels")
+		}
+		labels = labels[1:]
+	}
+	for _, label := range labels {
+		if label == "" {
+			return fmt.Errorf("empty label")
+		}
+		if len(label) > 63 {
+			return fmt.Errorf("label %q longer than 63 characters", label)
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("label %q starts or ends with hyphen", label)
+		}
+		for _, ch := range label {
+			if !('a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || '0' <= ch && ch <= '9' || ch == '-' || ch == '_') {
+				return fmt.Errorf("invalid character %q", ch)
+			}
+		}
+	}
+	return nil
+}
+
+// checkURI - URI SAN must be absolute and have host (or opaque part, for
+// URNs); userinfo is refused, as it may carry credentials.
+func checkURI(u *url.URL) error {
+	switch {
+	case u == nil:
+		return fmt.Errorf("nil URI")
+	case u.Scheme == "":
+		return fmt.Errorf("no scheme")
+	case u.User != nil:
+		return fmt.Errorf("userinfo not allowed")
+	case u.Host == "" && u.Opaque == "":
+		return fmt.Errorf("no host")
+	}
+	if u.Host != "" && net.ParseIP(u.Hostname()) == nil {
+		return checkDNSName(u.Hostname(), false)
+	}
+	return nil
+}
+
+// checkEmail - email SAN must be bare address (RFC 5322 addr-spec) with
+// valid domain.
+func checkEmail(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil {
+		return err
+	}
+	if addr.Name != "" || addr.Address != email {
+		return fmt.Errorf("not a bare address")
+	}
+	domain := email[strings.LastIndex(email, "@")+1:]
+	return checkDNSName(domain, false)
+}
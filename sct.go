@@ -0,0 +1,73 @@
+package herots
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/pem"
+	"fmt"
+	"os"
+)
+
+// sctMinSize - size of v1 SCT (RFC 6962) with empty extensions and
+// signature: version, log id, timestamp, extensions length, hash and
+// signature algorithms, signature length.
+const sctMinSize = 1 + 32 + 8 + 2 + 2 + 2
+
+// SetSCTs - function for set Signed Certificate Timestamps, which are sent
+// to clients during handshake for key pair with given PEM encoded
+// certificate (loaded by LoadKeyPair or AddKeyPair).
+//
+// Each SCT is serialized SignedCertificateTimestamp structure (RFC 6962),
+// as issued by CT log. SCTs embedded in certificate itself need no setup:
+// clients read them from certificate. SetSCTs may be called on running
+// server; nil scts removes previously set ones.
+func (s *Server) SetSCTs(cert []byte, scts [][]byte) error {
+	for i, sct := range scts {
+		if len(sct) < sctMinSize || sct[0] != 0 {
+			return fmt.Errorf("set SCTs error: SCT #%d is not valid v1 SCT\n", i)
+		}
+	}
+
+	pemData, _ := pem.Decode(cert)
+	if pemData == nil {
+		return fmt.Errorf("set SCTs error: no PEM data\n")
+	}
+
+	s.certMu.Lock()
+	defer s.certMu.Unlock()
+
+	c := s.keyPairByCertLocked(pemData.Bytes)
+	if c == nil {
+		return fmt.Errorf("set SCTs error: certificate of no loaded key pair\n")
+	}
+	c.SignedCertificateTimestamps = scts
+	s.publishClientCAsLocked(s.clientCAs.Load())
+
+	s.logger.Log(fmt.Sprintf("set SCTs - ok (%d SCTs)", len(scts)), LogLevelInfo)
+
+	return nil
+}
+
+// LoadSCTFiles - same as SetSCTs, reading each SCT from file (e.g. .sct
+// files of CT submission tools).
+func (s *Server) LoadSCTFiles(cert []byte, paths ...string) error {
+	scts := make([][]byte, 0, len(paths))
+	for _, path := range paths {
+		sct, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("load SCT files error: %v\n", err)
+		}
+		scts = append(scts, sct)
+	}
+	return s.SetSCTs(cert, scts)
+}
+
+// keyPairByCertLocked - loaded key pair with given DER leaf certificate.
+func (s *Server) keyPairByCertLocked(der []byte) *tls.Certificate {
+	for _, c := range s.keyPairsLocked() {
+		if bytes.Equal(c.Certificate[0], der) {
+			return c
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package herots
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSCTs(t *testing.T) {
+	s, c := startTestServer(t, &Options{})
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.certs.Cert.Certificate[0]})
+
+	if err := s.SetSCTs(cert, [][]byte{{1, 2, 3}}); err == nil {
+		t.Fatalf("malformed SCT accepted\n")
+	}
+	other, _ := genKeyPair(t, "other")
+	if err := s.SetSCTs(other, nil); err == nil {
+		t.Fatalf("SCTs for unknown certificate accepted\n")
+	}
+
+	sct := make([]byte, sctMinSize)
+	sct[1] = 0xaa
+	path := filepath.Join(t.TempDir(), "log.sct")
+	os.WriteFile(path, sct, 0600)
+	if err := s.LoadSCTFiles(cert, path); err != nil {
+		t.Fatalf("load SCT files:\n%v\n", err)
+	}
+
+	go func() {
+		if conn, err := s.AcceptConn(); err == nil {
+			conn.Handshake()
+			conn.Close()
+		}
+	}()
+	conn, err := tls.Dial("tcp", s.listener.Addr().String(), c.tlsConfig())
+	if err != nil {
+		t.Fatalf("dial:\n%v\n", err)
+	}
+	defer conn.Close()
+
+	scts := conn.ConnectionState().SignedCertificateTimestamps
+	if len(scts) != 1 || string(scts[0]) != string(sct) {
+		t.Fatalf("SCTs not sent to client: %x\n", scts)
+	}
+}
@@ -0,0 +1,174 @@
+package herots
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// File names of mounted Kubernetes TLS secret (kubernetes.io/tls type);
+// Docker secrets and cert-manager use the same names.
+const (
+	SecretCertFile = "tls.crt"
+	SecretKeyFile  = "tls.key"
+	SecretCAFile   = "ca.crt"
+)
+
+// secretDataLink - symlink, which kubelet atomically switches to new
+// timestamped directory on secret update.
+const secretDataLink = "..data"
+
+// DefaultSecretPollInterval - default interval of secret directory checks.
+const DefaultSecretPollInterval = 10 * time.Second
+
+// SecretDirOptions - structure, which is used to configure
+// Server.WatchSecretDir.
+type SecretDirOptions struct {
+	// Interval - how often directory is checked for changes. Checks are
+	// cheap: with kubelet layout only "..data" link is read.
+	//
+	// Default: DefaultSecretPollInterval (10s).
+	Interval time.Duration
+
+	// OnReload - function, which is called after each reload attempt
+	// caused by change of directory, with its error (nil on success).
+	OnReload func(err error)
+}
+
+// LoadSecretDir - function for load key pair and trusted client CAs from
+// directory with mounted Kubernetes TLS secret or Docker secrets: tls.crt,
+// tls.key and optional ca.crt.
+//
+// If ca.crt exists, it replaces client CA pool, otherwise pool is reset
+// as with LoadKeyPair. Key pair and pool are
+// swapped together, so no handshake sees one without the other.
+func (s *Server) LoadSecretDir(dir string) error {
+	cert, err := os.ReadFile(filepath.Join(dir, SecretCertFile))
+	if err != nil {
+		return fmt.Errorf("load secret dir error: %v\n", err)
+	}
+	key, err := os.ReadFile(filepath.Join(dir, SecretKeyFile))
+	if err != nil {
+		return fmt.Errorf("load secret dir error: %v\n", err)
+	}
+
+	c, own, err := s.options.loadKeyPair(cert, key)
+	if err != nil {
+		return fmt.Errorf("%s: %v\n", LoadKeyPairError, err)
+	}
+
+	var cas []*x509.Certificate
+	data, err := os.ReadFile(filepath.Join(dir, SecretCAFile))
+	switch {
+	case err == nil:
+		if cas, err = parseCACerts(data); err != nil {
+			return fmt.Errorf("load secret dir error: %s: %v\n", SecretCAFile, err)
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("load secret dir error: %v\n", err)
+	}
+
+	s.certMu.Lock()
+	s.certs.Cert = c
+	s.certs.Extra = nil
+	if cas == nil {
+		cas = s.reloadClientCAsLocked(own)
+	}
+	s.setClientCAsLocked(cas)
+	s.certMu.Unlock()
+
+	s.logger.Log("load secret dir "+dir+" - ok", LogLevelInfo)
+	s.reapplyTrustBundles()
+
+	return nil
+}
+
+// WatchSecretDir - load secret directory (see LoadSecretDir) and keep
+// reloading it on change, until returned stop function is called.
+//
+// Kubelet updates mounted secret by writing new timestamped directory and
+// atomically switching "..data" symlink to it; change of the link target
+// triggers reload. Without such link (Docker secrets, plain directory)
+// contents of files are compared instead. Failed reload (e.g. of half
+// updated plain directory) is reported to Errors and retried on next
+// check; server keeps current certificates meanwhile.
+//
+// Directory is polled: go standard library has no portable file change
+// notifications.
+func (s *Server) WatchSecretDir(dir string, o *SecretDirOptions) (stop func(), err error) {
+	var opts SecretDirOptions
+	if o != nil {
+		opts = *o
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultSecretPollInterval
+	}
+
+	version, err := secretDirVersion(dir)
+	if err != nil {
+		return nil, fmt.Errorf("load secret dir error: %v\n", err)
+	}
+	if err := s.LoadSecretDir(dir); err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		tick, stopTicker := s.clock.NewTicker(opts.Interval)
+		defer stopTicker()
+		for {
+			select {
+			case <-tick:
+			case <-done:
+				return
+			}
+
+			v, err := secretDirVersion(dir)
+			if err == nil && v == version {
+				continue
+			}
+			if err == nil {
+				err = s.LoadSecretDir(dir)
+			}
+			if err != nil {
+				s.reportError("secret reload", nil, err)
+			} else {
+				version = v
+				s.logger.Log("secret dir "+dir+" changed, certs reloaded", LogLevelNotice)
+			}
+			if opts.OnReload != nil {
+				opts.OnReload(err)
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }, nil
+}
+
+// secretDirVersion - return string, which changes whenever contents of
+// secret directory change: target of kubelet "..data" link if it exists,
+// digest of files otherwise.
+func secretDirVersion(dir string) (string, error) {
+	if target, err := os.Readlink(filepath.Join(dir, secretDataLink)); err == nil {
+		return target, nil
+	}
+
+	h := sha256.New()
+	for _, name := range []string{SecretCertFile, SecretKeyFile, SecretCAFile} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil && !(name == SecretCAFile && errors.Is(err, fs.ErrNotExist)) {
+			return "", err
+		}
+		h.Write([]byte(name))
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
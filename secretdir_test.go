@@ -0,0 +1,98 @@
+package herots
+
+import (
+	"bytes"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeSecret - write secret files into new timestamped directory of dir
+// and switch "..data" link to it, as kubelet does.
+func writeSecret(t *testing.T, dir, version string, files map[string][]byte) {
+	data := filepath.Join(dir, "..2026_10_14_"+version)
+	if err := os.Mkdir(data, 0700); err != nil {
+		t.Fatalf("mkdir:\n%v\n", err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(data, name), content, 0600); err != nil {
+			t.Fatalf("write %s:\n%v\n", name, err)
+		}
+		link := filepath.Join(dir, name)
+		if _, err := os.Lstat(link); err != nil {
+			if err := os.Symlink(filepath.Join(secretDataLink, name), link); err != nil {
+				t.Skipf("symlinks not supported: %v\n", err)
+			}
+		}
+	}
+
+	tmp := filepath.Join(dir, "..data_tmp")
+	if err := os.Symlink(filepath.Base(data), tmp); err != nil {
+		t.Skipf("symlinks not supported: %v\n", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, secretDataLink)); err != nil {
+		t.Fatalf("switch data link:\n%v\n", err)
+	}
+}
+
+func TestWatchSecretDir(t *testing.T) {
+	dir := t.TempDir()
+	cert, key := genKeyPair(t, "herots test")
+	ca, _ := genKeyPair(t, "herots CA")
+	writeSecret(t, dir, "1", map[string][]byte{SecretCertFile: cert, SecretKeyFile: key})
+
+	reloaded := make(chan error, 1)
+	s := NewServer(&Options{})
+	stop, err := s.WatchSecretDir(dir, &SecretDirOptions{
+		Interval: 10 * time.Millisecond,
+		OnReload: func(err error) { reloaded <- err },
+	})
+	if err != nil {
+		t.Fatalf("watch secret dir:\n%v\n", err)
+	}
+	defer stop()
+
+	if n := len(s.clientCAList); n != 1 {
+		t.Fatalf("without ca.crt pool must hold own cert, got %d certs\n", n)
+	}
+
+	cert, key = genKeyPair(t, "herots test")
+	writeSecret(t, dir, "2", map[string][]byte{SecretCertFile: cert, SecretKeyFile: key, SecretCAFile: ca})
+	select {
+	case err := <-reloaded:
+		if err != nil {
+			t.Fatalf("reload:\n%v\n", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("rotation not detected\n")
+	}
+
+	s.certMu.Lock()
+	defer s.certMu.Unlock()
+	block, _ := pem.Decode(cert)
+	if !bytes.Equal(s.certs.Cert.Certificate[0], block.Bytes) {
+		t.Fatalf("rotated cert not loaded\n")
+	}
+	block, _ = pem.Decode(ca)
+	if len(s.clientCAList) != 1 || !bytes.Equal(s.clientCAList[0].Raw, block.Bytes) {
+		t.Fatalf("client CA pool not replaced by ca.crt\n")
+	}
+}
+
+func TestSecretDirVersionPlain(t *testing.T) {
+	dir := t.TempDir()
+	cert, key := genKeyPair(t, "herots test")
+	os.WriteFile(filepath.Join(dir, SecretCertFile), cert, 0600)
+	os.WriteFile(filepath.Join(dir, SecretKeyFile), key, 0600)
+
+	v1, err := secretDirVersion(dir)
+	if err != nil {
+		t.Fatalf("version:\n%v\n", err)
+	}
+	os.WriteFile(filepath.Join(dir, SecretCAFile), cert, 0600)
+	if v2, _ := secretDirVersion(dir); v2 == v1 {
+		t.Fatalf("version not changed with contents\n")
+	}
+}
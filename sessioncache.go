@@ -0,0 +1,310 @@
+package herots
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DefaultClientSessionCacheSize - default capacity of client session
+// cache, used when only Options.ClientSessionCacheFile is set.
+const DefaultClientSessionCacheSize = 64
+
+// sessionCacheMagic - header of session cache file.
+const sessionCacheMagic = "HSC1"
+
+// lruCache - size limited map, which evicts least recently used entries.
+type lruCache[V any] struct {
+	capacity int
+
+	mu sync.Mutex
+	ll *list.List
+	m  map[string]*list.Element
+}
+
+type lruEntry[V any] struct {
+	key   string
+	value V
+}
+
+func newLRUCache[V any](capacity int) *lruCache[V] {
+	return &lruCache[V]{
+		capacity: capacity,
+		ll:       list.New(),
+		m:        make(map[string]*list.Element),
+	}
+}
+
+func (c *lruCache[V]) get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.m[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*lruEntry[V]).value, true
+}
+
+func (c *lruCache[V]) put(key string, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.m[key]; ok {
+		e.Value.(*lruEntry[V]).value = value
+		c.ll.MoveToFront(e)
+		return
+	}
+
+	c.insertLocked(key, value)
+}
+
+// putIfAbsent - store value only if key isn't cached; report whether it
+// was stored. Check and insert are atomic.
+func (c *lruCache[V]) putIfAbsent(key string, value V) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.m[key]; ok {
+		c.ll.MoveToFront(e)
+		return false
+	}
+	c.insertLocked(key, value)
+	return true
+}
+
+func (c *lruCache[V]) insertLocked(key string, value V) {
+	c.m[key] = c.ll.PushFront(&lruEntry[V]{key: key, value: value})
+	if c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.m, oldest.Value.(*lruEntry[V]).key)
+	}
+}
+
+func (c *lruCache[V]) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.m[key]; ok {
+		c.ll.Remove(e)
+		delete(c.m, key)
+	}
+}
+
+// removeFunc - remove entries, for which f returns true.
+func (c *lruCache[V]) removeFunc(f func(key string, value V) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, e := range c.m {
+		if f(key, e.Value.(*lruEntry[V]).value) {
+			c.ll.Remove(e)
+			delete(c.m, key)
+		}
+	}
+}
+
+func (c *lruCache[V]) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+func (c *lruCache[V]) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	clear(c.m)
+}
+
+// each - call f for entries from oldest to newest.
+func (c *lruCache[V]) each(f func(key string, value V)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for e := c.ll.Back(); e != nil; e = e.Prev() {
+		entry := e.Value.(*lruEntry[V])
+		f(entry.key, entry.value)
+	}
+}
+
+// ClientSessionCache - LRU tls.ClientSessionCache, which can be saved to
+// and loaded from file, so sessions survive process restart.
+type ClientSessionCache struct {
+	lru *lruCache[*tls.ClientSessionState]
+}
+
+// NewClientSessionCache - function for create ClientSessionCache with
+// given capacity (DefaultClientSessionCacheSize if capacity < 1).
+func NewClientSessionCache(capacity int) *ClientSessionCache {
+	if capacity < 1 {
+		capacity = DefaultClientSessionCacheSize
+	}
+	return &ClientSessionCache{lru: newLRUCache[*tls.ClientSessionState](capacity)}
+}
+
+// Get - implements tls.ClientSessionCache.
+func (c *ClientSessionCache) Get(key string) (*tls.ClientSessionState, bool) {
+	return c.lru.get(key)
+}
+
+// Put - implements tls.ClientSessionCache; nil state removes entry.
+func (c *ClientSessionCache) Put(key string, cs *tls.ClientSessionState) {
+	if cs == nil {
+		c.lru.remove(key)
+		return
+	}
+	c.lru.put(key, cs)
+}
+
+// Len - return number of cached sessions.
+func (c *ClientSessionCache) Len() int {
+	return c.lru.len()
+}
+
+// Save - write cached sessions to file (atomically, with 0600
+// permissions: sessions contain secrets).
+func (c *ClientSessionCache) Save(path string) error {
+	var buf bytes.Buffer
+	buf.WriteString(sessionCacheMagic)
+
+	// oldest first, so Load restores LRU order
+	c.lru.each(func(key string, cs *tls.ClientSessionState) {
+		ticket, st, err := cs.ResumptionState()
+		if err != nil || st == nil {
+			return
+		}
+		data, err := st.Bytes()
+		if err != nil {
+			return
+		}
+		for _, field := range [][]byte{[]byte(key), ticket, data} {
+			buf.Write(binary.AppendUvarint(nil, uint64(len(field))))
+			buf.Write(field)
+		}
+	})
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".herots-sessions-*")
+	if err != nil {
+		return fmt.Errorf("save session cache fail: %v\n", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("save session cache fail: %v\n", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("save session cache fail: %v\n", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("save session cache fail: %v\n", err)
+	}
+	return nil
+}
+
+// Load - add sessions saved by Save to cache. Expired sessions are loaded
+// too; crypto/tls skips them on use.
+func (c *ClientSessionCache) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("load session cache fail: %w\n", err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	magic := make([]byte, len(sessionCacheMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != sessionCacheMagic {
+		return fmt.Errorf("load session cache fail: not a session cache file\n")
+	}
+
+	readField := func() ([]byte, error) {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if n > 1<<20 {
+			return nil, errors.New("field too large")
+		}
+		field := make([]byte, n)
+		_, err = io.ReadFull(r, field)
+		return field, err
+	}
+
+	for {
+		key, err := readField()
+		if err == io.EOF {
+			return nil
+		}
+		var ticket, data []byte
+		if err == nil {
+			ticket, err = readField()
+		}
+		if err == nil {
+			data, err = readField()
+		}
+		if err != nil {
+			return fmt.Errorf("load session cache fail: %v\n", err)
+		}
+
+		st, err := tls.ParseSessionState(data)
+		if err != nil {
+			continue
+		}
+		cs, err := tls.NewResumptionState(ticket, st)
+		if err != nil {
+			continue
+		}
+		c.Put(string(key), cs)
+	}
+}
+
+// sessionIDSize - size of cache key sent to clients by server-side
+// session cache.
+const sessionIDSize = 32
+
+// wrapSession - store session state in server-side cache and return its
+// key as ticket (see Options.SessionCacheSize).
+func (s *Server) wrapSession(_ tls.ConnectionState, st *tls.SessionState) ([]byte, error) {
+	data, err := st.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, sessionIDSize)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	s.sessions.put(string(id), data)
+	return id, nil
+}
+
+// unwrapSession - look session up in server-side cache; unknown
+// sessions fall back to full handshake.
+func (s *Server) unwrapSession(id []byte, _ tls.ConnectionState) (*tls.SessionState, error) {
+	data, ok := s.sessions.get(string(id))
+	if !ok {
+		return nil, nil
+	}
+	return tls.ParseSessionState(data)
+}
+
+// FlushSessionCache - drop all sessions from server-side session cache
+// (see Options.SessionCacheSize), so clients make full handshake on next
+// connection. Sessions resumed by stateless tickets can't be revoked this
+// way.
+func (s *Server) FlushSessionCache() {
+	if s.sessions != nil {
+		s.sessions.clear()
+		s.logger.Log("flush session cache - ok", LogLevelInfo)
+	}
+}
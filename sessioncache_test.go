@@ -0,0 +1,131 @@
+package herots
+
+import (
+	"crypto/tls"
+	"path/filepath"
+	"testing"
+)
+
+func TestClientSessionCacheLRU(t *testing.T) {
+	cache := NewClientSessionCache(2)
+	st := &tls.ClientSessionState{}
+	cache.Put("a", st)
+	cache.Put("b", st)
+	cache.Get("a")
+	cache.Put("c", st)
+
+	if _, ok := cache.Get("b"); ok {
+		t.Fatalf("least recently used session not evicted\n")
+	}
+	if _, ok := cache.Get("a"); !ok {
+		t.Fatalf("recently used session evicted\n")
+	}
+	cache.Put("a", nil)
+	if cache.Len() != 1 {
+		t.Fatalf("unexpected cache size %d\n", cache.Len())
+	}
+}
+
+func TestClientSessionCachePersistence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "sessions")
+	s, c := startTestServer(t, &Options{})
+	cert, pool := c.certs.Cert, c.certs.Pool
+
+	newClient := func() *Client {
+		c := NewClient(&Options{
+			Host:                   s.options.Host,
+			Port:                   s.options.Port,
+			ClientSessionCacheSize: 8,
+			ClientSessionCacheFile: file,
+		})
+		c.certs.Cert, c.certs.Pool = cert, pool
+		return c
+	}
+
+	c = newClient()
+	if got := acceptResumption(t, s, c.Dial); got != ResumptionNone {
+		t.Fatalf("first connection resumed: %q\n", got)
+	}
+	if got := acceptResumption(t, s, c.Dial); got != ResumptionPSK {
+		t.Fatalf("reconnect not resumed: %q\n", got)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("save session cache:\n%v\n", err)
+	}
+
+	c = newClient()
+	if c.SessionCache().Len() == 0 {
+		t.Fatalf("session cache not loaded from file\n")
+	}
+	if got := acceptResumption(t, s, c.Dial); got != ResumptionPSK {
+		t.Fatalf("connection with restored cache not resumed: %q\n", got)
+	}
+}
+
+func TestServerSessionCache(t *testing.T) {
+	s, c := startTestServer(t, &Options{SessionCacheSize: 1})
+	config := c.tlsConfig()
+	config.ClientSessionCache = tls.NewLRUClientSessionCache(8)
+	dial := func() (*tls.Conn, error) {
+		return tls.Dial("tcp", s.listener.Addr().String(), config)
+	}
+
+	if got := acceptResumption(t, s, dial); got != ResumptionNone {
+		t.Fatalf("first connection resumed: %q\n", got)
+	}
+	if got := acceptResumption(t, s, dial); got != ResumptionPSK {
+		t.Fatalf("reconnect not resumed: %q\n", got)
+	}
+	if n := s.sessions.len(); n != 1 {
+		t.Fatalf("cache size limit not applied: %d sessions\n", n)
+	}
+
+	s.FlushSessionCache()
+	if got := acceptResumption(t, s, dial); got != ResumptionNone {
+		t.Fatalf("flushed session resumed: %q\n", got)
+	}
+}
+
+func TestSessionTicketsDisabled(t *testing.T) {
+	s, c := startTestServer(t, &Options{SessionTicketsDisabled: true, SessionCacheSize: 8})
+	config := c.tlsConfig()
+	config.ClientSessionCache = tls.NewLRUClientSessionCache(8)
+	dial := func() (*tls.Conn, error) {
+		return tls.Dial("tcp", s.listener.Addr().String(), config)
+	}
+
+	for i := 0; i < 2; i++ {
+		if got := acceptResumption(t, s, dial); got != ResumptionNone {
+			t.Fatalf("connection %d resumed: %q\n", i, got)
+		}
+	}
+}
+
+// acceptResumption - connect to s with dial, accept connection and return
+// how its session was established.
+func acceptResumption(t *testing.T, s *Server, dial func() (*tls.Conn, error)) ResumptionMechanism {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, err := dial()
+		if err != nil {
+			t.Errorf("dial:\n%v\n", err)
+			return
+		}
+		// TLS 1.3 tickets arrive after handshake
+		conn.Read(make([]byte, 1))
+		conn.Close()
+	}()
+	conn, err := s.AcceptConn()
+	if err != nil {
+		t.Fatalf("accept:\n%v\n", err)
+	}
+	defer conn.Close()
+	got, err := conn.Resumption()
+	if err != nil {
+		t.Fatalf("handshake:\n%v\n", err)
+	}
+	conn.Write([]byte{0})
+	<-done
+	return got
+}
@@ -0,0 +1,92 @@
+package herots
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// ErrServerClosed - returned by AcceptConn (and Accept) after server was
+// closed.
+var ErrServerClosed = errors.New("server closed")
+
+// drainPollInterval - how often Shutdown checks for remaining connections.
+const drainPollInterval = 20 * time.Millisecond
+
+// Close - stop listening and close all active connections immediately.
+func (s *Server) Close() error {
+	err := s.closeListener()
+	for _, c := range s.Conns() {
+		c.closeWith(CloseReasonShutdown, nil)
+	}
+	s.wipeKeys()
+	return err
+}
+
+// Shutdown - gracefully stop server.
+//
+// Shutdown stops accepting new connections and marks active ones as
+// draining: Options.OnDrain is called for each of them and further reads
+// return io.EOF, so handlers can finish current work and close connection.
+// OnDrain callbacks run concurrently and don't extend grace period:
+// connections still open after Options.ShutdownGracePeriod (counted from
+// the Shutdown call, by Options.Clock) are closed forcibly.
+func (s *Server) Shutdown() error {
+	deadline := s.clock.Now().Add(s.options.ShutdownGracePeriod)
+	err := s.closeListener()
+
+	// connections registered from now on (accepted concurrently with
+	// shutdown) are drained right in register
+	s.connsMu.Lock()
+	s.shuttingDown = true
+	s.connsMu.Unlock()
+
+	conns := s.Conns()
+	s.logger.Log("shutdown: draining "+strconv.Itoa(len(conns))+" conns", LogLevelNotice)
+	for _, c := range conns {
+		c.drain(CloseReasonShutdown)
+	}
+
+	// clock only bounds the wait; closed connections are polled in real
+	// time
+	for len(s.Conns()) > 0 && s.clock.Now().Before(deadline) {
+		time.Sleep(drainPollInterval)
+	}
+
+	if rest := s.Conns(); len(rest) > 0 {
+		s.logger.Log("shutdown: closing "+strconv.Itoa(len(rest))+" conns after grace period", LogLevelNotice)
+		for _, c := range rest {
+			c.closeWith(CloseReasonShutdown, nil)
+		}
+	}
+
+	s.wipeKeys()
+	s.logger.Log("shutdown - ok", LogLevelNotice)
+
+	return err
+}
+
+// closeListener - mark server closed and close listener (once).
+func (s *Server) closeListener() error {
+	ln := s.currentListener()
+	if s.closed.Swap(true) || ln == nil {
+		return nil
+	}
+	removeListener(ln)
+	return ln.Close()
+}
+
+// drain - mark connection as draining for given reason, interrupt
+// pending read and notify handler (in background, so slow callback delays
+// nothing else).
+func (c *Conn) drain(reason CloseReason) {
+	c.setCause(reason, nil)
+	if c.draining.Swap(true) {
+		return
+	}
+	// interrupts both pending read and rate limiter wait
+	c.SetReadDeadline(time.Now())
+	if c.server.options.OnDrain != nil {
+		go c.server.options.OnDrain(c)
+	}
+}
@@ -0,0 +1,161 @@
+package herots
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/iu0v1/herots/herotstest"
+)
+
+func TestShutdownDrain(t *testing.T) {
+	drained := make(chan uint64, 1)
+	s, c := startTestServer(t, &Options{
+		OnDrain:             func(c *Conn) { drained <- c.ID() },
+		ShutdownGracePeriod: 5 * time.Second,
+	})
+
+	go func() {
+		conn, err := c.Dial()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("ping"))
+		io.Copy(io.Discard, conn)
+	}()
+
+	conn, err := s.AcceptConn()
+	if err != nil {
+		t.Fatalf("accept:\n%v\n", err)
+	}
+
+	handlerDone := make(chan error, 1)
+	go func() {
+		defer conn.Close()
+		buf := make([]byte, 16)
+		for {
+			if _, err := conn.Read(buf); err != nil {
+				handlerDone <- err
+				return
+			}
+		}
+	}()
+
+	start := time.Now()
+	if err := s.Shutdown(); err != nil {
+		t.Fatalf("shutdown:\n%v\n", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("shutdown waited for full grace period: %v\n", d)
+	}
+
+	if id := <-drained; id != conn.ID() {
+		t.Fatalf("OnDrain called for unexpected conn %d\n", id)
+	}
+	if err := <-handlerDone; err != io.EOF {
+		t.Fatalf("expected io.EOF on drained conn, got %v\n", err)
+	}
+
+	if _, err := s.AcceptConn(); err != ErrServerClosed {
+		t.Fatalf("expected ErrServerClosed, got %v\n", err)
+	}
+}
+
+func TestShutdownSlowOnDrain(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	s, c := startTestServer(t, &Options{
+		OnDrain:             func(c *Conn) { <-release },
+		ShutdownGracePeriod: 200 * time.Millisecond,
+	})
+
+	for i := 0; i < 3; i++ {
+		go func() {
+			conn, err := c.Dial()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			io.Copy(io.Discard, conn)
+		}()
+		conn, err := s.AcceptConn()
+		if err != nil {
+			t.Fatalf("accept:\n%v\n", err)
+		}
+		// handler which ignores drain and keeps connection open
+		conn.Handshake()
+	}
+
+	start := time.Now()
+	s.Shutdown()
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("blocked OnDrain delayed shutdown: %v\n", d)
+	}
+	if n := len(s.Conns()); n != 0 {
+		t.Fatalf("%d conns left after shutdown\n", n)
+	}
+}
+
+func TestShutdownLateConn(t *testing.T) {
+	s := NewServer(&Options{})
+	s.Shutdown()
+
+	a, b := net.Pipe()
+	defer b.Close()
+
+	// conn accepted concurrently with Shutdown gets drained on register
+	c := newConn(s, a)
+	defer c.Close()
+	if !c.draining.Load() {
+		t.Fatalf("conn registered during shutdown not drained\n")
+	}
+	if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v\n", err)
+	}
+}
+
+func TestShutdownGraceClock(t *testing.T) {
+	clock := herotstest.NewFakeClock(time.Now())
+	s, c := startTestServer(t, &Options{
+		Clock:               clock,
+		ShutdownGracePeriod: time.Hour,
+	})
+
+	go func() {
+		conn, err := c.Dial()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(io.Discard, conn)
+	}()
+	conn, err := s.AcceptConn()
+	if err != nil {
+		t.Fatalf("accept:\n%v\n", err)
+	}
+	// handler which ignores drain and keeps connection open
+	conn.Handshake()
+
+	done := make(chan struct{})
+	go func() {
+		s.Shutdown()
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatalf("shutdown didn't wait for grace period\n")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	clock.Advance(time.Hour)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("shutdown didn't end after grace period\n")
+	}
+	if n := len(s.Conns()); n != 0 {
+		t.Fatalf("%d conns left after shutdown\n", n)
+	}
+}
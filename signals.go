@@ -0,0 +1,159 @@
+package herots
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SignalOptions - structure, which is used to configure operational
+// control signals (see Server.HandleSignals):
+//
+//	SIGHUP  - reload certificates and configuration (see Reload)
+//	SIGUSR1 - dump server and connection stats (see Server.DumpStats)
+//	SIGUSR2 - toggle debug logging (see Server.SetDebugLog)
+//
+// Signals are available on unix only; on other platforms HandleSignals
+// does nothing.
+type SignalOptions struct {
+	// Disabled - don't handle control signals at all, for embedders which
+	// own signal handling of process. SIGINT and SIGTERM handling of
+	// Server.Run is not affected.
+	Disabled bool
+
+	// Reload - function, which is called on SIGHUP, e.g. to reload key
+	// pair after renewal (see ReloadKeyPairFiles). Error is logged and
+	// server keeps running with current configuration.
+	//
+	// Default: nil (SIGHUP is not handled).
+	Reload func(s *Server) error
+
+	// DisableStats - don't handle SIGUSR1.
+	DisableStats bool
+
+	// DisableDebugToggle - don't handle SIGUSR2.
+	DisableDebugToggle bool
+}
+
+// ReloadKeyPairFiles - return SignalOptions.Reload function, which loads
+// key pair from PEM files (see Server.LoadKeyPair).
+func ReloadKeyPairFiles(certFile, keyFile string) func(s *Server) error {
+	return func(s *Server) error {
+		cert, err := os.ReadFile(certFile)
+		if err != nil {
+			return err
+		}
+		key, err := os.ReadFile(keyFile)
+		if err != nil {
+			return err
+		}
+		return s.LoadKeyPair(cert, key)
+	}
+}
+
+// HandleSignals - start handling of operational control signals according
+// to Options.Signals, until returned stop function is called. Server.Run
+// does it by default.
+func (s *Server) HandleSignals() (stop func()) {
+	var o SignalOptions
+	if s.options.Signals != nil {
+		o = *s.options.Signals
+	}
+	if o.Disabled {
+		return func() {}
+	}
+
+	actions := make(map[os.Signal]func())
+	if o.Reload != nil && sigReload != nil {
+		actions[sigReload] = func() {
+			if err := o.Reload(s); err != nil {
+				s.logger.Log(fmt.Sprintf("reload fail: %v", err), LogLevelError)
+				return
+			}
+			s.logger.Log("reload - ok", LogLevelNotice)
+		}
+	}
+	if !o.DisableStats && sigStats != nil {
+		actions[sigStats] = s.DumpStats
+	}
+	if !o.DisableDebugToggle && sigDebug != nil {
+		actions[sigDebug] = func() { s.SetDebugLog(!s.DebugLog()) }
+	}
+	if len(actions) == 0 {
+		return func() {}
+	}
+
+	ch := make(chan os.Signal, 1)
+	sigs := make([]os.Signal, 0, len(actions))
+	for sig := range actions {
+		sigs = append(sigs, sig)
+	}
+	signal.Notify(ch, sigs...)
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-ch:
+				s.logger.Log("got signal "+sig.String(), LogLevelInfo)
+				actions[sig]()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			close(done)
+		})
+	}
+}
+
+// DumpStats - write server stats and list of active connections to log,
+// regardless of log level.
+func (s *Server) DumpStats() {
+	st := s.Stats()
+	conns := s.Conns()
+	s.logger.print(fmt.Sprintf("stats: accepted %d, handshakes full %d, resumed %d, failed %d, active conns %d",
+		st.Accepted, st.HandshakesFull, st.HandshakesResumed, st.HandshakesFailed, len(conns)))
+
+	if len(st.Closed) > 0 {
+		reasons := closeReasons(st.Closed)
+		parts := make([]string, len(reasons))
+		for i, reason := range reasons {
+			parts[i] = fmt.Sprintf("%s %d", reason, st.Closed[reason])
+		}
+		s.logger.print("stats: closed by reason: " + strings.Join(parts, ", "))
+	}
+
+	for _, u := range s.QuotaUsage() {
+		s.logger.print(fmt.Sprintf("stats: quota of %q, conns %d, bytes %d, messages %d, rejected %d",
+			u.Key, u.Conns, u.Bytes, u.Messages, u.Rejected))
+	}
+
+	now := s.clock.Now()
+	for _, c := range conns {
+		cs := c.Stats()
+		s.logger.print(fmt.Sprintf("stats: %s, age %v, read %d, written %d",
+			c, now.Sub(cs.Accepted).Round(time.Second), cs.BytesRead, cs.BytesWritten))
+	}
+}
+
+// SetDebugLog - turn debug logging on or off: while it is on, messages of
+// all levels are logged, regardless of Options.LogLevel.
+func (s *Server) SetDebugLog(on bool) {
+	if s.logger.debug.Swap(on) != on {
+		s.logger.print(fmt.Sprintf("debug log: %v", on))
+	}
+}
+
+// DebugLog - report whether debug logging is on.
+func (s *Server) DebugLog() bool {
+	return s.logger.debug.Load()
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package herots
+
+import (
+	"os"
+)
+
+// operational control signals are not available on this platform
+var (
+	sigReload os.Signal
+	sigStats  os.Signal
+	sigDebug  os.Signal
+)
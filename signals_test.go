@@ -0,0 +1,56 @@
+//go:build unix
+
+package herots
+
+import (
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestHandleSignals(t *testing.T) {
+	var out syncBuffer
+	reloaded := make(chan struct{}, 1)
+	s := NewServer(&Options{
+		LogLevel:       LogLevelNotice,
+		LogDestination: &out,
+		Signals: &SignalOptions{
+			Reload: func(s *Server) error {
+				reloaded <- struct{}{}
+				return nil
+			},
+		},
+	})
+	stop := s.HandleSignals()
+	defer stop()
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGHUP)
+	select {
+	case <-reloaded:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("reload not called on SIGHUP\n")
+	}
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGUSR2)
+	waitFor(t, "debug log toggle", func() bool { return s.DebugLog() })
+	s.logger.Log("verbose message", LogLevelError)
+	if !strings.Contains(out.String(), "verbose message") {
+		t.Fatalf("debug log doesn't show messages of all levels:\n%s", out.String())
+	}
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGUSR1)
+	waitFor(t, "stats dump", func() bool {
+		return strings.Contains(out.String(), "stats: accepted 0")
+	})
+}
+
+func TestHandleSignalsDisabled(t *testing.T) {
+	s := NewServer(&Options{Signals: &SignalOptions{Disabled: true}})
+	s.HandleSignals()()
+
+	s = NewServer(&Options{Signals: &SignalOptions{DisableDebugToggle: true}})
+	stop := s.HandleSignals()
+	stop()
+	stop()
+}
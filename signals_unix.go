@@ -0,0 +1,15 @@
+//go:build unix
+
+package herots
+
+import (
+	"os"
+	"syscall"
+)
+
+// operational control signals, see SignalOptions
+var (
+	sigReload os.Signal = syscall.SIGHUP
+	sigStats  os.Signal = syscall.SIGUSR1
+	sigDebug  os.Signal = syscall.SIGUSR2
+)
@@ -0,0 +1,188 @@
+package herots
+
+import (
+	"crypto"
+	"errors"
+	"io"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// ErrSignPoolFull - returned by private key operation (and so fails
+// handshake) when sign pool queue is full, see SignPoolOptions.MaxQueue.
+var ErrSignPoolFull = errors.New("sign pool queue is full")
+
+// DefaultSignPoolQueue - default limit of private key operations waiting
+// for sign pool worker.
+const DefaultSignPoolQueue = 256
+
+// SignPoolOptions - structure, which is used to configure pool of
+// private key operations (see Options.SignPool).
+//
+// Without pool every full handshake signs in its own goroutine, so burst
+// of handshakes with RSA-4096 key (a few milliseconds of CPU per
+// signature) occupies all cores and starves established connections.
+// With pool at most Workers signatures run at once; others wait in queue
+// of up to MaxQueue operations, and handshakes beyond it fail fast with
+// ErrSignPoolFull instead of piling up. Resumed handshakes don't sign and
+// don't use pool.
+type SignPoolOptions struct {
+	// Workers - maximum number of concurrent private key operations.
+	//
+	// Default: half of GOMAXPROCS, at least 1.
+	Workers int
+
+	// MaxQueue - maximum number of operations waiting for worker.
+	//
+	// Default: DefaultSignPoolQueue (256).
+	MaxQueue int
+}
+
+// SignPoolStats - counters of sign pool.
+type SignPoolStats struct {
+	Workers int
+	// Active - operations running now
+	Active int64
+	// Queued - operations waiting for worker now; MaxQueued - its peak
+	Queued    int64
+	MaxQueued int64
+	// Signs - completed operations (signatures and RSA decryptions)
+	Signs int64
+	// Rejected - operations refused as queue was full
+	Rejected int64
+	// AverageWait and MaxWait - time spent in queue
+	AverageWait time.Duration
+	MaxWait     time.Duration
+}
+
+// signPool - bounded pool of private key operations: slots of sem are
+// workers, operation runs in goroutine of handshake once it gets slot.
+type signPool struct {
+	sem      chan struct{}
+	maxQueue int64
+
+	queued    atomic.Int64
+	maxQueued atomic.Int64
+	signs     atomic.Int64
+	rejected  atomic.Int64
+	// total and max wait, in nanoseconds
+	totalWait atomic.Int64
+	maxWait   atomic.Int64
+}
+
+func newSignPool(o *SignPoolOptions) *signPool {
+	workers := o.Workers
+	if workers <= 0 {
+		workers = max(1, runtime.GOMAXPROCS(0)/2)
+	}
+	maxQueue := o.MaxQueue
+	if maxQueue <= 0 {
+		maxQueue = DefaultSignPoolQueue
+	}
+	return &signPool{sem: make(chan struct{}, workers), maxQueue: int64(maxQueue)}
+}
+
+// do - run f in pool.
+func (p *signPool) do(f func() ([]byte, error)) ([]byte, error) {
+	start := time.Now()
+	select {
+	case p.sem <- struct{}{}:
+	default:
+		n := p.queued.Add(1)
+		if n > p.maxQueue {
+			p.queued.Add(-1)
+			p.rejected.Add(1)
+			return nil, ErrSignPoolFull
+		}
+		storeMax(&p.maxQueued, n)
+		p.sem <- struct{}{}
+		p.queued.Add(-1)
+	}
+	wait := int64(time.Since(start))
+	p.totalWait.Add(wait)
+	storeMax(&p.maxWait, wait)
+
+	defer func() { <-p.sem }()
+	out, err := f()
+	p.signs.Add(1)
+	return out, err
+}
+
+func (p *signPool) stats() SignPoolStats {
+	st := SignPoolStats{
+		Workers:   cap(p.sem),
+		Active:    int64(len(p.sem)),
+		Queued:    p.queued.Load(),
+		MaxQueued: p.maxQueued.Load(),
+		Signs:     p.signs.Load(),
+		Rejected:  p.rejected.Load(),
+		MaxWait:   time.Duration(p.maxWait.Load()),
+	}
+	if st.Signs > 0 {
+		st.AverageWait = time.Duration(p.totalWait.Load() / st.Signs)
+	}
+	return st
+}
+
+// storeMax - raise v to n, if n is greater.
+func storeMax(v *atomic.Int64, n int64) {
+	for {
+		cur := v.Load()
+		if n <= cur || v.CompareAndSwap(cur, n) {
+			return
+		}
+	}
+}
+
+// wrap - return private key, which runs its operations in pool. RSA keys
+// stay crypto.Decrypter, as RSA key exchange cipher suites need it.
+func (p *signPool) wrap(key crypto.PrivateKey) crypto.PrivateKey {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return key
+	}
+	ps := &pooledSigner{signer: signer, pool: p}
+	if d, ok := key.(crypto.Decrypter); ok {
+		return &pooledDecrypter{pooledSigner: ps, decrypter: d}
+	}
+	return ps
+}
+
+type pooledSigner struct {
+	signer crypto.Signer
+	pool   *signPool
+}
+
+// Public - implements crypto.Signer.
+func (p *pooledSigner) Public() crypto.PublicKey {
+	return p.signer.Public()
+}
+
+// Sign - implements crypto.Signer.
+func (p *pooledSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return p.pool.do(func() ([]byte, error) {
+		return p.signer.Sign(rand, digest, opts)
+	})
+}
+
+type pooledDecrypter struct {
+	*pooledSigner
+	decrypter crypto.Decrypter
+}
+
+// Decrypt - implements crypto.Decrypter.
+func (p *pooledDecrypter) Decrypt(rand io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	return p.pool.do(func() ([]byte, error) {
+		return p.decrypter.Decrypt(rand, msg, opts)
+	})
+}
+
+// SignPoolStats - return counters of sign pool (zero if Options.SignPool
+// is not set).
+func (s *Server) SignPoolStats() SignPoolStats {
+	if s.signPool == nil {
+		return SignPoolStats{}
+	}
+	return s.signPool.stats()
+}
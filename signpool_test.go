@@ -0,0 +1,157 @@
+package herots
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSignPool(t *testing.T) {
+	p := newSignPool(&SignPoolOptions{Workers: 1, MaxQueue: 1})
+
+	release := make(chan struct{})
+	results := make(chan error, 2)
+	for range 2 {
+		go func() {
+			_, err := p.do(func() ([]byte, error) {
+				<-release
+				return nil, nil
+			})
+			results <- err
+		}()
+	}
+	waitFor(t, "queued operation", func() bool {
+		st := p.stats()
+		return st.Active == 1 && st.Queued == 1
+	})
+
+	if _, err := p.do(func() ([]byte, error) { return nil, nil }); !errors.Is(err, ErrSignPoolFull) {
+		t.Fatalf("operation over queue limit: %v, want ErrSignPoolFull\n", err)
+	}
+
+	close(release)
+	for range 2 {
+		if err := <-results; err != nil {
+			t.Fatalf("pooled operation:\n%v\n", err)
+		}
+	}
+
+	st := p.stats()
+	if st.Workers != 1 || st.Signs != 2 || st.Rejected != 1 || st.MaxQueued != 1 || st.Queued != 0 {
+		t.Fatalf("unexpected stats %+v\n", st)
+	}
+	if st.MaxWait <= 0 {
+		t.Fatalf("no wait time recorded: %+v\n", st)
+	}
+
+	// RSA key exchange needs decrypter
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key:\n%v\n", err)
+	}
+	if _, ok := p.wrap(priv).(crypto.Decrypter); !ok {
+		t.Fatalf("wrapped RSA key is not crypto.Decrypter\n")
+	}
+}
+
+func TestSignPoolHandshake(t *testing.T) {
+	s, c := startTestServer(t, &Options{
+		SignPool:               &SignPoolOptions{Workers: 2},
+		SessionTicketsDisabled: true,
+	})
+	go acceptHandshakes(s)
+
+	for range 3 {
+		conn, err := c.Dial()
+		if err != nil {
+			t.Fatalf("dial:\n%v\n", err)
+		}
+		conn.Close()
+	}
+	if st := s.SignPoolStats(); st.Signs < 3 || st.Workers != 2 {
+		t.Fatalf("handshakes not signed in pool: %+v\n", st)
+	}
+}
+
+// acceptHandshakes - accept and handshake connections until server is
+// closed.
+func acceptHandshakes(s *Server) {
+	for {
+		conn, err := s.AcceptConn()
+		if err != nil {
+			return
+		}
+		go func() {
+			conn.Handshake()
+			conn.Close()
+		}()
+	}
+}
+
+var (
+	benchRSAOnce sync.Once
+	benchRSAKey  *rsa.PrivateKey
+)
+
+// benchmarkHandshakes - full handshakes with RSA-4096 key from parallel
+// clients.
+func benchmarkHandshakes(b *testing.B, pool *SignPoolOptions) {
+	benchRSAOnce.Do(func() {
+		var err error
+		if benchRSAKey, err = rsa.GenerateKey(rand.Reader, 4096); err != nil {
+			b.Fatalf("generate key:\n%v\n", err)
+		}
+	})
+	cert, key := signKeyPair(b, "herots bench", benchRSAKey)
+
+	o := &Options{
+		Host:                   "127.0.0.1",
+		Port:                   freePort(b),
+		SignPool:               pool,
+		SessionTicketsDisabled: true,
+	}
+	s := NewServer(o)
+	if err := s.LoadKeyPair(cert, key); err != nil {
+		b.Fatalf("server load key pair:\n%v\n", err)
+	}
+	if err := s.Start(); err != nil {
+		b.Fatalf("server start:\n%v\n", err)
+	}
+	defer s.Close()
+	go acceptHandshakes(s)
+
+	c := NewClient(&Options{Host: o.Host, Port: o.Port})
+	if err := c.LoadKeyPair(cert, key); err != nil {
+		b.Fatalf("client load key pair:\n%v\n", err)
+	}
+
+	start := time.Now()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			conn, err := c.Dial()
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			conn.Close()
+		}
+	})
+	b.StopTimer()
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "handshakes/s")
+	if pool != nil {
+		b.ReportMetric(float64(s.SignPoolStats().AverageWait.Microseconds()), "wait-µs/op")
+	}
+}
+
+func BenchmarkHandshakeRSA4096(b *testing.B) {
+	benchmarkHandshakes(b, nil)
+}
+
+func BenchmarkHandshakeRSA4096Pooled(b *testing.B) {
+	benchmarkHandshakes(b, &SignPoolOptions{})
+}
@@ -0,0 +1,378 @@
+package herots
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultSOCKS5DialTimeout - default timeout of connecting to destination
+// in SOCKS5 mode.
+const DefaultSOCKS5DialTimeout = 10 * time.Second
+
+// SOCKS5 protocol constants (RFC 1928)
+const (
+	socks5Version     = 5
+	socks5NoAuth      = 0
+	socks5NoMethods   = 0xff
+	socks5CmdConnect  = 1
+	socks5AddrIPv4    = 1
+	socks5AddrDomain  = 3
+	socks5AddrIPv6    = 4
+	socks5Succeeded   = 0
+	socks5NotAllowed  = 2
+	socks5HostUnreach = 4
+	socks5Refused     = 5
+	socks5CmdNotSupp  = 7
+	socks5AddrNotSupp = 8
+)
+
+// ErrSOCKS5Unverified - returned by ServeSOCKS5 without SOCKS5Options.Allow
+// for connection, which client certificate was not verified.
+var ErrSOCKS5Unverified = errors.New("socks5 refused: client certificate not verified and no Allow hook")
+
+// ErrSOCKS5LocalDestination - wrapped by SOCKS5Error of destination on
+// loopback or link-local address, refused without
+// SOCKS5Options.AllowLocal.
+var ErrSOCKS5LocalDestination = errors.New("socks5 destination is loopback or link-local")
+
+// SOCKS5Error - SOCKS5 request failure with reply code, sent to peer or
+// received from it.
+type SOCKS5Error struct {
+	Code byte
+	Err  error
+}
+
+func (e *SOCKS5Error) Error() string {
+	str := "socks5 request failed (code " + strconv.Itoa(int(e.Code)) + ")"
+	if e.Err != nil {
+		str += ": " + e.Err.Error()
+	}
+	return str
+}
+
+func (e *SOCKS5Error) Unwrap() error {
+	return e.Err
+}
+
+// SOCKS5Options - structure, which is used to configure ServeSOCKS5.
+type SOCKS5Options struct {
+	// Allow - hook, which decides whether client may connect to addr
+	// ("host:port" as requested). Client certificate of connection is
+	// available via Conn.Identity.
+	//
+	// Default: nil (any destination allowed, but only to clients with
+	// verified certificate chain, see TLSAuthType; other connections are
+	// refused with ErrSOCKS5Unverified).
+	Allow func(c *Conn, addr string) error
+
+	// AllowLocal - allow destinations on loopback and link-local
+	// addresses (services of proxy host itself, cloud metadata endpoints).
+	// Destination is checked as requested and, with default Dial, as
+	// resolved; custom Dial must check resolved addresses itself.
+	//
+	// Default: false.
+	AllowLocal bool
+
+	// Dial - function for connecting to destination.
+	//
+	// Default: net.Dialer with DialTimeout.
+	Dial func(network, addr string) (net.Conn, error)
+
+	// DialTimeout - timeout of default Dial.
+	//
+	// Default: DefaultSOCKS5DialTimeout (10s).
+	DialTimeout time.Duration
+}
+
+// ServeSOCKS5 - serve connection as SOCKS5 proxy: read CONNECT request,
+// connect to requested destination and relay data until one of sides
+// closes connection.
+//
+// Peer is authenticated by TLS client certificate, so only "no
+// authentication" SOCKS5 method is offered: without Allow hook only
+// clients with verified certificate chain are served. Only CONNECT
+// command is supported. ServeSOCKS5 doesn't close c.
+func ServeSOCKS5(c *Conn, o *SOCKS5Options) error {
+	if o == nil {
+		o = &SOCKS5Options{}
+	}
+
+	if o.Allow == nil {
+		id, err := c.Identity()
+		if err != nil || len(id.Chains) == 0 {
+			return &SOCKS5Error{Code: socks5NotAllowed, Err: ErrSOCKS5Unverified}
+		}
+	}
+
+	addr, err := socks5ReadRequest(c)
+	if err != nil {
+		var serr *SOCKS5Error
+		if errors.As(err, &serr) {
+			socks5Reply(c, serr.Code, nil)
+		}
+		return err
+	}
+
+	if !o.AllowLocal {
+		host, _, _ := net.SplitHostPort(addr)
+		if err := socks5CheckLocal(net.ParseIP(host)); err != nil {
+			socks5Reply(c, socks5NotAllowed, nil)
+			return &SOCKS5Error{Code: socks5NotAllowed, Err: err}
+		}
+	}
+
+	if o.Allow != nil {
+		if err := o.Allow(c, addr); err != nil {
+			socks5Reply(c, socks5NotAllowed, nil)
+			return &SOCKS5Error{Code: socks5NotAllowed, Err: err}
+		}
+	}
+
+	dial := o.Dial
+	if dial == nil {
+		timeout := o.DialTimeout
+		if timeout <= 0 {
+			timeout = DefaultSOCKS5DialTimeout
+		}
+		d := &net.Dialer{Timeout: timeout}
+		if !o.AllowLocal {
+			// check addresses of resolved host names too
+			d.Control = func(network, address string, _ syscall.RawConn) error {
+				host, _, _ := net.SplitHostPort(address)
+				return socks5CheckLocal(net.ParseIP(host))
+			}
+		}
+		dial = d.Dial
+	}
+
+	dst, err := dial("tcp", addr)
+	if err != nil {
+		code := byte(socks5HostUnreach)
+		switch {
+		case errors.Is(err, ErrSOCKS5LocalDestination):
+			code = socks5NotAllowed
+		case isConnRefused(err):
+			code = socks5Refused
+		}
+		socks5Reply(c, code, nil)
+		return &SOCKS5Error{Code: code, Err: err}
+	}
+	defer dst.Close()
+
+	if err := socks5Reply(c, socks5Succeeded, dst.LocalAddr()); err != nil {
+		return err
+	}
+	c.server.logger.Log("socks5 "+c.String()+" -> "+addr, LogLevelInfo)
+
+	return relay(c, dst)
+}
+
+// socks5CheckLocal - refuse loopback, link-local and unspecified (which
+// reaches local host) ip. Nil ip (host name) passes.
+func socks5CheckLocal(ip net.IP) error {
+	if ip == nil {
+		return nil
+	}
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("%w: %s", ErrSOCKS5LocalDestination, ip)
+	}
+	return nil
+}
+
+// SOCKS5Connect - send SOCKS5 CONNECT request for addr over established
+// connection to server running ServeSOCKS5, and wait for reply.
+//
+// On success conn carries data of connection to addr.
+func SOCKS5Connect(conn net.Conn, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("socks5 bad port %q", portStr)
+	}
+
+	req := []byte{socks5Version, 1, socks5NoAuth, socks5Version, socks5CmdConnect, 0}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+		req = append(append(req, socks5AddrIPv4), ip.To4()...)
+	} else if ip != nil {
+		req = append(append(req, socks5AddrIPv6), ip.To16()...)
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("socks5 host name too long")
+		}
+		req = append(append(req, socks5AddrDomain, byte(len(host))), host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	var method [2]byte
+	if _, err := io.ReadFull(conn, method[:]); err != nil {
+		return err
+	}
+	if method[0] != socks5Version || method[1] != socks5NoAuth {
+		return fmt.Errorf("socks5 method not accepted")
+	}
+
+	var hdr [4]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return err
+	}
+	if hdr[0] != socks5Version {
+		return fmt.Errorf("socks5 bad reply version %d", hdr[0])
+	}
+	if _, err := socks5ReadAddr(conn, hdr[3]); err != nil {
+		return err
+	}
+	if hdr[1] != socks5Succeeded {
+		return &SOCKS5Error{Code: hdr[1]}
+	}
+	return nil
+}
+
+// socks5ReadRequest - negotiate method and read CONNECT destination.
+func socks5ReadRequest(rw io.ReadWriter) (string, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(rw, hdr[:]); err != nil {
+		return "", err
+	}
+	if hdr[0] != socks5Version {
+		return "", fmt.Errorf("socks5 bad version %d", hdr[0])
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(rw, methods); err != nil {
+		return "", err
+	}
+
+	method := byte(socks5NoMethods)
+	for _, m := range methods {
+		if m == socks5NoAuth {
+			method = socks5NoAuth
+		}
+	}
+	if _, err := rw.Write([]byte{socks5Version, method}); err != nil {
+		return "", err
+	}
+	if method == socks5NoMethods {
+		return "", fmt.Errorf("socks5 no acceptable auth method")
+	}
+
+	var req [4]byte
+	if _, err := io.ReadFull(rw, req[:]); err != nil {
+		return "", err
+	}
+	if req[0] != socks5Version {
+		return "", fmt.Errorf("socks5 bad version %d", req[0])
+	}
+
+	addr, err := socks5ReadAddr(rw, req[3])
+	if err != nil {
+		return "", err
+	}
+	if req[1] != socks5CmdConnect {
+		return "", &SOCKS5Error{Code: socks5CmdNotSupp, Err: fmt.Errorf("command %d", req[1])}
+	}
+	return addr, nil
+}
+
+// socks5ReadAddr - read address of given type and port.
+func socks5ReadAddr(r io.Reader, atyp byte) (string, error) {
+	var host string
+	switch atyp {
+	case socks5AddrIPv4, socks5AddrIPv6:
+		ip := make(net.IP, 4)
+		if atyp == socks5AddrIPv6 {
+			ip = make(net.IP, 16)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socks5AddrDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(r, n[:]); err != nil {
+			return "", err
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(r, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		return "", &SOCKS5Error{Code: socks5AddrNotSupp, Err: fmt.Errorf("address type %d", atyp)}
+	}
+
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), nil
+}
+
+// socks5Reply - send reply with bound address (zero IPv4 if nil).
+func socks5Reply(w io.Writer, code byte, bound net.Addr) error {
+	ip := net.IPv4zero.To4()
+	port := 0
+	if tcp, ok := bound.(*net.TCPAddr); ok {
+		ip, port = tcp.IP, tcp.Port
+	}
+
+	msg := []byte{socks5Version, code, 0}
+	if ip4 := ip.To4(); ip4 != nil {
+		msg = append(append(msg, socks5AddrIPv4), ip4...)
+	} else {
+		msg = append(append(msg, socks5AddrIPv6), ip.To16()...)
+	}
+	msg = binary.BigEndian.AppendUint16(msg, uint16(port))
+
+	_, err := w.Write(msg)
+	return err
+}
+
+// closeWriter - connection with half-close support.
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// relay - copy data between a and b in both directions, half-closing
+// write side of each when its source is exhausted. Returns first copy
+// error, if any.
+func relay(a, b net.Conn) error {
+	var (
+		wg   sync.WaitGroup
+		once sync.Once
+		rerr error
+	)
+	copyHalf := func(dst, src net.Conn) {
+		defer wg.Done()
+		_, err := io.Copy(dst, src)
+		if err != nil {
+			once.Do(func() { rerr = err })
+			// unblock other direction
+			a.SetReadDeadline(time.Now())
+			b.SetReadDeadline(time.Now())
+			return
+		}
+		if cw, ok := dst.(closeWriter); ok {
+			cw.CloseWrite()
+		}
+	}
+
+	wg.Add(2)
+	go copyHalf(a, b)
+	go copyHalf(b, a)
+	wg.Wait()
+
+	return rerr
+}
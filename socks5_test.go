@@ -0,0 +1,134 @@
+package herots
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestSOCKS5(t *testing.T) {
+	s, c := startTestServer(t, &Options{})
+
+	// echo destination
+	dst, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen:\n%v\n", err)
+	}
+	defer dst.Close()
+	go func() {
+		for {
+			conn, err := dst.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	denied := errors.New("not for you")
+	served := make(chan error, 2)
+	go func() {
+		for i := 0; i < 2; i++ {
+			conn, err := s.AcceptConn()
+			if err != nil {
+				return
+			}
+			go func() {
+				served <- ServeSOCKS5(conn, &SOCKS5Options{
+					AllowLocal: true,
+					Allow: func(c *Conn, addr string) error {
+						if addr == dst.Addr().String() {
+							return nil
+						}
+						return denied
+					},
+				})
+				conn.Close()
+			}()
+		}
+	}()
+
+	conn, err := c.Dial()
+	if err != nil {
+		t.Fatalf("dial:\n%v\n", err)
+	}
+	if err := SOCKS5Connect(conn, dst.Addr().String()); err != nil {
+		t.Fatalf("socks5 connect:\n%v\n", err)
+	}
+	conn.Write([]byte("ping"))
+	conn.CloseWrite()
+	data, err := io.ReadAll(conn)
+	if err != nil || string(data) != "ping" {
+		t.Fatalf("unexpected relayed data: %q, %v\n", data, err)
+	}
+	conn.Close()
+	if err := <-served; err != nil {
+		t.Fatalf("serve:\n%v\n", err)
+	}
+
+	conn, err = c.Dial()
+	if err != nil {
+		t.Fatalf("dial:\n%v\n", err)
+	}
+	defer conn.Close()
+	var serr *SOCKS5Error
+	if err := SOCKS5Connect(conn, "127.0.0.1:1"); !errors.As(err, &serr) || serr.Code != socks5NotAllowed {
+		t.Fatalf("expected not allowed reply, got %v\n", err)
+	}
+	if err := <-served; !errors.Is(err, denied) {
+		t.Fatalf("expected denied serve error, got %v\n", err)
+	}
+}
+
+func TestSOCKS5Defaults(t *testing.T) {
+	s, c := startTestServer(t, &Options{})
+
+	serve := func(o *SOCKS5Options) chan error {
+		served := make(chan error, 1)
+		go func() {
+			conn, err := s.AcceptConn()
+			if err != nil {
+				served <- err
+				return
+			}
+			served <- ServeSOCKS5(conn, o)
+			conn.Close()
+		}()
+		return served
+	}
+
+	// self-signed client certificate is not verified
+	served := serve(nil)
+	conn, err := c.Dial()
+	if err != nil {
+		t.Fatalf("dial:\n%v\n", err)
+	}
+	if err := SOCKS5Connect(conn, "192.0.2.1:80"); err == nil {
+		t.Fatalf("unverified client served\n")
+	}
+	conn.Close()
+	if err := <-served; !errors.Is(err, ErrSOCKS5Unverified) {
+		t.Fatalf("expected ErrSOCKS5Unverified, got %v\n", err)
+	}
+
+	allowAll := &SOCKS5Options{Allow: func(c *Conn, addr string) error { return nil }}
+	for _, addr := range []string{"127.0.0.1:1", "[fe80::1]:80", "0.0.0.0:1", "localhost:1"} {
+		served := serve(allowAll)
+		conn, err := c.Dial()
+		if err != nil {
+			t.Fatalf("dial:\n%v\n", err)
+		}
+		var serr *SOCKS5Error
+		if err := SOCKS5Connect(conn, addr); !errors.As(err, &serr) || serr.Code != socks5NotAllowed {
+			t.Fatalf("%s: expected not allowed reply, got %v\n", addr, err)
+		}
+		conn.Close()
+		if err := <-served; !errors.Is(err, ErrSOCKS5LocalDestination) {
+			t.Fatalf("%s: expected ErrSOCKS5LocalDestination, got %v\n", addr, err)
+		}
+	}
+}
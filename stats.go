@@ -0,0 +1,86 @@
+package herots
+
+import (
+	"crypto/tls"
+	"sync"
+	"sync/atomic"
+)
+
+// ServerStats - counters of server activity since start.
+type ServerStats struct {
+	Accepted          int64
+	HandshakesFull    int64
+	HandshakesResumed int64
+	HandshakesFailed  int64
+	// Shed - connections dropped by admission queue (see AcceptQueue)
+	Shed int64
+	// Banned - connections closed on accept, as their IP is banned (see
+	// AbuseOptions.MaxFailures)
+	Banned int64
+	// QuotaRejected - connections and I/O operations rejected by quota
+	// of identity (see Options.Quota)
+	QuotaRejected int64
+	// Closed - closed connections by reason
+	Closed map[CloseReason]int64
+}
+
+// serverStats - atomic counters behind ServerStats.
+type serverStats struct {
+	accepted          atomic.Int64
+	handshakesFull    atomic.Int64
+	handshakesResumed atomic.Int64
+	handshakesFailed  atomic.Int64
+	shed              atomic.Int64
+	banned            atomic.Int64
+	quotaRejected     atomic.Int64
+
+	closedMu sync.Mutex
+	closed   map[CloseReason]int64
+}
+
+// Stats - return counters of server activity.
+func (s *Server) Stats() ServerStats {
+	return ServerStats{
+		Accepted:          s.stats.accepted.Load(),
+		HandshakesFull:    s.stats.handshakesFull.Load(),
+		HandshakesResumed: s.stats.handshakesResumed.Load(),
+		HandshakesFailed:  s.stats.handshakesFailed.Load(),
+		Shed:              s.stats.shed.Load(),
+		Banned:            s.stats.banned.Load(),
+		QuotaRejected:     s.stats.quotaRejected.Load(),
+		Closed:            s.stats.closedByReason(),
+	}
+}
+
+// ResumptionMechanism - how TLS session of connection was established.
+type ResumptionMechanism string
+
+// predefined ResumptionMechanism values
+const (
+	// full handshake, no resumption
+	ResumptionNone ResumptionMechanism = "none"
+	// TLS 1.2 session ticket
+	ResumptionTicket ResumptionMechanism = "ticket"
+	// TLS 1.3 pre-shared key from ticket
+	ResumptionPSK ResumptionMechanism = "psk"
+)
+
+// resumption - mechanism of session establishment from connection state.
+func resumption(st tls.ConnectionState) ResumptionMechanism {
+	switch {
+	case !st.DidResume:
+		return ResumptionNone
+	case st.Version >= tls.VersionTLS13:
+		return ResumptionPSK
+	}
+	return ResumptionTicket
+}
+
+// Resumption - report whether connection resumed earlier TLS session and
+// by which mechanism. Handshake is run if it has not yet been.
+func (c *Conn) Resumption() (ResumptionMechanism, error) {
+	if err := c.Handshake(); err != nil {
+		return ResumptionNone, err
+	}
+	return resumption(c.ConnectionState()), nil
+}
@@ -0,0 +1,49 @@
+package herots
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestResumptionStats(t *testing.T) {
+	s, c := startTestServer(t, &Options{})
+
+	config := c.tlsConfig()
+	config.ClientSessionCache = tls.NewLRUClientSessionCache(8)
+
+	dial := func(done chan struct{}) {
+		defer close(done)
+		conn, err := tls.Dial("tcp", s.listener.Addr().String(), config)
+		if err != nil {
+			t.Errorf("dial:\n%v\n", err)
+			return
+		}
+		// TLS 1.3 tickets arrive after handshake
+		conn.Read(make([]byte, 1))
+		conn.Close()
+	}
+
+	for i, want := range []ResumptionMechanism{ResumptionNone, ResumptionPSK} {
+		done := make(chan struct{})
+		go dial(done)
+		conn, err := s.AcceptConn()
+		if err != nil {
+			t.Fatalf("accept:\n%v\n", err)
+		}
+		got, err := conn.Resumption()
+		if err != nil {
+			t.Fatalf("handshake:\n%v\n", err)
+		}
+		conn.Write([]byte{0})
+		<-done
+		conn.Close()
+		if got != want {
+			t.Fatalf("connection %d: resumption %q, want %q\n", i, got, want)
+		}
+	}
+
+	st := s.Stats()
+	if st.Accepted != 2 || st.HandshakesFull != 1 || st.HandshakesResumed != 1 {
+		t.Fatalf("unexpected stats: %+v\n", st)
+	}
+}
@@ -0,0 +1,347 @@
+package herots
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultTrustBundleInterval - default interval of trust bundle refresh.
+const DefaultTrustBundleInterval = 5 * time.Minute
+
+// DefaultTrustBundleTimeout - default timeout of trust bundle request.
+const DefaultTrustBundleTimeout = 30 * time.Second
+
+// TrustBundleSignatureHeader - HTTP header with base64 encoded ed25519
+// signature of response body, see TrustBundleOptions.PublicKey.
+const TrustBundleSignatureHeader = "X-Herots-Signature"
+
+// maxTrustBundleSize - limit of fetched bundle size.
+const maxTrustBundleSize = 4 << 20
+
+// TrustBundleOptions - structure, which is used to configure
+// Server.WatchTrustBundle.
+type TrustBundleOptions struct {
+	// URL - HTTPS URL of PEM encoded client CA bundle. Bundle replaces
+	// whole client CA pool (see ReplaceClientCAPool).
+	URL string
+
+	// ChainURL - HTTPS URL of PEM encoded intermediate certificates of
+	// server key pair (without leaf). Chain must start with issuer of
+	// loaded certificate.
+	//
+	// Default: "" (chain of loaded key pair is kept).
+	ChainURL string
+
+	// PublicKey - key, which must have signed fetched documents: response
+	// must carry TrustBundleSignatureHeader with signature of body.
+	//
+	// Default: nil (no signature check; use Pins then).
+	PublicKey ed25519.PublicKey
+
+	// Pins - SHA-256 fingerprints (see FingerprintSHA256) of certificates
+	// of bundle endpoint; connection is trusted only if its chain contains
+	// one of them.
+	//
+	// Default: nil (no pinning).
+	Pins [][]byte
+
+	// RootCAs - roots to verify bundle endpoint.
+	//
+	// Default: nil (system roots).
+	RootCAs *x509.CertPool
+
+	// Interval - how often bundle is refreshed. Unchanged bundle costs a
+	// conditional request (ETag).
+	//
+	// Default: DefaultTrustBundleInterval (5m).
+	Interval time.Duration
+
+	// Timeout - timeout of one request.
+	//
+	// Default: DefaultTrustBundleTimeout (30s).
+	Timeout time.Duration
+
+	// OnUpdate - function, which is called after each refresh, which
+	// fetched new document or failed, with its error (nil on success).
+	OnUpdate func(err error)
+}
+
+// WatchTrustBundle - fetch client CA bundle (and server chain, if
+// configured) from central endpoint and keep refreshing it, until
+// returned stop function is called.
+//
+// First fetch is done before WatchTrustBundle returns; its failure is
+// returned. Later failures are reported to Errors, and server keeps last
+// good bundle. Documents are applied only after their signature check
+// passed. Key pair reload (LoadKeyPair, LoadSecretDir, SIGHUP) resets
+// client CA pool and chain, so last applied documents are applied again
+// after it.
+func (s *Server) WatchTrustBundle(o *TrustBundleOptions) (stop func(), err error) {
+	opts := *o
+	if opts.URL == "" {
+		return nil, fmt.Errorf("trust bundle error: no URL\n")
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultTrustBundleInterval
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTrustBundleTimeout
+	}
+
+	client := trustBundleClient(&opts)
+	w := &trustWatch{bundle: &bundleFetcher{client: client, url: opts.URL, key: opts.PublicKey}}
+	if opts.ChainURL != "" {
+		w.chain = &bundleFetcher{client: client, url: opts.ChainURL, key: opts.PublicKey}
+	}
+
+	if _, err := w.refresh(s); err != nil {
+		return nil, err
+	}
+
+	s.trustMu.Lock()
+	if s.trustWatches == nil {
+		s.trustWatches = make(map[*trustWatch]struct{})
+	}
+	s.trustWatches[w] = struct{}{}
+	s.trustMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		tick, stopTicker := s.clock.NewTicker(opts.Interval)
+		defer stopTicker()
+		for {
+			select {
+			case <-tick:
+			case <-done:
+				return
+			}
+
+			changed, err := w.refresh(s)
+			if err != nil {
+				s.reportError("trust bundle", nil, err)
+			}
+			if (changed || err != nil) && opts.OnUpdate != nil {
+				opts.OnUpdate(err)
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			s.trustMu.Lock()
+			delete(s.trustWatches, w)
+			s.trustMu.Unlock()
+		})
+	}, nil
+}
+
+// trustWatch - fetchers of running WatchTrustBundle.
+type trustWatch struct {
+	mu     sync.Mutex
+	bundle *bundleFetcher
+	// chain - nil without ChainURL
+	chain *bundleFetcher
+}
+
+// refresh - fetch documents and apply changed ones.
+func (w *trustWatch) refresh(s *Server) (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	data, changed, err := w.bundle.fetch()
+	if err != nil {
+		return false, err
+	}
+	if changed {
+		if err := s.ReplaceClientCAPool([][]byte{data}); err != nil {
+			return false, fmt.Errorf("trust bundle error: %v\n", err)
+		}
+		w.bundle.commit()
+	}
+
+	if w.chain == nil {
+		return changed, nil
+	}
+	data, chainChanged, err := w.chain.fetch()
+	if err != nil {
+		return changed, err
+	}
+	if chainChanged {
+		if err := s.setChain(data); err != nil {
+			return changed, err
+		}
+		w.chain.commit()
+	}
+	return changed || chainChanged, nil
+}
+
+// reapply - apply last applied documents again, without fetching them
+// (unchanged documents are not fetched again, see bundleFetcher).
+func (w *trustWatch) reapply(s *Server) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.bundle.last != nil {
+		if err := s.ReplaceClientCAPool([][]byte{w.bundle.last}); err != nil {
+			return fmt.Errorf("trust bundle error: %v\n", err)
+		}
+	}
+	if w.chain != nil && w.chain.last != nil {
+		return s.setChain(w.chain.last)
+	}
+	return nil
+}
+
+// reapplyTrustBundles - apply documents of running WatchTrustBundle
+// watchers after key pair reload.
+func (s *Server) reapplyTrustBundles() {
+	s.trustMu.Lock()
+	watches := make([]*trustWatch, 0, len(s.trustWatches))
+	for w := range s.trustWatches {
+		watches = append(watches, w)
+	}
+	s.trustMu.Unlock()
+
+	for _, w := range watches {
+		if err := w.reapply(s); err != nil {
+			s.reportError("trust bundle", nil, err)
+		}
+	}
+}
+
+// setChain - replace intermediates of primary key pair.
+func (s *Server) setChain(data []byte) error {
+	certs, err := parseCACerts(data)
+	if err != nil {
+		return fmt.Errorf("trust bundle error: chain: %v\n", err)
+	}
+
+	s.certMu.Lock()
+	defer s.certMu.Unlock()
+
+	if len(s.certs.Cert.Certificate) == 0 {
+		return fmt.Errorf("%s\n", NoKeyPairLoadError)
+	}
+	leaf, err := x509.ParseCertificate(s.certs.Cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("trust bundle error: chain: %v\n", err)
+	}
+	if err := leaf.CheckSignatureFrom(certs[0]); err != nil {
+		return fmt.Errorf("trust bundle error: chain doesn't start with issuer of loaded cert: %v\n", err)
+	}
+
+	// key pair is shared with published configs, replace it as a whole
+	c := s.certs.Cert
+	c.Certificate = [][]byte{c.Certificate[0]}
+	for _, cert := range certs {
+		c.Certificate = append(c.Certificate, cert.Raw)
+	}
+	s.certs.Cert = c
+	s.publishClientCAsLocked(s.clientCAs.Load())
+	s.logger.Log("trust bundle: server chain updated", LogLevelInfo)
+
+	return nil
+}
+
+// trustBundleClient - HTTP client with endpoint verification of opts.
+func trustBundleClient(opts *TrustBundleOptions) *http.Client {
+	config := &tls.Config{RootCAs: opts.RootCAs, MinVersion: tls.VersionTLS12}
+	if len(opts.Pins) > 0 {
+		pins := opts.Pins
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			for _, cert := range cs.PeerCertificates {
+				for _, pin := range pins {
+					if FingerprintEqual(FingerprintSHA256(cert), pin) {
+						return nil
+					}
+				}
+			}
+			return errors.New("trust bundle endpoint doesn't match pinned certificates")
+		}
+	}
+
+	return &http.Client{
+		Timeout:   opts.Timeout,
+		Transport: &http.Transport{TLSClientConfig: config, Proxy: http.ProxyFromEnvironment},
+	}
+}
+
+// bundleFetcher - conditional (ETag) fetcher of signed document.
+type bundleFetcher struct {
+	client *http.Client
+	url    string
+	key    ed25519.PublicKey
+
+	// etag and contents of applied document
+	etag string
+	last []byte
+
+	// fetched document, waiting for commit
+	pendingETag string
+	pending     []byte
+}
+
+// fetch - fetch document, reporting whether it differs from applied one.
+// Changed document becomes applied one on commit.
+func (f *bundleFetcher) fetch() ([]byte, bool, error) {
+	req, err := http.NewRequest(http.MethodGet, f.url, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("trust bundle error: %v\n", err)
+	}
+	if f.etag != "" {
+		req.Header.Set("If-None-Match", f.etag)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, false, fmt.Errorf("trust bundle error: %v\n", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil, false, nil
+	case http.StatusOK:
+	default:
+		return nil, false, fmt.Errorf("trust bundle error: %s: %s\n", f.url, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxTrustBundleSize+1))
+	if err != nil {
+		return nil, false, fmt.Errorf("trust bundle error: %v\n", err)
+	}
+	if len(data) > maxTrustBundleSize {
+		return nil, false, fmt.Errorf("trust bundle error: %s: document too large\n", f.url)
+	}
+
+	if f.key != nil {
+		sig, err := base64.StdEncoding.DecodeString(resp.Header.Get(TrustBundleSignatureHeader))
+		if err != nil || !ed25519.Verify(f.key, data, sig) {
+			return nil, false, fmt.Errorf("trust bundle error: %s: bad signature\n", f.url)
+		}
+	}
+
+	f.pendingETag, f.pending = resp.Header.Get("ETag"), data
+	if f.last != nil && bytes.Equal(data, f.last) {
+		// endpoint without ETag support
+		f.commit()
+		return nil, false, nil
+	}
+	return data, true, nil
+}
+
+// commit - mark fetched document as applied.
+func (f *bundleFetcher) commit() {
+	f.etag, f.last = f.pendingETag, f.pending
+}
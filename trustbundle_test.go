@@ -0,0 +1,200 @@
+package herots
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// bundleEndpoint - test trust bundle endpoint with ETag support.
+type bundleEndpoint struct {
+	key ed25519.PrivateKey
+
+	mu     sync.Mutex
+	docs   map[string][]byte
+	badSig bool
+}
+
+func (e *bundleEndpoint) set(path string, doc []byte, badSig bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.docs[path], e.badSig = doc, badSig
+}
+
+func (e *bundleEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	doc, badSig := e.docs[r.URL.Path], e.badSig
+	e.mu.Unlock()
+
+	sum := sha256.Sum256(doc)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	sig := ed25519.Sign(e.key, doc)
+	if badSig {
+		sig[0] ^= 0xff
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set(TrustBundleSignatureHeader, base64.StdEncoding.EncodeToString(sig))
+	w.Write(doc)
+}
+
+func TestWatchTrustBundle(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key:\n%v\n", err)
+	}
+	ca1, _ := genKeyPair(t, "CA 1")
+	ca2, _ := genKeyPair(t, "CA 2")
+	cert, key := genKeyPair(t, "herots test")
+
+	e := &bundleEndpoint{key: priv, docs: map[string][]byte{}}
+	e.set("/bundle.pem", ca1, false)
+	e.set("/chain.pem", cert, false)
+	ts := httptest.NewTLSServer(e)
+	defer ts.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(ts.Certificate())
+
+	s := NewServer(&Options{})
+	if err := s.LoadKeyPair(cert, key); err != nil {
+		t.Fatalf("load key pair:\n%v\n", err)
+	}
+
+	updated := make(chan error, 1)
+	opts := &TrustBundleOptions{
+		URL:       ts.URL + "/bundle.pem",
+		ChainURL:  ts.URL + "/chain.pem",
+		PublicKey: pub,
+		Pins:      [][]byte{FingerprintSHA256(ts.Certificate())},
+		RootCAs:   roots,
+		Interval:  10 * time.Millisecond,
+		OnUpdate:  func(err error) { updated <- err },
+	}
+	stop, err := s.WatchTrustBundle(opts)
+	if err != nil {
+		t.Fatalf("watch trust bundle:\n%v\n", err)
+	}
+	defer stop()
+
+	trusted := func() string {
+		s.certMu.Lock()
+		defer s.certMu.Unlock()
+		if len(s.clientCAList) != 1 {
+			return ""
+		}
+		return s.clientCAList[0].Subject.CommonName
+	}
+	if cn := trusted(); cn != "CA 1" {
+		t.Fatalf("bundle not applied, trusted %q\n", cn)
+	}
+	s.certMu.Lock()
+	if n := len(s.certs.Cert.Certificate); n != 2 {
+		t.Fatalf("server chain not applied: %d certs\n", n)
+	}
+	s.certMu.Unlock()
+
+	e.set("/bundle.pem", ca2, true)
+	if err := <-updated; err == nil {
+		t.Fatalf("bundle with bad signature accepted\n")
+	}
+	if cn := trusted(); cn != "CA 1" {
+		t.Fatalf("bad bundle changed pool, trusted %q\n", cn)
+	}
+
+	e.set("/bundle.pem", ca2, false)
+	for err := range updated {
+		if err == nil {
+			break
+		}
+	}
+	if cn := trusted(); cn != "CA 2" {
+		t.Fatalf("refreshed bundle not applied, trusted %q\n", cn)
+	}
+}
+
+func TestTrustBundlePinMismatch(t *testing.T) {
+	ca, _ := genKeyPair(t, "CA")
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(ca)
+	}))
+	defer ts.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(ts.Certificate())
+
+	s := NewServer(&Options{})
+	_, err := s.WatchTrustBundle(&TrustBundleOptions{
+		URL:     ts.URL,
+		Pins:    [][]byte{make([]byte, 32)},
+		RootCAs: roots,
+	})
+	if err == nil {
+		t.Fatalf("endpoint not matching pins accepted\n")
+	}
+}
+
+func TestTrustBundleKeyPairReload(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key:\n%v\n", err)
+	}
+	ca, _ := genKeyPair(t, "CA")
+	cert, key := genKeyPair(t, "herots test")
+
+	e := &bundleEndpoint{key: priv, docs: map[string][]byte{}}
+	e.set("/bundle.pem", ca, false)
+	e.set("/chain.pem", cert, false)
+	ts := httptest.NewTLSServer(e)
+	defer ts.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(ts.Certificate())
+
+	s := NewServer(&Options{})
+	if err := s.LoadKeyPair(cert, key); err != nil {
+		t.Fatalf("load key pair:\n%v\n", err)
+	}
+	stop, err := s.WatchTrustBundle(&TrustBundleOptions{
+		URL:       ts.URL + "/bundle.pem",
+		ChainURL:  ts.URL + "/chain.pem",
+		PublicKey: pub,
+		RootCAs:   roots,
+		Interval:  time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("watch trust bundle:\n%v\n", err)
+	}
+
+	// reload resets pool and chain; endpoint would answer 304 now
+	if err := s.LoadKeyPair(cert, key); err != nil {
+		t.Fatalf("reload key pair:\n%v\n", err)
+	}
+	s.certMu.Lock()
+	cas, certs := len(s.clientCAList), len(s.certs.Cert.Certificate)
+	cn := s.clientCAList[0].Subject.CommonName
+	s.certMu.Unlock()
+	if cas != 1 || cn != "CA" || certs != 2 {
+		t.Fatalf("bundle not re-applied after reload: %d CAs (%q), %d chain certs\n", cas, cn, certs)
+	}
+
+	// stopped watcher is not re-applied
+	stop()
+	if err := s.LoadKeyPair(cert, key); err != nil {
+		t.Fatalf("reload key pair:\n%v\n", err)
+	}
+	s.certMu.Lock()
+	cn = s.clientCAList[0].Subject.CommonName
+	s.certMu.Unlock()
+	if cn != "herots test" {
+		t.Fatalf("stopped bundle applied after reload, trusted %q\n", cn)
+	}
+}
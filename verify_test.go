@@ -0,0 +1,86 @@
+package herots
+
+import (
+	"crypto/tls"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientVerification(t *testing.T) {
+	ca, err := NewCA("herots CA", time.Hour)
+	if err != nil {
+		t.Fatalf("new CA:\n%v\n", err)
+	}
+	cert, key, err := ca.Issue(&IssueRequest{
+		Profile:    ProfilePeer,
+		CommonName: "herald",
+		DNSNames:   []string{"herald.internal"},
+	})
+	if err != nil {
+		t.Fatalf("issue cert:\n%v\n", err)
+	}
+	clientCert, clientKey := genKeyPair(t, "agent")
+
+	o := &Options{Host: "127.0.0.1", Port: freePort(t)}
+	s := NewServer(o)
+	if err := s.LoadKeyPair(cert, key); err != nil {
+		t.Fatalf("server load key pair:\n%v\n", err)
+	}
+	s.AddClientCACert(clientCert)
+	if err := s.Start(); err != nil {
+		t.Fatalf("server start:\n%v\n", err)
+	}
+	defer s.Close()
+	go func() {
+		for {
+			conn, err := s.AcceptConn()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.Handshake()
+				conn.Close()
+			}()
+		}
+	}()
+
+	dial := func(co *Options, trust bool) error {
+		co.Host, co.Port = o.Host, o.Port
+		c := NewClient(co)
+		c.LoadKeyPair(clientCert, clientKey)
+		if trust {
+			c.AddCertToRootCA(ca.Certificate())
+		}
+		conn, err := c.Dial()
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+
+	if err := dial(&Options{}, true); err == nil {
+		t.Fatalf("certificate for other name accepted for IP address\n")
+	}
+	if err := dial(&Options{ServerName: "herald.internal"}, true); err != nil {
+		t.Fatalf("dial with ServerName:\n%v\n", err)
+	}
+	if err := dial(&Options{InsecureSkipVerify: true}, false); err != nil {
+		t.Fatalf("dial with InsecureSkipVerify:\n%v\n", err)
+	}
+
+	errPin := errors.New("not pinned")
+	err = dial(&Options{
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if cs.PeerCertificates[0].Subject.CommonName != "pinned" {
+				return errPin
+			}
+			return nil
+		},
+	}, false)
+	if err == nil || !strings.Contains(err.Error(), errPin.Error()) {
+		t.Fatalf("VerifyConnection error not returned: %v\n", err)
+	}
+}